@@ -2,19 +2,33 @@ package main
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
+	"github.com/sreejeet/garagesale/internal/export"
+	"github.com/sreejeet/garagesale/internal/org"
 	"github.com/sreejeet/garagesale/internal/platform/auth"
 	"github.com/sreejeet/garagesale/internal/platform/conf"
 	"github.com/sreejeet/garagesale/internal/platform/database"
+	"github.com/sreejeet/garagesale/internal/platform/sheet"
+	"github.com/sreejeet/garagesale/internal/platform/storage"
+	"github.com/sreejeet/garagesale/internal/product"
+	"github.com/sreejeet/garagesale/internal/report"
 	"github.com/sreejeet/garagesale/internal/schema"
 	"github.com/sreejeet/garagesale/internal/user"
 )
@@ -37,6 +51,45 @@ func run() error {
 			// Currently set to true for convenience
 			DisableTLS bool `conf:"default:true"`
 		}
+		// Amounts kept before they had currencies are converted from whole
+		// units of the currency given for the organization, like
+		// <org id>:EUR, or from US dollars.
+		Migrate struct {
+			LegacyCurrencies map[string]string
+		}
+		Purge struct {
+			// Deleted products are kept this long before
+			// purge-products removes them for good.
+			Retention time.Duration `conf:"default:2160h"`
+		}
+		// Storage must match the one of sales-api so purge-products
+		// can remove the images of purged products.
+		Storage struct {
+			Kind        string `conf:"default:fs"`
+			Dir         string `conf:"default:images"`
+			S3Endpoint  string
+			S3Region    string `conf:"default:us-east-1"`
+			S3Bucket    string
+			S3AccessKey string
+			S3SecretKey string `conf:"noprint"`
+		}
+		// Imported products are created in this organization on behalf
+		// of this user, who must be a member of it.
+		Import struct {
+			OrgID  string `conf:"default:00000000-0000-0000-0000-000000000001"`
+			UserID string
+			Mode   string `conf:"default:all-or-nothing"`
+		}
+		// Exports cover products created or sales recorded from From up
+		// to To, which are dates like 2019-01-31. To defaults to now and
+		// From to 30 days before To. Format is taken from the extension
+		// of the file unless set.
+		Export struct {
+			OrgID  string `conf:"default:00000000-0000-0000-0000-000000000001"`
+			From   string
+			To     string
+			Format string
+		}
 		Args conf.Args
 	}
 
@@ -60,16 +113,34 @@ func run() error {
 		DisableTLS: cfg.DB.DisableTLS,
 	}
 
+	storageConfig := storage.Config{
+		Kind: cfg.Storage.Kind,
+		Dir:  cfg.Storage.Dir,
+		S3: storage.S3Config{
+			Endpoint:  cfg.Storage.S3Endpoint,
+			Region:    cfg.Storage.S3Region,
+			Bucket:    cfg.Storage.S3Bucket,
+			AccessKey: cfg.Storage.S3AccessKey,
+			SecretKey: cfg.Storage.S3SecretKey,
+		},
+	}
+
 	var err error
 	switch cfg.Args.Num(0) {
 	case "migrate":
-		err = migrate(dbConfig)
+		err = migrate(dbConfig, cfg.Migrate.LegacyCurrencies)
 	case "seed":
 		err = seed(dbConfig)
 	case "useradd":
 		err = useradd(dbConfig, cfg.Args.Num(1), cfg.Args.Num(2))
 	case "keygen":
-		err = keygen(cfg.Args.Num(1))
+		err = keygen(cfg.Args.Num(1), cfg.Args.Num(2))
+	case "purge-products":
+		err = purgeProducts(dbConfig, storageConfig, cfg.Purge.Retention)
+	case "import-products":
+		err = importProducts(dbConfig, cfg.Import.OrgID, cfg.Import.UserID, cfg.Import.Mode, cfg.Args.Num(1))
+	case "export":
+		err = exportData(dbConfig, cfg.Export.OrgID, cfg.Export.From, cfg.Export.To, cfg.Export.Format, cfg.Args.Num(1), cfg.Args.Num(2))
 	default:
 		err = errors.New("Must specify a command")
 	}
@@ -82,7 +153,7 @@ func run() error {
 }
 
 // migrate the schema to the database
-func migrate(cfg database.Config) error {
+func migrate(cfg database.Config, legacy map[string]string) error {
 
 	db, err := database.Open(cfg)
 	if err != nil {
@@ -90,7 +161,7 @@ func migrate(cfg database.Config) error {
 	}
 	defer db.Close()
 
-	if err := schema.Migrate(db); err != nil {
+	if err := schema.Migrate(db, legacy); err != nil {
 		return err
 	}
 
@@ -150,7 +221,7 @@ func useradd(cfg database.Config, email, password string) error {
 		Roles:           []string{auth.RoleAdmin, auth.RoleUser},
 	}
 
-	u, err := user.Create(ctx, db, nu, time.Now())
+	u, err := user.Create(ctx, db, org.DefaultID, nu, time.Now())
 	if err != nil {
 		return err
 	}
@@ -159,16 +230,235 @@ func useradd(cfg database.Config, email, password string) error {
 	return nil
 }
 
-// keygen creates an x509 private key for signing auth tokens.
-func keygen(path string) error {
+// purgeProducts permanently removes products that were deleted longer than
+// the retention period ago. Their sales and images are removed with them.
+func purgeProducts(cfg database.Config, storageConfig storage.Config, retention time.Duration) error {
+
+	if retention <= 0 {
+		return errors.New("purge retention must be positive")
+	}
+
+	store, err := storage.Open(storageConfig)
+	if err != nil {
+		return err
+	}
+
+	db, err := database.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	before := time.Now().Add(-retention)
+	n, err := product.Purge(context.Background(), db, store, before)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Purged %d products deleted before %s\n", n, before.Format(time.RFC3339))
+	return nil
+}
+
+// importProducts creates products from a CSV or NDJSON file. The format is
+// taken from the file extension.
+func importProducts(cfg database.Config, orgID, userID, mode, path string) error {
 
 	if path == "" {
-		return errors.New("keygen missing argument for key path")
+		return errors.New("import-products missing argument for file path")
 	}
+	if userID == "" {
+		return errors.New("import-products needs the user to import as, set --import-user-id")
+	}
+	if _, err := uuid.Parse(userID); err != nil {
+		return errors.New("--import-user-id must be a uuid")
+	}
+	if _, err := uuid.Parse(orgID); err != nil {
+		return errors.New("--import-org-id must be a uuid")
+	}
+
+	var format string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		format = product.FormatCSV
+	case ".ndjson", ".jsonl":
+		format = product.FormatNDJSON
+	default:
+		return errors.New("import file must end in .csv, .ndjson or .jsonl")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "opening import file")
+	}
+	defer f.Close()
+
+	db, err := database.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
 
-	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	// Import as the user would through the API, with the roles of their
+	// membership in the organization.
+	ctx := context.Background()
+	now := time.Now()
+	claims, err := user.Claims(ctx, db, userID, orgID, now)
 	if err != nil {
-		return errors.Wrap(err, "generating keys")
+		if err == user.ErrForbidden {
+			return errors.New("the import user is not a member of the organization")
+		}
+		return errors.Wrap(err, "loading import user")
+	}
+
+	res, err := product.Import(ctx, db, claims, f, format, mode, now)
+	if err != nil {
+		return err
+	}
+
+	for _, re := range res.Errors {
+		fmt.Printf("Row %d: %s\n", re.Row, re.Error)
+		for _, fe := range re.Fields {
+			fmt.Printf("  %s: %s\n", fe.Field, fe.Error)
+		}
+	}
+	fmt.Printf("Imported %d of %d products\n", res.Imported, res.Rows)
+	return nil
+}
+
+// exportData writes the products or sales of an organization to a file, or
+// to stdout when the path is "-".
+func exportData(cfg database.Config, orgID, from, to, format, what, path string) error {
+
+	var fn func(context.Context, *sqlx.DB, string, report.Range, io.Writer, string) (int, error)
+	switch what {
+	case "products":
+		fn = export.Products
+	case "sales":
+		fn = export.Sales
+	default:
+		return errors.New("export must be called with products or sales and a file path")
+	}
+	if path == "" {
+		return errors.New("export missing argument for file path")
+	}
+
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".ndjson", ".jsonl":
+			format = sheet.NDJSON
+		case ".xlsx":
+			format = sheet.XLSX
+		default:
+			format = sheet.CSV
+		}
+	}
+	if !sheet.Valid(format) {
+		return sheet.ErrFormat
+	}
+
+	var rng report.Range
+	var err error
+	rng.To = time.Now().UTC()
+	if to != "" {
+		if rng.To, err = time.Parse("2006-01-02", to); err != nil {
+			return errors.Wrap(err, "parsing export to date")
+		}
+	}
+	rng.From = rng.To.AddDate(0, 0, -30)
+	if from != "" {
+		if rng.From, err = time.Parse("2006-01-02", from); err != nil {
+			return errors.Wrap(err, "parsing export from date")
+		}
+	}
+
+	db, err := database.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	out := os.Stdout
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return errors.Wrap(err, "creating export file")
+		}
+		defer f.Close()
+		out = f
+	}
+
+	n, err := fn(context.Background(), db, orgID, rng, out, format)
+	if err != nil {
+
+		// Do not leave an incomplete file behind.
+		if out != os.Stdout {
+			os.Remove(path)
+		}
+		return err
+	}
+	if out != os.Stdout {
+		if err := out.Close(); err != nil {
+			return errors.Wrap(err, "closing export file")
+		}
+		fmt.Printf("Exported %d %s to %s\n", n, what, path)
+	}
+	return nil
+}
+
+// keygen creates an x509 private key for signing auth tokens. The type of key
+// is one of rsa (the default), ecdsa (P-256), ecdsa384 (P-384) or ed25519.
+func keygen(path, keyType string) error {
+
+	if path == "" {
+		return errors.New("keygen missing argument for key path")
+	}
+
+	var block pem.Block
+	switch keyType {
+	case "", "rsa":
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return errors.Wrap(err, "generating keys")
+		}
+		block = pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		}
+
+	case "ecdsa", "ecdsa384":
+		curve := elliptic.P256()
+		if keyType == "ecdsa384" {
+			curve = elliptic.P384()
+		}
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return errors.Wrap(err, "generating keys")
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return errors.Wrap(err, "marshaling private key")
+		}
+		block = pem.Block{
+			Type:  "EC PRIVATE KEY",
+			Bytes: der,
+		}
+
+	case "ed25519":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return errors.Wrap(err, "generating keys")
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return errors.Wrap(err, "marshaling private key")
+		}
+		block = pem.Block{
+			Type:  "PRIVATE KEY",
+			Bytes: der,
+		}
+
+	default:
+		return errors.Errorf("keygen unknown key type %q, expected rsa, ecdsa, ecdsa384 or ed25519", keyType)
 	}
 
 	file, err := os.Create(path)
@@ -177,11 +467,6 @@ func keygen(path string) error {
 	}
 	defer file.Close()
 
-	block := pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(key),
-	}
-
 	if err := pem.Encode(file, &block); err != nil {
 		return errors.Wrap(err, "encoding to private key file")
 	}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/sreejeet/garagesale/internal/apikey"
+	"github.com/sreejeet/garagesale/internal/platform/auth"
+	"github.com/sreejeet/garagesale/internal/platform/web"
+	"go.opencensus.io/trace"
+)
+
+// APIKeys holds handlers for managing the API keys of machine clients.
+type APIKeys struct {
+	db  *sqlx.DB
+	log *log.Logger
+}
+
+// Create generates a new API key. The response holds the full key,
+// which can not be retrieved again afterwards.
+func (a *APIKeys) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.APIKeys.Create")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var nk apikey.NewKey
+	if err := web.Decode(r, &nk); err != nil {
+		return errors.Wrap(err, "decoding api key")
+	}
+
+	k, err := apikey.Create(ctx, a.db, claims, nk, v.Start)
+	if err != nil {
+		switch err {
+		case apikey.ErrInvalidID, apikey.ErrUnknownRole:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case apikey.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		default:
+			return errors.Wrap(err, "creating api key")
+		}
+	}
+	a.log.Printf("%s : API KEY CREATED : %s (%s) by %s", v.TraceID, k.ID, k.Prefix, claims.Subject)
+
+	return web.Respond(ctx, w, k, http.StatusCreated)
+}
+
+// List returns a page of API keys without their secrets.
+func (a *APIKeys) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.APIKeys.List")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	page, limit, err := web.ParsePaging(r)
+	if err != nil {
+		return err
+	}
+
+	list, total, err := apikey.List(ctx, a.db, claims.OrgID, page, limit)
+	if err != nil {
+		return errors.Wrap(err, "listing api keys")
+	}
+
+	return web.Respond(ctx, w, web.NewPage(r, list, total, page, limit), http.StatusOK)
+}
+
+// Revoke makes an API key unusable from now on.
+func (a *APIKeys) Revoke(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.APIKeys.Revoke")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	if err := apikey.Revoke(ctx, a.db, claims.OrgID, id, time.Now()); err != nil {
+		switch err {
+		case apikey.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case apikey.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return errors.Wrapf(err, "revoking api key %q", id)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
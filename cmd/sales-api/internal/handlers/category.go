@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/sreejeet/garagesale/internal/category"
+	"github.com/sreejeet/garagesale/internal/platform/auth"
+	"github.com/sreejeet/garagesale/internal/platform/web"
+	"go.opencensus.io/trace"
+)
+
+// Categories holds handlers for the product categories of an organization.
+type Categories struct {
+	db  *sqlx.DB
+	log *log.Logger
+}
+
+// List returns every category of the active organization of the client.
+func (c *Categories) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Categories.List")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	list, err := category.List(ctx, c.db, claims.OrgID)
+	if err != nil {
+		return errors.Wrap(err, "listing categories")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// Retrieve returns the category from the url.
+func (c *Categories) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Categories.Retrieve")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	id := chi.URLParam(r, "id")
+	cat, err := category.Retrieve(ctx, c.db, claims.OrgID, id)
+	if err != nil {
+		return categoryError(err, id)
+	}
+
+	return web.Respond(ctx, w, cat, http.StatusOK)
+}
+
+// Create adds a category to the active organization of the client.
+func (c *Categories) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Categories.Create")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var nc category.NewCategory
+	if err := web.Decode(r, &nc); err != nil {
+		return errors.Wrap(err, "decoding new category")
+	}
+
+	cat, err := category.Create(ctx, c.db, claims.OrgID, nc, time.Now())
+	if err != nil {
+		return categoryError(err, nc.Name)
+	}
+
+	return web.Respond(ctx, w, cat, http.StatusCreated)
+}
+
+// Patch applies a JSON merge patch or JSON Patch document to the category
+// from the url. Categories are moved by changing their parent.
+func (c *Categories) Patch(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Categories.Patch")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	apply := func(e *category.EditCategory) error {
+		return web.Patch(w, r, e)
+	}
+
+	id := chi.URLParam(r, "id")
+	if err := category.Patch(ctx, c.db, claims.OrgID, id, apply, time.Now()); err != nil {
+		return categoryError(err, id)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Delete removes the category from the url. Its products are kept.
+func (c *Categories) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Categories.Delete")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	id := chi.URLParam(r, "id")
+	if err := category.Delete(ctx, c.db, claims.OrgID, id); err != nil {
+		return categoryError(err, id)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// categoryError maps the errors of the category package to web errors.
+func categoryError(err error, id string) error {
+	switch err {
+	case category.ErrInvalidID, category.ErrInvalidParent:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case category.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case category.ErrDuplicateName, category.ErrHasChildren:
+		return web.NewRequestError(err, http.StatusConflict)
+	default:
+		return errors.Wrapf(err, "category %q", id)
+	}
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/sreejeet/garagesale/internal/export"
+	"github.com/sreejeet/garagesale/internal/platform/auth"
+	"github.com/sreejeet/garagesale/internal/platform/sheet"
+	"github.com/sreejeet/garagesale/internal/platform/web"
+	"github.com/sreejeet/garagesale/internal/report"
+	"go.opencensus.io/trace"
+)
+
+// Exports holds the handlers downloading products and sales as files.
+type Exports struct {
+	db  *sqlx.DB
+	log *log.Logger
+}
+
+// exportFunc is the signature of the functions of the export package.
+type exportFunc func(ctx context.Context, db *sqlx.DB, orgID string, r report.Range, w io.Writer, format string) (int, error)
+
+// countFunc counts the rows an exportFunc would write.
+type countFunc func(ctx context.Context, db *sqlx.DB, orgID string, r report.Range) (int, error)
+
+// Products downloads the products created within the range.
+func (e *Exports) Products(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Exports.Products")
+	defer span.End()
+
+	return e.stream(ctx, w, r, "products", export.Products, export.CountProducts)
+}
+
+// Sales downloads the sales recorded within the range.
+func (e *Exports) Sales(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Exports.Sales")
+	defer span.End()
+
+	return e.stream(ctx, w, r, "sales", export.Sales, export.CountSales)
+}
+
+// stream runs an export straight into the response. The range is read like
+// the one of reports and the format query parameter is csv, the default,
+// ndjson or xlsx. Everything the client sent is checked up front because
+// once rows are streamed the status code can not change anymore, which is
+// also why XLSX exports are counted first and failures while streaming are
+// only logged. Exports still running when the write timeout of the server
+// passes are cut off, so very large ones are better made with sales-admin
+// export.
+func (e *Exports) stream(ctx context.Context, w http.ResponseWriter, r *http.Request, name string, fn exportFunc, count countFunc) error {
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	rng, err := parseRange(r)
+	if err != nil {
+		return err
+	}
+	if !rng.From.Before(rng.To) {
+		return web.NewRequestError(report.ErrInvalidRange, http.StatusBadRequest)
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = sheet.CSV
+	}
+	if !sheet.Valid(format) {
+		return web.NewRequestError(sheet.ErrFormat, http.StatusBadRequest)
+	}
+
+	// A sheet holds one row more than the export for the header.
+	if format == sheet.XLSX {
+		n, err := count(ctx, e.db, claims.OrgID, rng)
+		if err != nil {
+			return errors.Wrapf(err, "counting %s", name)
+		}
+		if n+1 > sheet.MaxXLSXRows {
+			return web.NewRequestError(sheet.ErrTooManyRows, http.StatusBadRequest)
+		}
+	}
+
+	// The export writes into a pipe the response is copied from. Closing
+	// the reading end stops the export when the client goes away.
+	pr, pw := io.Pipe()
+	exported := make(chan error, 1)
+	go func() {
+		_, err := fn(ctx, e.db, claims.OrgID, rng, pw, format)
+		pw.CloseWithError(err)
+		exported <- err
+	}()
+
+	filename := fmt.Sprintf("%s_%s_%s.%s", name, rng.From.Format("2006-01-02"), rng.To.Format("2006-01-02"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	err = web.RespondStream(ctx, w, pr, sheet.ContentType(format), http.StatusOK)
+	pr.Close()
+	if exportErr := <-exported; exportErr != nil && errors.Cause(exportErr) != io.ErrClosedPipe {
+		err = exportErr
+	}
+	if err != nil {
+		e.log.Printf("%s : EXPORT FAILED : %s : %+v", v.TraceID, name, err)
+	}
+	return nil
+}
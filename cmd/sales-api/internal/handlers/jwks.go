@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/sreejeet/garagesale/internal/platform/auth"
+	"github.com/sreejeet/garagesale/internal/platform/web"
+	"go.opencensus.io/trace"
+)
+
+// Keys holds the handler publishing the public keys used for tokens.
+type Keys struct {
+	keys      *auth.KeySet
+	algorithm string
+}
+
+// JWKS responds with the public keys of the key set so other services can
+// verify tokens issued by this service without calling it.
+func (k *Keys) JWKS(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Keys.JWKS")
+	defer span.End()
+
+	// The keys only change on restart so clients may cache them for a while.
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	return web.Respond(ctx, w, k.keys.JWKS(k.algorithm), http.StatusOK)
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/sreejeet/garagesale/internal/order"
+	"github.com/sreejeet/garagesale/internal/platform/auth"
+	"github.com/sreejeet/garagesale/internal/platform/web"
+	"github.com/sreejeet/garagesale/internal/product"
+	"go.opencensus.io/trace"
+)
+
+// Orders holds handlers for checking out and reading orders.
+type Orders struct {
+	db *sqlx.DB
+}
+
+// Checkout records a new order made of one or more product lines.
+// Either every line is sold or the whole order is rejected.
+func (o *Orders) Checkout(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Orders.Checkout")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var no order.NewOrder
+	if err := web.Decode(r, &no); err != nil {
+		return errors.Wrap(err, "decoding order")
+	}
+
+	ord, err := order.Checkout(ctx, o.db, claims, no, time.Now())
+	if err != nil {
+
+		// Line errors are wrapped with the product they belong to
+		// so the client can tell which line was rejected.
+		switch errors.Cause(err) {
+		case product.ErrInvalidID, product.ErrCurrency, order.ErrMixedCurrencies:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case product.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case product.ErrInsufficientStock:
+			return web.NewRequestError(err, http.StatusConflict)
+		default:
+			return errors.Wrap(err, "checking out order")
+		}
+	}
+
+	return web.Respond(ctx, w, ord, http.StatusCreated)
+}
+
+// Retrieve returns a single order with all of its lines.
+func (o *Orders) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Orders.Retrieve")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	ord, err := order.Retrieve(ctx, o.db, claims.OrgID, id)
+	if err != nil {
+		switch err {
+		case order.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case order.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return errors.Wrapf(err, "finding order %q", id)
+		}
+	}
+
+	return web.Respond(ctx, w, ord, http.StatusOK)
+}
+
+// List returns a page of orders, newest first.
+func (o *Orders) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Orders.List")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	page, limit, err := web.ParsePaging(r)
+	if err != nil {
+		return err
+	}
+
+	list, total, err := order.List(ctx, o.db, claims.OrgID, page, limit)
+	if err != nil {
+		return errors.Wrap(err, "listing orders")
+	}
+
+	return web.Respond(ctx, w, web.NewPage(r, list, total, page, limit), http.StatusOK)
+}
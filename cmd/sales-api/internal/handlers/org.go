@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/sreejeet/garagesale/internal/org"
+	"github.com/sreejeet/garagesale/internal/platform/auth"
+	"github.com/sreejeet/garagesale/internal/platform/mail"
+	"github.com/sreejeet/garagesale/internal/platform/web"
+	"go.opencensus.io/trace"
+)
+
+// Orgs holds handlers for organizations and their members.
+type Orgs struct {
+	db     *sqlx.DB
+	log    *log.Logger
+	mailer mail.Mailer
+
+	// publicURL is the address of the API as seen by clients. It is
+	// used in the invitations sent out by email.
+	publicURL string
+}
+
+// Create adds a new organization with the client as its first member.
+func (o *Orgs) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Orgs.Create")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var no org.NewOrg
+	if err := web.Decode(r, &no); err != nil {
+		return errors.Wrap(err, "decoding new organization")
+	}
+
+	created, err := org.Create(ctx, o.db, claims, no, time.Now())
+	if err != nil {
+		return errors.Wrap(err, "creating organization")
+	}
+
+	return web.Respond(ctx, w, created, http.StatusCreated)
+}
+
+// List returns the organizations the client is a member of. A token for any
+// of them can be requested with the org parameter of /v1/users/token.
+func (o *Orgs) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Orgs.List")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	orgs, err := org.ListForUser(ctx, o.db, claims.Subject)
+	if err != nil {
+		return errors.Wrap(err, "listing organizations")
+	}
+
+	return web.Respond(ctx, w, orgs, http.StatusOK)
+}
+
+// Members returns the members of the specified organization, which
+// must be the active organization of the client.
+func (o *Orgs) Members(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Orgs.Members")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	id := chi.URLParam(r, "id")
+	members, err := org.Members(ctx, o.db, claims, id)
+	if err != nil {
+		return orgError(err, id)
+	}
+
+	return web.Respond(ctx, w, members, http.StatusOK)
+}
+
+// RemoveMember takes a user out of the specified organization.
+func (o *Orgs) RemoveMember(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Orgs.RemoveMember")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	id := chi.URLParam(r, "id")
+	if err := org.RemoveMember(ctx, o.db, claims, id, chi.URLParam(r, "user_id")); err != nil {
+		return orgError(err, id)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Invite emails an invitation to join the specified organization.
+func (o *Orgs) Invite(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Orgs.Invite")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var ni org.NewInvite
+	if err := web.Decode(r, &ni); err != nil {
+		return errors.Wrap(err, "decoding invitation")
+	}
+
+	id := chi.URLParam(r, "id")
+	invited, token, err := org.Invite(ctx, o.db, claims, id, ni, v.Start)
+	if err != nil {
+		return orgError(err, id)
+	}
+
+	msg := mail.Message{
+		To:      ni.Email,
+		Subject: "You are invited to " + invited.Name + " on garagesale",
+		Body: "Hi,\r\n\r\n" +
+			"You have been invited to join " + invited.Name + ". Once you are signed in,\r\n" +
+			"accept the invitation by sending this token:\r\n\r\n" +
+			token + "\r\n\r\n" +
+			"to " + o.publicURL + "/v1/orgs/invites/accept\r\n" +
+			"The invitation expires in " + org.InviteTTL.String() + ".\r\n",
+	}
+	if err := o.mailer.Send(ctx, msg); err != nil {
+		return errors.Wrap(err, "sending invitation email")
+	}
+	o.log.Printf("%s : INVITE : %q to %s by %s", v.TraceID, ni.Email, id, claims.Subject)
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Accept makes the client a member of the organization it was invited to.
+func (o *Orgs) Accept(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Orgs.Accept")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var ai org.AcceptInvite
+	if err := web.Decode(r, &ai); err != nil {
+		return errors.Wrap(err, "decoding invitation")
+	}
+
+	joined, err := org.Accept(ctx, o.db, claims, ai.Token, time.Now())
+	if err != nil {
+		return orgError(err, "")
+	}
+
+	return web.Respond(ctx, w, joined, http.StatusOK)
+}
+
+// orgError maps the errors of the org package to web errors.
+func orgError(err error, id string) error {
+	switch err {
+	case org.ErrInvalidID:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case org.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case org.ErrForbidden:
+		return web.NewRequestError(err, http.StatusForbidden)
+	case org.ErrInvalidToken:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	default:
+		return errors.Wrapf(err, "organization %q", id)
+	}
+}
@@ -2,14 +2,21 @@ package handlers
 
 import (
 	"context"
+	"io"
+	"io/ioutil"
 	"log"
+	"mime"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 	"github.com/sreejeet/garagesale/internal/platform/auth"
+	"github.com/sreejeet/garagesale/internal/platform/storage"
 	"github.com/sreejeet/garagesale/internal/platform/web"
 	"github.com/sreejeet/garagesale/internal/product"
 	"go.opencensus.io/trace"
@@ -18,34 +25,194 @@ import (
 // Products define all handlers for products. It also holds
 // the application state needed by the handler methods.
 type Products struct {
-	db  *sqlx.DB
-	log *log.Logger
+	db    *sqlx.DB
+	log   *log.Logger
+	store storage.BlobStore
 }
 
-// List is an http handler for returning
-// a json list of products.
+// List is an http handler for returning a json page of products.
+// Clients can filter, sort and page through products using query parameters.
+// With facets=true the page also holds counts per category and tag for
+// navigation. They are only computed on request as they are costly.
 func (p *Products) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 
 	ctx, span := trace.StartSpan(ctx, "handlers.Product.List")
 	defer span.End()
 
-	list, err := product.List(ctx, p.db)
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	q, err := parseListQuery(r)
+	if err != nil {
+		return err
+	}
+	q.OrgID = claims.OrgID
+
+	withFacets, err := boolParam(r.URL.Query(), "facets")
+	if err != nil {
+		return err
+	}
+
+	list, total, err := product.List(ctx, p.db, q)
 	if err != nil {
-		return errors.Wrap(err, "Error listing products")
+		switch err {
+		case product.ErrInvalidID, product.ErrInvalidSort, product.ErrMissingCurrency:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "Error listing products")
+		}
+	}
+
+	page := productPage{
+		Page: web.NewPage(r, list, total, q.Page, q.Limit),
+	}
+	if withFacets {
+		page.Facets, err = product.ListFacets(ctx, p.db, q)
+		if err != nil {
+			return errors.Wrap(err, "Error counting product facets")
+		}
 	}
 
 	// Using the web.Respond helper to return json
-	return web.Respond(ctx, w, list, http.StatusOK)
+	return web.Respond(ctx, w, page, http.StatusOK)
+}
+
+// productPage is a page of products together with the facet counts
+// of every product matching the query when they were asked for.
+type productPage struct {
+	web.Page
+	Facets *product.Facets `json:"facets,omitempty"`
+}
+
+// Search is an http handler returning a json page of the products matching
+// the words in the q parameter, best matches first. It accepts the same
+// filters as List.
+func (p *Products) Search(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Product.Search")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	q, err := parseListQuery(r)
+	if err != nil {
+		return err
+	}
+	q.OrgID = claims.OrgID
+
+	q.Search = strings.TrimSpace(r.URL.Query().Get("q"))
+	if q.Search == "" {
+		return web.NewRequestError(errors.New("missing search query q"), http.StatusBadRequest)
+	}
+
+	list, total, err := product.List(ctx, p.db, q)
+	if err != nil {
+		switch err {
+		case product.ErrInvalidID, product.ErrInvalidSort, product.ErrMissingCurrency:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "Error searching products")
+		}
+	}
+
+	return web.Respond(ctx, w, web.NewPage(r, list, total, q.Page, q.Limit), http.StatusOK)
+}
+
+// parseListQuery reads the paging, filtering and sorting
+// parameters of a product list request.
+func parseListQuery(r *http.Request) (product.ListQuery, error) {
+
+	var q product.ListQuery
+	var err error
+
+	q.Page, q.Limit, err = web.ParsePaging(r)
+	if err != nil {
+		return q, err
+	}
+
+	v := r.URL.Query()
+	q.Name = v.Get("name")
+	q.UserID = v.Get("user_id")
+	q.CategoryID = v.Get("category")
+	q.Tags = v["tag"]
+	q.Currency = v.Get("currency")
+	q.Sort = v.Get("sort")
+
+	if q.MinCost, err = intParam(v, "min_cost"); err != nil {
+		return q, err
+	}
+	if q.MaxCost, err = intParam(v, "max_cost"); err != nil {
+		return q, err
+	}
+
+	if q.InStock, err = boolParam(v, "in_stock"); err != nil {
+		return q, err
+	}
+	if q.IncludeDeleted, err = boolParam(v, "include_deleted"); err != nil {
+		return q, err
+	}
+
+	return q, nil
+}
+
+// intParam returns the named query parameter as an int64
+// or nil when the parameter was not provided.
+func intParam(v url.Values, name string) (*int64, error) {
+
+	s := v.Get(name)
+	if s == "" {
+		return nil, nil
+	}
+
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return nil, web.NewRequestError(errors.Errorf("invalid %s %q", name, s), http.StatusBadRequest)
+	}
+
+	return &i, nil
+}
+
+// boolParam returns the named query parameter as a bool
+// or false when the parameter was not provided.
+func boolParam(v url.Values, name string) (bool, error) {
+
+	s := v.Get(name)
+	if s == "" {
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, web.NewRequestError(errors.Errorf("invalid %s %q", name, s), http.StatusBadRequest)
+	}
+
+	return b, nil
 }
 
 // Retrieve is used to get a single product based on its ID from the URL parameter.
+// Deleted products are only returned when the include_deleted parameter is set.
 func (p *Products) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 
 	ctx, span := trace.StartSpan(ctx, "handlers.Products.Retrieve")
 	defer span.End()
 
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	includeDeleted, err := boolParam(r.URL.Query(), "include_deleted")
+	if err != nil {
+		return err
+	}
+
 	id := chi.URLParam(r, "id")
-	prod, err := product.Retrieve(ctx, p.db, id)
+	prod, err := product.Retrieve(ctx, p.db, claims.OrgID, id, includeDeleted)
 	if err != nil {
 		switch err {
 		case product.ErrInvalidID:
@@ -57,10 +224,40 @@ func (p *Products) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.
 		}
 	}
 
+	// The version lets clients make conditional updates with If-Match.
+	w.Header().Set("ETag", etag(prod.Version))
+
 	// Using the web.Respond helper to return json
 	return web.Respond(ctx, w, prod, http.StatusOK)
 }
 
+// etag formats the version of a product as an entity tag.
+func etag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// ifMatch returns the product version required by the If-Match header of a
+// request or zero when any version may be changed. Tags that are not product
+// versions can never match so they fail with 412 Precondition Failed, and so
+// do weak tags as If-Match requires the strong comparison.
+func ifMatch(r *http.Request) (int, error) {
+
+	h := strings.TrimSpace(r.Header.Get("If-Match"))
+	if h == "" || h == "*" {
+		return 0, nil
+	}
+
+	s, err := strconv.Unquote(h)
+	if err == nil {
+		var version int
+		if version, err = strconv.Atoi(s); err == nil && version > 0 {
+			return version, nil
+		}
+	}
+
+	return 0, web.NewRequestError(errors.Errorf("invalid If-Match %q", h), http.StatusPreconditionFailed)
+}
+
 // Create is used to create a new product from the body of a request.
 // The created product is sent back to the client
 // in conformance to the RESTful architecture.
@@ -83,13 +280,73 @@ func (p *Products) Create(ctx context.Context, w http.ResponseWriter, r *http.Re
 	// Creating product in database
 	prod, err := product.Create(ctx, p.db, claims, newProd, time.Now())
 	if err != nil {
-		return errors.Wrap(err, "Error creating product")
+		switch err {
+		case product.ErrInvalidCategory:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "Error creating product")
+		}
 	}
 
 	// Using the web.Respond helper to return json
 	return web.Respond(ctx, w, &prod, http.StatusCreated)
 }
 
+// maxImportSize limits the size of an import file sent to the API.
+const maxImportSize = 32 << 20
+
+// Import creates products from a CSV or NDJSON file sent as the request body.
+// The format is taken from the Content-Type and the mode from the mode query
+// parameter, which defaults to all-or-nothing. The response lists the errors
+// of every rejected row and is a 422 when nothing was imported.
+func (p *Products) Import(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Products.Import")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var format string
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		format = product.FormatCSV
+	case "application/x-ndjson", "application/ndjson":
+		format = product.FormatNDJSON
+	default:
+		err := errors.New("content type must be text/csv or application/x-ndjson")
+		return web.NewRequestError(err, http.StatusUnsupportedMediaType)
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = product.ImportAllOrNothing
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxImportSize)
+	res, err := product.Import(ctx, p.db, claims, body, format, mode, time.Now())
+	if err != nil {
+		switch err {
+		case product.ErrImportMode, product.ErrImportHeader, product.ErrImportEmpty:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case product.ErrImportTooLarge:
+			return web.NewRequestError(err, http.StatusRequestEntityTooLarge)
+		}
+		if cause := errors.Cause(err); web.TooLarge(cause) {
+			return web.NewRequestError(cause, http.StatusRequestEntityTooLarge)
+		}
+		return errors.Wrap(err, "importing products")
+	}
+
+	if res.Imported == 0 {
+		return web.Respond(ctx, w, res, http.StatusUnprocessableEntity)
+	}
+	return web.Respond(ctx, w, res, http.StatusCreated)
+}
+
 // AddSale records a new sale transaction for a specific product.
 // It takes a NewSale object in json from and returns the added record to the caller.
 func (p *Products) AddSale(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
@@ -102,33 +359,125 @@ func (p *Products) AddSale(ctx context.Context, w http.ResponseWriter, r *http.R
 		return errors.Wrap(err, "decoding new sale")
 	}
 
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
 	productID := chi.URLParam(r, "id")
 
-	sale, err := product.AddSale(ctx, p.db, ns, productID, time.Now())
+	sale, err := product.AddSale(ctx, p.db, claims, ns, productID, time.Now())
 	if err != nil {
-		return errors.Wrap(err, "adding new sale")
+		switch err {
+		case product.ErrInvalidID, product.ErrCurrency:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case product.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case product.ErrInsufficientStock:
+			return web.NewRequestError(err, http.StatusConflict)
+		default:
+			return errors.Wrap(err, "adding new sale")
+		}
 	}
 
 	return web.Respond(ctx, w, sale, http.StatusCreated)
 }
 
+// RefundSale records a refund against a sale. The refund is returned as a
+// reversing sale entry while the original sale is left untouched.
+func (p *Products) RefundSale(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Products.RefundSale")
+	defer span.End()
+
+	var nr product.NewRefund
+	if err := web.Decode(r, &nr); err != nil {
+		return errors.Wrap(err, "decoding refund")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	refund, err := product.Refund(ctx, p.db, claims, id, nr, time.Now())
+	if err != nil {
+		return reversalError(err, id)
+	}
+
+	return web.Respond(ctx, w, refund, http.StatusCreated)
+}
+
+// VoidSale cancels a sale that was recorded by mistake.
+func (p *Products) VoidSale(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Products.VoidSale")
+	defer span.End()
+
+	var nv product.NewVoid
+	if err := web.Decode(r, &nv); err != nil {
+		return errors.Wrap(err, "decoding void")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	void, err := product.Void(ctx, p.db, claims, id, nv, time.Now())
+	if err != nil {
+		return reversalError(err, id)
+	}
+
+	return web.Respond(ctx, w, void, http.StatusCreated)
+}
+
+// reversalError maps the errors of refunds and voids to web errors.
+func reversalError(err error, id string) error {
+	switch err {
+	case product.ErrInvalidID, product.ErrCurrency:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case product.ErrSaleNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case product.ErrInvalidReversal:
+		return web.NewRequestError(err, http.StatusConflict)
+	default:
+		return errors.Wrapf(err, "reversing sale %q", id)
+	}
+}
+
 // ListSales lists all sales for a specific product.
 func (p *Products) ListSales(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 
 	ctx, span := trace.StartSpan(ctx, "handlers.Products.ListSales")
 	defer span.End()
 
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
 	id := chi.URLParam(r, "id")
 
-	list, err := product.ListSales(ctx, p.db, id)
+	list, err := product.ListSales(ctx, p.db, claims.OrgID, id)
 	if err != nil {
-		return errors.Wrap(err, "getting sales list")
+		switch err {
+		case product.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "getting sales list")
+		}
 	}
 
 	return web.Respond(ctx, w, list, http.StatusOK)
 }
 
 // Update takes the product id from the url and updates the fields that have been provided to it.
+// When the If-Match header is set the product is only updated if its ETag still matches.
 func (p *Products) Update(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 
 	ctx, span := trace.StartSpan(ctx, "handlers.Products.Update")
@@ -146,14 +495,23 @@ func (p *Products) Update(ctx context.Context, w http.ResponseWriter, r *http.Re
 		return errors.New("claims missing from context")
 	}
 
-	if err := product.Update(ctx, p.db, claims, id, update, time.Now()); err != nil {
+	version, err := ifMatch(r)
+	if err != nil {
+		return err
+	}
+
+	if err := product.Update(ctx, p.db, claims, id, version, update, time.Now()); err != nil {
 		switch err {
+		case product.ErrVersionConflict:
+			return web.NewRequestError(err, http.StatusPreconditionFailed)
 		case product.ErrNotFound:
 			return web.NewRequestError(err, http.StatusNotFound)
-		case product.ErrInvalidID:
+		case product.ErrInvalidID, product.ErrInvalidCategory:
 			return web.NewRequestError(err, http.StatusBadRequest)
 		case product.ErrForbidden:
 			return web.NewRequestError(err, http.StatusForbidden)
+		case product.ErrCurrencyChange:
+			return web.NewRequestError(err, http.StatusConflict)
 		default:
 			return errors.Wrapf(err, "updating product %q", id)
 		}
@@ -162,15 +520,63 @@ func (p *Products) Update(ctx context.Context, w http.ResponseWriter, r *http.Re
 	return web.Respond(ctx, w, nil, http.StatusNoContent)
 }
 
-// Delete removes a specific product from the database based on the give id.
+// Patch applies a JSON merge patch or JSON Patch document to the product from
+// the url. Like Update it honors the If-Match header.
+func (p *Products) Patch(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Products.Patch")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	version, err := ifMatch(r)
+	if err != nil {
+		return err
+	}
+
+	id := chi.URLParam(r, "id")
+
+	apply := func(e *product.EditProduct) error {
+		return web.Patch(w, r, e)
+	}
+
+	if err := product.Patch(ctx, p.db, claims, id, version, apply, time.Now()); err != nil {
+		switch err {
+		case product.ErrVersionConflict:
+			return web.NewRequestError(err, http.StatusPreconditionFailed)
+		case product.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case product.ErrInvalidID, product.ErrInvalidCategory:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case product.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		case product.ErrCurrencyChange:
+			return web.NewRequestError(err, http.StatusConflict)
+		default:
+			return errors.Wrapf(err, "patching product %q", id)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Delete archives a specific product based on the give id. Its sales are kept.
 func (p *Products) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 
 	ctx, span := trace.StartSpan(ctx, "handlers.Products.Delete")
 	defer span.End()
 
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
 	id := chi.URLParam(r, "id")
 
-	if err := product.Delete(ctx, p.db, id); err != nil {
+	if err := product.Delete(ctx, p.db, claims.OrgID, id, time.Now()); err != nil {
 		switch err {
 		case product.ErrInvalidID:
 			return web.NewRequestError(err, http.StatusBadRequest)
@@ -181,3 +587,176 @@ func (p *Products) Delete(ctx context.Context, w http.ResponseWriter, r *http.Re
 
 	return web.Respond(ctx, w, nil, http.StatusNoContent)
 }
+
+// Restore brings back a deleted product.
+func (p *Products) Restore(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Products.Restore")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	if err := product.Restore(ctx, p.db, claims.OrgID, id); err != nil {
+		switch err {
+		case product.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case product.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return errors.Wrapf(err, "restoring product %q", id)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// AddImage uploads an image of the product from the url. The image is either
+// the "image" field of a multipart form or the raw request body.
+func (p *Products) AddImage(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Products.AddImage")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	body := io.Reader(r.Body)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		r.Body = http.MaxBytesReader(w, r.Body, product.MaxImageSize+1<<20)
+		f, _, err := r.FormFile("image")
+		if err != nil {
+			return web.NewRequestError(errors.Wrap(err, "reading image field"), http.StatusBadRequest)
+		}
+		defer f.Close()
+		body = f
+	}
+
+	// Read one byte past the limit so oversized images are recognized.
+	data, err := ioutil.ReadAll(io.LimitReader(body, product.MaxImageSize+1))
+	if err != nil {
+		return web.NewRequestError(errors.Wrap(err, "reading image"), http.StatusBadRequest)
+	}
+
+	img, err := product.AddImage(ctx, p.db, p.store, claims, id, data, time.Now())
+	if err != nil {
+		return imageError(err, id)
+	}
+
+	return web.Respond(ctx, w, img, http.StatusCreated)
+}
+
+// ListImages returns the images of the product from the url.
+func (p *Products) ListImages(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Products.ListImages")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	images, err := product.ListImages(ctx, p.db, claims.OrgID, id)
+	if err != nil {
+		return imageError(err, id)
+	}
+
+	return web.Respond(ctx, w, images, http.StatusOK)
+}
+
+// Image sends the content of a product image.
+func (p *Products) Image(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Products.Image")
+	defer span.End()
+
+	return p.sendImage(ctx, w, r, false)
+}
+
+// Thumbnail sends the thumbnail of a product image.
+func (p *Products) Thumbnail(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Products.Thumbnail")
+	defer span.End()
+
+	return p.sendImage(ctx, w, r, true)
+}
+
+// sendImage streams an image or its thumbnail from the blob store.
+func (p *Products) sendImage(ctx context.Context, w http.ResponseWriter, r *http.Request, thumbnail bool) error {
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	img, err := product.RetrieveImage(ctx, p.db, claims.OrgID, id, chi.URLParam(r, "image_id"))
+	if err != nil {
+		return imageError(err, id)
+	}
+
+	key, contentType := img.Key(), img.ContentType
+	if thumbnail {
+		key, contentType = img.ThumbnailKey(), img.ThumbnailType()
+	}
+
+	rc, err := p.store.Get(ctx, key)
+	if err != nil {
+		return errors.Wrapf(err, "loading image %q", img.ID)
+	}
+	defer rc.Close()
+
+	// Images never change, a new upload gets a new ID.
+	w.Header().Set("Cache-Control", "private, max-age=86400, immutable")
+
+	return web.RespondStream(ctx, w, rc, contentType, http.StatusOK)
+}
+
+// DeleteImage removes an image of the product from the url.
+func (p *Products) DeleteImage(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Products.DeleteImage")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	if err := product.DeleteImage(ctx, p.db, p.store, claims, id, chi.URLParam(r, "image_id")); err != nil {
+		return imageError(err, id)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// imageError maps the errors of the image functions to responses.
+func imageError(err error, id string) error {
+	switch err {
+	case product.ErrInvalidID, product.ErrImageType:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case product.ErrNotFound, product.ErrImageNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case product.ErrForbidden:
+		return web.NewRequestError(err, http.StatusForbidden)
+	case product.ErrImageTooLarge:
+		return web.NewRequestError(err, http.StatusRequestEntityTooLarge)
+	default:
+		return errors.Wrapf(err, "handling images of product %q", id)
+	}
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/sreejeet/garagesale/internal/platform/auth"
+	"github.com/sreejeet/garagesale/internal/platform/web"
+	"github.com/sreejeet/garagesale/internal/report"
+	"go.opencensus.io/trace"
+)
+
+// These are the defaults of report parameters not set by the client.
+const (
+	defaultReportPeriod = 30 * 24 * time.Hour
+	defaultTopLimit     = 10
+)
+
+// Reports holds the handlers for the sales reports.
+type Reports struct {
+	db *sqlx.DB
+}
+
+// reportResult is the envelope of every report. Items
+// holds the rows of the report for the range.
+type reportResult struct {
+	report.Range
+	Items interface{} `json:"items"`
+}
+
+// Revenue reports the units sold and revenue made per day, week or month.
+func (rp *Reports) Revenue(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Reports.Revenue")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	rng, err := parseRange(r)
+	if err != nil {
+		return err
+	}
+
+	interval := r.URL.Query().Get("interval")
+	if interval == "" {
+		interval = "day"
+	}
+
+	periods, err := report.Revenue(ctx, rp.db, claims.OrgID, rng, interval)
+	if err != nil {
+		return reportError(err)
+	}
+
+	return web.Respond(ctx, w, reportResult{Range: rng, Items: periods}, http.StatusOK)
+}
+
+// TopProducts reports the best selling products by units or revenue.
+func (rp *Reports) TopProducts(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Reports.TopProducts")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	rng, err := parseRange(r)
+	if err != nil {
+		return err
+	}
+	limit, err := limitParam(r, defaultTopLimit)
+	if err != nil {
+		return err
+	}
+
+	by := r.URL.Query().Get("by")
+	if by == "" {
+		by = "units"
+	}
+
+	products, err := report.TopProducts(ctx, rp.db, claims.OrgID, rng, by, limit)
+	if err != nil {
+		return reportError(err)
+	}
+
+	return web.Respond(ctx, w, reportResult{Range: rng, Items: products}, http.StatusOK)
+}
+
+// SellThrough reports the products that sold the largest share of their quantity.
+func (rp *Reports) SellThrough(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Reports.SellThrough")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	rng, err := parseRange(r)
+	if err != nil {
+		return err
+	}
+	limit, err := limitParam(r, web.DefaultLimit)
+	if err != nil {
+		return err
+	}
+
+	rates, err := report.SellThrough(ctx, rp.db, claims.OrgID, rng, limit)
+	if err != nil {
+		return reportError(err)
+	}
+
+	return web.Respond(ctx, w, reportResult{Range: rng, Items: rates}, http.StatusOK)
+}
+
+// Discounts reports how much buyers paid below the cost of the products.
+func (rp *Reports) Discounts(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Reports.Discounts")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	rng, err := parseRange(r)
+	if err != nil {
+		return err
+	}
+
+	discounts, err := report.Discounts(ctx, rp.db, claims.OrgID, rng)
+	if err != nil {
+		return reportError(err)
+	}
+
+	return web.Respond(ctx, w, reportResult{Range: rng, Items: discounts}, http.StatusOK)
+}
+
+// Sellers reports the totals of the products of every seller.
+func (rp *Reports) Sellers(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Reports.Sellers")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	rng, err := parseRange(r)
+	if err != nil {
+		return err
+	}
+
+	sellers, err := report.Sellers(ctx, rp.db, claims.OrgID, rng)
+	if err != nil {
+		return reportError(err)
+	}
+
+	return web.Respond(ctx, w, reportResult{Range: rng, Items: sellers}, http.StatusOK)
+}
+
+// reportError maps the errors of the report functions to web errors.
+func reportError(err error) error {
+	switch err {
+	case report.ErrInvalidID, report.ErrInvalidRange, report.ErrInvalidInterval, report.ErrInvalidRanking:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	default:
+		return errors.Wrap(err, "computing report")
+	}
+}
+
+// parseRange reads the from and to query parameters which are either dates
+// or RFC 3339 timestamps. Like in report.Range, to is exclusive so a date
+// stops the range at the start of that day. To defaults to now and from to
+// 30 days before to.
+func parseRange(r *http.Request) (report.Range, error) {
+
+	var rng report.Range
+	v := r.URL.Query()
+
+	parse := func(name string) (time.Time, error) {
+		s := v.Get(name)
+		if t, err := time.Parse("2006-01-02", s); err == nil {
+			return t, nil
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return t, web.NewRequestError(errors.Errorf("invalid %s %q", name, s), http.StatusBadRequest)
+		}
+		return t.UTC(), nil
+	}
+
+	var err error
+	rng.To = time.Now().UTC()
+	if v.Get("to") != "" {
+		if rng.To, err = parse("to"); err != nil {
+			return rng, err
+		}
+	}
+	rng.From = rng.To.Add(-defaultReportPeriod)
+	if v.Get("from") != "" {
+		if rng.From, err = parse("from"); err != nil {
+			return rng, err
+		}
+	}
+
+	return rng, nil
+}
+
+// limitParam reads the limit query parameter. It falls back to def
+// when the parameter is missing and is capped at web.MaxLimit.
+func limitParam(r *http.Request, def int) (int, error) {
+
+	s := r.URL.Query().Get("limit")
+	if s == "" {
+		return def, nil
+	}
+
+	limit, err := strconv.Atoi(s)
+	if err != nil || limit < 1 {
+		return 0, web.NewRequestError(errors.Errorf("invalid limit %q", s), http.StatusBadRequest)
+	}
+	if limit > web.MaxLimit {
+		limit = web.MaxLimit
+	}
+
+	return limit, nil
+}
@@ -6,13 +6,19 @@ import (
 	"os"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/sreejeet/garagesale/internal/apikey"
+	"github.com/sreejeet/garagesale/internal/lockout"
 	"github.com/sreejeet/garagesale/internal/mid"
 	"github.com/sreejeet/garagesale/internal/platform/auth"
+	"github.com/sreejeet/garagesale/internal/platform/mail"
+	"github.com/sreejeet/garagesale/internal/platform/storage"
 	"github.com/sreejeet/garagesale/internal/platform/web"
+	"github.com/sreejeet/garagesale/internal/policy"
+	"github.com/sreejeet/garagesale/internal/session"
 )
 
 // API constructs an app instance with all application routes defined
-func API(shutdown chan os.Signal, db *sqlx.DB, log *log.Logger, authenticator *auth.Authenticator) http.Handler {
+func API(shutdown chan os.Signal, db *sqlx.DB, log *log.Logger, authenticator *auth.Authenticator, keys *auth.KeySet, mailer mail.Mailer, store storage.BlobStore, publicURL string, guard *lockout.Guard) http.Handler {
 
 	// App holds all the routes as well as the middleware chain
 	app := web.NewApp(
@@ -24,6 +30,22 @@ func API(shutdown chan os.Signal, db *sqlx.DB, log *log.Logger, authenticator *a
 		mid.Panics(log),
 	)
 
+	// Every authenticated route also checks the token has not been revoked
+	// and accepts API keys of machine clients.
+	authn := mid.Authenticate(authenticator, session.RevocationCheck(db), apikey.Authenticate(db))
+
+	// can builds the middleware requiring one of the permissions.
+	perms := policy.Resolver(db)
+	can := func(permissions ...string) web.Middleware {
+		return mid.HasPermission(perms, permissions...)
+	}
+
+	// Data of an organization can only be reached by clients acting in one.
+	inOrg := mid.HasOrg()
+
+	// Account routes are only for users themselves, never for API keys.
+	noKeys := mid.NoKeys()
+
 	{
 		c := Check{db: db}
 
@@ -31,30 +53,171 @@ func API(shutdown chan os.Signal, db *sqlx.DB, log *log.Logger, authenticator *a
 		app.Handle(http.MethodGet, "/v1/health", c.Health)
 	}
 
+	{
+		k := Keys{
+			keys:      keys,
+			algorithm: authenticator.Algorithm(),
+		}
+
+		// Public keys for verifying tokens
+		app.Handle(http.MethodGet, "/.well-known/jwks.json", k.JWKS)
+	}
+
 	{
 		// User authentication routes
-		u := Users{db: db, authenticator: authenticator}
+		u := Users{
+			db:            db,
+			log:           log,
+			authenticator: authenticator,
+			mailer:        mailer,
+			guard:         guard,
+			publicURL:     publicURL,
+		}
 		app.Handle(http.MethodGet, "/v1/users/token", u.Token)
+		app.Handle(http.MethodPost, "/v1/users/token/refresh", u.Refresh)
+		app.Handle(http.MethodPost, "/v1/users/logout", u.Logout, authn)
+
+		// Self service sign up routes
+		app.Handle(http.MethodPost, "/v1/users/register", u.Register)
+		app.Handle(http.MethodGet, "/v1/users/verify", u.Verify)
+		app.Handle(http.MethodPost, "/v1/users/verify/resend", u.ResendVerification)
+
+		// Password routes
+		app.Handle(http.MethodPut, "/v1/users/me/password", u.ChangePassword, authn, noKeys)
+		app.Handle(http.MethodPost, "/v1/users/password/forgot", u.ForgotPassword)
+		app.Handle(http.MethodPost, "/v1/users/password/reset", u.ResetPassword)
+
+		// User management routes. Users may read and update their own
+		// record, everything else requires permission to manage users.
+		// API keys can not reach the routes that change accounts.
+		app.Handle(http.MethodGet, "/v1/users", u.List, authn, inOrg, can(policy.UsersManage))
+		app.Handle(http.MethodPost, "/v1/users", u.Create, authn, inOrg, can(policy.UsersManage))
+		app.Handle(http.MethodGet, "/v1/users/me", u.Me, authn)
+		app.Handle(http.MethodGet, "/v1/users/{id}", u.Retrieve, authn, noKeys)
+		app.Handle(http.MethodPut, "/v1/users/{id}", u.Update, authn, noKeys)
+		app.Handle(http.MethodPatch, "/v1/users/{id}", u.Patch, authn, noKeys)
+		app.Handle(http.MethodDelete, "/v1/users/{id}", u.Delete, authn, inOrg, can(policy.UsersManage))
+		app.Handle(http.MethodDelete, "/v1/users/{id}/lockout", u.Unlock, authn, inOrg, can(policy.UsersManage))
+		app.Handle(http.MethodDelete, "/v1/users/{id}/sessions", u.RevokeSessions, authn, noKeys)
 	}
 
 	{
-		// All handlers inside this block must be authenticated using tokens
+		o := Orgs{
+			db:        db,
+			log:       log,
+			mailer:    mailer,
+			publicURL: publicURL,
+		}
 
-		p := Products{
+		// Organization routes. Org admins manage the members
+		// of the organization they are acting in.
+		app.Handle(http.MethodPost, "/v1/orgs", o.Create, authn, can(policy.OrgsCreate))
+		app.Handle(http.MethodGet, "/v1/orgs", o.List, authn)
+		app.Handle(http.MethodPost, "/v1/orgs/invites/accept", o.Accept, authn, noKeys)
+		app.Handle(http.MethodGet, "/v1/orgs/{id}/members", o.Members, authn, inOrg, can(policy.UsersManage))
+		app.Handle(http.MethodDelete, "/v1/orgs/{id}/members/{user_id}", o.RemoveMember, authn, inOrg, can(policy.UsersManage))
+		app.Handle(http.MethodPost, "/v1/orgs/{id}/invites", o.Invite, authn, inOrg, can(policy.UsersManage))
+	}
+
+	{
+		k := APIKeys{
+			db:  db,
+			log: log,
+		}
+
+		// API key management routes
+		app.Handle(http.MethodPost, "/v1/apikeys", k.Create, authn, inOrg, can(policy.APIKeysManage))
+		app.Handle(http.MethodGet, "/v1/apikeys", k.List, authn, inOrg, can(policy.APIKeysManage))
+		app.Handle(http.MethodDelete, "/v1/apikeys/{id}", k.Revoke, authn, inOrg, can(policy.APIKeysManage))
+	}
+
+	{
+		c := Categories{
 			db:  db,
 			log: log,
 		}
 
+		// Category routes. Everybody who can read products can browse them.
+		app.Handle(http.MethodGet, "/v1/categories", c.List, authn, inOrg, can(policy.ProductsRead))
+		app.Handle(http.MethodGet, "/v1/categories/{id}", c.Retrieve, authn, inOrg, can(policy.ProductsRead))
+		app.Handle(http.MethodPost, "/v1/categories", c.Create, authn, inOrg, can(policy.CategoriesManage))
+		app.Handle(http.MethodPatch, "/v1/categories/{id}", c.Patch, authn, inOrg, can(policy.CategoriesManage))
+		app.Handle(http.MethodDelete, "/v1/categories/{id}", c.Delete, authn, inOrg, can(policy.CategoriesManage))
+
+		t := Tags{
+			db:  db,
+			log: log,
+		}
+
+		// Tag routes. Products are tagged through the product routes.
+		app.Handle(http.MethodGet, "/v1/tags", t.List, authn, inOrg, can(policy.ProductsRead))
+		app.Handle(http.MethodGet, "/v1/tags/{id}", t.Retrieve, authn, inOrg, can(policy.ProductsRead))
+		app.Handle(http.MethodPost, "/v1/tags", t.Create, authn, inOrg, can(policy.TagsManage))
+		app.Handle(http.MethodPut, "/v1/tags/{id}", t.Rename, authn, inOrg, can(policy.TagsManage))
+		app.Handle(http.MethodDelete, "/v1/tags/{id}", t.Delete, authn, inOrg, can(policy.TagsManage))
+	}
+
+	{
+		// All handlers inside this block must be authenticated using tokens
+
+		p := Products{
+			db:    db,
+			log:   log,
+			store: store,
+		}
+
 		// Product specific routes
-		app.Handle(http.MethodGet, "/v1/products", p.List, mid.Authenticate(authenticator))
-		app.Handle(http.MethodGet, "/v1/products/{id}", p.Retrieve, mid.Authenticate(authenticator))
-		app.Handle(http.MethodPost, "/v1/products", p.Create, mid.Authenticate(authenticator))
-		app.Handle(http.MethodPut, "/v1/products/{id}", p.Update, mid.Authenticate(authenticator))
-		app.Handle(http.MethodDelete, "/v1/products/{id}", p.Delete, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+		app.Handle(http.MethodGet, "/v1/products", p.List, authn, inOrg, can(policy.ProductsRead))
+		app.Handle(http.MethodGet, "/v1/products/search", p.Search, authn, inOrg, can(policy.ProductsRead))
+		app.Handle(http.MethodGet, "/v1/products/{id}", p.Retrieve, authn, inOrg, can(policy.ProductsRead))
+		app.Handle(http.MethodPost, "/v1/products", p.Create, authn, inOrg, can(policy.ProductsCreate))
+		app.Handle(http.MethodPost, "/v1/products/import", p.Import, authn, inOrg, can(policy.ProductsCreate))
+		app.Handle(http.MethodPut, "/v1/products/{id}", p.Update, authn, inOrg, can(policy.ProductsUpdate, policy.ProductsUpdateOwn))
+		app.Handle(http.MethodPatch, "/v1/products/{id}", p.Patch, authn, inOrg, can(policy.ProductsUpdate, policy.ProductsUpdateOwn))
+		app.Handle(http.MethodDelete, "/v1/products/{id}", p.Delete, authn, inOrg, can(policy.ProductsDelete))
+		app.Handle(http.MethodPost, "/v1/products/{id}/restore", p.Restore, authn, inOrg, can(policy.ProductsRestore))
+
+		// Product image routes. Images can be changed by whoever can update the product.
+		app.Handle(http.MethodPost, "/v1/products/{id}/images", p.AddImage, authn, inOrg, can(policy.ProductsUpdate, policy.ProductsUpdateOwn))
+		app.Handle(http.MethodGet, "/v1/products/{id}/images", p.ListImages, authn, inOrg, can(policy.ProductsRead))
+		app.Handle(http.MethodGet, "/v1/products/{id}/images/{image_id}", p.Image, authn, inOrg, can(policy.ProductsRead))
+		app.Handle(http.MethodGet, "/v1/products/{id}/images/{image_id}/thumbnail", p.Thumbnail, authn, inOrg, can(policy.ProductsRead))
+		app.Handle(http.MethodDelete, "/v1/products/{id}/images/{image_id}", p.DeleteImage, authn, inOrg, can(policy.ProductsUpdate, policy.ProductsUpdateOwn))
 
 		// Sale specific routes
-		app.Handle(http.MethodPost, "/v1/products/{id}/sales", p.AddSale, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
-		app.Handle(http.MethodGet, "/v1/products/{id}/sales", p.ListSales, mid.Authenticate(authenticator))
+		app.Handle(http.MethodPost, "/v1/products/{id}/sales", p.AddSale, authn, inOrg, can(policy.SalesCreate))
+		app.Handle(http.MethodGet, "/v1/products/{id}/sales", p.ListSales, authn, inOrg, can(policy.SalesRead))
+		app.Handle(http.MethodPost, "/v1/sales/{id}/refund", p.RefundSale, authn, inOrg, can(policy.SalesReverse))
+		app.Handle(http.MethodPost, "/v1/sales/{id}/void", p.VoidSale, authn, inOrg, can(policy.SalesReverse))
+
+		o := Orders{db: db}
+
+		// Order specific routes
+		app.Handle(http.MethodPost, "/v1/orders", o.Checkout, authn, inOrg, can(policy.OrdersCreate))
+		app.Handle(http.MethodGet, "/v1/orders", o.List, authn, inOrg, can(policy.OrdersRead))
+		app.Handle(http.MethodGet, "/v1/orders/{id}", o.Retrieve, authn, inOrg, can(policy.OrdersRead))
+	}
+
+	{
+		rp := Reports{db: db}
+
+		// Report routes are limited to admins and auditors.
+		app.Handle(http.MethodGet, "/v1/reports/revenue", rp.Revenue, authn, inOrg, can(policy.ReportsRead))
+		app.Handle(http.MethodGet, "/v1/reports/top-products", rp.TopProducts, authn, inOrg, can(policy.ReportsRead))
+		app.Handle(http.MethodGet, "/v1/reports/sell-through", rp.SellThrough, authn, inOrg, can(policy.ReportsRead))
+		app.Handle(http.MethodGet, "/v1/reports/discounts", rp.Discounts, authn, inOrg, can(policy.ReportsRead))
+		app.Handle(http.MethodGet, "/v1/reports/sellers", rp.Sellers, authn, inOrg, can(policy.ReportsRead))
+	}
+
+	{
+		e := Exports{
+			db:  db,
+			log: log,
+		}
+
+		// Exports hold the same figures as reports so they share the policy.
+		app.Handle(http.MethodGet, "/v1/exports/products", e.Products, authn, inOrg, can(policy.ReportsRead))
+		app.Handle(http.MethodGet, "/v1/exports/sales", e.Sales, authn, inOrg, can(policy.ReportsRead))
 	}
 
 	return app
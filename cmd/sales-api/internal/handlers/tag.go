@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/sreejeet/garagesale/internal/platform/auth"
+	"github.com/sreejeet/garagesale/internal/platform/web"
+	"github.com/sreejeet/garagesale/internal/tag"
+	"go.opencensus.io/trace"
+)
+
+// Tags holds handlers for the product tags of an organization.
+type Tags struct {
+	db  *sqlx.DB
+	log *log.Logger
+}
+
+// List returns every tag of the active organization of the client
+// with the number of products carrying it.
+func (t *Tags) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Tags.List")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	list, err := tag.List(ctx, t.db, claims.OrgID)
+	if err != nil {
+		return errors.Wrap(err, "listing tags")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// Retrieve returns the tag from the url.
+func (t *Tags) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Tags.Retrieve")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	id := chi.URLParam(r, "id")
+	tg, err := tag.Retrieve(ctx, t.db, claims.OrgID, id)
+	if err != nil {
+		return tagError(err, id)
+	}
+
+	return web.Respond(ctx, w, tg, http.StatusOK)
+}
+
+// Create adds a tag to the active organization of the client.
+func (t *Tags) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Tags.Create")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var nt tag.NewTag
+	if err := web.Decode(r, &nt); err != nil {
+		return errors.Wrap(err, "decoding new tag")
+	}
+
+	tg, err := tag.Create(ctx, t.db, claims.OrgID, nt, time.Now())
+	if err != nil {
+		return tagError(err, nt.Name)
+	}
+
+	return web.Respond(ctx, w, tg, http.StatusCreated)
+}
+
+// Rename changes the name of the tag from the url.
+func (t *Tags) Rename(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Tags.Rename")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var nt tag.NewTag
+	if err := web.Decode(r, &nt); err != nil {
+		return errors.Wrap(err, "decoding tag")
+	}
+
+	id := chi.URLParam(r, "id")
+	if err := tag.Rename(ctx, t.db, claims.OrgID, id, nt); err != nil {
+		return tagError(err, id)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Delete removes the tag from the url and from every product carrying it.
+func (t *Tags) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Tags.Delete")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	id := chi.URLParam(r, "id")
+	if err := tag.Delete(ctx, t.db, claims.OrgID, id); err != nil {
+		return tagError(err, id)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// tagError maps the errors of the tag package to web errors.
+func tagError(err error, id string) error {
+	switch err {
+	case tag.ErrInvalidID:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case tag.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case tag.ErrDuplicateName:
+		return web.NewRequestError(err, http.StatusConflict)
+	default:
+		return errors.Wrapf(err, "tag %q", id)
+	}
+}
@@ -2,12 +2,22 @@ package handlers
 
 import (
 	"context"
+	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
+	"github.com/go-chi/chi"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
+	"github.com/sreejeet/garagesale/internal/apikey"
+	"github.com/sreejeet/garagesale/internal/lockout"
 	"github.com/sreejeet/garagesale/internal/platform/auth"
+	"github.com/sreejeet/garagesale/internal/platform/mail"
 	"github.com/sreejeet/garagesale/internal/platform/web"
+	"github.com/sreejeet/garagesale/internal/session"
 	"github.com/sreejeet/garagesale/internal/user"
 	"go.opencensus.io/trace"
 )
@@ -15,10 +25,19 @@ import (
 // Users holds handlers for dealing with user.
 type Users struct {
 	db            *sqlx.DB
+	log           *log.Logger
 	authenticator *auth.Authenticator
+	mailer        mail.Mailer
+	guard         *lockout.Guard
+
+	// publicURL is the address of the API as seen by clients. It is
+	// used to build the links sent out by email.
+	publicURL string
 }
 
 // Token creates an auth token for the user after authenticating themselves with an email and password.
+// The organization the token acts in can be chosen with the org query parameter; by default it is
+// the organization the user joined first.
 func (u *Users) Token(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 
 	ctx, span := trace.StartSpan(ctx, "handlers.Users.Token")
@@ -36,22 +55,76 @@ func (u *Users) Token(ctx context.Context, w http.ResponseWriter, r *http.Reques
 		return web.NewRequestError(err, http.StatusUnauthorized)
 	}
 
+	// Refuse the attempt outright while the account or the address
+	// is locked out or still has to wait after earlier failures.
+	addr := remoteAddr(r)
+	if err := u.guard.Check(ctx, email, addr, v.Start); err != nil {
+		return lockedError(w, err)
+	}
+
 	// Use the email and password to authenticate from the database.
 	// If the user is authenticated, get the claims of the user.
 	claims, err := user.Authenticate(ctx, u.db, v.Start, email, pass)
 	if err != nil {
 		switch err {
 		case user.ErrAuthenticationFailure:
+			failures, locked, ferr := u.guard.Fail(ctx, email, addr, v.Start)
+			if ferr != nil {
+				return errors.Wrap(ferr, "recording failed login")
+			}
+			u.log.Printf("%s : AUTH FAILURE : account %q from %s, %d failures", v.TraceID, email, addr, failures)
+			if locked != nil {
+				u.log.Printf("%s : LOCKOUT : account %q from %s : %s", v.TraceID, email, addr, locked)
+			}
 			return web.NewRequestError(err, http.StatusUnauthorized)
+		case user.ErrNotVerified:
+			return web.NewRequestError(err, http.StatusForbidden)
 		default:
 			return errors.Wrap(err, "authenticating")
 		}
 	}
 
+	if err := u.guard.Succeed(ctx, email); err != nil {
+		return errors.Wrap(err, "clearing failed logins")
+	}
+
+	if orgID := r.URL.Query().Get("org"); orgID != "" && orgID != claims.OrgID {
+		claims, err = user.Claims(ctx, u.db, claims.Subject, orgID, v.Start)
+		if err != nil {
+			return userError(err, email)
+		}
+	}
+
+	return u.respondToken(ctx, w, claims, v.Start)
+}
+
+// tokenResponse is returned whenever a user logs in or refreshes their session.
+type tokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// respondToken creates an access token for the claims and starts
+// a new session for the user with a fresh refresh token.
+func (u *Users) respondToken(ctx context.Context, w http.ResponseWriter, claims auth.Claims, now time.Time) error {
+
+	refresh, err := session.Issue(ctx, u.db, claims.Subject, claims.OrgID, now)
+	if err != nil {
+		return errors.Wrap(err, "issuing refresh token")
+	}
+
+	return u.respondTokens(ctx, w, claims, refresh)
+}
+
+// respondTokens responds with an access token for the claims and a refresh token.
+func (u *Users) respondTokens(ctx context.Context, w http.ResponseWriter, claims auth.Claims, refresh string) error {
+
 	// Create a new token usingthe claims of the user returned from the databse.
-	var tkn struct {
-		Token string `json:"token"`
+	tkn := tokenResponse{
+		RefreshToken: refresh,
 	}
+
+	var err error
 	tkn.Token, err = u.authenticator.GenerateToken(claims)
 	if err != nil {
 		return errors.Wrap(err, "generating token")
@@ -59,3 +132,502 @@ func (u *Users) Token(ctx context.Context, w http.ResponseWriter, r *http.Reques
 
 	return web.Respond(ctx, w, tkn, http.StatusOK)
 }
+
+// Refresh exchanges a refresh token for a new access token and a new refresh
+// token. Each refresh token can only be used once.
+func (u *Users) Refresh(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.Refresh")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var req struct {
+		RefreshToken string `json:"refresh_token" validate:"required"`
+	}
+	if err := web.Decode(r, &req); err != nil {
+		return errors.Wrap(err, "decoding refresh request")
+	}
+
+	userID, orgID, refresh, err := session.Rotate(ctx, u.db, req.RefreshToken, v.Start)
+	if err != nil {
+		switch err {
+		case session.ErrTokenReuse:
+			u.log.Printf("%s : REFRESH TOKEN REUSE : session revoked", v.TraceID)
+			return web.NewRequestError(err, http.StatusUnauthorized)
+		case session.ErrInvalidToken:
+			return web.NewRequestError(err, http.StatusUnauthorized)
+		default:
+			return errors.Wrap(err, "rotating refresh token")
+		}
+	}
+
+	// Users removed from the organization of the session have to log in again.
+	claims, err := user.Claims(ctx, u.db, userID, orgID, v.Start)
+	if err != nil {
+		switch err {
+		case user.ErrAuthenticationFailure, user.ErrNotVerified, user.ErrForbidden:
+			return web.NewRequestError(err, http.StatusUnauthorized)
+		default:
+			return errors.Wrap(err, "refreshing claims")
+		}
+	}
+
+	return u.respondTokens(ctx, w, claims, refresh)
+}
+
+// Logout revokes the access token used for the request. When a refresh token
+// is provided in the body, the session it belongs to is ended as well.
+func (u *Users) Logout(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.Logout")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	// The body is optional for clients that only hold an access token.
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if r.ContentLength > 0 {
+		if err := web.Decode(r, &req); err != nil {
+			return errors.Wrap(err, "decoding logout request")
+		}
+	}
+
+	if err := session.Logout(ctx, u.db, claims, req.RefreshToken, time.Now()); err != nil {
+		return errors.Wrap(err, "logging out")
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// RevokeSessions ends every session of the specified user and revokes their
+// API keys. Users may end their own sessions, admins may end anyone's.
+func (u *Users) RevokeSessions(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.RevokeSessions")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	id := chi.URLParam(r, "id")
+	if _, err := user.Retrieve(ctx, claims, u.db, id); err != nil {
+		return userError(err, id)
+	}
+
+	if err := session.RevokeAll(ctx, u.db, id, v.Start); err != nil {
+		return errors.Wrapf(err, "revoking sessions of user %q", id)
+	}
+	if err := apikey.RevokeAll(ctx, u.db, id, v.Start); err != nil {
+		return errors.Wrapf(err, "revoking api keys of user %q", id)
+	}
+	u.log.Printf("%s : SESSIONS REVOKED : user %s by %s", v.TraceID, id, claims.Subject)
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Unlock clears the failed logins and any lockout of the specified user, who
+// must be a member of the active organization.
+func (u *Users) Unlock(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.Unlock")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	id := chi.URLParam(r, "id")
+	usr, err := user.Retrieve(ctx, claims, u.db, id)
+	if err != nil {
+		return userError(err, id)
+	}
+
+	if err := u.guard.Unlock(ctx, usr.Email); err != nil {
+		return errors.Wrapf(err, "unlocking user %q", id)
+	}
+	u.log.Printf("%s : UNLOCK : account %q by %s", v.TraceID, usr.Email, claims.Subject)
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// remoteAddr returns the IP address of the client without the port.
+func remoteAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// lockedError converts a lockout error into a 429 response
+// telling the client when to try again.
+func lockedError(w http.ResponseWriter, err error) error {
+
+	lerr, ok := errors.Cause(err).(*lockout.LockedError)
+	if !ok {
+		return errors.Wrap(err, "checking failed logins")
+	}
+
+	wait := time.Until(lerr.Until)
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+
+	return web.NewRequestError(lerr, http.StatusTooManyRequests)
+}
+
+// Register signs up a new regular user. The account stays unverified until
+// the link sent to the provided email address is followed. Nothing is saved
+// when the email can not be sent so the address can be used again.
+func (u *Users) Register(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.Register")
+	defer span.End()
+
+	var reg user.Registration
+	if err := web.Decode(r, &reg); err != nil {
+		return errors.Wrap(err, "decoding registration")
+	}
+
+	usr, err := user.Register(ctx, u.db, reg, time.Now(), u.sendVerification)
+	if err != nil {
+		return userError(err, reg.Email)
+	}
+
+	return web.Respond(ctx, w, usr, http.StatusCreated)
+}
+
+// ResendVerification emails a new verification link to an unverified user.
+// The response is the same whether or not the email belongs to one so this
+// can not be used to find out who has an account.
+func (u *Users) ResendVerification(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.ResendVerification")
+	defer span.End()
+
+	var req user.VerificationRequest
+	if err := web.Decode(r, &req); err != nil {
+		return errors.Wrap(err, "decoding verification request")
+	}
+
+	err := user.ResendVerification(ctx, u.db, req.Email, time.Now(), u.sendVerification)
+	if err != nil && err != user.ErrNotFound {
+		return errors.Wrap(err, "resending verification")
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// sendVerification emails the link confirming the address of a user.
+func (u *Users) sendVerification(ctx context.Context, usr user.User, token string) error {
+
+	link := u.publicURL + "/v1/users/verify?token=" + url.QueryEscape(token)
+	msg := mail.Message{
+		To:      usr.Email,
+		Subject: "Confirm your garagesale account",
+		Body: "Hi " + usr.Name + ",\r\n\r\n" +
+			"Please confirm your email address by following this link:\r\n\r\n" +
+			link + "\r\n\r\n" +
+			"The link expires in " + user.VerificationTTL.String() + ".\r\n",
+	}
+
+	return u.mailer.Send(ctx, msg)
+}
+
+// Verify confirms the email address of a registered user
+// using the token from the verification email.
+func (u *Users) Verify(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.Verify")
+	defer span.End()
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		err := errors.New("must provide a verification token")
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	if err := user.Verify(ctx, u.db, token, time.Now()); err != nil {
+		switch err {
+		case user.ErrInvalidToken:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "verifying user")
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// ChangePassword replaces the password of the user making the request.
+// The current password has to be provided as well.
+func (u *Users) ChangePassword(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.ChangePassword")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var pc user.PasswordChange
+	if err := web.Decode(r, &pc); err != nil {
+		return errors.Wrap(err, "decoding password change")
+	}
+
+	if err := user.ChangePassword(ctx, u.db, claims.Subject, pc, time.Now()); err != nil {
+		return userError(err, claims.Subject)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// ForgotPassword emails a password reset token to the provided address.
+// The response is the same whether or not the email belongs to a user so
+// this can not be used to find out who has an account.
+func (u *Users) ForgotPassword(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.ForgotPassword")
+	defer span.End()
+
+	var req user.PasswordResetRequest
+	if err := web.Decode(r, &req); err != nil {
+		return errors.Wrap(err, "decoding password reset request")
+	}
+
+	usr, token, err := user.RequestPasswordReset(ctx, u.db, req.Email, time.Now())
+	switch err {
+	case nil:
+	case user.ErrNotFound:
+		return web.Respond(ctx, w, nil, http.StatusNoContent)
+	default:
+		return errors.Wrap(err, "requesting password reset")
+	}
+
+	msg := mail.Message{
+		To:      usr.Email,
+		Subject: "Reset your garagesale password",
+		Body: "Hi " + usr.Name + ",\r\n\r\n" +
+			"Someone asked to reset the password of your account. If it was you,\r\n" +
+			"use this token to choose a new password:\r\n\r\n" +
+			token + "\r\n\r\n" +
+			"It can be sent to " + u.publicURL + "/v1/users/password/reset\r\n" +
+			"and expires in " + user.ResetTTL.String() + ". If it was not you, ignore this email.\r\n",
+	}
+	if err := u.mailer.Send(ctx, msg); err != nil {
+		return errors.Wrap(err, "sending password reset email")
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// ResetPassword chooses a new password using a password reset token.
+func (u *Users) ResetPassword(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.ResetPassword")
+	defer span.End()
+
+	var pr user.PasswordReset
+	if err := web.Decode(r, &pr); err != nil {
+		return errors.Wrap(err, "decoding password reset")
+	}
+
+	if err := user.ResetPassword(ctx, u.db, pr, time.Now()); err != nil {
+		switch err {
+		case user.ErrInvalidToken:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "resetting password")
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// List returns the members of the active organization.
+func (u *Users) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.List")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	users, err := user.List(ctx, u.db, claims.OrgID)
+	if err != nil {
+		return errors.Wrap(err, "listing users")
+	}
+
+	return web.Respond(ctx, w, users, http.StatusOK)
+}
+
+// Retrieve returns the specified user from the system.
+func (u *Users) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.Retrieve")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	return u.respondUser(ctx, w, claims, chi.URLParam(r, "id"))
+}
+
+// Me returns the user making the request.
+func (u *Users) Me(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.Me")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	return u.respondUser(ctx, w, claims, claims.Subject)
+}
+
+// respondUser retrieves a user and responds with it.
+func (u *Users) respondUser(ctx context.Context, w http.ResponseWriter, claims auth.Claims, id string) error {
+
+	usr, err := user.Retrieve(ctx, claims, u.db, id)
+	if err != nil {
+		return userError(err, id)
+	}
+
+	return web.Respond(ctx, w, usr, http.StatusOK)
+}
+
+// Create inserts a new user into the system as a member of the active organization.
+func (u *Users) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.Create")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var nu user.NewUser
+	if err := web.Decode(r, &nu); err != nil {
+		return errors.Wrap(err, "decoding new user")
+	}
+
+	usr, err := user.Create(ctx, u.db, claims.OrgID, nu, time.Now())
+	if err != nil {
+		return userError(err, nu.Email)
+	}
+
+	return web.Respond(ctx, w, usr, http.StatusCreated)
+}
+
+// Update updates the specified user in the system. A changed email address
+// has to be confirmed through the link sent to it before it can log in.
+func (u *Users) Update(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.Update")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var upd user.UpdateUser
+	if err := web.Decode(r, &upd); err != nil {
+		return errors.Wrap(err, "decoding user update")
+	}
+
+	id := chi.URLParam(r, "id")
+	if err := user.Update(ctx, claims, u.db, id, upd, time.Now(), u.sendVerification); err != nil {
+		return userError(err, id)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Patch applies a JSON merge patch or JSON Patch document to the specified user.
+func (u *Users) Patch(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.Patch")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	apply := func(e *user.EditUser) error {
+		return web.Patch(w, r, e)
+	}
+
+	id := chi.URLParam(r, "id")
+	if err := user.Patch(ctx, claims, u.db, id, apply, time.Now(), u.sendVerification); err != nil {
+		return userError(err, id)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Delete removes the specified user from the system.
+func (u *Users) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Users.Delete")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	id := chi.URLParam(r, "id")
+	if err := user.Delete(ctx, claims, u.db, id); err != nil {
+		return userError(err, id)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// userError maps the errors of the user package to web errors.
+func userError(err error, id string) error {
+	switch err {
+	case user.ErrInvalidID, user.ErrUnknownRole:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case user.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case user.ErrForbidden:
+		return web.NewRequestError(err, http.StatusForbidden)
+	case user.ErrDuplicateEmail:
+		return web.NewRequestError(err, http.StatusConflict)
+	case user.ErrAuthenticationFailure:
+		return web.NewRequestError(err, http.StatusUnauthorized)
+	default:
+		return errors.Wrapf(err, "user %q", id)
+	}
+}
@@ -2,9 +2,7 @@ package main
 
 import (
 	"context"
-	"crypto/rsa"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
@@ -20,12 +18,15 @@ import (
 	_ "net/http/pprof" // Register pprof handlers
 
 	"contrib.go.opencensus.io/exporter/zipkin"
-	"github.com/dgrijalva/jwt-go"
 	"github.com/pkg/errors"
 	"github.com/sreejeet/garagesale/cmd/sales-api/internal/handlers"
+	"github.com/sreejeet/garagesale/internal/lockout"
 	"github.com/sreejeet/garagesale/internal/platform/auth"
 	"github.com/sreejeet/garagesale/internal/platform/conf"
 	"github.com/sreejeet/garagesale/internal/platform/database"
+	"github.com/sreejeet/garagesale/internal/platform/mail"
+	"github.com/sreejeet/garagesale/internal/platform/storage"
+	"github.com/sreejeet/garagesale/internal/platform/web"
 	"go.opencensus.io/trace"
 )
 
@@ -48,6 +49,7 @@ func run() error {
 			ReadTimeout     time.Duration `conf:"default:5s"`
 			WriteTimeout    time.Duration `conf:"default:5s"`
 			ShutdownTimeout time.Duration `conf:"default:5s"`
+			PublicURL       string        `conf:"default:http://localhost:8000"`
 		}
 		DB struct {
 			User     string `conf:"default:postgres"`
@@ -59,9 +61,53 @@ func run() error {
 			DisableTLS bool `conf:"default:true"`
 		}
 		Auth struct {
-			KeyID          string `conf:"default:1"`
-			PrivateKeyFile string `conf:"default:private.pem"`
-			Algorithm      string `conf:"default:RS256"`
+			// KeysFolder holds one PEM file per key named <kid>.pem. Only the
+			// active key is used for signing, all keys are used for verifying.
+			KeysFolder string `conf:"default:keys"`
+			ActiveKID  string `conf:"default:1"`
+			// Algorithm is detected from the type of the active key when blank.
+			Algorithm string
+			// Issuer and Audience must differ between environments sharing keys
+			// so tokens of one environment are refused by the others.
+			Issuer        string        `conf:"default:garagesale"`
+			Audience      string        `conf:"default:sales-api"`
+			TokenLifetime time.Duration `conf:"default:1h"`
+			Leeway        time.Duration `conf:"default:30s"`
+		}
+		Password struct {
+			MinLength int `conf:"default:8"`
+			// Banned passwords are added to the built in list of common passwords.
+			Banned []string
+		}
+		Lockout struct {
+			// Store is where failed logins are tracked: postgres or memory.
+			Store           string        `conf:"default:postgres"`
+			MaxFailures     int           `conf:"default:5"`
+			AddrMaxFailures int           `conf:"default:50"`
+			Duration        time.Duration `conf:"default:15m"`
+			BaseDelay       time.Duration `conf:"default:1s"`
+			MaxDelay        time.Duration `conf:"default:30s"`
+			Window          time.Duration `conf:"default:15m"`
+		}
+		Mail struct {
+			From string `conf:"default:garagesale@localhost"`
+			// Messages are written to the outbox directory
+			// unless an SMTP host is configured.
+			Outbox       string `conf:"default:outbox"`
+			SMTPHost     string
+			SMTPPort     int `conf:"default:587"`
+			SMTPUser     string
+			SMTPPassword string `conf:"noprint"`
+		}
+		Storage struct {
+			// Kind is where product images are kept: fs or s3.
+			Kind        string `conf:"default:fs"`
+			Dir         string `conf:"default:images"`
+			S3Endpoint  string
+			S3Region    string `conf:"default:us-east-1"`
+			S3Bucket    string
+			S3AccessKey string
+			S3SecretKey string `conf:"noprint"`
 		}
 		Trace struct {
 			URL         string  `conf:"default:http://localhost:9411/api/v2/spans"`
@@ -86,16 +132,62 @@ func run() error {
 	log.Print("Started service")
 	defer log.Print("Ended service")
 
+	// Apply the password policy used when validating new passwords
+	web.SetPasswordPolicy(web.PasswordPolicy{
+		MinLength: cfg.Password.MinLength,
+		Banned:    append(web.DefaultBannedPasswords, cfg.Password.Banned...),
+	})
+
 	// Initialize authentication support
-	authenticator, err := createAuth(
-		cfg.Auth.PrivateKeyFile,
-		cfg.Auth.KeyID,
+	authenticator, keys, err := createAuth(
+		cfg.Auth.KeysFolder,
+		cfg.Auth.ActiveKID,
 		cfg.Auth.Algorithm,
+		auth.Policy{
+			Issuer:   cfg.Auth.Issuer,
+			Audience: cfg.Auth.Audience,
+			Lifetime: cfg.Auth.TokenLifetime,
+			Leeway:   cfg.Auth.Leeway,
+		},
 	)
 	if err != nil {
 		return errors.Wrap(err, "constructing authenticator")
 	}
 
+	// Initialize mail delivery
+	var mailer mail.Mailer
+	if cfg.Mail.SMTPHost != "" {
+		mailer, err = mail.NewSMTP(mail.SMTPConfig{
+			Host:     cfg.Mail.SMTPHost,
+			Port:     cfg.Mail.SMTPPort,
+			Username: cfg.Mail.SMTPUser,
+			Password: cfg.Mail.SMTPPassword,
+			From:     cfg.Mail.From,
+		})
+	} else {
+		log.Printf("No SMTP host configured, writing mail to %s", cfg.Mail.Outbox)
+		mailer, err = mail.NewOutbox(cfg.Mail.Outbox, cfg.Mail.From)
+	}
+	if err != nil {
+		return errors.Wrap(err, "constructing mailer")
+	}
+
+	// Initialize storage of product images
+	blobs, err := storage.Open(storage.Config{
+		Kind: cfg.Storage.Kind,
+		Dir:  cfg.Storage.Dir,
+		S3: storage.S3Config{
+			Endpoint:  cfg.Storage.S3Endpoint,
+			Region:    cfg.Storage.S3Region,
+			Bucket:    cfg.Storage.S3Bucket,
+			AccessKey: cfg.Storage.S3AccessKey,
+			SecretKey: cfg.Storage.S3SecretKey,
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "constructing blob store")
+	}
+
 	// Start database
 	db, err := database.Open(database.Config{
 		User:       cfg.DB.User,
@@ -109,6 +201,30 @@ func run() error {
 	}
 	defer db.Close()
 
+	// Start brute force protection for logins
+	var store lockout.Store
+	switch cfg.Lockout.Store {
+	case "postgres":
+		store = lockout.NewPostgresStore(db)
+	case "memory":
+		store = lockout.NewMemoryStore()
+	default:
+		return errors.Errorf("unknown lockout store %q", cfg.Lockout.Store)
+	}
+	policy := lockout.Policy{
+		MaxFailures:     cfg.Lockout.MaxFailures,
+		LockoutDuration: cfg.Lockout.Duration,
+		BaseDelay:       cfg.Lockout.BaseDelay,
+		MaxDelay:        cfg.Lockout.MaxDelay,
+		Window:          cfg.Lockout.Window,
+	}
+	// Addresses can be shared by many people so they are only
+	// locked out after many failures and are never delayed.
+	addrPolicy := policy
+	addrPolicy.MaxFailures = cfg.Lockout.AddrMaxFailures
+	addrPolicy.BaseDelay = 0
+	guard := lockout.NewGuard(store, policy, addrPolicy)
+
 	// Start Tracing Support
 
 	closer, err := registerTracer(
@@ -142,7 +258,7 @@ func run() error {
 	// Create api as a http.Server
 	api := http.Server{
 		Addr:         cfg.Web.Address,
-		Handler:      handlers.API(shutdown, db, log, authenticator),
+		Handler:      handlers.API(shutdown, db, log, authenticator, keys, mailer, blobs, cfg.Web.PublicURL, guard),
 		ReadTimeout:  cfg.Web.ReadTimeout,
 		WriteTimeout: cfg.Web.WriteTimeout,
 	}
@@ -192,23 +308,22 @@ func run() error {
 	return nil
 }
 
-// createAuth ceates an authenticator. It reads a private key file, looks up the the
-// public key for it, then creats an authenticator using the algorithm provided for signing.
-func createAuth(privateKeyFile, keyID, algorithm string) (*auth.Authenticator, error) {
+// createAuth ceates an authenticator. It loads the key set from a folder of PEM files,
+// then creats an authenticator signing with the active key using the algorithm provided.
+// The RSA, ECDSA or Ed25519 type of each key is detected from its PEM file.
+func createAuth(keysFolder, activeKID, algorithm string, policy auth.Policy) (*auth.Authenticator, *auth.KeySet, error) {
 
-	keyContents, err := ioutil.ReadFile(privateKeyFile)
+	keys, err := auth.LoadKeySet(keysFolder, activeKID)
 	if err != nil {
-		return nil, errors.Wrap(err, "reading auth private key")
+		return nil, nil, errors.Wrap(err, "loading auth keys")
 	}
 
-	key, err := jwt.ParseRSAPrivateKeyFromPEM(keyContents)
+	authenticator, err := auth.NewAuthenticator(keys.PrivateKey(), activeKID, algorithm, keys.Lookup, policy)
 	if err != nil {
-		return nil, errors.Wrap(err, "parsing auth private key")
+		return nil, nil, err
 	}
 
-	public := auth.NewSimpleKeyLookupFunc(keyID, key.Public().(*rsa.PublicKey))
-
-	return auth.NewAuthenticator(key, keyID, algorithm, public)
+	return authenticator, keys, nil
 }
 
 // registerTracer is used to register a tracer for a particular service
@@ -0,0 +1,214 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sreejeet/garagesale/cmd/sales-api/internal/handlers"
+	"github.com/sreejeet/garagesale/internal/platform/auth"
+	"github.com/sreejeet/garagesale/internal/tests"
+)
+
+// TestOrders runs a series of tests to exercise Order behavior from the API.
+func TestOrders(t *testing.T) {
+	test := tests.New(t)
+	defer test.Teardown()
+
+	shutdown := make(chan os.Signal, 1)
+	ot := OrderTests{
+		app:          handlers.API(shutdown, test.DB, test.Log, test.Authenticator, test.Keys, test.Outbox, test.Store, "http://localhost", test.Guard),
+		adminToken:   test.Token("admin@example.com", "gophers"),
+		cashierToken: roleToken(t, test, "cashier@example.com", auth.RoleCashier),
+	}
+
+	t.Run("Checkout", ot.Checkout)
+	t.Run("CheckoutOversold", ot.CheckoutOversold)
+	t.Run("OtherOrg", ot.OtherOrg)
+}
+
+// OrderTests holds methods for each order subtest. Checkout records the
+// order the later subtests read.
+type OrderTests struct {
+	app          http.Handler
+	adminToken   string
+	cashierToken string
+	orderID      string
+}
+
+// These are the products of the seed data.
+const (
+	comicsID    = "a2b0639f-2cc6-44b8-b97b-15d69dbb511e"
+	mcdonaldsID = "72f8b983-3eb4-48db-9ed0-e45cc6bd716b"
+)
+
+// do sends a request with a bearer token and returns the response.
+func (ot *OrderTests) do(method, url, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp := httptest.NewRecorder()
+	ot.app.ServeHTTP(resp, req)
+	return resp
+}
+
+// stock reads the stock of a product.
+func (ot *OrderTests) stock(t *testing.T, id string) float64 {
+	t.Helper()
+
+	resp := ot.do("GET", "/v1/products/"+id, ot.adminToken, "")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("reading product: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+	var p map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		t.Fatalf("decoding product: %s", err)
+	}
+	return p["stock"].(float64)
+}
+
+// Checkout records an order of two products and reads it back.
+func (ot *OrderTests) Checkout(t *testing.T) {
+
+	body := `{"lines":[
+		{"product_id":"` + comicsID + `","quantity":1,"paid":{"amount":5000,"currency":"USD"}},
+		{"product_id":"` + mcdonaldsID + `","quantity":2,"paid":{"amount":15000,"currency":"USD"}}
+	]}`
+	resp := ot.do("POST", "/v1/orders", ot.cashierToken, body)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("checking out: expected status code %v, got %v: %s", http.StatusCreated, resp.Code, resp.Body)
+	}
+
+	var created struct {
+		ID    string                 `json:"id"`
+		Total map[string]interface{} `json:"total"`
+		Lines []interface{}          `json:"lines"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("decoding order: %s", err)
+	}
+	if created.Total["amount"] != float64(20000) || created.Total["currency"] != "USD" || len(created.Lines) != 2 {
+		t.Fatalf("unexpected order %+v", created)
+	}
+	ot.orderID = created.ID
+
+	// Cashiers record orders but only those reading sales may see them.
+	if resp := ot.do("GET", "/v1/orders/"+ot.orderID, ot.cashierToken, ""); resp.Code != http.StatusForbidden {
+		t.Fatalf("reading order as cashier: expected status code %v, got %v", http.StatusForbidden, resp.Code)
+	}
+	if resp := ot.do("GET", "/v1/orders/not-an-id", ot.adminToken, ""); resp.Code != http.StatusBadRequest {
+		t.Fatalf("reading invalid order id: expected status code %v, got %v", http.StatusBadRequest, resp.Code)
+	}
+
+	resp = ot.do("GET", "/v1/orders/"+ot.orderID, ot.adminToken, "")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("reading order: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+	var got struct {
+		ID    string        `json:"id"`
+		Lines []interface{} `json:"lines"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("decoding order: %s", err)
+	}
+	if got.ID != ot.orderID || len(got.Lines) != 2 {
+		t.Fatalf("unexpected order %+v", got)
+	}
+
+	resp = ot.do("GET", "/v1/orders", ot.adminToken, "")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("listing orders: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+	var page struct {
+		Items []struct {
+			ID string `json:"id"`
+		} `json:"items"`
+		Total int `json:"total"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		t.Fatalf("decoding orders: %s", err)
+	}
+	if page.Total != 1 || len(page.Items) != 1 || page.Items[0].ID != ot.orderID {
+		t.Fatalf("expected the new order to be listed, got %+v", page)
+	}
+}
+
+// CheckoutOversold makes sure an order with a single oversold line is
+// rejected as a whole.
+func (ot *OrderTests) CheckoutOversold(t *testing.T) {
+
+	comics := ot.stock(t, comicsID)
+
+	body := `{"lines":[
+		{"product_id":"` + comicsID + `","quantity":1,"paid":{"amount":5000,"currency":"USD"}},
+		{"product_id":"` + mcdonaldsID + `","quantity":1000,"paid":{"amount":15000,"currency":"USD"}}
+	]}`
+	if resp := ot.do("POST", "/v1/orders", ot.cashierToken, body); resp.Code != http.StatusConflict {
+		t.Fatalf("checking out oversold order: expected status code %v, got %v", http.StatusConflict, resp.Code)
+	}
+
+	// The line that could be sold was rolled back with the rest.
+	if got := ot.stock(t, comicsID); got != comics {
+		t.Fatalf("expected stock of %v after failed checkout, got %v", comics, got)
+	}
+
+	resp := ot.do("GET", "/v1/orders", ot.adminToken, "")
+	var page struct {
+		Total int `json:"total"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		t.Fatalf("decoding orders: %s", err)
+	}
+	if page.Total != 1 {
+		t.Fatalf("expected no order to be recorded, got %d orders", page.Total)
+	}
+}
+
+// OtherOrg makes sure orders can not be read from another organization.
+func (ot *OrderTests) OtherOrg(t *testing.T) {
+
+	resp := ot.do("POST", "/v1/orgs", ot.adminToken, `{"name":"Planet Express"}`)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("creating organization: expected status code %v, got %v", http.StatusCreated, resp.Code)
+	}
+	var created map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+
+	req := httptest.NewRequest("GET", "/v1/users/token?org="+created["id"].(string), nil)
+	req.SetBasicAuth("admin@example.com", "gophers")
+	resp = httptest.NewRecorder()
+	ot.app.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("switching organization: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+	var tkn map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&tkn); err != nil {
+		t.Fatalf("decoding token: %s", err)
+	}
+	orgToken := tkn["token"]
+
+	if resp := ot.do("GET", "/v1/orders/"+ot.orderID, orgToken, ""); resp.Code != http.StatusNotFound {
+		t.Fatalf("reading order of another organization: expected status code %v, got %v", http.StatusNotFound, resp.Code)
+	}
+
+	resp = ot.do("GET", "/v1/orders", orgToken, "")
+	var page struct {
+		Total int `json:"total"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		t.Fatalf("decoding orders: %s", err)
+	}
+	if page.Total != 0 {
+		t.Fatalf("expected no orders in a new organization, got %d", page.Total)
+	}
+
+	// Selling the products of another organization fails like unknown products.
+	body := `{"lines":[{"product_id":"` + comicsID + `","quantity":1,"paid":{"amount":5000,"currency":"USD"}}]}`
+	if resp := ot.do("POST", "/v1/orders", orgToken, body); resp.Code != http.StatusNotFound {
+		t.Fatalf("checking out product of another organization: expected status code %v, got %v", http.StatusNotFound, resp.Code)
+	}
+}
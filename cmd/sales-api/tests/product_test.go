@@ -1,24 +1,38 @@
 package tests
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"image"
+	"image/png"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/sreejeet/garagesale/cmd/sales-api/internal/handlers"
+	"github.com/sreejeet/garagesale/internal/org"
+	"github.com/sreejeet/garagesale/internal/platform/auth"
+	"github.com/sreejeet/garagesale/internal/product"
 	"github.com/sreejeet/garagesale/internal/tests"
+	"github.com/sreejeet/garagesale/internal/user"
 )
 
 // ProductTests is used for passing dependencies for tests and also simplify
 // adding subtests.
 type ProductTests struct {
-	app        http.Handler
-	adminToken string
+	app          http.Handler
+	adminToken   string
+	cashierToken string
+	sellerToken  string
 }
 
 func TestProducts(t *testing.T) {
@@ -34,12 +48,47 @@ func TestProducts(t *testing.T) {
 			test.DB,
 			test.Log,
 			test.Authenticator,
+			test.Keys,
+			test.Outbox,
+			test.Store,
+			"http://localhost",
+			test.Guard,
 		),
-		adminToken: test.Token("admin@example.com", "gophers"),
+		adminToken:   test.Token("admin@example.com", "gophers"),
+		cashierToken: roleToken(t, test, "cashier@example.com", auth.RoleCashier),
+		sellerToken:  roleToken(t, test, "seller@example.com", auth.RoleSeller),
 	}
 
 	t.Run("List", tests.List)
+	t.Run("ListFiltered", tests.ListFiltered)
+	t.Run("Search", tests.Search)
 	t.Run("ProductCRUD", tests.ProductCRUD)
+	t.Run("AddSaleStock", tests.AddSaleStock)
+	t.Run("VoidSale", tests.VoidSale)
+	t.Run("Permissions", tests.Permissions)
+	t.Run("CategoriesAndTags", tests.CategoriesAndTags)
+	t.Run("Images", tests.Images)
+	t.Run("Reports", tests.Reports)
+	t.Run("Exports", tests.Exports)
+	t.Run("Import", tests.Import)
+}
+
+// roleToken creates a user with a single role and returns a token for it.
+func roleToken(t *testing.T, test *tests.Test, email, role string) string {
+	t.Helper()
+
+	nu := user.NewUser{
+		Name:            role,
+		Email:           email,
+		Roles:           []string{role},
+		Password:        "gophers",
+		PasswordConfirm: "gophers",
+	}
+	if _, err := user.Create(context.Background(), test.DB, org.DefaultID, nu, time.Now()); err != nil {
+		t.Fatalf("creating %s user: %s", role, err)
+	}
+
+	return test.Token(email, "gophers")
 }
 
 // List tests the listing of products from the API
@@ -55,11 +104,23 @@ func (p *ProductTests) List(t *testing.T) {
 		t.Fatalf("expected http status code %v, got %v", http.StatusOK, resp.Code)
 	}
 
-	// Using a slice of empty interface to decode the response.
-	var list []map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+	// Using a slice of empty interface to decode the items of the page.
+	var page struct {
+		Items []map[string]interface{} `json:"items"`
+		Total int                      `json:"total"`
+		Next  string                   `json:"next"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
 		t.Fatalf("decoding list of products: %s", err)
 	}
+	list := page.Items
+
+	if exp, got := 2, page.Total; exp != got {
+		t.Fatalf("expected product total %v, got %v", exp, got)
+	}
+	if page.Next != "" {
+		t.Fatalf("expected no next page, got %q", page.Next)
+	}
 
 	// The exprected list of products.
 	// This list must be exactly the same as the products defined in the database seeding function.
@@ -67,22 +128,36 @@ func (p *ProductTests) List(t *testing.T) {
 		{
 			"id":           "a2b0639f-2cc6-44b8-b97b-15d69dbb511e",
 			"name":         "Comic Books",
-			"cost":         float64(50),
+			"description":  "",
+			"cost":         map[string]interface{}{"amount": float64(5000), "currency": "USD"},
 			"quantity":     float64(42),
-			"revenue":      float64(350),
+			"revenue":      map[string]interface{}{"amount": float64(35000), "currency": "USD"},
 			"sold":         float64(7),
+			"stock":        float64(35),
+			"version":      float64(1),
+			"category_id":  nil,
+			"tags":         []interface{}{},
+			"images":       []interface{}{},
 			"user_id":      "00000000-0000-0000-0000-000000000000",
+			"org_id":       org.DefaultID,
 			"date_created": "2019-01-01T00:00:01.000001Z",
 			"date_updated": "2019-01-01T00:00:01.000001Z",
 		},
 		{
 			"id":           "72f8b983-3eb4-48db-9ed0-e45cc6bd716b",
 			"name":         "McDonalds Toys",
-			"cost":         float64(75),
+			"description":  "",
+			"cost":         map[string]interface{}{"amount": float64(7500), "currency": "USD"},
 			"quantity":     float64(120),
-			"revenue":      float64(225),
+			"revenue":      map[string]interface{}{"amount": float64(22500), "currency": "USD"},
 			"sold":         float64(3),
+			"stock":        float64(117),
+			"version":      float64(1),
+			"category_id":  nil,
+			"tags":         []interface{}{},
+			"images":       []interface{}{},
 			"user_id":      "00000000-0000-0000-0000-000000000000",
+			"org_id":       org.DefaultID,
 			"date_created": "2019-01-01T00:00:02.000001Z",
 			"date_updated": "2019-01-01T00:00:02.000001Z",
 		},
@@ -94,13 +169,134 @@ func (p *ProductTests) List(t *testing.T) {
 	}
 }
 
+// ListFiltered tests filtering, sorting and paging of products from the API
+func (p *ProductTests) ListFiltered(t *testing.T) {
+
+	req := httptest.NewRequest("GET", "/v1/products?name=toys&sort=-cost&limit=1", nil)
+	req.Header.Set("Authorization", "Bearer "+p.adminToken)
+	resp := httptest.NewRecorder()
+
+	p.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected http status code %v, got %v", http.StatusOK, resp.Code)
+	}
+
+	var page struct {
+		Items []map[string]interface{} `json:"items"`
+		Total int                      `json:"total"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		t.Fatalf("decoding list of products: %s", err)
+	}
+
+	if exp, got := 1, page.Total; exp != got {
+		t.Fatalf("expected product total %v, got %v", exp, got)
+	}
+	if exp, got := "McDonalds Toys", page.Items[0]["name"]; exp != got {
+		t.Fatalf("expected product %q, got %q", exp, got)
+	}
+
+	// Unknown sort fields are a client error.
+	req = httptest.NewRequest("GET", "/v1/products?sort=password_hash", nil)
+	req.Header.Set("Authorization", "Bearer "+p.adminToken)
+	resp = httptest.NewRecorder()
+
+	p.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected http status code %v, got %v", http.StatusBadRequest, resp.Code)
+	}
+
+	// Costs can only be compared within a currency.
+	for query, want := range map[string]int{
+		"min_cost=6000":              http.StatusBadRequest,
+		"min_cost=6000&currency=USD": http.StatusOK,
+	} {
+		req = httptest.NewRequest("GET", "/v1/products?"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+p.adminToken)
+		resp = httptest.NewRecorder()
+
+		p.app.ServeHTTP(resp, req)
+
+		if resp.Code != want {
+			t.Fatalf("%s: expected http status code %v, got %v", query, want, resp.Code)
+		}
+	}
+}
+
+// Search tests finding products by the words in their name and description.
+func (p *ProductTests) Search(t *testing.T) {
+
+	body := strings.NewReader(`{"description":"First editions of superhero stories"}`)
+	req := httptest.NewRequest("PUT", "/v1/products/a2b0639f-2cc6-44b8-b97b-15d69dbb511e", body)
+	req.Header.Set("Authorization", "Bearer "+p.adminToken)
+	resp := httptest.NewRecorder()
+
+	p.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusNoContent {
+		t.Fatalf("updating description: expected status code %v, got %v", http.StatusNoContent, resp.Code)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		total int
+		first string
+	}{
+		{"Description", "superheroes", 1, "Comic Books"},
+		{"Typo", "McDonals", 1, "McDonalds Toys"},
+		{"Either", "toys or comics", 2, ""},
+		{"NoMatch", "furniture", 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/v1/products/search?q="+url.QueryEscape(tt.query), nil)
+			req.Header.Set("Authorization", "Bearer "+p.adminToken)
+			resp := httptest.NewRecorder()
+
+			p.app.ServeHTTP(resp, req)
+
+			if resp.Code != http.StatusOK {
+				t.Fatalf("expected http status code %v, got %v", http.StatusOK, resp.Code)
+			}
+
+			var page struct {
+				Items []map[string]interface{} `json:"items"`
+				Total int                      `json:"total"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+				t.Fatalf("decoding search results: %s", err)
+			}
+			if page.Total != tt.total {
+				t.Fatalf("expected %d results, got %d", tt.total, page.Total)
+			}
+			if tt.first != "" && page.Items[0]["name"] != tt.first {
+				t.Fatalf("expected %q first, got %v", tt.first, page.Items[0]["name"])
+			}
+		})
+	}
+
+	// A search needs something to look for.
+	req = httptest.NewRequest("GET", "/v1/products/search?q=+", nil)
+	req.Header.Set("Authorization", "Bearer "+p.adminToken)
+	resp = httptest.NewRecorder()
+
+	p.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected http status code %v, got %v", http.StatusBadRequest, resp.Code)
+	}
+}
+
 // ProductCRUD test will be used to perform all CRUD operations of the API
 func (p *ProductTests) ProductCRUD(t *testing.T) {
 
 	var created map[string]interface{}
 
 	{ // CREATE
-		body := strings.NewReader(`{"name":"product0","cost":55,"quantity":6}`)
+		body := strings.NewReader(`{"name":"product0","cost":{"amount":5500,"currency":"USD"},"quantity":6}`)
 
 		req := httptest.NewRequest("POST", "/v1/products", body)
 		req.Header.Set("Content-Type", "application/json")
@@ -132,11 +328,18 @@ func (p *ProductTests) ProductCRUD(t *testing.T) {
 			"date_created": created["date_created"],
 			"date_updated": created["date_updated"],
 			"name":         "product0",
-			"cost":         float64(55),
+			"description":  "",
+			"cost":         map[string]interface{}{"amount": float64(5500), "currency": "USD"},
 			"quantity":     float64(6),
 			"sold":         float64(0),
-			"revenue":      float64(0),
+			"stock":        float64(6),
+			"revenue":      map[string]interface{}{"amount": float64(0), "currency": "USD"},
+			"version":      float64(1),
+			"category_id":  nil,
+			"tags":         []interface{}{},
+			"images":       []interface{}{},
 			"user_id":      tests.AdminID,
+			"org_id":       org.DefaultID,
 		}
 
 		if diff := cmp.Diff(want, created); diff != "" {
@@ -169,11 +372,12 @@ func (p *ProductTests) ProductCRUD(t *testing.T) {
 	}
 
 	{ // UPDATE
-		body := strings.NewReader(`{"name":"Updated Name","cost":20,"quantity":10}`)
+		body := `{"name":"Updated Name","cost":{"amount":2000,"currency":"USD"},"quantity":10}`
 		url := fmt.Sprintf("/v1/products/%s", created["id"])
-		req := httptest.NewRequest("PUT", url, body)
+		req := httptest.NewRequest("PUT", url, strings.NewReader(body))
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("Authorization", "Bearer "+p.adminToken)
+		req.Header.Set("If-Match", `"1"`)
 		resp := httptest.NewRecorder()
 
 		p.app.ServeHTTP(resp, req)
@@ -182,6 +386,19 @@ func (p *ProductTests) ProductCRUD(t *testing.T) {
 			t.Fatalf("updating: expected status code %v, got %v", http.StatusNoContent, resp.Code)
 		}
 
+		// A second update based on the same version is a conflict.
+		req = httptest.NewRequest("PUT", url, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+p.adminToken)
+		req.Header.Set("If-Match", `"1"`)
+		resp = httptest.NewRecorder()
+
+		p.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusPreconditionFailed {
+			t.Fatalf("updating stale version: expected status code %v, got %v", http.StatusPreconditionFailed, resp.Code)
+		}
+
 		// Retrieve updated record to be sure it worked.
 		req = httptest.NewRequest("GET", url, nil)
 		req.Header.Set("Content-Type", "application/json")
@@ -193,6 +410,9 @@ func (p *ProductTests) ProductCRUD(t *testing.T) {
 		if resp.Code != http.StatusOK {
 			t.Fatalf("retrieving: expected status code %v, got %v", http.StatusOK, resp.Code)
 		}
+		if exp, got := `"2"`, resp.Header().Get("ETag"); exp != got {
+			t.Fatalf("expected ETag %s, got %s", exp, got)
+		}
 
 		var updated map[string]interface{}
 		if err := json.NewDecoder(resp.Body).Decode(&updated); err != nil {
@@ -204,11 +424,18 @@ func (p *ProductTests) ProductCRUD(t *testing.T) {
 			"date_created": created["date_created"],
 			"date_updated": updated["date_updated"],
 			"name":         "Updated Name",
-			"cost":         float64(20),
+			"description":  "",
+			"cost":         map[string]interface{}{"amount": float64(2000), "currency": "USD"},
 			"quantity":     float64(10),
 			"sold":         float64(0),
-			"revenue":      float64(0),
+			"stock":        float64(10),
+			"revenue":      map[string]interface{}{"amount": float64(0), "currency": "USD"},
+			"version":      float64(2),
+			"category_id":  nil,
+			"tags":         []interface{}{},
+			"images":       []interface{}{},
 			"user_id":      tests.AdminID,
+			"org_id":       org.DefaultID,
 		}
 
 		// Updated product should match the one we created.
@@ -217,6 +444,64 @@ func (p *ProductTests) ProductCRUD(t *testing.T) {
 		}
 	}
 
+	{ // PATCH
+		url := fmt.Sprintf("/v1/products/%s", created["id"])
+
+		patches := []struct {
+			name    string
+			typ     string
+			ifMatch string
+			body    string
+			status  int
+		}{
+			{"Merge", "application/merge-patch+json", `"2"`, `{"cost":{"amount":2500}}`, http.StatusNoContent},
+			{"JSONPatch", "application/json-patch+json", "", `[{"op":"test","path":"/cost/amount","value":2500},{"op":"replace","path":"/quantity","value":12}]`, http.StatusNoContent},
+			{"UnsupportedType", "application/json", "", `{"cost":{"amount":3000}}`, http.StatusUnsupportedMediaType},
+			{"Invalid", "application/merge-patch+json", "", `{"quantity":0}`, http.StatusBadRequest},
+			{"InvalidCurrency", "application/merge-patch+json", "", `{"cost":{"currency":"usd"}}`, http.StatusBadRequest},
+			{"UnknownField", "application/merge-patch+json", "", `{"stock":99}`, http.StatusBadRequest},
+			{"StaleVersion", "application/merge-patch+json", `"2"`, `{"cost":{"amount":3000}}`, http.StatusPreconditionFailed},
+			{"WeakTag", "application/merge-patch+json", `W/"4"`, `{"cost":{"amount":3000}}`, http.StatusPreconditionFailed},
+			{"TestFails", "application/json-patch+json", "", `[{"op":"test","path":"/cost/amount","value":2000},{"op":"replace","path":"/cost/amount","value":3000}]`, http.StatusConflict},
+		}
+
+		for _, tt := range patches {
+			req := httptest.NewRequest("PATCH", url, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.typ)
+			req.Header.Set("Authorization", "Bearer "+p.adminToken)
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			resp := httptest.NewRecorder()
+
+			p.app.ServeHTTP(resp, req)
+
+			if resp.Code != tt.status {
+				t.Fatalf("patching %s: expected status code %v, got %v", tt.name, tt.status, resp.Code)
+			}
+		}
+
+		// Only the successful patches were applied.
+		req := httptest.NewRequest("GET", url, nil)
+		req.Header.Set("Authorization", "Bearer "+p.adminToken)
+		resp := httptest.NewRecorder()
+
+		p.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusOK {
+			t.Fatalf("retrieving: expected status code %v, got %v", http.StatusOK, resp.Code)
+		}
+
+		var patched map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&patched); err != nil {
+			t.Fatalf("decoding: %s", err)
+		}
+		cost := patched["cost"].(map[string]interface{})
+		if cost["amount"] != float64(2500) || cost["currency"] != "USD" || patched["quantity"] != float64(12) || patched["version"] != float64(4) {
+			t.Fatalf("expected cost 25.00 USD, quantity 12 and version 4, got %v, %v and %v", cost, patched["quantity"], patched["version"])
+		}
+	}
+
 	{ // DELETE
 		url := fmt.Sprintf("/v1/products/%s", created["id"])
 		req := httptest.NewRequest("DELETE", url, nil)
@@ -240,8 +525,110 @@ func (p *ProductTests) ProductCRUD(t *testing.T) {
 		if resp.Code != http.StatusNotFound {
 			t.Fatalf("retrieving: expected status code %v, got %v", http.StatusNotFound, resp.Code)
 		}
+
+		// Deleted products can still be read on request.
+		req = httptest.NewRequest("GET", url+"?include_deleted=true", nil)
+		req.Header.Set("Authorization", "Bearer "+p.adminToken)
+		resp = httptest.NewRecorder()
+
+		p.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusOK {
+			t.Fatalf("retrieving deleted: expected status code %v, got %v", http.StatusOK, resp.Code)
+		}
 	}
 
+	{ // RESTORE
+
+		url := fmt.Sprintf("/v1/products/%s", created["id"])
+
+		// Only admins may restore products.
+		req := httptest.NewRequest("POST", url+"/restore", nil)
+		req.Header.Set("Authorization", "Bearer "+p.sellerToken)
+		resp := httptest.NewRecorder()
+
+		p.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusForbidden {
+			t.Fatalf("restoring as seller: expected status code %v, got %v", http.StatusForbidden, resp.Code)
+		}
+
+		req = httptest.NewRequest("POST", url+"/restore", nil)
+		req.Header.Set("Authorization", "Bearer "+p.adminToken)
+		resp = httptest.NewRecorder()
+
+		p.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusNoContent {
+			t.Fatalf("restoring: expected status code %v, got %v", http.StatusNoContent, resp.Code)
+		}
+
+		req = httptest.NewRequest("GET", url, nil)
+		req.Header.Set("Authorization", "Bearer "+p.adminToken)
+		resp = httptest.NewRecorder()
+
+		p.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusOK {
+			t.Fatalf("retrieving restored: expected status code %v, got %v", http.StatusOK, resp.Code)
+		}
+	}
+}
+
+// AddSaleStock tests that sales are limited by the stock of a product.
+func (p *ProductTests) AddSaleStock(t *testing.T) {
+
+	// McDonalds Toys has 117 items left in the seed data.
+	url := "/v1/products/72f8b983-3eb4-48db-9ed0-e45cc6bd716b/sales"
+	body := strings.NewReader(`{"quantity":118,"paid":{"amount":10000,"currency":"USD"}}`)
+
+	req := httptest.NewRequest("POST", url, body)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.adminToken)
+	resp := httptest.NewRecorder()
+
+	p.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusConflict {
+		t.Fatalf("overselling: expected status code %v, got %v", http.StatusConflict, resp.Code)
+	}
+
+	// Selling a product that does not exist is not found rather than a server error.
+	url = "/v1/products/0b9a6b6f-3a0c-4c5d-9a53-6f0b6b6b6b6b/sales"
+	body = strings.NewReader(`{"quantity":1,"paid":{"amount":10000,"currency":"USD"}}`)
+
+	req = httptest.NewRequest("POST", url, body)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.adminToken)
+	resp = httptest.NewRecorder()
+
+	p.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusNotFound {
+		t.Fatalf("unknown product: expected status code %v, got %v", http.StatusNotFound, resp.Code)
+	}
+}
+
+// VoidSale tests that a sale can be voided once and only once.
+func (p *ProductTests) VoidSale(t *testing.T) {
+
+	// This is the first sale of Comic Books in the seed data.
+	url := "/v1/sales/98b6d4b8-f04b-4c79-8c2e-a0aef46854b7/void"
+
+	for _, want := range []int{http.StatusCreated, http.StatusConflict} {
+		body := strings.NewReader(`{"reason":"rung up twice"}`)
+
+		req := httptest.NewRequest("POST", url, body)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+p.adminToken)
+		resp := httptest.NewRecorder()
+
+		p.app.ServeHTTP(resp, req)
+
+		if resp.Code != want {
+			t.Fatalf("voiding: expected status code %v, got %v", want, resp.Code)
+		}
+	}
 }
 
 // CreateRequiresFields tests the request decoder for proper validation checks
@@ -261,3 +648,464 @@ func (p *ProductTests) CreateRequiresFields(t *testing.T) {
 		t.Fatalf("expected status code %v, got %v", http.StatusBadRequest, resp.Code)
 	}
 }
+
+// Permissions tests that what a client may do depends on the permissions
+// of its roles.
+func (p *ProductTests) Permissions(t *testing.T) {
+
+	do := func(method, url, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+		p.app.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// Sellers manage their own products.
+	resp := do("POST", "/v1/products", p.sellerToken, `{"name":"Board Games","cost":{"amount":3000,"currency":"USD"},"quantity":4}`)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("seller creating: expected status code %v, got %v", http.StatusCreated, resp.Code)
+	}
+	var created map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+	own := fmt.Sprintf("/v1/products/%s", created["id"])
+	comics := "/v1/products/a2b0639f-2cc6-44b8-b97b-15d69dbb511e"
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		token  string
+		body   string
+		want   int
+	}{
+		{"SellerUpdatesOwn", "PUT", own, p.sellerToken, `{"cost":{"amount":3500,"currency":"USD"}}`, http.StatusNoContent},
+		{"SellerUpdatesOther", "PUT", comics, p.sellerToken, `{"cost":{"amount":3500,"currency":"USD"}}`, http.StatusForbidden},
+		{"SellerSells", "POST", own + "/sales", p.sellerToken, `{"quantity":1,"paid":{"amount":3500,"currency":"USD"}}`, http.StatusForbidden},
+		{"CashierCreates", "POST", "/v1/products", p.cashierToken, `{"name":"Kites","cost":{"amount":1000,"currency":"USD"},"quantity":1}`, http.StatusForbidden},
+		{"CashierUpdates", "PUT", own, p.cashierToken, `{"cost":{"amount":100,"currency":"USD"}}`, http.StatusForbidden},
+		{"CashierSells", "POST", own + "/sales", p.cashierToken, `{"quantity":1,"paid":{"amount":3500,"currency":"USD"}}`, http.StatusCreated},
+		{"CashierDeletes", "DELETE", own, p.cashierToken, ``, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if resp := do(tt.method, tt.url, tt.token, tt.body); resp.Code != tt.want {
+				t.Fatalf("expected status code %v, got %v", tt.want, resp.Code)
+			}
+		})
+	}
+}
+
+// CategoriesAndTags tests grouping products in categories and tags and
+// browsing them with facet counts.
+func (p *ProductTests) CategoriesAndTags(t *testing.T) {
+
+	do := func(method, url, token, typ, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		if typ != "" {
+			req.Header.Set("Content-Type", typ)
+		}
+		resp := httptest.NewRecorder()
+		p.app.ServeHTTP(resp, req)
+		return resp
+	}
+
+	create := func(body string) string {
+		resp := do("POST", "/v1/categories", p.adminToken, "", body)
+		if resp.Code != http.StatusCreated {
+			t.Fatalf("creating category %s: expected status code %v, got %v", body, http.StatusCreated, resp.Code)
+		}
+		var c map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&c); err != nil {
+			t.Fatalf("decoding: %s", err)
+		}
+		return c["id"].(string)
+	}
+
+	toys := create(`{"name":"Toys"}`)
+	figures := create(fmt.Sprintf(`{"name":"Figures","parent_id":%q}`, toys))
+	comics := "/v1/products/a2b0639f-2cc6-44b8-b97b-15d69dbb511e"
+	mcdonalds := "/v1/products/72f8b983-3eb4-48db-9ed0-e45cc6bd716b"
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		token  string
+		typ    string
+		body   string
+		want   int
+	}{
+		{"CashierCreates", "POST", "/v1/categories", p.cashierToken, "", `{"name":"Kites"}`, http.StatusForbidden},
+		{"DuplicateName", "POST", "/v1/categories", p.adminToken, "", `{"name":"Toys"}`, http.StatusConflict},
+		{"UnknownParent", "POST", "/v1/categories", p.adminToken, "", `{"name":"Kites","parent_id":"9b1a1f0e-8f5e-4f6a-9d3c-2e7b6a5c4d3b"}`, http.StatusBadRequest},
+		{"MoveBelowItself", "PATCH", "/v1/categories/" + toys, p.adminToken, "application/merge-patch+json", fmt.Sprintf(`{"parent_id":%q}`, figures), http.StatusBadRequest},
+		{"UnknownCategory", "PUT", comics, p.adminToken, "", `{"category_id":"9b1a1f0e-8f5e-4f6a-9d3c-2e7b6a5c4d3b"}`, http.StatusBadRequest},
+		{"CategorizeAndTag", "PUT", mcdonalds, p.adminToken, "", fmt.Sprintf(`{"category_id":%q,"tags":["Vintage","boxed"]}`, figures), http.StatusNoContent},
+		{"Tag", "PATCH", comics, p.adminToken, "application/json-patch+json", `[{"op":"add","path":"/tags/-","value":"vintage"}]`, http.StatusNoContent},
+		{"DeleteWithChildren", "DELETE", "/v1/categories/" + toys, p.adminToken, "", ``, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		if resp := do(tt.method, tt.url, tt.token, tt.typ, tt.body); resp.Code != tt.want {
+			t.Fatalf("%s: expected status code %v, got %v", tt.name, tt.want, resp.Code)
+		}
+	}
+
+	type facets struct {
+		Categories []struct {
+			ID    string `json:"id"`
+			Count int    `json:"count"`
+		} `json:"categories"`
+		Tags []struct {
+			Name  string `json:"name"`
+			Count int    `json:"count"`
+		} `json:"tags"`
+	}
+	list := func(query string) (int, facets) {
+		resp := do("GET", "/v1/products?facets=true&"+query, p.adminToken, "", "")
+		if resp.Code != http.StatusOK {
+			t.Fatalf("listing %s: expected status code %v, got %v", query, http.StatusOK, resp.Code)
+		}
+		var page struct {
+			Total  int    `json:"total"`
+			Facets facets `json:"facets"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+			t.Fatalf("decoding: %s", err)
+		}
+		return page.Total, page.Facets
+	}
+
+	// Products of subcategories are found through their parents.
+	total, f := list("category=" + toys)
+	if total != 1 {
+		t.Fatalf("expected 1 toy, got %d", total)
+	}
+	if len(f.Categories) != 2 || f.Categories[0].ID != figures || f.Categories[0].Count != 1 || f.Categories[1].ID != toys || f.Categories[1].Count != 1 {
+		t.Fatalf("unexpected category facets %+v", f.Categories)
+	}
+	if len(f.Tags) != 2 || f.Tags[0].Name != "boxed" || f.Tags[1].Name != "vintage" {
+		t.Fatalf("unexpected tag facets %+v", f.Tags)
+	}
+
+	// Tags are matched regardless of case.
+	total, f = list("tag=Vintage")
+	if total != 2 {
+		t.Fatalf("expected 2 vintage products, got %d", total)
+	}
+	if len(f.Tags) != 2 || f.Tags[0].Name != "vintage" || f.Tags[0].Count != 2 {
+		t.Fatalf("unexpected tag facets %+v", f.Tags)
+	}
+
+	if total, _ := list("tag=vintage&tag=boxed"); total != 1 {
+		t.Fatalf("expected 1 product with both tags, got %d", total)
+	}
+
+	// Facets are only counted when asked for.
+	resp := do("GET", "/v1/products?tag=vintage", p.adminToken, "", "")
+	var plain map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&plain); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+	if _, ok := plain["facets"]; ok {
+		t.Fatalf("expected no facets without facets=true, got %v", plain["facets"])
+	}
+
+	resp = do("GET", mcdonalds, p.adminToken, "", "")
+	var prod map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&prod); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+	if diff := cmp.Diff([]interface{}{"boxed", "vintage"}, prod["tags"]); diff != "" {
+		t.Fatalf("unexpected tags:\n%s", diff)
+	}
+
+	// Deleting a tag or category removes it from its products.
+	resp = do("GET", "/v1/tags", p.adminToken, "", "")
+	var tags []map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+	if len(tags) != 2 || tags[1]["name"] != "vintage" || tags[1]["products"] != float64(2) {
+		t.Fatalf("unexpected tags %v", tags)
+	}
+	if resp := do("DELETE", "/v1/tags/"+tags[1]["id"].(string), p.adminToken, "", ""); resp.Code != http.StatusNoContent {
+		t.Fatalf("deleting tag: expected status code %v, got %v", http.StatusNoContent, resp.Code)
+	}
+	if resp := do("DELETE", "/v1/categories/"+figures, p.adminToken, "", ""); resp.Code != http.StatusNoContent {
+		t.Fatalf("deleting category: expected status code %v, got %v", http.StatusNoContent, resp.Code)
+	}
+	if total, _ := list("tag=vintage"); total != 0 {
+		t.Fatalf("expected no vintage products after deleting the tag, got %d", total)
+	}
+	if total, _ := list("category=" + toys); total != 0 {
+		t.Fatalf("expected no toys after deleting the subcategory, got %d", total)
+	}
+}
+
+// Images tests uploading, reading and deleting product images.
+func (p *ProductTests) Images(t *testing.T) {
+
+	do := func(method, url, token, typ string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		if typ != "" {
+			req.Header.Set("Content-Type", typ)
+		}
+		resp := httptest.NewRecorder()
+		p.app.ServeHTTP(resp, req)
+		return resp
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 512, 256))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// Upload as a multipart form like browsers do.
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	fw, err := mw.CreateFormFile("image", "comics.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(data)
+	mw.Close()
+
+	comics := "/v1/products/a2b0639f-2cc6-44b8-b97b-15d69dbb511e"
+
+	tests := []struct {
+		name  string
+		token string
+		typ   string
+		body  []byte
+		want  int
+	}{
+		{"NotAnImage", p.adminToken, "", []byte("hello, world"), http.StatusBadRequest},
+		{"TooLarge", p.adminToken, "", make([]byte, product.MaxImageSize+1), http.StatusRequestEntityTooLarge},
+		{"SellerNotOwner", p.sellerToken, "", data, http.StatusForbidden},
+		{"Upload", p.adminToken, mw.FormDataContentType(), form.Bytes(), http.StatusCreated},
+	}
+	for _, tt := range tests {
+		if resp := do("POST", comics+"/images", tt.token, tt.typ, tt.body); resp.Code != tt.want {
+			t.Fatalf("%s: expected status code %v, got %v", tt.name, tt.want, resp.Code)
+		}
+	}
+
+	resp := do("GET", comics+"/images", p.cashierToken, "", nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("listing images: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+	var images []map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&images); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+	if len(images) != 1 || images[0]["width"] != float64(512) || images[0]["content_type"] != "image/png" {
+		t.Fatalf("unexpected images %v", images)
+	}
+
+	resp = do("GET", images[0]["url"].(string), p.cashierToken, "", nil)
+	if resp.Code != http.StatusOK || !bytes.Equal(resp.Body.Bytes(), data) {
+		t.Fatalf("getting image: expected the uploaded image, got status code %v", resp.Code)
+	}
+
+	resp = do("GET", images[0]["thumbnail_url"].(string), p.cashierToken, "", nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("getting thumbnail: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+	thumb, err := png.DecodeConfig(resp.Body)
+	if err != nil {
+		t.Fatalf("decoding thumbnail: %s", err)
+	}
+	if thumb.Width != product.ThumbnailSize || thumb.Height != product.ThumbnailSize/2 {
+		t.Fatalf("expected a %dx%d thumbnail, got %dx%d", product.ThumbnailSize, product.ThumbnailSize/2, thumb.Width, thumb.Height)
+	}
+
+	img := comics + "/images/" + images[0]["id"].(string)
+	if resp := do("DELETE", img, p.adminToken, "", nil); resp.Code != http.StatusNoContent {
+		t.Fatalf("deleting image: expected status code %v, got %v", http.StatusNoContent, resp.Code)
+	}
+	if resp := do("GET", img, p.adminToken, "", nil); resp.Code != http.StatusNotFound {
+		t.Fatalf("getting deleted image: expected status code %v, got %v", http.StatusNotFound, resp.Code)
+	}
+}
+
+// Reports tests that reports are computed for admins only and validate their parameters.
+func (p *ProductTests) Reports(t *testing.T) {
+
+	do := func(url, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+		p.app.ServeHTTP(resp, req)
+		return resp
+	}
+
+	const january = "from=2019-01-01&to=2019-02-01"
+
+	tests := []struct {
+		name  string
+		url   string
+		token string
+		want  int
+	}{
+		{"Revenue", "/v1/reports/revenue?interval=week&" + january, p.adminToken, http.StatusOK},
+		{"TopProducts", "/v1/reports/top-products?by=revenue&" + january, p.adminToken, http.StatusOK},
+		{"SellThrough", "/v1/reports/sell-through?limit=5&" + january, p.adminToken, http.StatusOK},
+		{"Discounts", "/v1/reports/discounts?" + january, p.adminToken, http.StatusOK},
+		{"Sellers", "/v1/reports/sellers?" + january, p.adminToken, http.StatusOK},
+		{"Cashier", "/v1/reports/revenue?" + january, p.cashierToken, http.StatusForbidden},
+		{"InvalidInterval", "/v1/reports/revenue?interval=year", p.adminToken, http.StatusBadRequest},
+		{"InvalidDate", "/v1/reports/revenue?from=yesterday", p.adminToken, http.StatusBadRequest},
+		{"Backwards", "/v1/reports/revenue?from=2019-02-01&to=2019-01-01", p.adminToken, http.StatusBadRequest},
+		{"InvalidRanking", "/v1/reports/top-products?by=cost", p.adminToken, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if resp := do(tt.url, tt.token); resp.Code != tt.want {
+			t.Fatalf("%s: expected status code %v, got %v", tt.name, tt.want, resp.Code)
+		}
+	}
+
+	resp := do("/v1/reports/revenue?interval=month&"+january, p.adminToken)
+	var result struct {
+		From  time.Time `json:"from"`
+		Items []struct {
+			Start   time.Time              `json:"start"`
+			Revenue map[string]interface{} `json:"revenue"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+	if !result.From.Equal(time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected the report to start on the first of January, got %v", result.From)
+	}
+	if len(result.Items) != 1 || result.Items[0].Revenue["currency"] != "USD" {
+		t.Fatalf("expected one month of revenue in USD, got %v", result.Items)
+	}
+}
+
+// Exports tests that products and sales are downloaded in every format.
+func (p *ProductTests) Exports(t *testing.T) {
+
+	do := func(url, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+		p.app.ServeHTTP(resp, req)
+		return resp
+	}
+
+	const january = "from=2019-01-01&to=2019-02-01"
+
+	tests := []struct {
+		name  string
+		url   string
+		token string
+		want  int
+	}{
+		{"Cashier", "/v1/exports/sales?" + january, p.cashierToken, http.StatusForbidden},
+		{"InvalidFormat", "/v1/exports/sales?format=pdf&" + january, p.adminToken, http.StatusBadRequest},
+		{"Backwards", "/v1/exports/products?from=2019-02-01&to=2019-01-01", p.adminToken, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if resp := do(tt.url, tt.token); resp.Code != tt.want {
+			t.Fatalf("%s: expected status code %v, got %v", tt.name, tt.want, resp.Code)
+		}
+	}
+
+	resp := do("/v1/exports/sales?"+january, p.adminToken)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("exporting sales: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+	if exp, got := "text/csv; charset=utf-8", resp.Header().Get("Content-Type"); exp != got {
+		t.Fatalf("expected content type %q, got %q", exp, got)
+	}
+	if exp, got := `attachment; filename="sales_2019-01-01_2019-02-01.csv"`, resp.Header().Get("Content-Disposition"); exp != got {
+		t.Fatalf("expected content disposition %q, got %q", exp, got)
+	}
+
+	// The header and the three sales of the seed data.
+	if exp, got := 4, strings.Count(resp.Body.String(), "\n"); exp != got {
+		t.Fatalf("expected %d lines, got %d:\n%s", exp, got, resp.Body)
+	}
+
+	resp = do("/v1/exports/products?format=xlsx&"+january, p.adminToken)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("exporting products: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+	data := resp.Body.Bytes()
+	if _, err := zip.NewReader(bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatalf("reading workbook: %s", err)
+	}
+}
+
+// Import creates products from CSV and NDJSON files in both modes.
+func (p *ProductTests) Import(t *testing.T) {
+
+	do := func(body, contentType, mode, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/products/import?mode="+mode, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", contentType)
+		resp := httptest.NewRecorder()
+		p.app.ServeHTTP(resp, req)
+		return resp
+	}
+
+	const csvFile = "name,cost,currency,quantity,tags\n" +
+		"Lamp,12.50,USD,3,home;light\n" +
+		"Chair,abc,USD,1,\n"
+	const ndjsonFile = `{"name":"Rug","cost":{"amount":4000,"currency":"EUR"},"quantity":2}` + "\n\n" +
+		`{"name":"Vase","cost":{"amount":900,"currency":"EUR"},"quantity":1,"colour":"blue"}` + "\n"
+
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		mode        string
+		token       string
+		want        int
+	}{
+		{"AllOrNothing", csvFile, "text/csv", "all-or-nothing", p.adminToken, http.StatusUnprocessableEntity},
+		{"Cashier", csvFile, "text/csv", "best-effort", p.cashierToken, http.StatusForbidden},
+		{"UnknownType", csvFile, "text/plain", "best-effort", p.adminToken, http.StatusUnsupportedMediaType},
+		{"UnknownMode", csvFile, "text/csv", "some", p.adminToken, http.StatusBadRequest},
+		{"BadHeader", "name,price\nLamp,1\n", "text/csv", "best-effort", p.adminToken, http.StatusBadRequest},
+		{"Empty", "", "application/x-ndjson", "best-effort", p.adminToken, http.StatusBadRequest},
+		{"TooLarge", strings.Repeat("\n", 32<<20+1), "application/x-ndjson", "best-effort", p.adminToken, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		if resp := do(tt.body, tt.contentType, tt.mode, tt.token); resp.Code != tt.want {
+			t.Fatalf("%s: expected status code %v, got %v: %s", tt.name, tt.want, resp.Code, resp.Body)
+		}
+	}
+
+	for _, tt := range []struct {
+		body        string
+		contentType string
+		badRow      int
+	}{
+		{csvFile, "text/csv; charset=utf-8", 2},
+		{ndjsonFile, "application/x-ndjson", 2},
+	} {
+		resp := do(tt.body, tt.contentType, "best-effort", p.adminToken)
+		if resp.Code != http.StatusCreated {
+			t.Fatalf("importing %s: expected status code %v, got %v: %s", tt.contentType, http.StatusCreated, resp.Code, resp.Body)
+		}
+
+		var result product.ImportResult
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("decoding: %s", err)
+		}
+		if result.Rows != 2 || result.Imported != 1 || len(result.Products) != 1 {
+			t.Fatalf("importing %s: expected one of two rows imported, got %+v", tt.contentType, result)
+		}
+		if len(result.Errors) != 1 || result.Errors[0].Row != tt.badRow {
+			t.Fatalf("importing %s: expected an error for row %d, got %+v", tt.contentType, tt.badRow, result.Errors)
+		}
+	}
+}
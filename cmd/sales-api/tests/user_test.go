@@ -2,11 +2,16 @@ package tests
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/sreejeet/garagesale/cmd/sales-api/internal/handlers"
+	"github.com/sreejeet/garagesale/internal/platform/mail"
 	"github.com/sreejeet/garagesale/internal/tests"
 )
 
@@ -15,19 +20,40 @@ func TestUsers(t *testing.T) {
 	test := tests.New(t)
 	defer test.Teardown()
 
-	ut := UserTests{app: handlers.API(test.DB, test.Log, test.Authenticator)}
+	shutdown := make(chan os.Signal, 1)
+	ut := UserTests{
+		app:        handlers.API(shutdown, test.DB, test.Log, test.Authenticator, test.Keys, test.Outbox, test.Store, "http://localhost", test.Guard),
+		adminToken: test.Token("admin@example.com", "gophers"),
+		userToken:  test.Token("user@example.com", "gophers"),
+		outbox:     test.Outbox,
+	}
 
 	t.Run("TokenRequireAuth", ut.TokenRequireAuth)
 	t.Run("TokenDenyUnknown", ut.TokenDenyUnknown)
 	t.Run("TokenDenyBadPassword", ut.TokenDenyBadPassword)
 	t.Run("TokenSuccess", ut.TokenSuccess)
+	t.Run("Me", ut.Me)
+	t.Run("CreateRequiresAdmin", ut.CreateRequiresAdmin)
+	t.Run("Create", ut.Create)
+	t.Run("Patch", ut.Patch)
+	t.Run("Register", ut.Register)
+	t.Run("PasswordPolicy", ut.PasswordPolicy)
+	t.Run("PasswordReset", ut.PasswordReset)
+	t.Run("Lockout", ut.Lockout)
+	t.Run("Sessions", ut.Sessions)
+	t.Run("APIKeys", ut.APIKeys)
+	t.Run("JWKS", ut.JWKS)
+	t.Run("Orgs", ut.Orgs)
 }
 
 // UserTests holds methods for each user subtest. This type allows passing
 // dependencies for tests while still providing a convenient syntax when
 // subtests are registered.
 type UserTests struct {
-	app http.Handler
+	app        http.Handler
+	adminToken string
+	userToken  string
+	outbox     *mail.Outbox
 }
 
 // TokenRequireAuth ensures that requests with no authentication are denied.
@@ -92,11 +118,653 @@ func (ut *UserTests) TokenSuccess(t *testing.T) {
 		t.Fatalf("decoding: %s", err)
 	}
 
-	if len(got) != 1 {
+	if len(got) != 2 {
 		t.Error("unexpected values in token response")
 	}
 
 	if got["token"] == "" {
 		t.Fatal("token was not in response")
 	}
+	if got["refresh_token"] == "" {
+		t.Fatal("refresh token was not in response")
+	}
+}
+
+// Me ensures users can read their own record and that it never includes the password hash.
+func (ut *UserTests) Me(t *testing.T) {
+
+	req := httptest.NewRequest("GET", "/v1/users/me", nil)
+	req.Header.Set("Authorization", "Bearer "+ut.userToken)
+	resp := httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Fatalf("getting: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+
+	var got map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+
+	if exp := tests.UserID; got["id"] != exp {
+		t.Fatalf("expected user id %v, got %v", exp, got["id"])
+	}
+	if _, ok := got["password_hash"]; ok {
+		t.Fatal("password hash should not be in response")
+	}
+
+	// Regular users can not read other users.
+	req = httptest.NewRequest("GET", "/v1/users/"+tests.AdminID, nil)
+	req.Header.Set("Authorization", "Bearer "+ut.userToken)
+	resp = httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusForbidden {
+		t.Fatalf("getting: expected status code %v, got %v", http.StatusForbidden, resp.Code)
+	}
+}
+
+// CreateRequiresAdmin ensures regular users can not create users.
+func (ut *UserTests) CreateRequiresAdmin(t *testing.T) {
+
+	body := strings.NewReader(`{}`)
+	req := httptest.NewRequest("POST", "/v1/users", body)
+	req.Header.Set("Authorization", "Bearer "+ut.userToken)
+	resp := httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusForbidden {
+		t.Fatalf("posting: expected status code %v, got %v", http.StatusForbidden, resp.Code)
+	}
+}
+
+// Create ensures admins can create users who can then get a token.
+func (ut *UserTests) Create(t *testing.T) {
+
+	body := strings.NewReader(`{"name":"Leela","email":"leela@example.com","roles":["USER"],"password":"gophers-rule","password_confirm":"gophers-rule"}`)
+	req := httptest.NewRequest("POST", "/v1/users", body)
+	req.Header.Set("Authorization", "Bearer "+ut.adminToken)
+	resp := httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("posting: expected status code %v, got %v", http.StatusCreated, resp.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/v1/users/token", nil)
+	req.SetBasicAuth("leela@example.com", "gophers-rule")
+	resp = httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Fatalf("getting token: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+}
+
+// Patch ensures users can patch their own record but only admins can change roles.
+func (ut *UserTests) Patch(t *testing.T) {
+
+	patches := []struct {
+		name   string
+		token  string
+		typ    string
+		body   string
+		status int
+	}{
+		{"OwnName", ut.userToken, "application/merge-patch+json", `{"name":"Amy Wong"}`, http.StatusNoContent},
+		{"OwnRoles", ut.userToken, "application/json-patch+json", `[{"op":"add","path":"/roles/-","value":"ADMIN"}]`, http.StatusForbidden},
+		{"InvalidEmail", ut.userToken, "application/merge-patch+json", `{"email":"amy"}`, http.StatusBadRequest},
+		{"AdminRoles", ut.adminToken, "application/json-patch+json", `[{"op":"replace","path":"/roles","value":["USER"]}]`, http.StatusNoContent},
+	}
+
+	for _, tt := range patches {
+		req := httptest.NewRequest("PATCH", "/v1/users/"+tests.UserID, strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", tt.typ)
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		resp := httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != tt.status {
+			t.Fatalf("patching %s: expected status code %v, got %v", tt.name, tt.status, resp.Code)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/v1/users/me", nil)
+	req.Header.Set("Authorization", "Bearer "+ut.userToken)
+	resp := httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	var got map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+	if exp := "Amy Wong"; got["name"] != exp {
+		t.Fatalf("expected name %q, got %v", exp, got["name"])
+	}
+	if exp, roles := 1, got["roles"].([]interface{}); len(roles) != exp {
+		t.Fatalf("expected %d roles, got %v", exp, roles)
+	}
+}
+
+// Register ensures self registered users can only log in after verifying their email.
+func (ut *UserTests) Register(t *testing.T) {
+
+	body := strings.NewReader(`{"name":"Fry","email":"fry@example.com","password":"gophers-rule","password_confirm":"gophers-rule"}`)
+	req := httptest.NewRequest("POST", "/v1/users/register", body)
+	resp := httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("registering: expected status code %v, got %v", http.StatusCreated, resp.Code)
+	}
+
+	// Unverified users are refused a token.
+	req = httptest.NewRequest("GET", "/v1/users/token", nil)
+	req.SetBasicAuth("fry@example.com", "gophers-rule")
+	resp = httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusForbidden {
+		t.Fatalf("getting token: expected status code %v, got %v", http.StatusForbidden, resp.Code)
+	}
+
+	// A lost verification email can be sent again. Unknown addresses
+	// get the same response.
+	for _, email := range []string{"fry@example.com", "nobody@example.com"} {
+		req = httptest.NewRequest("POST", "/v1/users/verify/resend", strings.NewReader(`{"email":"`+email+`"}`))
+		resp = httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusNoContent {
+			t.Fatalf("resending verification to %s: expected status code %v, got %v", email, http.StatusNoContent, resp.Code)
+		}
+	}
+
+	// Follow the link from the latest verification email.
+	msgs, err := ut.outbox.Messages()
+	if err != nil {
+		t.Fatalf("reading outbox: %s", err)
+	}
+	if len(msgs) == 0 {
+		t.Fatal("no verification email was sent")
+	}
+	link := regexp.MustCompile(`http://localhost(/v1/users/verify\?token=\S+)`).FindStringSubmatch(msgs[len(msgs)-1])
+	if link == nil {
+		t.Fatalf("verification link missing from email:\n%s", msgs[len(msgs)-1])
+	}
+
+	req = httptest.NewRequest("GET", link[1], nil)
+	resp = httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusNoContent {
+		t.Fatalf("verifying: expected status code %v, got %v", http.StatusNoContent, resp.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/v1/users/token", nil)
+	req.SetBasicAuth("fry@example.com", "gophers-rule")
+	resp = httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Fatalf("getting token: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+}
+
+// PasswordPolicy ensures weak passwords are rejected.
+func (ut *UserTests) PasswordPolicy(t *testing.T) {
+
+	body := strings.NewReader(`{"name":"Zoidberg","email":"zoidberg@example.com","password":"password","password_confirm":"password"}`)
+	req := httptest.NewRequest("POST", "/v1/users/register", body)
+	resp := httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("registering: expected status code %v, got %v", http.StatusBadRequest, resp.Code)
+	}
+}
+
+// PasswordReset ensures a reset token can be used once to choose a new password.
+func (ut *UserTests) PasswordReset(t *testing.T) {
+
+	body := strings.NewReader(`{"email":"user@example.com"}`)
+	req := httptest.NewRequest("POST", "/v1/users/password/forgot", body)
+	resp := httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusNoContent {
+		t.Fatalf("requesting reset: expected status code %v, got %v", http.StatusNoContent, resp.Code)
+	}
+
+	msgs, err := ut.outbox.Messages()
+	if err != nil {
+		t.Fatalf("reading outbox: %s", err)
+	}
+	token := regexp.MustCompile(`(?m)^([A-Za-z0-9_-]{43})\r$`).FindStringSubmatch(msgs[len(msgs)-1])
+	if token == nil {
+		t.Fatalf("reset token missing from email:\n%s", msgs[len(msgs)-1])
+	}
+
+	for _, want := range []int{http.StatusNoContent, http.StatusBadRequest} {
+		body = strings.NewReader(`{"token":"` + token[1] + `","password":"new-gophers","password_confirm":"new-gophers"}`)
+		req = httptest.NewRequest("POST", "/v1/users/password/reset", body)
+		resp = httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != want {
+			t.Fatalf("resetting: expected status code %v, got %v", want, resp.Code)
+		}
+	}
+
+	req = httptest.NewRequest("GET", "/v1/users/token", nil)
+	req.SetBasicAuth("user@example.com", "new-gophers")
+	resp = httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Fatalf("getting token: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+}
+
+// Lockout ensures repeated failed logins lock an account until an admin unlocks it.
+func (ut *UserTests) Lockout(t *testing.T) {
+
+	body := strings.NewReader(`{"name":"Kif","email":"kif@example.com","roles":["USER"],"password":"gophers-rule","password_confirm":"gophers-rule"}`)
+	req := httptest.NewRequest("POST", "/v1/users", body)
+	req.Header.Set("Authorization", "Bearer "+ut.adminToken)
+	resp := httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("posting: expected status code %v, got %v", http.StatusCreated, resp.Code)
+	}
+
+	var created map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+
+	// The test guard locks accounts after 3 failures.
+	for i := 0; i < 3; i++ {
+		req = httptest.NewRequest("GET", "/v1/users/token", nil)
+		req.SetBasicAuth("kif@example.com", "wrong")
+		resp = httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusUnauthorized {
+			t.Fatalf("getting token: expected status code %v, got %v", http.StatusUnauthorized, resp.Code)
+		}
+	}
+
+	// Even the right password is refused while locked.
+	req = httptest.NewRequest("GET", "/v1/users/token", nil)
+	req.SetBasicAuth("kif@example.com", "gophers-rule")
+	resp = httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusTooManyRequests {
+		t.Fatalf("getting token: expected status code %v, got %v", http.StatusTooManyRequests, resp.Code)
+	}
+	if resp.Header().Get("Retry-After") == "" {
+		t.Fatal("expected a Retry-After header")
+	}
+
+	req = httptest.NewRequest("DELETE", fmt.Sprintf("/v1/users/%s/lockout", created["id"]), nil)
+	req.Header.Set("Authorization", "Bearer "+ut.adminToken)
+	resp = httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusNoContent {
+		t.Fatalf("unlocking: expected status code %v, got %v", http.StatusNoContent, resp.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/v1/users/token", nil)
+	req.SetBasicAuth("kif@example.com", "gophers-rule")
+	resp = httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Fatalf("getting token: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+}
+
+// Sessions ensures refresh tokens rotate, that reusing one ends the session
+// and that logged out tokens are refused.
+func (ut *UserTests) Sessions(t *testing.T) {
+
+	req := httptest.NewRequest("GET", "/v1/users/token", nil)
+	req.SetBasicAuth("admin@example.com", "gophers")
+	resp := httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Fatalf("getting token: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+
+	var first map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&first); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+
+	refresh := func(token string) *httptest.ResponseRecorder {
+		body := strings.NewReader(`{"refresh_token":"` + token + `"}`)
+		req := httptest.NewRequest("POST", "/v1/users/token/refresh", body)
+		resp := httptest.NewRecorder()
+		ut.app.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp = refresh(first["refresh_token"])
+	if resp.Code != http.StatusOK {
+		t.Fatalf("refreshing: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+
+	var second map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&second); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+	if second["token"] == "" || second["refresh_token"] == first["refresh_token"] {
+		t.Fatalf("expected a new token pair, got %v", second)
+	}
+
+	// Using the first refresh token again is treated as theft so
+	// the whole session, including the rotated token, is revoked.
+	if resp = refresh(first["refresh_token"]); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("reusing: expected status code %v, got %v", http.StatusUnauthorized, resp.Code)
+	}
+	if resp = refresh(second["refresh_token"]); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("refreshing revoked: expected status code %v, got %v", http.StatusUnauthorized, resp.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/v1/users/logout", nil)
+	req.Header.Set("Authorization", "Bearer "+second["token"])
+	resp = httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusNoContent {
+		t.Fatalf("logging out: expected status code %v, got %v", http.StatusNoContent, resp.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/v1/users/me", nil)
+	req.Header.Set("Authorization", "Bearer "+second["token"])
+	resp = httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusUnauthorized {
+		t.Fatalf("getting after logout: expected status code %v, got %v", http.StatusUnauthorized, resp.Code)
+	}
+
+	// Other sessions are not affected by the logout.
+	req = httptest.NewRequest("GET", "/v1/users/me", nil)
+	req.Header.Set("Authorization", "Bearer "+ut.adminToken)
+	resp = httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Fatalf("getting: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+}
+
+// APIKeys ensures admins can issue API keys that authenticate with their own
+// roles until they are revoked.
+func (ut *UserTests) APIKeys(t *testing.T) {
+
+	body := strings.NewReader(`{"name":"Tablet 1","roles":["USER"]}`)
+	req := httptest.NewRequest("POST", "/v1/apikeys", body)
+	req.Header.Set("Authorization", "Bearer "+ut.adminToken)
+	resp := httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("posting: expected status code %v, got %v", http.StatusCreated, resp.Code)
+	}
+
+	var created map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+	key, _ := created["key"].(string)
+	if !strings.HasPrefix(key, created["prefix"].(string)) {
+		t.Fatalf("expected key %q to start with prefix %q", key, created["prefix"])
+	}
+
+	// Keys can not have roles that do not exist.
+	req = httptest.NewRequest("POST", "/v1/apikeys", strings.NewReader(`{"name":"Tablet 2","roles":["ROOT"]}`))
+	req.Header.Set("Authorization", "Bearer "+ut.adminToken)
+	resp = httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("posting unknown role: expected status code %v, got %v", http.StatusBadRequest, resp.Code)
+	}
+
+	// The key is never shown again.
+	req = httptest.NewRequest("GET", "/v1/apikeys", nil)
+	req.Header.Set("Authorization", "Bearer "+ut.adminToken)
+	resp = httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Fatalf("listing: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+	if strings.Contains(resp.Body.String(), key) {
+		t.Fatal("listing must not include the key")
+	}
+
+	for _, tt := range []struct {
+		method, url string
+		want        int
+	}{
+		{"GET", "/v1/products", http.StatusOK},
+		{"GET", "/v1/apikeys", http.StatusForbidden},
+		{"PUT", "/v1/users/me/password", http.StatusForbidden},
+		{"PATCH", "/v1/users/" + tests.AdminID, http.StatusForbidden},
+		{"DELETE", "/v1/users/" + tests.AdminID + "/sessions", http.StatusForbidden},
+	} {
+		req = httptest.NewRequest(tt.method, tt.url, nil)
+		req.Header.Set("Authorization", "ApiKey "+key)
+		resp = httptest.NewRecorder()
+
+		ut.app.ServeHTTP(resp, req)
+
+		if resp.Code != tt.want {
+			t.Fatalf("%s %s with key: expected status code %v, got %v", tt.method, tt.url, tt.want, resp.Code)
+		}
+	}
+
+	req = httptest.NewRequest("DELETE", fmt.Sprintf("/v1/apikeys/%s", created["id"]), nil)
+	req.Header.Set("Authorization", "Bearer "+ut.adminToken)
+	resp = httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusNoContent {
+		t.Fatalf("revoking: expected status code %v, got %v", http.StatusNoContent, resp.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/v1/products", nil)
+	req.Header.Set("Authorization", "ApiKey "+key)
+	resp = httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusUnauthorized {
+		t.Fatalf("using revoked key: expected status code %v, got %v", http.StatusUnauthorized, resp.Code)
+	}
+}
+
+// JWKS ensures the public keys for verifying tokens are published.
+func (ut *UserTests) JWKS(t *testing.T) {
+
+	req := httptest.NewRequest("GET", "/.well-known/jwks.json", nil)
+	resp := httptest.NewRecorder()
+
+	ut.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Fatalf("getting: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+
+	var got struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+
+	if len(got.Keys) != 1 {
+		t.Fatalf("expected a single key, got %d", len(got.Keys))
+	}
+	if k := got.Keys[0]; k["kty"] != "RSA" || k["alg"] != "RS256" || k["kid"] == "" || k["n"] == "" || k["e"] == "" {
+		t.Fatalf("unexpected key %v", k)
+	}
+}
+
+// Orgs ensures members can be invited to organizations and that the data
+// of one organization can not be reached from another.
+func (ut *UserTests) Orgs(t *testing.T) {
+
+	// do sends a request with a bearer token and returns the response.
+	do := func(method, url, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+		ut.app.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// login requests a token acting in the organization org.
+	login := func(email, pass, org string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/v1/users/token?org="+org, nil)
+		req.SetBasicAuth(email, pass)
+		resp := httptest.NewRecorder()
+		ut.app.ServeHTTP(resp, req)
+		return resp
+	}
+
+	token := func(resp *httptest.ResponseRecorder) string {
+		var got map[string]string
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatalf("decoding token: %s", err)
+		}
+		return got["token"]
+	}
+
+	// Fry registered without joining an organization.
+	resp := login("fry@example.com", "gophers-rule", "")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("getting token: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+	fryToken := token(resp)
+
+	if resp := do("GET", "/v1/products", fryToken, ""); resp.Code != http.StatusForbidden {
+		t.Fatalf("listing products without organization: expected status code %v, got %v", http.StatusForbidden, resp.Code)
+	}
+
+	resp = do("POST", "/v1/orgs", ut.adminToken, `{"name":"Planet Express"}`)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("creating organization: expected status code %v, got %v", http.StatusCreated, resp.Code)
+	}
+	var created map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+	orgID := created["id"].(string)
+
+	// Members are managed from a token acting in the organization.
+	if resp := do("POST", "/v1/orgs/"+orgID+"/invites", ut.adminToken, `{"email":"fry@example.com"}`); resp.Code != http.StatusForbidden {
+		t.Fatalf("inviting from another organization: expected status code %v, got %v", http.StatusForbidden, resp.Code)
+	}
+
+	resp = login("admin@example.com", "gophers", orgID)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("switching organization: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+	orgToken := token(resp)
+
+	if resp := do("POST", "/v1/orgs/"+orgID+"/invites", orgToken, `{"email":"fry@example.com"}`); resp.Code != http.StatusNoContent {
+		t.Fatalf("inviting: expected status code %v, got %v", http.StatusNoContent, resp.Code)
+	}
+
+	msgs, err := ut.outbox.Messages()
+	if err != nil {
+		t.Fatalf("reading outbox: %s", err)
+	}
+	invite := regexp.MustCompile(`sending this token:\r\n\r\n(\S+)`).FindStringSubmatch(msgs[len(msgs)-1])
+	if invite == nil {
+		t.Fatalf("invitation token missing from email:\n%s", msgs[len(msgs)-1])
+	}
+
+	if resp := do("POST", "/v1/orgs/invites/accept", ut.userToken, fmt.Sprintf(`{"token":%q}`, invite[1])); resp.Code != http.StatusBadRequest {
+		t.Fatalf("accepting invitation of someone else: expected status code %v, got %v", http.StatusBadRequest, resp.Code)
+	}
+	if resp := do("POST", "/v1/orgs/invites/accept", fryToken, fmt.Sprintf(`{"token":%q}`, invite[1])); resp.Code != http.StatusOK {
+		t.Fatalf("accepting invitation: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+
+	resp = login("fry@example.com", "gophers-rule", orgID)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("getting token for organization: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+	fryToken = token(resp)
+
+	// The products of the default organization are not visible.
+	resp = do("GET", "/v1/products", fryToken, "")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("listing products: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+	var page struct {
+		Total int `json:"total"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+	if page.Total != 0 {
+		t.Fatalf("expected no products in a new organization, got %d", page.Total)
+	}
+	if resp := do("GET", "/v1/products/a2b0639f-2cc6-44b8-b97b-15d69dbb511e", fryToken, ""); resp.Code != http.StatusNotFound {
+		t.Fatalf("reading product of another organization: expected status code %v, got %v", http.StatusNotFound, resp.Code)
+	}
+
+	// Invitees join as regular users while the creator administers
+	// the organization.
+	if resp := do("GET", "/v1/orgs/"+orgID+"/members", fryToken, ""); resp.Code != http.StatusForbidden {
+		t.Fatalf("listing members as regular user: expected status code %v, got %v", http.StatusForbidden, resp.Code)
+	}
+	if resp := do("GET", "/v1/orgs/"+orgID+"/members", orgToken, ""); resp.Code != http.StatusOK {
+		t.Fatalf("listing members as creator: expected status code %v, got %v", http.StatusOK, resp.Code)
+	}
+
+	if resp := login("user@example.com", "gophers", orgID); resp.Code != http.StatusForbidden {
+		t.Fatalf("getting token for foreign organization: expected status code %v, got %v", http.StatusForbidden, resp.Code)
+	}
 }
@@ -0,0 +1,264 @@
+package apikey
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/sreejeet/garagesale/internal/platform/auth"
+	"github.com/sreejeet/garagesale/internal/policy"
+	"go.opencensus.io/trace"
+)
+
+// Custom errors for expected failing conditions
+var (
+	// Invalid UUID
+	ErrInvalidID = errors.New("invalid ID")
+	// Unable to find key based on UUID
+	ErrNotFound = errors.New("api key not found")
+	// A role of a new key does not exist
+	ErrUnknownRole = errors.New("api key roles must be known roles")
+	// A role of a new key is not held by its creator
+	ErrForbidden = errors.New("api keys can only have roles of their creator")
+)
+
+// keyPrefix starts every key so they are easy to spot, for example
+// by secret scanners, and to tell apart from bearer tokens.
+const keyPrefix = "gsk_"
+
+// prefixLen is how much of a key is kept in clear text for listings.
+const prefixLen = len(keyPrefix) + 6
+
+// Create generates a new API key with the provided roles on behalf of the
+// user in the claims. The key acts in the active organization of the user.
+// The roles must be known and held by the user in that organization, else
+// ErrUnknownRole or ErrForbidden is returned. The full key is only returned
+// here.
+func Create(ctx context.Context, db *sqlx.DB, user auth.Claims, nk NewKey, now time.Time) (*Created, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.apikey.Create")
+	defer span.End()
+
+	if _, err := uuid.Parse(user.OrgID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	roles := []string{}
+	seen := map[string]bool{}
+	for _, r := range nk.Roles {
+		if !seen[r] {
+			seen[r] = true
+			roles = append(roles, r)
+		}
+	}
+
+	known, err := policy.KnownRoles(ctx, db, roles)
+	if err != nil {
+		return nil, err
+	}
+	if !known {
+		return nil, ErrUnknownRole
+	}
+
+	// A key can never do more than the user creating it.
+	var held pq.StringArray
+	const mq = `SELECT roles FROM org_members WHERE org_id = $1 AND user_id = $2`
+	if err := db.GetContext(ctx, &held, mq, user.OrgID, user.Subject); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrForbidden
+		}
+		return nil, errors.Wrap(err, "selecting roles of the creator")
+	}
+	for _, r := range roles {
+		if !contains(held, r) {
+			return nil, ErrForbidden
+		}
+	}
+
+	secret, _, err := auth.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+	secret = keyPrefix + secret
+
+	c := Created{
+		Key: Key{
+			ID:          uuid.New().String(),
+			Name:        nk.Name,
+			Prefix:      secret[:prefixLen],
+			KeyHash:     auth.HashSecret(secret),
+			Roles:       roles,
+			UserID:      user.Subject,
+			OrgID:       user.OrgID,
+			DateCreated: now.UTC(),
+		},
+		Secret: secret,
+	}
+
+	const q = `INSERT INTO api_keys
+		(key_id, name, prefix, key_hash, roles, user_id, org_id, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	_, err = db.ExecContext(ctx, q,
+		c.ID, c.Name, c.Prefix, c.KeyHash,
+		c.Roles, c.UserID, c.OrgID, c.DateCreated,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting api key")
+	}
+
+	return &c, nil
+}
+
+// List returns a page of API keys of the organization orgID, newest first.
+// Revoked keys are included so admins can see when they were revoked.
+func List(ctx context.Context, db *sqlx.DB, orgID string, page, limit int) ([]Key, int, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.apikey.List")
+	defer span.End()
+
+	if _, err := uuid.Parse(orgID); err != nil {
+		return nil, 0, ErrInvalidID
+	}
+
+	var total int
+	const count = `SELECT COUNT(*) FROM api_keys WHERE org_id = $1`
+	if err := db.GetContext(ctx, &total, count, orgID); err != nil {
+		return nil, 0, errors.Wrap(err, "counting api keys")
+	}
+
+	keys := []Key{}
+	const q = `SELECT * FROM api_keys
+				WHERE org_id = $1
+				ORDER BY date_created DESC, key_id
+				LIMIT $2 OFFSET $3`
+	if err := db.SelectContext(ctx, &keys, q, orgID, limit, (page-1)*limit); err != nil {
+		return nil, 0, errors.Wrap(err, "selecting api keys")
+	}
+
+	return keys, total, nil
+}
+
+// Revoke makes an API key of the organization orgID unusable. Revoking
+// a key twice keeps the time of the first revocation.
+func Revoke(ctx context.Context, db *sqlx.DB, orgID, id string, now time.Time) error {
+
+	ctx, span := trace.StartSpan(ctx, "internal.apikey.Revoke")
+	defer span.End()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+	if _, err := uuid.Parse(orgID); err != nil {
+		return ErrInvalidID
+	}
+
+	const q = `UPDATE api_keys SET date_revoked = COALESCE(date_revoked, $3)
+				WHERE key_id = $1 AND org_id = $2`
+	res, err := db.ExecContext(ctx, q, id, orgID, now.UTC())
+	if err != nil {
+		return errors.Wrapf(err, "revoking api key %s", id)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "counting revoked api keys")
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// RevokeAll makes every API key of the user unusable, in all of their
+// organizations. Keys revoked earlier keep the time of their revocation.
+func RevokeAll(ctx context.Context, db *sqlx.DB, userID string, now time.Time) error {
+
+	ctx, span := trace.StartSpan(ctx, "internal.apikey.RevokeAll")
+	defer span.End()
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return ErrInvalidID
+	}
+
+	const q = `UPDATE api_keys SET date_revoked = $2
+				WHERE user_id = $1 AND date_revoked IS NULL`
+	if _, err := db.ExecContext(ctx, q, userID, now.UTC()); err != nil {
+		return errors.Wrapf(err, "revoking api keys of user %s", userID)
+	}
+
+	return nil
+}
+
+// lastUsedPrecision is how stale the recorded last use of a key may get.
+// Busy keys would otherwise cause a write on every request.
+const lastUsedPrecision = time.Minute
+
+// Authenticate returns a KeyFunc looking up API keys in the database. The
+// claims carry the organization of the key, the user who created it as the
+// subject and the key's ID in KeyID. Their roles are those of the key the
+// user still holds in the organization, and keys of users who have left it
+// are rejected.
+func Authenticate(db *sqlx.DB) auth.KeyFunc {
+
+	f := func(ctx context.Context, key string, now time.Time) (auth.Claims, error) {
+
+		ctx, span := trace.StartSpan(ctx, "internal.apikey.Authenticate")
+		defer span.End()
+
+		var k struct {
+			Key
+			MemberRoles pq.StringArray `db:"member_roles"`
+		}
+		const q = `SELECT k.*, m.roles AS member_roles FROM api_keys AS k
+					JOIN org_members AS m ON m.org_id = k.org_id AND m.user_id = k.user_id
+					WHERE k.key_hash = $1 AND k.date_revoked IS NULL`
+		if err := db.GetContext(ctx, &k, q, auth.HashSecret(key)); err != nil {
+			if err == sql.ErrNoRows {
+				return auth.Claims{}, auth.ErrInvalidKey
+			}
+			return auth.Claims{}, errors.Wrap(err, "selecting api key")
+		}
+
+		// The condition is repeated in the statement so concurrent
+		// requests do not all write.
+		if k.DateLastUsed == nil || now.Sub(*k.DateLastUsed) >= lastUsedPrecision {
+			const q = `UPDATE api_keys SET date_last_used = $2
+						WHERE key_id = $1 AND (date_last_used IS NULL OR date_last_used <= $3)`
+			stale := now.Add(-lastUsedPrecision).UTC()
+			if _, err := db.ExecContext(ctx, q, k.ID, now.UTC(), stale); err != nil {
+				return auth.Claims{}, errors.Wrap(err, "recording api key use")
+			}
+		}
+
+		roles := []string{}
+		for _, r := range k.Roles {
+			if contains(k.MemberRoles, r) {
+				roles = append(roles, r)
+			}
+		}
+
+		// The claims only live for the request so a short expiry is enough.
+		claims := auth.NewClaims(k.UserID, roles, now, time.Minute)
+		claims.OrgID = k.OrgID
+		claims.KeyID = k.ID
+
+		return claims, nil
+	}
+
+	return f
+}
+
+// contains reports whether roles holds role.
+func contains(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package apikey_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sreejeet/garagesale/internal/apikey"
+	"github.com/sreejeet/garagesale/internal/org"
+	"github.com/sreejeet/garagesale/internal/platform/auth"
+	"github.com/sreejeet/garagesale/internal/tests"
+)
+
+func TestAPIKeys(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	admin := auth.NewClaims(tests.AdminID, []string{auth.RoleAdmin}, now, time.Hour)
+	admin.OrgID = org.DefaultID
+
+	nk := apikey.NewKey{
+		Name:  "Nightly sync",
+		Roles: []string{auth.RoleUser},
+	}
+	k, err := apikey.Create(ctx, db, admin, nk, now)
+	if err != nil {
+		t.Fatalf("creating api key: %s", err)
+	}
+
+	lookup := apikey.Authenticate(db)
+
+	claims, err := lookup(ctx, k.Secret, now)
+	if err != nil {
+		t.Fatalf("authenticating with key: %s", err)
+	}
+	if claims.Subject != tests.AdminID || claims.KeyID != k.ID || claims.OrgID != org.DefaultID || !claims.HasRole(auth.RoleUser) || claims.HasRole(auth.RoleAdmin) {
+		t.Fatalf("unexpected claims for key: %+v", claims)
+	}
+
+	if _, err := lookup(ctx, k.Secret+"x", now); err != auth.ErrInvalidKey {
+		t.Fatalf("expected %v for unknown key, got %v", auth.ErrInvalidKey, err)
+	}
+
+	keys, total, err := apikey.List(ctx, db, org.DefaultID, 1, 10)
+	if err != nil {
+		t.Fatalf("listing api keys: %s", err)
+	}
+	if total != 1 || len(keys) != 1 || keys[0].DateLastUsed == nil || !keys[0].DateLastUsed.Equal(now) {
+		t.Fatalf("expected one key used at %s, got %d: %+v", now, total, keys)
+	}
+
+	// The last use is only recorded once a minute.
+	for _, tt := range []struct {
+		used, want time.Time
+	}{
+		{now.Add(30 * time.Second), now},
+		{now.Add(time.Minute), now.Add(time.Minute)},
+	} {
+		if _, err := lookup(ctx, k.Secret, tt.used); err != nil {
+			t.Fatalf("authenticating with key: %s", err)
+		}
+		keys, _, err := apikey.List(ctx, db, org.DefaultID, 1, 10)
+		if err != nil {
+			t.Fatalf("listing api keys: %s", err)
+		}
+		if !keys[0].DateLastUsed.Equal(tt.want) {
+			t.Fatalf("using key at %s: expected last use at %s, got %s", tt.used, tt.want, keys[0].DateLastUsed)
+		}
+	}
+
+	if err := apikey.Revoke(ctx, db, org.DefaultID, k.ID, now); err != nil {
+		t.Fatalf("revoking api key: %s", err)
+	}
+	if _, err := lookup(ctx, k.Secret, now); err != auth.ErrInvalidKey {
+		t.Fatalf("expected %v for revoked key, got %v", auth.ErrInvalidKey, err)
+	}
+
+	if err := apikey.Revoke(ctx, db, org.DefaultID, "8b4d2d0e-7d6b-4a36-9d8e-2c1b7b2e4f6a", now); err != apikey.ErrNotFound {
+		t.Fatalf("expected %v revoking unknown key, got %v", apikey.ErrNotFound, err)
+	}
+
+	// Revoking all keys of a user leaves the keys of others alone.
+	mine, err := apikey.Create(ctx, db, admin, nk, now)
+	if err != nil {
+		t.Fatalf("creating api key: %s", err)
+	}
+	other := auth.NewClaims(tests.UserID, []string{auth.RoleUser}, now, time.Hour)
+	other.OrgID = org.DefaultID
+	theirs, err := apikey.Create(ctx, db, other, nk, now)
+	if err != nil {
+		t.Fatalf("creating api key: %s", err)
+	}
+
+	if err := apikey.RevokeAll(ctx, db, tests.AdminID, now); err != nil {
+		t.Fatalf("revoking api keys of user: %s", err)
+	}
+	if _, err := lookup(ctx, mine.Secret, now); err != auth.ErrInvalidKey {
+		t.Fatalf("expected %v for key of revoked user, got %v", auth.ErrInvalidKey, err)
+	}
+	if _, err := lookup(ctx, theirs.Secret, now); err != nil {
+		t.Fatalf("keys of other users should still work: %s", err)
+	}
+
+	// Keys only get roles their creator holds in the organization.
+	if _, err := apikey.Create(ctx, db, admin, apikey.NewKey{Name: "Typo", Roles: []string{"ADMN"}}, now); err != apikey.ErrUnknownRole {
+		t.Fatalf("expected %v for unknown role, got %v", apikey.ErrUnknownRole, err)
+	}
+	if _, err := apikey.Create(ctx, db, other, apikey.NewKey{Name: "Escalate", Roles: []string{auth.RoleAdmin}}, now); err != apikey.ErrForbidden {
+		t.Fatalf("expected %v for role not held, got %v", apikey.ErrForbidden, err)
+	}
+
+	// Keys lose the roles their user loses and stop working when the user
+	// leaves the organization.
+	strong, err := apikey.Create(ctx, db, admin, apikey.NewKey{Name: "Admin", Roles: []string{auth.RoleAdmin, auth.RoleUser}}, now)
+	if err != nil {
+		t.Fatalf("creating api key: %s", err)
+	}
+	const demote = `UPDATE org_members SET roles = '{USER}' WHERE org_id = $1 AND user_id = $2`
+	if _, err := db.Exec(demote, org.DefaultID, tests.AdminID); err != nil {
+		t.Fatalf("demoting admin: %s", err)
+	}
+	claims, err = lookup(ctx, strong.Secret, now)
+	if err != nil {
+		t.Fatalf("authenticating with key: %s", err)
+	}
+	if claims.HasRole(auth.RoleAdmin) || !claims.HasRole(auth.RoleUser) {
+		t.Fatalf("expected the key of a demoted user to lose the admin role, got %v", claims.Roles)
+	}
+	const remove = `DELETE FROM org_members WHERE org_id = $1 AND user_id = $2`
+	if _, err := db.Exec(remove, org.DefaultID, tests.AdminID); err != nil {
+		t.Fatalf("removing admin: %s", err)
+	}
+	if _, err := lookup(ctx, strong.Secret, now); err != auth.ErrInvalidKey {
+		t.Fatalf("expected %v for key of removed user, got %v", auth.ErrInvalidKey, err)
+	}
+}
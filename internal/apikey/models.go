@@ -0,0 +1,36 @@
+package apikey
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Key is an API key used by machines such as point-of-sale tablets and sync
+// scripts to authenticate without a user's password. Only a hash of the key
+// is stored; the Prefix helps people recognize a key in listings.
+type Key struct {
+	ID           string         `db:"key_id" json:"id"`
+	Name         string         `db:"name" json:"name"`
+	Prefix       string         `db:"prefix" json:"prefix"`
+	KeyHash      string         `db:"key_hash" json:"-"`
+	Roles        pq.StringArray `db:"roles" json:"roles"`
+	UserID       string         `db:"user_id" json:"user_id"`
+	OrgID        string         `db:"org_id" json:"org_id"`
+	DateCreated  time.Time      `db:"date_created" json:"date_created"`
+	DateLastUsed *time.Time     `db:"date_last_used" json:"date_last_used,omitempty"`
+	DateRevoked  *time.Time     `db:"date_revoked" json:"date_revoked,omitempty"`
+}
+
+// NewKey is what we require from admins when creating an API key.
+type NewKey struct {
+	Name  string   `json:"name" validate:"required"`
+	Roles []string `json:"roles" validate:"required"`
+}
+
+// Created is returned once when a key is created. It is the only time the
+// full key is ever shown.
+type Created struct {
+	Key
+	Secret string `json:"key"`
+}
@@ -0,0 +1,230 @@
+// Package category manages the tree of categories products are grouped in.
+package category
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// Custom errors for expected failing conditions
+var (
+	// Invalid UUID
+	ErrInvalidID = errors.New("invalid ID")
+	// Unable to find category based on UUID
+	ErrNotFound = errors.New("category not found")
+	// ErrInvalidParent occurs when the parent of a category does not exist or
+	// is the category itself or one of its subcategories.
+	ErrInvalidParent = errors.New("invalid parent category")
+	// ErrDuplicateName occurs when a category has a sibling of the same name.
+	ErrDuplicateName = errors.New("category name already used")
+	// ErrHasChildren occurs when deleting a category that has subcategories.
+	ErrHasChildren = errors.New("category has subcategories")
+)
+
+// isUniqueViolation reports whether err was caused by a unique constraint.
+func isUniqueViolation(err error) bool {
+	pqErr, ok := errors.Cause(err).(*pq.Error)
+	return ok && pqErr.Code == "23505"
+}
+
+// List returns every category of an organization ordered by name. Clients
+// build the tree from the parent of each category.
+func List(ctx context.Context, db *sqlx.DB, orgID string) ([]Category, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.category.List")
+	defer span.End()
+
+	if _, err := uuid.Parse(orgID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	categories := []Category{}
+	const q = `SELECT * FROM categories WHERE org_id = $1 ORDER BY name, category_id`
+	if err := db.SelectContext(ctx, &categories, q, orgID); err != nil {
+		return nil, errors.Wrap(err, "selecting categories")
+	}
+
+	return categories, nil
+}
+
+// Retrieve gets a single category of the organization orgID.
+func Retrieve(ctx context.Context, db *sqlx.DB, orgID, id string) (*Category, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.category.Retrieve")
+	defer span.End()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+	if _, err := uuid.Parse(orgID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var c Category
+	const q = `SELECT * FROM categories WHERE category_id = $1 AND org_id = $2`
+	if err := db.GetContext(ctx, &c, q, id, orgID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "selecting one category")
+	}
+
+	return &c, nil
+}
+
+// Exists reports whether the category id belongs to the organization orgID.
+// It accepts a transaction so callers can check a category they are about
+// to reference.
+func Exists(ctx context.Context, db sqlx.QueryerContext, orgID, id string) (bool, error) {
+
+	if _, err := uuid.Parse(id); err != nil {
+		return false, nil
+	}
+	if _, err := uuid.Parse(orgID); err != nil {
+		return false, nil
+	}
+
+	var exists bool
+	const q = `SELECT EXISTS (SELECT 1 FROM categories WHERE category_id = $1 AND org_id = $2)`
+	if err := sqlx.GetContext(ctx, db, &exists, q, id, orgID); err != nil {
+		return false, errors.Wrap(err, "checking category")
+	}
+
+	return exists, nil
+}
+
+// Create adds a category to the organization orgID.
+func Create(ctx context.Context, db *sqlx.DB, orgID string, nc NewCategory, now time.Time) (*Category, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.category.Create")
+	defer span.End()
+
+	if nc.ParentID != nil {
+		ok, err := Exists(ctx, db, orgID, *nc.ParentID)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrInvalidParent
+		}
+	}
+
+	c := Category{
+		ID:          uuid.New().String(),
+		OrgID:       orgID,
+		ParentID:    nc.ParentID,
+		Name:        nc.Name,
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+
+	const q = `INSERT INTO categories
+		(category_id, org_id, parent_id, name, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := db.ExecContext(ctx, q, c.ID, c.OrgID, c.ParentID, c.Name, c.DateCreated, c.DateUpdated)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrDuplicateName
+		}
+		return nil, errors.Wrap(err, "inserting category")
+	}
+
+	return &c, nil
+}
+
+// Patch modifies a category of the organization orgID by letting apply change
+// its editable fields. Errors returned by apply are returned unchanged. A
+// category can not be moved below itself or one of its subcategories.
+func Patch(ctx context.Context, db *sqlx.DB, orgID, id string, apply func(*EditCategory) error, now time.Time) error {
+
+	ctx, span := trace.StartSpan(ctx, "internal.category.Patch")
+	defer span.End()
+
+	c, err := Retrieve(ctx, db, orgID, id)
+	if err != nil {
+		return err
+	}
+
+	e := EditCategory{
+		Name:     c.Name,
+		ParentID: c.ParentID,
+	}
+	if err := apply(&e); err != nil {
+		return err
+	}
+
+	if e.ParentID != nil {
+		ok, err := Exists(ctx, db, orgID, *e.ParentID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrInvalidParent
+		}
+
+		// The new parent must not be in the subtree of the category.
+		var cycle bool
+		const q = `WITH RECURSIVE tree AS (
+						SELECT category_id FROM categories WHERE category_id = $1
+						UNION ALL
+						SELECT c.category_id FROM categories AS c
+						JOIN tree ON c.parent_id = tree.category_id
+					)
+					SELECT EXISTS (SELECT 1 FROM tree WHERE category_id = $2)`
+		if err := db.GetContext(ctx, &cycle, q, id, *e.ParentID); err != nil {
+			return errors.Wrap(err, "checking category tree")
+		}
+		if cycle {
+			return ErrInvalidParent
+		}
+	}
+
+	const q = `UPDATE categories SET
+				"name" = $2,
+				"parent_id" = $3,
+				"date_updated" = $4
+				WHERE category_id = $1`
+	if _, err := db.ExecContext(ctx, q, id, e.Name, e.ParentID, now.UTC()); err != nil {
+		if isUniqueViolation(err) {
+			return ErrDuplicateName
+		}
+		return errors.Wrap(err, "updating category")
+	}
+
+	return nil
+}
+
+// Delete removes a category of the organization orgID. Its products are left
+// without a category. Categories with subcategories can not be deleted.
+func Delete(ctx context.Context, db *sqlx.DB, orgID, id string) error {
+
+	ctx, span := trace.StartSpan(ctx, "internal.category.Delete")
+	defer span.End()
+
+	if _, err := Retrieve(ctx, db, orgID, id); err != nil {
+		return err
+	}
+
+	var children int
+	const count = `SELECT COUNT(*) FROM categories WHERE parent_id = $1`
+	if err := db.GetContext(ctx, &children, count, id); err != nil {
+		return errors.Wrapf(err, "counting subcategories of %s", id)
+	}
+	if children > 0 {
+		return ErrHasChildren
+	}
+
+	const q = `DELETE FROM categories WHERE category_id = $1`
+	if _, err := db.ExecContext(ctx, q, id); err != nil {
+		return errors.Wrapf(err, "deleting category %s", id)
+	}
+
+	return nil
+}
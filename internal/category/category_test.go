@@ -0,0 +1,77 @@
+package category_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sreejeet/garagesale/internal/category"
+	"github.com/sreejeet/garagesale/internal/org"
+	"github.com/sreejeet/garagesale/internal/tests"
+)
+
+func TestCategories(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	books, err := category.Create(ctx, db, org.DefaultID, category.NewCategory{Name: "Books"}, now)
+	if err != nil {
+		t.Fatalf("creating category: %s", err)
+	}
+	comics, err := category.Create(ctx, db, org.DefaultID, category.NewCategory{Name: "Comics", ParentID: &books.ID}, now)
+	if err != nil {
+		t.Fatalf("creating subcategory: %s", err)
+	}
+
+	// Names only need to be unique among siblings.
+	if _, err := category.Create(ctx, db, org.DefaultID, category.NewCategory{Name: "Books"}, now); err != category.ErrDuplicateName {
+		t.Fatalf("expected %v creating duplicate, got %v", category.ErrDuplicateName, err)
+	}
+	if _, err := category.Create(ctx, db, org.DefaultID, category.NewCategory{Name: "Books", ParentID: &books.ID}, now); err != nil {
+		t.Fatalf("creating subcategory named like its parent: %s", err)
+	}
+
+	// Other organizations can not see or use the categories.
+	const otherOrg = "8c1f0b5e-2f4d-4a7e-9b8e-3d7a1c2b4e5f"
+	if _, err := category.Retrieve(ctx, db, otherOrg, books.ID); err != category.ErrNotFound {
+		t.Fatalf("expected %v reading category of another organization, got %v", category.ErrNotFound, err)
+	}
+	if ok, err := category.Exists(ctx, db, otherOrg, books.ID); err != nil || ok {
+		t.Fatalf("expected category to be missing in another organization, got %v, %v", ok, err)
+	}
+
+	// A category can not become its own descendant.
+	moveBelow := func(parent string) func(*category.EditCategory) error {
+		return func(e *category.EditCategory) error {
+			e.ParentID = &parent
+			return nil
+		}
+	}
+	if err := category.Patch(ctx, db, org.DefaultID, books.ID, moveBelow(comics.ID), now); err != category.ErrInvalidParent {
+		t.Fatalf("expected %v moving category below itself, got %v", category.ErrInvalidParent, err)
+	}
+
+	if err := category.Delete(ctx, db, org.DefaultID, books.ID); err != category.ErrHasChildren {
+		t.Fatalf("expected %v deleting category with subcategories, got %v", category.ErrHasChildren, err)
+	}
+
+	// Moving comics to the top level.
+	top := func(e *category.EditCategory) error {
+		e.ParentID = nil
+		return nil
+	}
+	if err := category.Patch(ctx, db, org.DefaultID, comics.ID, top, now); err != nil {
+		t.Fatalf("moving category: %s", err)
+	}
+
+	list, err := category.List(ctx, db, org.DefaultID)
+	if err != nil {
+		t.Fatalf("listing categories: %s", err)
+	}
+	if len(list) != 3 || list[2].Name != "Comics" || list[2].ParentID != nil {
+		t.Fatalf("expected comics at the top level, got %+v", list)
+	}
+}
@@ -0,0 +1,29 @@
+package category
+
+import "time"
+
+// Category groups products, like "Books" or "Toys". Categories form a tree:
+// a category without a parent is at the top level and every other category
+// is a subcategory of its parent. Categories belong to one organization.
+type Category struct {
+	ID          string    `db:"category_id" json:"id"`
+	OrgID       string    `db:"org_id" json:"org_id"`
+	ParentID    *string   `db:"parent_id" json:"parent_id"`
+	Name        string    `db:"name" json:"name"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+	DateUpdated time.Time `db:"date_updated" json:"date_updated"`
+}
+
+// NewCategory is what we require from clients when creating a category.
+// ParentID is left out for top level categories.
+type NewCategory struct {
+	Name     string  `json:"name" validate:"required"`
+	ParentID *string `json:"parent_id" validate:"omitempty,uuid"`
+}
+
+// EditCategory holds the fields of a category that can be changed. Patches
+// are applied to it; setting ParentID to null moves the category to the top.
+type EditCategory struct {
+	Name     string  `json:"name" validate:"required"`
+	ParentID *string `json:"parent_id" validate:"omitempty,uuid"`
+}
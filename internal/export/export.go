@@ -0,0 +1,275 @@
+// Package export writes the products and sales of an organization to sheets
+// for bookkeeping in other tools. Rows are read from the database one at a
+// time and written out right away, so exports of any size use little memory.
+// Exports cover a report.Range and fail with the errors of the report package
+// for invalid organizations and ranges. Amounts are written in major units,
+// like 12.50, next to their currency.
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/sreejeet/garagesale/internal/platform/money"
+	"github.com/sreejeet/garagesale/internal/platform/sheet"
+	"github.com/sreejeet/garagesale/internal/report"
+	"go.opencensus.io/trace"
+)
+
+// productColumns are the columns of a product export.
+var productColumns = []string{
+	"id", "name", "description", "category_id", "category", "tags",
+	"cost", "currency", "quantity", "sold", "stock", "revenue",
+	"date_created", "date_updated", "deleted_at",
+}
+
+// productRow is a product as read for an export. Sold, stock and revenue
+// cover every sale of the product, not only those within the range.
+type productRow struct {
+	ID          string         `db:"product_id"`
+	Name        string         `db:"name"`
+	Description string         `db:"description"`
+	CategoryID  *string        `db:"category_id"`
+	Category    *string        `db:"category"`
+	Tags        pq.StringArray `db:"tags"`
+	Cost        money.Money    `db:"cost"`
+	Quantity    int            `db:"quantity"`
+	Sold        int            `db:"sold"`
+	Stock       int            `db:"stock"`
+	Revenue     money.Money    `db:"revenue"`
+	DateCreated time.Time      `db:"date_created"`
+	DateUpdated time.Time      `db:"date_updated"`
+	DeletedAt   *time.Time     `db:"deleted_at"`
+}
+
+// saleColumns are the columns of a sale export.
+var saleColumns = []string{
+	"id", "date_created", "kind", "product_id", "product", "order_id",
+	"quantity", "paid", "currency", "reverses_sale_id", "reason",
+	"user_id", "user",
+}
+
+// saleRow is a sale as read for an export.
+type saleRow struct {
+	ID          string      `db:"sale_id"`
+	DateCreated time.Time   `db:"date_created"`
+	Kind        string      `db:"kind"`
+	ProductID   string      `db:"product_id"`
+	Product     string      `db:"product"`
+	OrderID     *string     `db:"order_id"`
+	Quantity    int         `db:"quantity"`
+	Paid        money.Money `db:"paid"`
+	ReversesID  *string     `db:"reverses_sale_id"`
+	Reason      string      `db:"reason"`
+	UserID      string      `db:"user_id"`
+	User        string      `db:"user_name"`
+}
+
+// Products writes the products of the organization created within the range
+// to w in format, oldest first. Deleted products are included with the time
+// they were deleted. It returns the number of products written.
+func Products(ctx context.Context, db *sqlx.DB, orgID string, r report.Range, w io.Writer, format string) (int, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.export.Products")
+	defer span.End()
+
+	if err := check(orgID, r, format); err != nil {
+		return 0, err
+	}
+
+	const q = `SELECT
+					p.product_id, p.name, p.description, p.category_id,
+					c.name AS category,
+					COALESCE(tg.tags, '{}') AS tags,
+					p.cost, p.quantity,
+					COALESCE(s.sold, 0) AS sold,
+					p.quantity - COALESCE(s.sold, 0) AS stock,
+					ROW(COALESCE(s.revenue, 0), (p.cost).currency)::money_value AS revenue,
+					p.date_created, p.date_updated, p.deleted_at
+				FROM products AS p
+				LEFT JOIN categories AS c ON c.category_id = p.category_id
+				LEFT JOIN LATERAL (
+					SELECT SUM(quantity) AS sold, SUM((paid).amount) AS revenue
+					FROM sales
+					WHERE product_id = p.product_id
+				) AS s ON TRUE
+				LEFT JOIN LATERAL (
+					SELECT ARRAY_AGG(t.name ORDER BY t.name) AS tags
+					FROM product_tags AS pt
+					JOIN tags AS t ON t.tag_id = pt.tag_id
+					WHERE pt.product_id = p.product_id
+				) AS tg ON TRUE
+				WHERE p.org_id = $1 AND p.date_created >= $2 AND p.date_created < $3
+				ORDER BY p.date_created, p.product_id`
+
+	rows, err := db.QueryxContext(ctx, q, orgID, r.From.UTC(), r.To.UTC())
+	if err != nil {
+		return 0, errors.Wrap(err, "selecting products")
+	}
+	defer rows.Close()
+
+	sw, err := sheet.New(w, format, "Products", productColumns)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for rows.Next() {
+		var p productRow
+		if err := rows.StructScan(&p); err != nil {
+			return n, errors.Wrap(err, "scanning product")
+		}
+
+		err := sw.Write(
+			p.ID, p.Name, p.Description, p.CategoryID, p.Category, strings.Join(p.Tags, ";"),
+			decimal(p.Cost), p.Cost.Currency, p.Quantity, p.Sold, p.Stock, decimal(p.Revenue),
+			p.DateCreated, p.DateUpdated, p.DeletedAt,
+		)
+		if err != nil {
+			return n, errors.Wrap(err, "writing product")
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, errors.Wrap(err, "reading products")
+	}
+
+	return n, sw.Close()
+}
+
+// Sales writes the sales, refunds and voids of the organization recorded
+// within the range to w in format, oldest first. Refunds and voids have
+// negative quantities and amounts like they are stored. It returns the number
+// of sales written.
+func Sales(ctx context.Context, db *sqlx.DB, orgID string, r report.Range, w io.Writer, format string) (int, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.export.Sales")
+	defer span.End()
+
+	if err := check(orgID, r, format); err != nil {
+		return 0, err
+	}
+
+	const q = `SELECT
+					s.sale_id, s.date_created, s.kind, s.product_id,
+					p.name AS product,
+					s.order_id, s.quantity, s.paid, s.reverses_sale_id, s.reason,
+					s.user_id, COALESCE(u.name, '') AS user_name
+				FROM sales AS s
+				JOIN products AS p ON p.product_id = s.product_id
+				LEFT JOIN users AS u ON u.user_id = s.user_id
+				WHERE p.org_id = $1 AND s.date_created >= $2 AND s.date_created < $3
+				ORDER BY s.date_created, s.sale_id`
+
+	rows, err := db.QueryxContext(ctx, q, orgID, r.From.UTC(), r.To.UTC())
+	if err != nil {
+		return 0, errors.Wrap(err, "selecting sales")
+	}
+	defer rows.Close()
+
+	sw, err := sheet.New(w, format, "Sales", saleColumns)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for rows.Next() {
+		var s saleRow
+		if err := rows.StructScan(&s); err != nil {
+			return n, errors.Wrap(err, "scanning sale")
+		}
+
+		err := sw.Write(
+			s.ID, s.DateCreated, s.Kind, s.ProductID, s.Product, s.OrderID,
+			s.Quantity, decimal(s.Paid), s.Paid.Currency, s.ReversesID, s.Reason,
+			s.UserID, s.User,
+		)
+		if err != nil {
+			return n, errors.Wrap(err, "writing sale")
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, errors.Wrap(err, "reading sales")
+	}
+
+	return n, sw.Close()
+}
+
+// CountProducts returns how many products Products would write for the
+// organization and range, so callers can refuse exports too large for a
+// format before writing anything.
+func CountProducts(ctx context.Context, db *sqlx.DB, orgID string, r report.Range) (int, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.export.CountProducts")
+	defer span.End()
+
+	if err := checkRange(orgID, r); err != nil {
+		return 0, err
+	}
+
+	var n int
+	const q = `SELECT COUNT(*) FROM products
+				WHERE org_id = $1 AND date_created >= $2 AND date_created < $3`
+	if err := db.GetContext(ctx, &n, q, orgID, r.From.UTC(), r.To.UTC()); err != nil {
+		return 0, errors.Wrap(err, "counting products")
+	}
+
+	return n, nil
+}
+
+// CountSales returns how many sales Sales would write for the organization
+// and range.
+func CountSales(ctx context.Context, db *sqlx.DB, orgID string, r report.Range) (int, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.export.CountSales")
+	defer span.End()
+
+	if err := checkRange(orgID, r); err != nil {
+		return 0, err
+	}
+
+	var n int
+	const q = `SELECT COUNT(*) FROM sales AS s
+				JOIN products AS p ON p.product_id = s.product_id
+				WHERE p.org_id = $1 AND s.date_created >= $2 AND s.date_created < $3`
+	if err := db.GetContext(ctx, &n, q, orgID, r.From.UTC(), r.To.UTC()); err != nil {
+		return 0, errors.Wrap(err, "counting sales")
+	}
+
+	return n, nil
+}
+
+// check validates the organization, range and format of an export.
+func check(orgID string, r report.Range, format string) error {
+	if err := checkRange(orgID, r); err != nil {
+		return err
+	}
+	if !sheet.Valid(format) {
+		return sheet.ErrFormat
+	}
+	return nil
+}
+
+// checkRange validates the organization and range of an export.
+func checkRange(orgID string, r report.Range) error {
+	if _, err := uuid.Parse(orgID); err != nil {
+		return report.ErrInvalidID
+	}
+	if !r.From.Before(r.To) {
+		return report.ErrInvalidRange
+	}
+	return nil
+}
+
+// decimal writes an amount as a number in major units.
+func decimal(m money.Money) json.Number {
+	return json.Number(m.Decimal())
+}
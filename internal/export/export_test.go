@@ -0,0 +1,98 @@
+package export_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sreejeet/garagesale/internal/export"
+	"github.com/sreejeet/garagesale/internal/org"
+	"github.com/sreejeet/garagesale/internal/platform/sheet"
+	"github.com/sreejeet/garagesale/internal/report"
+	"github.com/sreejeet/garagesale/internal/schema"
+	"github.com/sreejeet/garagesale/internal/tests"
+)
+
+func TestExport(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	if err := schema.Seed(db); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	january := report.Range{
+		From: time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC),
+		To:   time.Date(2019, time.February, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	// The seed data holds three sales made on the first of January.
+	var buf bytes.Buffer
+	n, err := export.Sales(ctx, db, org.DefaultID, january, &buf, sheet.CSV)
+	if err != nil {
+		t.Fatalf("exporting sales: %s", err)
+	}
+	if exp, got := 3, n; exp != got {
+		t.Fatalf("expected %d sales, got %d", exp, got)
+	}
+	const nobody = "00000000-0000-0000-0000-000000000000"
+	want := "id,date_created,kind,product_id,product,order_id,quantity,paid,currency,reverses_sale_id,reason,user_id,user\n" +
+		"98b6d4b8-f04b-4c79-8c2e-a0aef46854b7,2019-01-01T00:00:03Z,SALE,a2b0639f-2cc6-44b8-b97b-15d69dbb511e,Comic Books,,2,100.00,USD,,," + nobody + ",\n" +
+		"85f6fb09-eb05-4874-ae39-82d1a30fe0d7,2019-01-01T00:00:04Z,SALE,a2b0639f-2cc6-44b8-b97b-15d69dbb511e,Comic Books,,5,250.00,USD,,," + nobody + ",\n" +
+		"a235be9e-ab5d-44e6-a987-fa1c749264c7,2019-01-01T00:00:05Z,SALE,72f8b983-3eb4-48db-9ed0-e45cc6bd716b,McDonalds Toys,,3,225.00,USD,,," + nobody + ",\n"
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Fatalf("unexpected sales export:\n%s", diff)
+	}
+
+	buf.Reset()
+	if _, err := export.Products(ctx, db, org.DefaultID, january, &buf, sheet.NDJSON); err != nil {
+		t.Fatalf("exporting products: %s", err)
+	}
+	var products []map[string]interface{}
+	s := bufio.NewScanner(&buf)
+	for s.Scan() {
+		var p map[string]interface{}
+		if err := json.Unmarshal(s.Bytes(), &p); err != nil {
+			t.Fatalf("decoding product: %s", err)
+		}
+		products = append(products, p)
+	}
+	if exp, got := 2, len(products); exp != got {
+		t.Fatalf("expected %d products, got %d", exp, got)
+	}
+
+	// Counts match what the exports write.
+	if n, err := export.CountSales(ctx, db, org.DefaultID, january); err != nil || n != 3 {
+		t.Fatalf("expected 3 sales counted, got %d, %v", n, err)
+	}
+	if n, err := export.CountProducts(ctx, db, org.DefaultID, january); err != nil || n != 2 {
+		t.Fatalf("expected 2 products counted, got %d, %v", n, err)
+	}
+	comics := products[0]
+	if comics["name"] != "Comic Books" || comics["sold"] != 7.0 || comics["revenue"] != 350.0 || comics["cost"] != 50.0 {
+		t.Fatalf("unexpected product %v", comics)
+	}
+
+	// Nothing was sold in February so only the header is written.
+	february := report.Range{From: january.To, To: january.To.AddDate(0, 1, 0)}
+	buf.Reset()
+	if n, err := export.Sales(ctx, db, org.DefaultID, february, &buf, sheet.CSV); err != nil || n != 0 {
+		t.Fatalf("expected no sales in February, got %d, %v", n, err)
+	}
+	if exp, got := 1, bytes.Count(buf.Bytes(), []byte("\n")); exp != got {
+		t.Fatalf("expected %d line, got %d", exp, got)
+	}
+
+	backwards := report.Range{From: january.To, To: january.From}
+	if _, err := export.Sales(ctx, db, org.DefaultID, backwards, &buf, sheet.CSV); err != report.ErrInvalidRange {
+		t.Fatalf("expected %v, got %v", report.ErrInvalidRange, err)
+	}
+	if _, err := export.Products(ctx, db, org.DefaultID, january, &buf, "pdf"); err != sheet.ErrFormat {
+		t.Fatalf("expected %v, got %v", sheet.ErrFormat, err)
+	}
+}
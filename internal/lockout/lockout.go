@@ -0,0 +1,188 @@
+// Package lockout protects credential checks against brute force attacks.
+// Failed attempts are counted per account and per client address. Each
+// failure makes the caller wait progressively longer before the next attempt
+// and too many failures lock the key out for a while.
+package lockout
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Record holds the failed attempts recorded for a single key.
+type Record struct {
+	Failures    int       `db:"failures"`
+	LastFailure time.Time `db:"last_failure"`
+}
+
+// Store persists failure records. Implementations must be safe for concurrent use.
+type Store interface {
+
+	// Get returns the record for a key or a zero Record when there is none.
+	Get(ctx context.Context, key string) (Record, error)
+
+	// Fail records a failure at now and returns the updated record. Earlier
+	// failures that happened before since are forgotten first.
+	Fail(ctx context.Context, key string, now, since time.Time) (Record, error)
+
+	// Reset removes the record of a key.
+	Reset(ctx context.Context, key string) error
+}
+
+// Policy describes how failures of one kind of key are punished.
+//
+// After each failure the next attempt is refused for BaseDelay, doubling with
+// every further failure up to MaxDelay. Reaching MaxFailures locks the key
+// for LockoutDuration. Failures older than Window are forgotten.
+type Policy struct {
+	MaxFailures     int
+	LockoutDuration time.Duration
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	Window          time.Duration
+}
+
+// LockedError is returned when an attempt is refused. Locked is false when
+// the caller only has to wait out the progressive delay.
+type LockedError struct {
+	Until  time.Time
+	Locked bool
+}
+
+// Error implements the error interface.
+func (e *LockedError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("too many failed attempts, locked until %s", e.Until.UTC().Format(time.RFC3339))
+	}
+	return fmt.Sprintf("too many failed attempts, retry after %s", e.Until.UTC().Format(time.RFC3339))
+}
+
+// Guard tracks failed logins per account and per client address.
+type Guard struct {
+	store    Store
+	accounts Policy
+	addrs    Policy
+}
+
+// NewGuard creates a Guard. Addresses usually get a more generous policy than
+// accounts because many people can share an address behind a NAT.
+func NewGuard(store Store, accounts, addrs Policy) *Guard {
+	g := Guard{
+		store:    store,
+		accounts: accounts,
+		addrs:    addrs,
+	}
+	return &g
+}
+
+// accountKey and addrKey namespace keys so both kinds can share a store.
+// Emails are case insensitive so they are lowered first.
+func accountKey(email string) string { return "account:" + strings.ToLower(email) }
+func addrKey(addr string) string     { return "addr:" + addr }
+
+// Check returns a *LockedError when an attempt for the account from the
+// address must be refused at this time.
+func (g *Guard) Check(ctx context.Context, email, addr string, now time.Time) error {
+
+	checks := []struct {
+		key    string
+		policy Policy
+	}{
+		{accountKey(email), g.accounts},
+		{addrKey(addr), g.addrs},
+	}
+
+	for _, c := range checks {
+		r, err := g.store.Get(ctx, c.key)
+		if err != nil {
+			return err
+		}
+		if err := c.policy.blocked(r, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Fail records a failed attempt for the account and the address. It returns
+// the failures now counted against the account and a *LockedError when this
+// failure caused either of them to be locked out.
+func (g *Guard) Fail(ctx context.Context, email, addr string, now time.Time) (int, *LockedError, error) {
+
+	acc, err := g.store.Fail(ctx, accountKey(email), now, now.Add(-g.accounts.Window))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	ip, err := g.store.Fail(ctx, addrKey(addr), now, now.Add(-g.addrs.Window))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if lerr := g.accounts.blocked(acc, now); lerr != nil && lerr.Locked {
+		return acc.Failures, lerr, nil
+	}
+	if lerr := g.addrs.blocked(ip, now); lerr != nil && lerr.Locked {
+		return acc.Failures, lerr, nil
+	}
+
+	return acc.Failures, nil, nil
+}
+
+// Succeed clears the failures of an account after a successful login. The
+// failures of the address are kept so one valid account can not be used to
+// keep guessing the passwords of others.
+func (g *Guard) Succeed(ctx context.Context, email string) error {
+	return g.store.Reset(ctx, accountKey(email))
+}
+
+// Unlock clears the failures and any lockout of an account.
+func (g *Guard) Unlock(ctx context.Context, email string) error {
+	return g.store.Reset(ctx, accountKey(email))
+}
+
+// blocked returns a *LockedError when the record does not allow an attempt at now.
+func (p Policy) blocked(r Record, now time.Time) *LockedError {
+
+	if r.Failures == 0 {
+		return nil
+	}
+
+	// A lockout lasts its full duration even when that is longer than the
+	// window failures are counted in.
+	if p.MaxFailures > 0 && r.Failures >= p.MaxFailures {
+		until := r.LastFailure.Add(p.LockoutDuration)
+		if now.Before(until) {
+			return &LockedError{Until: until, Locked: true}
+		}
+		return nil
+	}
+
+	if now.Sub(r.LastFailure) >= p.Window {
+		return nil
+	}
+
+	until := r.LastFailure.Add(p.delay(r.Failures))
+	if now.Before(until) {
+		return &LockedError{Until: until}
+	}
+
+	return nil
+}
+
+// delay returns how long to wait after the given number of failures.
+func (p Policy) delay(failures int) time.Duration {
+
+	d := p.BaseDelay
+	for i := 1; i < failures && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+
+	return d
+}
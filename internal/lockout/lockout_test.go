@@ -0,0 +1,177 @@
+package lockout_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sreejeet/garagesale/internal/lockout"
+	"github.com/sreejeet/garagesale/internal/tests"
+)
+
+func TestGuard(t *testing.T) {
+
+	ctx := context.Background()
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	accounts := lockout.Policy{
+		MaxFailures:     3,
+		LockoutDuration: 10 * time.Minute,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Minute,
+		Window:          time.Hour,
+	}
+	addrs := accounts
+	addrs.MaxFailures = 100
+	addrs.BaseDelay = 0
+
+	g := lockout.NewGuard(lockout.NewMemoryStore(), accounts, addrs)
+
+	const email, addr = "admin@example.com", "192.0.2.1"
+
+	if err := g.Check(ctx, email, addr, now); err != nil {
+		t.Fatalf("first attempt should be allowed: %s", err)
+	}
+
+	// Every failure doubles the delay before the next attempt.
+	for i, delay := range []time.Duration{time.Second, 2 * time.Second} {
+		failures, locked, err := g.Fail(ctx, email, addr, now)
+		if err != nil {
+			t.Fatalf("recording failure: %s", err)
+		}
+		if exp := i + 1; failures != exp {
+			t.Fatalf("expected %d failures, got %d", exp, failures)
+		}
+		if locked != nil {
+			t.Fatalf("should not be locked after %d failures", failures)
+		}
+
+		err = g.Check(ctx, email, addr, now.Add(delay-time.Millisecond))
+		if lerr, ok := err.(*lockout.LockedError); !ok || lerr.Locked {
+			t.Fatalf("expected a delay after %d failures, got %v", failures, err)
+		}
+
+		now = now.Add(delay)
+		if err := g.Check(ctx, email, addr, now); err != nil {
+			t.Fatalf("attempt after the delay should be allowed: %s", err)
+		}
+	}
+
+	// The third failure locks the account but other accounts from
+	// the same address can still try.
+	if _, locked, err := g.Fail(ctx, email, addr, now); err != nil || locked == nil || !locked.Locked {
+		t.Fatalf("expected a lockout after 3 failures, got %v %v", locked, err)
+	}
+
+	err := g.Check(ctx, email, addr, now.Add(5*time.Minute))
+	if lerr, ok := err.(*lockout.LockedError); !ok || !lerr.Locked {
+		t.Fatalf("expected account to stay locked, got %v", err)
+	}
+	if err := g.Check(ctx, "user@example.com", addr, now); err != nil {
+		t.Fatalf("other accounts should not be locked: %s", err)
+	}
+	if err := g.Check(ctx, email, addr, now.Add(10*time.Minute)); err != nil {
+		t.Fatalf("lockout should expire: %s", err)
+	}
+
+	// Admins can lift a lockout early.
+	if err := g.Unlock(ctx, email); err != nil {
+		t.Fatalf("unlocking: %s", err)
+	}
+	if err := g.Check(ctx, email, addr, now); err != nil {
+		t.Fatalf("unlocked account should be allowed: %s", err)
+	}
+}
+
+func TestLongLockout(t *testing.T) {
+
+	ctx := context.Background()
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	p := lockout.Policy{
+		MaxFailures:     2,
+		LockoutDuration: 2 * time.Hour,
+		Window:          time.Hour,
+	}
+	g := lockout.NewGuard(lockout.NewMemoryStore(), p, p)
+
+	const email, addr = "admin@example.com", "192.0.2.1"
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := g.Fail(ctx, email, addr, now); err != nil {
+			t.Fatalf("recording failure: %s", err)
+		}
+	}
+
+	// The lockout outlasts the window failures are counted in.
+	err := g.Check(ctx, email, addr, now.Add(90*time.Minute))
+	if lerr, ok := err.(*lockout.LockedError); !ok || !lerr.Locked || !lerr.Until.Equal(now.Add(2*time.Hour)) {
+		t.Fatalf("expected account to stay locked past the window, got %v", err)
+	}
+	if err := g.Check(ctx, email, addr, now.Add(2*time.Hour)); err != nil {
+		t.Fatalf("lockout should expire: %s", err)
+	}
+}
+
+func TestPostgresStore(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	s := lockout.NewPostgresStore(db)
+
+	const key = "account:admin@example.com"
+
+	r, err := s.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("getting unknown key: %s", err)
+	}
+	if r.Failures != 0 {
+		t.Fatalf("expected no failures for an unknown key, got %d", r.Failures)
+	}
+
+	// Failures within the window add up.
+	for i := 1; i <= 2; i++ {
+		r, err := s.Fail(ctx, key, now, now.Add(-time.Hour))
+		if err != nil {
+			t.Fatalf("recording failure: %s", err)
+		}
+		if r.Failures != i || !r.LastFailure.Equal(now) {
+			t.Fatalf("expected %d failures at %s, got %+v", i, now, r)
+		}
+	}
+
+	r, err = s.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("getting key: %s", err)
+	}
+	if r.Failures != 2 || !r.LastFailure.Equal(now) {
+		t.Fatalf("expected 2 failures at %s, got %+v", now, r)
+	}
+
+	// Other keys are counted on their own.
+	if r, err := s.Fail(ctx, "addr:192.0.2.1", now, now.Add(-time.Hour)); err != nil || r.Failures != 1 {
+		t.Fatalf("expected 1 failure for another key, got %+v %v", r, err)
+	}
+
+	// A failure after the window starts counting again.
+	later := now.Add(2 * time.Hour)
+	r, err = s.Fail(ctx, key, later, later.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("recording failure: %s", err)
+	}
+	if r.Failures != 1 || !r.LastFailure.Equal(later) {
+		t.Fatalf("expected old failures to be forgotten, got %+v", r)
+	}
+
+	if err := s.Reset(ctx, key); err != nil {
+		t.Fatalf("resetting key: %s", err)
+	}
+	if r, err := s.Get(ctx, key); err != nil || r.Failures != 0 {
+		t.Fatalf("expected no failures after reset, got %+v %v", r, err)
+	}
+	if r, err := s.Get(ctx, "addr:192.0.2.1"); err != nil || r.Failures != 1 {
+		t.Fatalf("resetting one key should keep the others, got %+v %v", r, err)
+	}
+}
@@ -0,0 +1,54 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps failure records in memory. It is meant for tests and
+// single instance deployments; records are lost when the process exits.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]Record
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		records: make(map[string]Record),
+	}
+}
+
+// Get implements the Store interface.
+func (s *MemoryStore) Get(ctx context.Context, key string) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.records[key], nil
+}
+
+// Fail implements the Store interface.
+func (s *MemoryStore) Fail(ctx context.Context, key string, now, since time.Time) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.records[key]
+	if r.LastFailure.Before(since) {
+		r.Failures = 0
+	}
+	r.Failures++
+	r.LastFailure = now
+	s.records[key] = r
+
+	return r, nil
+}
+
+// Reset implements the Store interface.
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
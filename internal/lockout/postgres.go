@@ -0,0 +1,78 @@
+package lockout
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// PostgresStore keeps failure records in the login_failures table so they are
+// shared by every instance of the service and survive restarts.
+type PostgresStore struct {
+	db *sqlx.DB
+}
+
+// NewPostgresStore creates a PostgresStore using the provided database.
+func NewPostgresStore(db *sqlx.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// Get implements the Store interface.
+func (s *PostgresStore) Get(ctx context.Context, key string) (Record, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.lockout.Get")
+	defer span.End()
+
+	var r Record
+	const q = `SELECT failures, last_failure FROM login_failures WHERE key = $1`
+	if err := s.db.GetContext(ctx, &r, q, key); err != nil {
+		if err == sql.ErrNoRows {
+			return Record{}, nil
+		}
+		return Record{}, errors.Wrap(err, "selecting login failures")
+	}
+
+	return r, nil
+}
+
+// Fail implements the Store interface. The count is updated in a single
+// statement so concurrent failures are all counted.
+func (s *PostgresStore) Fail(ctx context.Context, key string, now, since time.Time) (Record, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.lockout.Fail")
+	defer span.End()
+
+	var r Record
+	const q = `INSERT INTO login_failures (key, failures, last_failure)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE
+				WHEN login_failures.last_failure < $3 THEN 1
+				ELSE login_failures.failures + 1
+			END,
+			last_failure = $2
+		RETURNING failures, last_failure`
+	if err := s.db.GetContext(ctx, &r, q, key, now.UTC(), since.UTC()); err != nil {
+		return Record{}, errors.Wrap(err, "recording login failure")
+	}
+
+	return r, nil
+}
+
+// Reset implements the Store interface.
+func (s *PostgresStore) Reset(ctx context.Context, key string) error {
+
+	ctx, span := trace.StartSpan(ctx, "internal.lockout.Reset")
+	defer span.End()
+
+	const q = `DELETE FROM login_failures WHERE key = $1`
+	if _, err := s.db.ExecContext(ctx, q, key); err != nil {
+		return errors.Wrap(err, "resetting login failures")
+	}
+
+	return nil
+}
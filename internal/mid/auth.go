@@ -5,6 +5,7 @@ import (
 	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/sreejeet/garagesale/internal/platform/auth"
 	"github.com/sreejeet/garagesale/internal/platform/web"
@@ -18,8 +19,17 @@ var ErrForbidden = web.NewRequestError(
 	http.StatusForbidden,
 )
 
-// Authenticate middleware validates the token in the Authorization header.
-func Authenticate(authenticator *auth.Authenticator) web.Middleware {
+// ErrRevoked is returned for tokens that were valid but have been revoked.
+var ErrRevoked = web.NewRequestError(
+	errors.New("token has been revoked"),
+	http.StatusUnauthorized,
+)
+
+// Authenticate middleware validates the credentials in the Authorization header.
+// Bearer tokens are parsed by the authenticator and checked against revocations
+// using isRevoked when it is not nil. API keys are accepted through the ApiKey
+// scheme when apiKeys is not nil.
+func Authenticate(authenticator *auth.Authenticator, isRevoked auth.RevokedFunc, apiKeys auth.KeyFunc) web.Middleware {
 
 	// This is the actual middleware function to be executed.
 	f := func(after web.Handler) web.Handler {
@@ -30,18 +40,25 @@ func Authenticate(authenticator *auth.Authenticator) web.Middleware {
 			ctx, span := trace.StartSpan(ctx, "internal.mid.Authenticate")
 			defer span.End()
 
-			// Parse the authorization header in format `Bearer <token>`.
+			// Parse the authorization header in format `Bearer <token>`
+			// or `ApiKey <key>`.
 			parts := strings.Split(r.Header.Get("Authorization"), " ")
-			if len(parts) != 2 || parts[0] != "Bearer" {
-				err := errors.New("expected authorization header format: Bearer <token>")
-				return web.NewRequestError(err, http.StatusUnauthorized)
-			}
 
-			_, span = trace.StartSpan(ctx, "auth.ParseClaims")
-			claims, err := authenticator.ParseClaims(parts[1])
-			span.End()
+			var claims auth.Claims
+			var err error
+			switch {
+			case len(parts) == 2 && parts[0] == "Bearer":
+				claims, err = parseToken(ctx, authenticator, isRevoked, parts[1])
+			case len(parts) == 2 && parts[0] == "ApiKey" && apiKeys != nil:
+				claims, err = apiKeys(ctx, parts[1], time.Now())
+				if err == auth.ErrInvalidKey {
+					err = web.NewRequestError(err, http.StatusUnauthorized)
+				}
+			default:
+				err = web.NewRequestError(errors.New("expected authorization header format: Bearer <token>"), http.StatusUnauthorized)
+			}
 			if err != nil {
-				return web.NewRequestError(err, http.StatusUnauthorized)
+				return err
 			}
 
 			// Add claims to the context so they can be retrieved later.
@@ -56,6 +73,29 @@ func Authenticate(authenticator *auth.Authenticator) web.Middleware {
 	return f
 }
 
+// parseToken validates a bearer token and makes sure it was not revoked.
+func parseToken(ctx context.Context, authenticator *auth.Authenticator, isRevoked auth.RevokedFunc, token string) (auth.Claims, error) {
+
+	_, span := trace.StartSpan(ctx, "auth.ParseClaims")
+	claims, err := authenticator.ParseClaims(token)
+	span.End()
+	if err != nil {
+		return auth.Claims{}, web.NewRequestError(err, http.StatusUnauthorized)
+	}
+
+	if isRevoked != nil {
+		revoked, err := isRevoked(ctx, claims)
+		if err != nil {
+			return auth.Claims{}, err
+		}
+		if revoked {
+			return auth.Claims{}, ErrRevoked
+		}
+	}
+
+	return claims, nil
+}
+
 // HasRole validates that an authenticated user has at least one role from a
 // specified list. This method constructs the actual function that is used.
 func HasRole(roles ...string) web.Middleware {
@@ -85,3 +125,109 @@ func HasRole(roles ...string) web.Middleware {
 
 	return f
 }
+
+// HasPermission validates that the roles of an authenticated client grant at
+// least one of the specified permissions. The permissions of the roles are
+// resolved using permissions and stored in the context for later checks.
+func HasPermission(permissions auth.PermissionsFunc, perms ...string) web.Middleware {
+
+	// This is the actual middleware function to be executed.
+	f := func(after web.Handler) web.Handler {
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+			ctx, span := trace.StartSpan(ctx, "internal.mid.HasPermission")
+			defer span.End()
+
+			claims, ok := ctx.Value(auth.Key).(auth.Claims)
+			if !ok {
+				return errors.New("claims missing from context: HasPermission called without/before Authenticate")
+			}
+
+			granted, ok := ctx.Value(auth.GrantedKey).(auth.Granted)
+			if !ok || !granted.Of(claims.Roles) {
+				resolved, err := permissions(ctx, claims.Roles)
+				if err != nil {
+					return err
+				}
+				granted = auth.Granted{Roles: claims.Roles, Permissions: resolved}
+				ctx = context.WithValue(ctx, auth.GrantedKey, granted)
+			}
+
+			for _, has := range granted.Permissions {
+				for _, want := range perms {
+					if has == want {
+						return after(ctx, w, r)
+					}
+				}
+			}
+
+			return ErrForbidden
+		}
+
+		return h
+	}
+
+	return f
+}
+
+// NoKeys rejects clients authenticated with an API key. Keys act as their
+// user but must not be able to take over the account, so routes changing
+// credentials, email addresses or sessions are reserved for the user.
+func NoKeys() web.Middleware {
+
+	// This is the actual middleware function to be executed.
+	f := func(after web.Handler) web.Handler {
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+			ctx, span := trace.StartSpan(ctx, "internal.mid.NoKeys")
+			defer span.End()
+
+			claims, ok := ctx.Value(auth.Key).(auth.Claims)
+			if !ok {
+				return errors.New("claims missing from context: NoKeys called without/before Authenticate")
+			}
+
+			if claims.KeyID != "" {
+				return ErrForbidden
+			}
+
+			return after(ctx, w, r)
+		}
+
+		return h
+	}
+
+	return f
+}
+
+// HasOrg validates that an authenticated client acts in an organization. It
+// guards every route reading or changing data that belongs to one.
+func HasOrg() web.Middleware {
+
+	// This is the actual middleware function to be executed.
+	f := func(after web.Handler) web.Handler {
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+			ctx, span := trace.StartSpan(ctx, "internal.mid.HasOrg")
+			defer span.End()
+
+			claims, ok := ctx.Value(auth.Key).(auth.Claims)
+			if !ok {
+				return errors.New("claims missing from context: HasOrg called without/before Authenticate")
+			}
+
+			if claims.OrgID == "" {
+				return ErrForbidden
+			}
+
+			return after(ctx, w, r)
+		}
+
+		return h
+	}
+
+	return f
+}
@@ -0,0 +1,34 @@
+package order
+
+import (
+	"time"
+
+	"github.com/sreejeet/garagesale/internal/platform/money"
+	"github.com/sreejeet/garagesale/internal/product"
+)
+
+// Order is a single checkout of one or more products. Each line of the order
+// is recorded as a product sale linked back to the order.
+// Total is the amount paid for all lines together. All lines of an order
+// are paid in the same currency.
+type Order struct {
+	ID          string         `db:"order_id" json:"id"`
+	UserID      string         `db:"user_id" json:"user_id"`
+	OrgID       string         `db:"org_id" json:"org_id"`
+	Total       money.Money    `db:"total" json:"total"`
+	DateCreated time.Time      `db:"date_created" json:"date_created"`
+	Lines       []product.Sale `db:"-" json:"lines"`
+}
+
+// NewOrder is the form sent by clients to check out an order.
+type NewOrder struct {
+	Lines []NewLine `json:"lines" validate:"required,min=1,dive"`
+}
+
+// NewLine is a single product of a new order. Paid is
+// the amount paid for all items of this line.
+type NewLine struct {
+	ProductID string      `json:"product_id" validate:"required"`
+	Quantity  int         `json:"quantity" validate:"gte=1"`
+	Paid      money.Money `json:"paid"`
+}
@@ -0,0 +1,192 @@
+package order
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/sreejeet/garagesale/internal/platform/auth"
+	"github.com/sreejeet/garagesale/internal/platform/money"
+	"github.com/sreejeet/garagesale/internal/product"
+	"go.opencensus.io/trace"
+)
+
+// Custom errors for expected failing conditions
+var (
+	// Invalid UUID
+	ErrInvalidID = errors.New("invalid ID")
+	// Unable to find order based on UUID
+	ErrNotFound = errors.New("order not found")
+	// ErrMixedCurrencies occurs when the lines of an order are paid in
+	// different currencies.
+	ErrMixedCurrencies = errors.New("all lines of an order must be paid in the same currency")
+)
+
+// Checkout records every line of an order in a single transaction. Either all
+// lines are sold or, when any line fails, none of them are. Errors from the
+// product package such as product.ErrInsufficientStock are returned wrapped
+// with the product that caused them.
+func Checkout(ctx context.Context, db *sqlx.DB, user auth.Claims, no NewOrder, now time.Time) (*Order, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.order.Checkout")
+	defer span.End()
+
+	o := Order{
+		ID:          uuid.New().String(),
+		UserID:      user.Subject,
+		OrgID:       user.OrgID,
+		DateCreated: now.UTC(),
+		Lines:       []product.Sale{},
+	}
+	if len(no.Lines) > 0 {
+		o.Total = money.New(0, no.Lines[0].Paid.Currency)
+	}
+	for _, l := range no.Lines {
+		total, err := o.Total.Add(l.Paid)
+		if err != nil {
+			return nil, ErrMixedCurrencies
+		}
+		o.Total = total
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting checkout transaction")
+	}
+
+	// Rolling back after a commit is a no-op so this is safe to defer.
+	defer tx.Rollback()
+
+	// The order row has to exist before the sales referencing it.
+	const q = `INSERT INTO orders
+		(order_id, user_id, org_id, total, date_created)
+		VALUES ($1, $2, $3, $4, $5)`
+	if _, err := tx.ExecContext(ctx, q, o.ID, o.UserID, o.OrgID, o.Total, o.DateCreated); err != nil {
+		return nil, errors.Wrap(err, "creating order")
+	}
+
+	// Products are locked in a fixed order so two checkouts sharing
+	// products can not deadlock waiting on each other.
+	lines := append([]NewLine{}, no.Lines...)
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].ProductID < lines[j].ProductID
+	})
+
+	for _, l := range lines {
+		ns := product.NewSale{
+			Quantity: l.Quantity,
+			Paid:     l.Paid,
+		}
+		s, err := product.AddSaleTx(ctx, tx, user, ns, l.ProductID, &o.ID, o.DateCreated)
+		if err != nil {
+			return nil, errors.Wrapf(err, "product %s", l.ProductID)
+		}
+		o.Lines = append(o.Lines, *s)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing order")
+	}
+
+	return &o, nil
+}
+
+// Retrieve gets a single order of the organization orgID and all of its lines.
+func Retrieve(ctx context.Context, db *sqlx.DB, orgID, id string) (*Order, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.order.Retrieve")
+	defer span.End()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+	if _, err := uuid.Parse(orgID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var o Order
+	const q = `SELECT * FROM orders WHERE order_id = $1 AND org_id = $2`
+	if err := db.GetContext(ctx, &o, q, id, orgID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "selecting one order")
+	}
+
+	orders := []Order{o}
+	if err := loadLines(ctx, db, orders); err != nil {
+		return nil, err
+	}
+
+	return &orders[0], nil
+}
+
+// List retrieves one page of orders of the organization orgID, newest first,
+// with their lines. It also returns the total number of orders.
+func List(ctx context.Context, db *sqlx.DB, orgID string, page, limit int) ([]Order, int, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.order.List")
+	defer span.End()
+
+	if _, err := uuid.Parse(orgID); err != nil {
+		return nil, 0, ErrInvalidID
+	}
+
+	var total int
+	const count = `SELECT COUNT(*) FROM orders WHERE org_id = $1`
+	if err := db.GetContext(ctx, &total, count, orgID); err != nil {
+		return nil, 0, errors.Wrap(err, "counting orders")
+	}
+
+	orders := []Order{}
+	const q = `SELECT * FROM orders
+				WHERE org_id = $1
+				ORDER BY date_created DESC, order_id
+				LIMIT $2 OFFSET $3`
+	if err := db.SelectContext(ctx, &orders, q, orgID, limit, (page-1)*limit); err != nil {
+		return nil, 0, errors.Wrap(err, "selecting orders")
+	}
+
+	if err := loadLines(ctx, db, orders); err != nil {
+		return nil, 0, err
+	}
+
+	return orders, total, nil
+}
+
+// loadLines fills in the lines of the provided orders using a single query.
+// Refunds and voids of a line are part of the order as well.
+func loadLines(ctx context.Context, db *sqlx.DB, orders []Order) error {
+
+	if len(orders) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(orders))
+	index := make(map[string]int, len(orders))
+	for i := range orders {
+		ids[i] = orders[i].ID
+		index[orders[i].ID] = i
+		orders[i].Lines = []product.Sale{}
+	}
+
+	var lines []product.Sale
+	const q = `SELECT * FROM sales
+				WHERE order_id = ANY($1)
+				ORDER BY date_created, sale_id`
+	if err := db.SelectContext(ctx, &lines, q, pq.Array(ids)); err != nil {
+		return errors.Wrap(err, "selecting order lines")
+	}
+
+	for _, l := range lines {
+		i := index[*l.OrderID]
+		orders[i].Lines = append(orders[i].Lines, l)
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package order_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sreejeet/garagesale/internal/order"
+	"github.com/sreejeet/garagesale/internal/org"
+	"github.com/sreejeet/garagesale/internal/platform/auth"
+	"github.com/sreejeet/garagesale/internal/platform/money"
+	"github.com/sreejeet/garagesale/internal/product"
+	"github.com/sreejeet/garagesale/internal/tests"
+)
+
+func TestOrders(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	// Create a claims object with some random UUID for testing
+	claims := auth.NewClaims(
+		"718ffbea-f4a1-4667-8ae3-b349da52675e",
+		[]string{auth.RoleAdmin, auth.RoleUser},
+		now, time.Hour,
+	)
+	claims.OrgID = org.DefaultID
+
+	puzzles, err := product.Create(ctx, db, claims, product.NewProduct{Name: "Puzzles", Cost: money.New(2500, "USD"), Quantity: 6}, now)
+	if err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+	toys, err := product.Create(ctx, db, claims, product.NewProduct{Name: "Toys", Cost: money.New(4000, "USD"), Quantity: 3}, now)
+	if err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+
+	{ // Checkout and retrieve
+
+		no := order.NewOrder{
+			Lines: []order.NewLine{
+				{ProductID: puzzles.ID, Quantity: 2, Paid: money.New(5000, "USD")},
+				{ProductID: toys.ID, Quantity: 1, Paid: money.New(3500, "USD")},
+			},
+		}
+
+		o, err := order.Checkout(ctx, db, claims, no, now)
+		if err != nil {
+			t.Fatalf("checking out order: %s", err)
+		}
+		if exp, got := money.New(8500, "USD"), o.Total; exp != got {
+			t.Fatalf("expected order total %v, got %v", exp, got)
+		}
+
+		saved, err := order.Retrieve(ctx, db, org.DefaultID, o.ID)
+		if err != nil {
+			t.Fatalf("getting order: %s", err)
+		}
+		if exp, got := 2, len(saved.Lines); exp != got {
+			t.Fatalf("expected %v order lines, got %v", exp, got)
+		}
+
+		sales, err := product.ListSales(ctx, db, org.DefaultID, toys.ID)
+		if err != nil {
+			t.Fatalf("listing sales: %s", err)
+		}
+		if exp, got := o.ID, *sales[0].OrderID; exp != got {
+			t.Fatalf("expected sale to belong to order %v, got %v", exp, got)
+		}
+	}
+
+	{ // A failing line rolls back the whole order
+
+		no := order.NewOrder{
+			Lines: []order.NewLine{
+				{ProductID: puzzles.ID, Quantity: 1, Paid: money.New(2500, "USD")},
+				{ProductID: toys.ID, Quantity: 3, Paid: money.New(12000, "USD")},
+			},
+		}
+
+		if _, err := order.Checkout(ctx, db, claims, no, now); errors.Cause(err) != product.ErrInsufficientStock {
+			t.Fatalf("expected %v, got %v", product.ErrInsufficientStock, err)
+		}
+
+		p, err := product.Retrieve(ctx, db, org.DefaultID, puzzles.ID, false)
+		if err != nil {
+			t.Fatalf("getting product: %s", err)
+		}
+		if exp, got := 2, p.Sold; exp != got {
+			t.Fatalf("expected sold %v after failed order, got %v", exp, got)
+		}
+
+		list, total, err := order.List(ctx, db, org.DefaultID, 1, 10)
+		if err != nil {
+			t.Fatalf("listing orders: %s", err)
+		}
+		if exp, got := 1, total; exp != got {
+			t.Fatalf("expected %v orders, got %v", exp, got)
+		}
+		if exp, got := 1, len(list); exp != got {
+			t.Fatalf("expected order list size %v, got %v", exp, got)
+		}
+	}
+	{ // Lines of one order can not mix currencies
+
+		no := order.NewOrder{
+			Lines: []order.NewLine{
+				{ProductID: puzzles.ID, Quantity: 1, Paid: money.New(2500, "USD")},
+				{ProductID: toys.ID, Quantity: 1, Paid: money.New(300000, "INR")},
+			},
+		}
+
+		if _, err := order.Checkout(ctx, db, claims, no, now); err != order.ErrMixedCurrencies {
+			t.Fatalf("expected %v, got %v", order.ErrMixedCurrencies, err)
+		}
+	}
+}
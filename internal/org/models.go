@@ -0,0 +1,40 @@
+package org
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Org is an organization, such as a community group running its own garage
+// sale. Products, orders and API keys belong to exactly one organization.
+type Org struct {
+	ID          string    `db:"org_id" json:"id"`
+	Name        string    `db:"name" json:"name"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+}
+
+// NewOrg is what we require from clients when creating an organization.
+type NewOrg struct {
+	Name string `json:"name" validate:"required"`
+}
+
+// Member is a user belonging to an organization. Roles are what the user
+// may do in this organization only.
+type Member struct {
+	UserID     string         `db:"user_id" json:"user_id"`
+	Name       string         `db:"name" json:"name"`
+	Email      string         `db:"email" json:"email"`
+	Roles      pq.StringArray `db:"roles" json:"roles"`
+	DateJoined time.Time      `db:"date_joined" json:"date_joined"`
+}
+
+// NewInvite is the form org admins send to invite someone by email.
+type NewInvite struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// AcceptInvite is the form sent by users accepting an invitation.
+type AcceptInvite struct {
+	Token string `json:"token" validate:"required"`
+}
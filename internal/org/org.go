@@ -0,0 +1,257 @@
+// Package org manages organizations and their members. A single deployment
+// serves many organizations; everything a client does is scoped to the
+// organization that is active in its token.
+package org
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/sreejeet/garagesale/internal/platform/auth"
+	"go.opencensus.io/trace"
+)
+
+// DefaultID is the organization every user and product existing before
+// organizations were introduced belongs to.
+const DefaultID = "00000000-0000-0000-0000-000000000001"
+
+// InviteTTL is how long an invitation can be accepted.
+const InviteTTL = 7 * 24 * time.Hour
+
+// Custom errors for expected failing conditions
+var (
+	// Invalid UUID
+	ErrInvalidID = errors.New("invalid ID")
+	// Unable to find organization or member based on UUID
+	ErrNotFound = errors.New("organization not found")
+	// ErrForbidden occurs when a client acts on an organization other than its active one.
+	ErrForbidden = errors.New("Attempted action is not allowed")
+	// ErrInvalidToken occurs when an invitation is unknown, expired or meant for someone else.
+	ErrInvalidToken = errors.New("invalid or expired invitation")
+)
+
+// Create adds a new organization with the creating user as its first member
+// and admin.
+func Create(ctx context.Context, db *sqlx.DB, user auth.Claims, no NewOrg, now time.Time) (*Org, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.org.Create")
+	defer span.End()
+
+	o := Org{
+		ID:          uuid.New().String(),
+		Name:        no.Name,
+		DateCreated: now.UTC(),
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting organization transaction")
+	}
+
+	// Rolling back after a commit is a no-op so this is safe to defer.
+	defer tx.Rollback()
+
+	const q = `INSERT INTO organizations (org_id, name, date_created) VALUES ($1, $2, $3)`
+	if _, err := tx.ExecContext(ctx, q, o.ID, o.Name, o.DateCreated); err != nil {
+		return nil, errors.Wrap(err, "inserting organization")
+	}
+
+	if err := AddMember(ctx, tx, o.ID, user.Subject, []string{auth.RoleAdmin}, now); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing organization")
+	}
+
+	return &o, nil
+}
+
+// AddMember makes a user a member of an organization with the provided roles.
+// Adding an existing member again has no effect, their roles are kept.
+func AddMember(ctx context.Context, db sqlx.ExecerContext, orgID, userID string, roles []string, now time.Time) error {
+
+	const q = `INSERT INTO org_members (org_id, user_id, roles, date_joined)
+				VALUES ($1, $2, $3, $4)
+				ON CONFLICT DO NOTHING`
+	if _, err := db.ExecContext(ctx, q, orgID, userID, pq.StringArray(roles), now.UTC()); err != nil {
+		return errors.Wrap(err, "adding member")
+	}
+
+	return nil
+}
+
+// ListForUser returns the organizations a user is a member of, in the order
+// they were joined.
+func ListForUser(ctx context.Context, db *sqlx.DB, userID string) ([]Org, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.org.ListForUser")
+	defer span.End()
+
+	orgs := []Org{}
+	const q = `SELECT o.org_id, o.name, o.date_created FROM organizations AS o
+				JOIN org_members AS m ON m.org_id = o.org_id
+				WHERE m.user_id = $1
+				ORDER BY m.date_joined, o.org_id`
+	if err := db.SelectContext(ctx, &orgs, q, userID); err != nil {
+		return nil, errors.Wrap(err, "selecting organizations")
+	}
+
+	return orgs, nil
+}
+
+// Members returns the members of the active organization of the client.
+func Members(ctx context.Context, db *sqlx.DB, user auth.Claims, orgID string) ([]Member, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.org.Members")
+	defer span.End()
+
+	if err := checkActive(user, orgID); err != nil {
+		return nil, err
+	}
+
+	members := []Member{}
+	const q = `SELECT u.user_id, u.name, u.email, m.roles, m.date_joined
+				FROM org_members AS m
+				JOIN users AS u ON u.user_id = m.user_id
+				WHERE m.org_id = $1
+				ORDER BY m.date_joined, u.user_id`
+	if err := db.SelectContext(ctx, &members, q, orgID); err != nil {
+		return nil, errors.Wrap(err, "selecting members")
+	}
+
+	return members, nil
+}
+
+// RemoveMember removes a user from the active organization of the client.
+func RemoveMember(ctx context.Context, db *sqlx.DB, user auth.Claims, orgID, userID string) error {
+
+	ctx, span := trace.StartSpan(ctx, "internal.org.RemoveMember")
+	defer span.End()
+
+	if err := checkActive(user, orgID); err != nil {
+		return err
+	}
+	if _, err := uuid.Parse(userID); err != nil {
+		return ErrInvalidID
+	}
+
+	const q = `DELETE FROM org_members WHERE org_id = $1 AND user_id = $2`
+	res, err := db.ExecContext(ctx, q, orgID, userID)
+	if err != nil {
+		return errors.Wrap(err, "removing member")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "counting removed members")
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Invite creates an invitation to the active organization of the client for
+// an email address. It returns the organization and the token to send to the
+// invitee. Only a hash of the token is stored.
+func Invite(ctx context.Context, db *sqlx.DB, user auth.Claims, orgID string, ni NewInvite, now time.Time) (*Org, string, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.org.Invite")
+	defer span.End()
+
+	if err := checkActive(user, orgID); err != nil {
+		return nil, "", err
+	}
+
+	var o Org
+	if err := db.GetContext(ctx, &o, `SELECT org_id, name, date_created FROM organizations WHERE org_id = $1`, orgID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, "", ErrNotFound
+		}
+		return nil, "", errors.Wrap(err, "selecting organization")
+	}
+
+	token, hash, err := auth.NewSecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	const q = `INSERT INTO org_invites (token_hash, org_id, email, invited_by, date_expires)
+				VALUES ($1, $2, $3, $4, $5)`
+	_, err = db.ExecContext(ctx, q, hash, orgID, strings.ToLower(ni.Email), user.Subject, now.Add(InviteTTL).UTC())
+	if err != nil {
+		return nil, "", errors.Wrap(err, "inserting invitation")
+	}
+
+	return &o, token, nil
+}
+
+// Accept makes the client a regular member of the organization it was invited
+// to. The invitation must have been sent to the email address of the client.
+func Accept(ctx context.Context, db *sqlx.DB, user auth.Claims, token string, now time.Time) (*Org, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.org.Accept")
+	defer span.End()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting invitation transaction")
+	}
+	defer tx.Rollback()
+
+	// The invitation is only removed when the membership is committed so
+	// a user with another email address can not burn it.
+	var inv struct {
+		OrgID       string    `db:"org_id"`
+		DateExpires time.Time `db:"date_expires"`
+	}
+	const q = `SELECT i.org_id, i.date_expires FROM org_invites AS i
+				JOIN users AS u ON LOWER(u.email) = i.email
+				WHERE i.token_hash = $1 AND u.user_id = $2
+				FOR UPDATE OF i`
+	if err := tx.GetContext(ctx, &inv, q, auth.HashSecret(token), user.Subject); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidToken
+		}
+		return nil, errors.Wrap(err, "selecting invitation")
+	}
+	if !now.Before(inv.DateExpires) {
+		return nil, ErrInvalidToken
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM org_invites WHERE token_hash = $1`, auth.HashSecret(token)); err != nil {
+		return nil, errors.Wrap(err, "deleting invitation")
+	}
+	if err := AddMember(ctx, tx, inv.OrgID, user.Subject, []string{auth.RoleUser}, now); err != nil {
+		return nil, err
+	}
+
+	var o Org
+	if err := tx.GetContext(ctx, &o, `SELECT org_id, name, date_created FROM organizations WHERE org_id = $1`, inv.OrgID); err != nil {
+		return nil, errors.Wrap(err, "selecting organization")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing invitation")
+	}
+
+	return &o, nil
+}
+
+// checkActive makes sure a client only manages its active organization.
+func checkActive(user auth.Claims, orgID string) error {
+	if _, err := uuid.Parse(orgID); err != nil {
+		return ErrInvalidID
+	}
+	if orgID != user.OrgID {
+		return ErrForbidden
+	}
+	return nil
+}
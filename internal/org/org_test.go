@@ -0,0 +1,94 @@
+package org_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sreejeet/garagesale/internal/org"
+	"github.com/sreejeet/garagesale/internal/platform/auth"
+	"github.com/sreejeet/garagesale/internal/schema"
+	"github.com/sreejeet/garagesale/internal/tests"
+)
+
+func TestOrgs(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	if err := schema.Seed(db); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	admin := auth.NewClaims(tests.AdminID, []string{auth.RoleAdmin}, now, time.Hour)
+	admin.OrgID = org.DefaultID
+
+	o, err := org.Create(ctx, db, admin, org.NewOrg{Name: "Planet Express"}, now)
+	if err != nil {
+		t.Fatalf("creating organization: %s", err)
+	}
+
+	orgs, err := org.ListForUser(ctx, db, tests.AdminID)
+	if err != nil {
+		t.Fatalf("listing organizations: %s", err)
+	}
+	if len(orgs) != 2 || orgs[0].ID != org.DefaultID || orgs[1].ID != o.ID {
+		t.Fatalf("expected the default and the new organization, got %+v", orgs)
+	}
+
+	// Members can only be managed from the active organization.
+	ni := org.NewInvite{Email: "user@example.com"}
+	if _, _, err := org.Invite(ctx, db, admin, o.ID, ni, now); err != org.ErrForbidden {
+		t.Fatalf("expected %v inviting to inactive organization, got %v", org.ErrForbidden, err)
+	}
+
+	admin.OrgID = o.ID
+	_, token, err := org.Invite(ctx, db, admin, o.ID, ni, now)
+	if err != nil {
+		t.Fatalf("inviting: %s", err)
+	}
+
+	// Invitations are only valid for the invited email address and until they expire.
+	if _, err := org.Accept(ctx, db, admin, token, now); err != org.ErrInvalidToken {
+		t.Fatalf("expected %v accepting invitation of someone else, got %v", org.ErrInvalidToken, err)
+	}
+	user := auth.NewClaims(tests.UserID, []string{auth.RoleUser}, now, time.Hour)
+	if _, err := org.Accept(ctx, db, user, token, now.Add(org.InviteTTL)); err != org.ErrInvalidToken {
+		t.Fatalf("expected %v accepting expired invitation, got %v", org.ErrInvalidToken, err)
+	}
+	if _, err := org.Accept(ctx, db, user, token, now); err != nil {
+		t.Fatalf("accepting invitation: %s", err)
+	}
+	if _, err := org.Accept(ctx, db, user, token, now); err != org.ErrInvalidToken {
+		t.Fatalf("expected %v reusing invitation, got %v", org.ErrInvalidToken, err)
+	}
+
+	members, err := org.Members(ctx, db, admin, o.ID)
+	if err != nil {
+		t.Fatalf("listing members: %s", err)
+	}
+	if len(members) != 2 {
+		t.Fatalf("expected 2 members, got %d", len(members))
+	}
+
+	// The creator administers the organization and invitees join as
+	// regular users whatever their roles elsewhere.
+	for _, m := range members {
+		want := auth.RoleUser
+		if m.UserID == tests.AdminID {
+			want = auth.RoleAdmin
+		}
+		if len(m.Roles) != 1 || m.Roles[0] != want {
+			t.Fatalf("expected member %s to have role %s, got %v", m.UserID, want, m.Roles)
+		}
+	}
+
+	if err := org.RemoveMember(ctx, db, admin, o.ID, tests.UserID); err != nil {
+		t.Fatalf("removing member: %s", err)
+	}
+	if err := org.RemoveMember(ctx, db, admin, o.ID, tests.UserID); err != org.ErrNotFound {
+		t.Fatalf("expected %v removing member twice, got %v", org.ErrNotFound, err)
+	}
+}
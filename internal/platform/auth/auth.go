@@ -1,8 +1,9 @@
 package auth
 
 import (
-	"crypto/rsa"
+	"crypto"
 	"fmt"
+	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/pkg/errors"
@@ -17,13 +18,15 @@ import (
 //
 // * Key-id-to-public-key resolution is usually accomplished via a public JWKS
 // endpoint. See https://auth0.com/docs/jwks for more details.
-type KeyLookupFunc func(kid string) (*rsa.PublicKey, error)
+//
+// * Keys may be RSA, ECDSA or Ed25519 public keys.
+type KeyLookupFunc func(kid string) (crypto.PublicKey, error)
 
 // NewSimpleKeyLookupFunc is used to make a key lookup function that returns a single public key based on the active key id.
-func NewSimpleKeyLookupFunc(activeKID string, publicKey *rsa.PublicKey) KeyLookupFunc {
+func NewSimpleKeyLookupFunc(activeKID string, publicKey crypto.PublicKey) KeyLookupFunc {
 
 	// Return KeyLookupFunc
-	f := func(kid string) (*rsa.PublicKey, error) {
+	f := func(kid string) (crypto.PublicKey, error) {
 		if activeKID != kid {
 			return nil, fmt.Errorf("unrecognized key id %q", kid)
 		}
@@ -33,18 +36,33 @@ func NewSimpleKeyLookupFunc(activeKID string, publicKey *rsa.PublicKey) KeyLooku
 	return f
 }
 
+// Policy holds the values every token must carry. Issuer and Audience are
+// written into the tokens we generate and required of the tokens we parse;
+// either is ignored when blank. Lifetime is the expiry of generated tokens
+// whose claims do not set one. Leeway is the clock skew allowed between
+// servers when checking the times in a token.
+type Policy struct {
+	Issuer   string
+	Audience string
+	Lifetime time.Duration
+	Leeway   time.Duration
+}
+
 // Authenticator authenticates clients. It can generate and
 // also recreate calaims by parsing tokens
 type Authenticator struct {
-	privateKey       *rsa.PrivateKey
+	privateKey       crypto.Signer
 	activeKID        string
 	algorithm        string
 	pubKeyLookupFunc KeyLookupFunc
+	policy           Policy
 	parser           *jwt.Parser
 }
 
 // NewAuthenticator is a factory function for creating Authenticator instances.
-func NewAuthenticator(privateKey *rsa.PrivateKey, activeKID, algorithm string, publicKeyLookupFunc KeyLookupFunc) (*Authenticator, error) {
+// The private key may be an RSA, ECDSA or Ed25519 key. When algorithm is blank
+// the default algorithm for the type of the key is used.
+func NewAuthenticator(privateKey crypto.Signer, activeKID, algorithm string, publicKeyLookupFunc KeyLookupFunc, policy Policy) (*Authenticator, error) {
 
 	// Validate privided parameters for potential errors.
 	if privateKey == nil {
@@ -53,18 +71,32 @@ func NewAuthenticator(privateKey *rsa.PrivateKey, activeKID, algorithm string, p
 	if activeKID == "" {
 		return nil, errors.New("active key id cannot be blank")
 	}
+	if algorithm == "" {
+		var err error
+		if algorithm, err = DefaultAlgorithm(privateKey.Public()); err != nil {
+			return nil, err
+		}
+	}
 	if jwt.GetSigningMethod(algorithm) == nil {
 		return nil, errors.Errorf("unknown algorithm %v", algorithm)
 	}
+	if !supportsAlgorithm(privateKey.Public(), algorithm) {
+		return nil, errors.Errorf("algorithm %v can not be used with a %T key", algorithm, privateKey)
+	}
 	if publicKeyLookupFunc == nil {
 		return nil, errors.New("public key function cannot be nil")
 	}
+	if policy.Lifetime < 0 || policy.Leeway < 0 {
+		return nil, errors.New("token lifetime and leeway cannot be negative")
+	}
 
-	// Create a parser that can be used to validate the
-	// signing algorithm used to avoid a critical bug in JWT.
+	// Verifying keys may be of different types while keys are rotated so the
+	// parser accepts any algorithm. Each token is instead checked against the
+	// algorithms of the key it names to avoid a critical bug in JWT.
 	// Refer: https://auth0.com/blog/critical-vulnerabilities-in-json-web-token-libraries/
+	// The claims are validated by us so the leeway can be applied.
 	parser := jwt.Parser{
-		ValidMethods: []string{algorithm},
+		SkipClaimsValidation: true,
 	}
 
 	a := Authenticator{
@@ -72,15 +104,36 @@ func NewAuthenticator(privateKey *rsa.PrivateKey, activeKID, algorithm string, p
 		activeKID:        activeKID,
 		algorithm:        algorithm,
 		pubKeyLookupFunc: publicKeyLookupFunc,
+		policy:           policy,
 		parser:           &parser,
 	}
 
 	return &a, nil
 }
 
+// Algorithm returns the name of the algorithm tokens are signed with.
+func (a *Authenticator) Algorithm() string {
+	return a.algorithm
+}
+
 // GenerateToken generates a signed JWT token string representing the user Claims.
+// The issuer and audience of the Authenticator's policy are applied. Claims
+// without an expiry get the lifetime of the policy.
 func (a *Authenticator) GenerateToken(claims Claims) (string, error) {
 
+	if a.policy.Issuer != "" {
+		claims.Issuer = a.policy.Issuer
+	}
+	if a.policy.Audience != "" {
+		claims.Audience = a.policy.Audience
+	}
+	if claims.ExpiresAt == 0 {
+		if a.policy.Lifetime == 0 {
+			return "", errors.New("token has no expiry")
+		}
+		claims.ExpiresAt = time.Unix(claims.IssuedAt, 0).Add(a.policy.Lifetime).Unix()
+	}
+
 	// Use the signing algorithm used by the Authenticator
 	method := jwt.GetSigningMethod(a.algorithm)
 
@@ -114,7 +167,15 @@ func (a *Authenticator) ParseClaims(tokenStr string) (Claims, error) {
 			return nil, errors.New("user token key id (kid) must be string")
 		}
 
-		return a.pubKeyLookupFunc(userKID)
+		key, err := a.pubKeyLookupFunc(userKID)
+		if err != nil {
+			return nil, err
+		}
+		if !supportsAlgorithm(key, t.Method.Alg()) {
+			return nil, errors.Errorf("algorithm %v can not be used with key %q", t.Method.Alg(), userKID)
+		}
+
+		return key, nil
 	}
 
 	var claims Claims
@@ -127,5 +188,36 @@ func (a *Authenticator) ParseClaims(tokenStr string) (Claims, error) {
 		return Claims{}, errors.New("invalid token")
 	}
 
+	if err := a.validate(claims, time.Now()); err != nil {
+		return Claims{}, errors.Wrap(err, "validating token")
+	}
+
 	return claims, nil
 }
+
+// validate checks the times, issuer and audience of claims against the policy.
+func (a *Authenticator) validate(claims Claims, now time.Time) error {
+
+	leeway := int64(a.policy.Leeway / time.Second)
+	unix := now.Unix()
+
+	switch {
+	case claims.ExpiresAt == 0:
+		return errors.New("token has no expiry")
+	case unix > claims.ExpiresAt+leeway:
+		return errors.New("token is expired")
+	case unix < claims.IssuedAt-leeway:
+		return errors.New("token used before issued")
+	case unix < claims.NotBefore-leeway:
+		return errors.New("token is not valid yet")
+	}
+
+	if a.policy.Issuer != "" && claims.Issuer != a.policy.Issuer {
+		return errors.Errorf("token issued by %q", claims.Issuer)
+	}
+	if a.policy.Audience != "" && claims.Audience != a.policy.Audience {
+		return errors.Errorf("token issued for %q", claims.Audience)
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package auth_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/sreejeet/garagesale/internal/platform/auth"
+)
+
+func TestAuthenticatorKeyTypes(t *testing.T) {
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		key  crypto.Signer
+		alg  string
+	}{
+		{"RSA", rsaKey, "RS256"},
+		{"P-256", ec256, "ES256"},
+		{"P-384", ec384, "ES384"},
+		{"Ed25519", edKey, "EdDSA"},
+	}
+
+	claims := auth.NewClaims("718ffbea-f4a1-4667-8ae3-b349da52675e", []string{auth.RoleUser}, time.Now(), time.Hour)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			keys := auth.NewKeySet(tt.name, tt.key)
+
+			// A blank algorithm is detected from the key.
+			a, err := auth.NewAuthenticator(tt.key, tt.name, "", keys.Lookup, auth.Policy{})
+			if err != nil {
+				t.Fatalf("creating authenticator: %s", err)
+			}
+			if a.Algorithm() != tt.alg {
+				t.Fatalf("expected algorithm %s, got %s", tt.alg, a.Algorithm())
+			}
+
+			tkn, err := a.GenerateToken(claims)
+			if err != nil {
+				t.Fatalf("generating token: %s", err)
+			}
+
+			parsed, err := a.ParseClaims(tkn)
+			if err != nil {
+				t.Fatalf("parsing token: %s", err)
+			}
+			if parsed.Subject != claims.Subject {
+				t.Fatalf("expected subject %s, got %s", claims.Subject, parsed.Subject)
+			}
+
+			jwks := keys.JWKS(a.Algorithm())
+			if len(jwks.Keys) != 1 || jwks.Keys[0].Algorithm != tt.alg {
+				t.Fatalf("unexpected key set %+v", jwks)
+			}
+		})
+	}
+
+	if _, err := auth.NewAuthenticator(ec256, "1", "RS256", auth.NewSimpleKeyLookupFunc("1", ec256.Public()), auth.Policy{}); err == nil {
+		t.Fatal("expected an error using an RSA algorithm with an ECDSA key")
+	}
+
+	// A token naming a key of another type must be refused.
+	signer, err := auth.NewAuthenticator(ec256, "shared", "", auth.NewSimpleKeyLookupFunc("shared", ec256.Public()), auth.Policy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := auth.NewAuthenticator(rsaKey, "shared", "", auth.NewSimpleKeyLookupFunc("shared", rsaKey.Public()), auth.Policy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tkn, err := signer.GenerateToken(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.ParseClaims(tkn); err == nil {
+		t.Fatal("expected an error verifying an ES256 token with an RSA key")
+	}
+}
+
+func TestAuthenticatorPolicy(t *testing.T) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup := auth.NewSimpleKeyLookupFunc("1", key.Public())
+
+	newAuth := func(p auth.Policy) *auth.Authenticator {
+		t.Helper()
+		a, err := auth.NewAuthenticator(key, "1", "", lookup, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+
+	production := newAuth(auth.Policy{Issuer: "production", Audience: "sales-api", Lifetime: 10 * time.Minute, Leeway: time.Minute})
+	staging := newAuth(auth.Policy{Issuer: "staging", Audience: "sales-api"})
+	reports := newAuth(auth.Policy{Issuer: "production", Audience: "reports"})
+
+	now := time.Now()
+	claims := auth.NewClaims("718ffbea-f4a1-4667-8ae3-b349da52675e", []string{auth.RoleUser}, now, 0)
+
+	tkn, err := production.GenerateToken(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := production.ParseClaims(tkn)
+	if err != nil {
+		t.Fatalf("parsing token: %s", err)
+	}
+	if got.Issuer != "production" || got.Audience != "sales-api" || got.ExpiresAt != now.Add(10*time.Minute).Unix() {
+		t.Fatalf("unexpected claims %+v", got)
+	}
+
+	// An expiry set by the caller is kept.
+	short := auth.NewClaims("718ffbea-f4a1-4667-8ae3-b349da52675e", []string{auth.RoleUser}, now, time.Minute)
+	tkn, err = production.GenerateToken(short)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := production.ParseClaims(tkn); err != nil || got.ExpiresAt != now.Add(time.Minute).Unix() {
+		t.Fatalf("expected the expiry of the claims to be kept, got %+v %v", got, err)
+	}
+
+	// Without a lifetime the claims must carry their own expiry.
+	if _, err := staging.GenerateToken(claims); err == nil {
+		t.Fatal("expected an error generating a token without expiry")
+	}
+	tkn, err = production.GenerateToken(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := staging.ParseClaims(tkn); err == nil {
+		t.Fatal("expected a production token to be refused by staging")
+	}
+	if _, err := reports.ParseClaims(tkn); err == nil {
+		t.Fatal("expected a token for another audience to be refused")
+	}
+
+	tests := []struct {
+		name   string
+		issued time.Time
+		valid  bool
+	}{
+		{"ExpiredWithinLeeway", now.Add(-10*time.Minute - 30*time.Second), true},
+		{"Expired", now.Add(-12 * time.Minute), false},
+		{"IssuedAheadWithinLeeway", now.Add(30 * time.Second), true},
+		{"IssuedInFuture", now.Add(5 * time.Minute), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := auth.NewClaims("718ffbea-f4a1-4667-8ae3-b349da52675e", []string{auth.RoleUser}, tt.issued, 0)
+			tkn, err := production.GenerateToken(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := production.ParseClaims(tkn); (err == nil) != tt.valid {
+				t.Fatalf("expected valid %v, got error %v", tt.valid, err)
+			}
+		})
+	}
+}
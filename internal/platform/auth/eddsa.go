@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"crypto/ed25519"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// The jwt package does not implement EdDSA (RFC 8037) so we register our
+// own signing method for Ed25519 keys.
+func init() {
+	jwt.RegisterSigningMethod(signingMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return signingMethodEdDSA
+	})
+}
+
+// signingMethodEdDSA signs tokens with Ed25519 keys.
+var signingMethodEdDSA = &signingMethodEd25519{}
+
+// signingMethodEd25519 implements jwt.SigningMethod for Ed25519.
+type signingMethodEd25519 struct{}
+
+// Alg returns the name of the algorithm used in token headers.
+func (m *signingMethodEd25519) Alg() string {
+	return "EdDSA"
+}
+
+// Verify checks the signature of a token using an ed25519.PublicKey.
+func (m *signingMethodEd25519) Verify(signingString, signature string, key interface{}) error {
+
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(pub, []byte(signingString), sig) {
+		return errors.New("ed25519: verification error")
+	}
+
+	return nil
+}
+
+// Sign signs a token using an ed25519.PrivateKey.
+func (m *signingMethodEd25519) Sign(signingString string, key interface{}) (string, error) {
+
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	return jwt.EncodeSegment(ed25519.Sign(priv, []byte(signingString))), nil
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+
+	"github.com/pkg/errors"
+)
+
+// ParsePrivateKeyPEM parses a PEM encoded RSA, ECDSA or Ed25519 private key.
+func ParsePrivateKeyPEM(b []byte) (crypto.Signer, error) {
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	default:
+		return nil, errors.Errorf("PEM block %q is not a private key", block.Type)
+	}
+}
+
+// ParsePublicKeyPEM parses a PEM encoded RSA, ECDSA or Ed25519 public key.
+func ParsePublicKeyPEM(b []byte) (crypto.PublicKey, error) {
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, errors.Errorf("PEM block %q is not a public key", block.Type)
+	}
+}
+
+// KeyAlgorithms returns the signing algorithms which may be used with a key.
+// It returns nil for keys of unsupported types.
+func KeyAlgorithms(key crypto.PublicKey) []string {
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}
+	case *ecdsa.PublicKey:
+
+		// Each ECDSA algorithm is bound to a single curve.
+		switch k.Curve {
+		case elliptic.P256():
+			return []string{"ES256"}
+		case elliptic.P384():
+			return []string{"ES384"}
+		case elliptic.P521():
+			return []string{"ES512"}
+		}
+	case ed25519.PublicKey:
+		return []string{"EdDSA"}
+	}
+
+	return nil
+}
+
+// DefaultAlgorithm returns the algorithm used for signing with a key when
+// none is configured.
+func DefaultAlgorithm(key crypto.PublicKey) (string, error) {
+	algs := KeyAlgorithms(key)
+	if len(algs) == 0 {
+		return "", errors.Errorf("unsupported key type %T", key)
+	}
+	return algs[0], nil
+}
+
+// supportsAlgorithm reports whether a key can be used with an algorithm.
+func supportsAlgorithm(key crypto.PublicKey, algorithm string) bool {
+	for _, alg := range KeyAlgorithms(key) {
+		if alg == algorithm {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// KeySet holds the keys used for tokens. Exactly one private key is active
+// and used for signing. Every key in the set, including keys of which only
+// the public part is known, is used for verifying. This lets signing keys be
+// rotated without invalidating tokens signed with the previous key.
+type KeySet struct {
+	activeKID string
+	private   crypto.Signer
+	public    map[string]crypto.PublicKey
+}
+
+// NewKeySet creates a key set signing with the provided private key.
+func NewKeySet(activeKID string, private crypto.Signer) *KeySet {
+
+	ks := KeySet{
+		activeKID: activeKID,
+		private:   private,
+		public:    map[string]crypto.PublicKey{},
+	}
+	ks.public[activeKID] = private.Public()
+
+	return &ks
+}
+
+// LoadKeySet reads every .pem file in dir. The file name without the
+// extension is the key id (kid). Files may hold an RSA, ECDSA or Ed25519
+// private key or just a public key; the latter can only be used to verify
+// tokens. The key with the id activeKID must be a private key and is used
+// for signing.
+func LoadKeySet(dir, activeKID string) (*KeySet, error) {
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, errors.Wrap(err, "listing key files")
+	}
+
+	private := map[string]crypto.Signer{}
+	public := map[string]crypto.PublicKey{}
+
+	for _, file := range files {
+		kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading key %q", kid)
+		}
+
+		// Only private key files can be parsed as private keys so
+		// anything else is treated as a verify-only public key.
+		if key, err := ParsePrivateKeyPEM(contents); err == nil {
+			private[kid] = key
+			public[kid] = key.Public()
+			continue
+		}
+		key, err := ParsePublicKeyPEM(contents)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing key %q", kid)
+		}
+		public[kid] = key
+	}
+
+	for kid, key := range public {
+		if KeyAlgorithms(key) == nil {
+			return nil, errors.Errorf("unsupported type %T of key %q", key, kid)
+		}
+	}
+
+	active, ok := private[activeKID]
+	if !ok {
+		return nil, errors.Errorf("no private key found for active key id %q in %s", activeKID, dir)
+	}
+
+	ks := NewKeySet(activeKID, active)
+	for kid, key := range public {
+		ks.AddPublicKey(kid, key)
+	}
+
+	return ks, nil
+}
+
+// AddPublicKey adds a key that is only used for verifying tokens. Keys must
+// be added before the set is used; the set is not safe for concurrent changes.
+func (ks *KeySet) AddPublicKey(kid string, key crypto.PublicKey) {
+	ks.public[kid] = key
+}
+
+// ActiveKID returns the id of the key used for signing.
+func (ks *KeySet) ActiveKID() string {
+	return ks.activeKID
+}
+
+// PrivateKey returns the key used for signing.
+func (ks *KeySet) PrivateKey() crypto.Signer {
+	return ks.private
+}
+
+// Lookup returns the public key for a key id. It is a KeyLookupFunc.
+func (ks *KeySet) Lookup(kid string) (crypto.PublicKey, error) {
+	key, ok := ks.public[kid]
+	if !ok {
+		return nil, errors.Errorf("unrecognized key id %q", kid)
+	}
+	return key, nil
+}
+
+// JWK is a public key in the JSON Web Key format of RFC 7517. Which of the
+// key parameters are set depends on the type of the key.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set as published at /.well-known/jwks.json.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the set, ordered by key id, so other
+// services can verify tokens. The active key is published with the provided
+// algorithm; other keys only when their type implies a single algorithm.
+func (ks *KeySet) JWKS(algorithm string) JWKS {
+
+	set := JWKS{
+		Keys: make([]JWK, 0, len(ks.public)),
+	}
+	for kid, key := range ks.public {
+		jwk, err := newJWK(kid, key)
+		if err != nil {
+			continue
+		}
+		if kid == ks.activeKID {
+			jwk.Algorithm = algorithm
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].KeyID < set.Keys[j].KeyID
+	})
+
+	return set
+}
+
+// newJWK converts a public key to a JWK.
+func newJWK(kid string, key crypto.PublicKey) (JWK, error) {
+
+	enc := base64.RawURLEncoding.EncodeToString
+
+	jwk := JWK{
+		Use:   "sig",
+		KeyID: kid,
+	}
+	if algs := KeyAlgorithms(key); len(algs) == 1 {
+		jwk.Algorithm = algs[0]
+	}
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = enc(k.N.Bytes())
+		jwk.E = enc(big.NewInt(int64(k.E)).Bytes())
+	case *ecdsa.PublicKey:
+
+		// Coordinates are padded to the size of the curve.
+		size := (k.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType = "EC"
+		jwk.Curve = k.Curve.Params().Name
+		jwk.X = enc(padBytes(k.X, size))
+		jwk.Y = enc(padBytes(k.Y, size))
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = enc(k)
+	default:
+		return JWK{}, errors.Errorf("unsupported key type %T", key)
+	}
+
+	return jwk, nil
+}
+
+// padBytes returns the big-endian bytes of n left padded with zeros to size.
+func padBytes(n *big.Int, size int) []byte {
+	b := n.Bytes()
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
@@ -0,0 +1,92 @@
+package auth_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sreejeet/garagesale/internal/platform/auth"
+)
+
+func TestLoadKeySet(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The old key was retired so only its public part is left.
+	old, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(old.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(dir, "old.pem"), "PUBLIC KEY", pub)
+
+	active, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(dir, "new.pem"), "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(active))
+
+	if _, err := auth.LoadKeySet(dir, "old"); err == nil {
+		t.Fatal("expected an error using a public key as the active key")
+	}
+
+	keys, err := auth.LoadKeySet(dir, "new")
+	if err != nil {
+		t.Fatalf("loading keys: %s", err)
+	}
+
+	jwks := keys.JWKS("RS256")
+	if len(jwks.Keys) != 2 || jwks.Keys[0].KeyID != "new" || jwks.Keys[1].KeyID != "old" {
+		t.Fatalf("expected keys new and old, got %+v", jwks.Keys)
+	}
+
+	// Tokens signed with the retired key are still accepted.
+	oldAuth, err := auth.NewAuthenticator(old, "old", "RS256", keys.Lookup, auth.Policy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newAuth, err := auth.NewAuthenticator(keys.PrivateKey(), keys.ActiveKID(), "RS256", keys.Lookup, auth.Policy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := auth.NewClaims("718ffbea-f4a1-4667-8ae3-b349da52675e", []string{auth.RoleUser}, time.Now(), time.Hour)
+	tkn, err := oldAuth.GenerateToken(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newAuth.ParseClaims(tkn); err != nil {
+		t.Fatalf("parsing token signed with retired key: %s", err)
+	}
+
+	if _, err := keys.Lookup("unknown"); err == nil {
+		t.Fatal("expected an error for an unknown key id")
+	}
+}
+
+func writePEM(t *testing.T, path, typ string, b []byte) {
+	t.Helper()
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := pem.Encode(f, &pem.Block{Type: typ, Bytes: b}); err != nil {
+		t.Fatal(err)
+	}
+}
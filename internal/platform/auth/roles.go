@@ -1,15 +1,22 @@
 package auth
 
 import (
+	"context"
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
 )
 
-// Here we define the roles a user can have.
+// Here we define the roles a user can have. What each role may do is
+// decided by the permissions granted to it.
 const (
-	RoleAdmin = "ADMIN"
-	RoleUser  = "USER"
+	RoleAdmin   = "ADMIN"
+	RoleUser    = "USER"
+	RoleCashier = "CASHIER"
+	RoleSeller  = "SELLER"
+	RoleAuditor = "AUDITOR"
 )
 
 // ctxKey represents the type of value for the context key.
@@ -18,28 +25,73 @@ type ctxKey int
 // Key is used to store/retrieve a Claims value from a context.Context.
 const Key ctxKey = 1
 
-// Claims is the payload of JWTs.
+// GrantedKey is used to store/retrieve a Granted value from a context.Context.
+const GrantedKey ctxKey = 2
+
+// Granted holds the permissions resolved for a set of roles. It is stored in
+// the context of a request so the permissions are only resolved once.
+type Granted struct {
+	Roles       []string
+	Permissions []string
+}
+
+// Of reports whether g was resolved for exactly the roles provided.
+func (g Granted) Of(roles []string) bool {
+	if len(g.Roles) != len(roles) {
+		return false
+	}
+	for i := range roles {
+		if g.Roles[i] != roles[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Claims is the payload of JWTs. OrgID is the organization the client is
+// currently acting in; data of other organizations is never visible to it.
+// KeyID is only set for clients authenticated with an API key.
 type Claims struct {
 	Roles []string `json:"roles"`
+	OrgID string   `json:"org,omitempty"`
+	KeyID string   `json:"key,omitempty"`
 	jwt.StandardClaims
 }
 
 // NewClaims creates a new Claims object for the identified user. Additional fields can be
-// set after creating this Claim.
+// set after creating this Claim. Every Claims gets a unique id (jti) so a single
+// token can be revoked. A zero expires leaves the expiry to the Authenticator.
 func NewClaims(subject string, roles []string, now time.Time, expires time.Duration) Claims {
 
 	c := Claims{
 		Roles: roles,
 		StandardClaims: jwt.StandardClaims{
-			Subject:   subject,
-			IssuedAt:  now.Unix(),
-			ExpiresAt: now.Add(expires).Unix(),
+			Id:       uuid.New().String(),
+			Subject:  subject,
+			IssuedAt: now.Unix(),
 		},
 	}
+	if expires != 0 {
+		c.ExpiresAt = now.Add(expires).Unix()
+	}
 
 	return c
 }
 
+// RevokedFunc reports whether the token the claims were parsed from
+// has been revoked, for example because the user logged out.
+type RevokedFunc func(ctx context.Context, claims Claims) (bool, error)
+
+// PermissionsFunc returns the permissions granted to a set of roles.
+type PermissionsFunc func(ctx context.Context, roles []string) ([]string, error)
+
+// KeyFunc returns the claims of the client identified by an API key.
+// It returns ErrInvalidKey when the key is unknown or was revoked.
+type KeyFunc func(ctx context.Context, key string, now time.Time) (Claims, error)
+
+// ErrInvalidKey is returned by a KeyFunc for keys that can not be used.
+var ErrInvalidKey = errors.New("invalid api key")
+
 // HasRole returns true if the claims has at least one of the provided roles.
 func (c Claims) HasRole(roles ...string) bool {
 
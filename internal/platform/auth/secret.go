@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+)
+
+// NewSecret generates a random opaque secret such as a verification or reset
+// token. Only the hash should ever be stored; the secret itself is handed to
+// the client once.
+func NewSecret() (secret, hash string, err error) {
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", errors.Wrap(err, "generating secret")
+	}
+
+	secret = base64.RawURLEncoding.EncodeToString(b)
+	return secret, HashSecret(secret), nil
+}
+
+// HashSecret returns the value stored in place of a secret. The secrets
+// are random and long so a fast hash is sufficient, unlike for passwords.
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,65 @@
+// Package imaging scales images, for example to create thumbnails.
+package imaging
+
+import (
+	"image"
+	"image/draw"
+)
+
+// Fit scales src down so it fits into a box of width by height pixels while
+// keeping its aspect ratio. Every pixel of the result is the average of the
+// source pixels it covers. Images that already fit are returned unchanged.
+func Fit(src image.Image, width, height int) image.Image {
+
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if sw <= width && sh <= height {
+		return src
+	}
+
+	// Use the smaller of the two scale factors so both sides fit.
+	dw, dh := width, sh*width/sw
+	if dh > height {
+		dw, dh = sw*height/sh, height
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	// Working on RGBA pixels directly is much faster than calling At
+	// for every pixel of a large image.
+	rgba := image.NewRGBA(image.Rect(0, 0, sw, sh))
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, (y+1)*sh/dh
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, (x+1)*sw/dw
+
+			var r, g, bl, a, n int
+			for sy := y0; sy < y1; sy++ {
+				i := rgba.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += int(rgba.Pix[i])
+					g += int(rgba.Pix[i+1])
+					bl += int(rgba.Pix[i+2])
+					a += int(rgba.Pix[i+3])
+					n++
+					i += 4
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(bl / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
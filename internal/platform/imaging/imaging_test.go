@@ -0,0 +1,45 @@
+package imaging_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/sreejeet/garagesale/internal/platform/imaging"
+)
+
+func TestFit(t *testing.T) {
+
+	// Left half black, right half white.
+	src := image.NewGray(image.Rect(0, 0, 400, 100))
+	for y := 0; y < 100; y++ {
+		for x := 200; x < 400; x++ {
+			src.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+
+	tests := []struct {
+		name          string
+		width, height int
+		expW, expH    int
+	}{
+		{"Wide", 100, 100, 100, 25},
+		{"Tall", 1000, 50, 200, 50},
+		{"Fits", 400, 400, 400, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := imaging.Fit(src, tt.width, tt.height)
+			if b := got.Bounds(); b.Dx() != tt.expW || b.Dy() != tt.expH {
+				t.Fatalf("expected %dx%d, got %dx%d", tt.expW, tt.expH, b.Dx(), b.Dy())
+			}
+
+			// Both halves keep their color.
+			left := color.GrayModel.Convert(got.At(got.Bounds().Min.X, 0)).(color.Gray)
+			right := color.GrayModel.Convert(got.At(got.Bounds().Max.X-1, 0)).(color.Gray)
+			if left.Y != 0 || right.Y != 255 {
+				t.Fatalf("expected black and white halves, got %d and %d", left.Y, right.Y)
+			}
+		})
+	}
+}
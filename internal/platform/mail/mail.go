@@ -0,0 +1,44 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email messages. Implementations are expected to be safe for
+// concurrent use because handlers share a single Mailer.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// format renders a message as it is sent over the wire including headers.
+func format(from string, msg Message, now time.Time) []byte {
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", header(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", header(msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(msg.Body)
+
+	return b.Bytes()
+}
+
+// header strips line breaks from a header value so callers can not
+// inject additional headers into a message.
+func header(v string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(v)
+}
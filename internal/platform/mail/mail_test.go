@@ -0,0 +1,50 @@
+package mail_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sreejeet/garagesale/internal/platform/mail"
+)
+
+func TestOutbox(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	o, err := mail.NewOutbox(dir, "sales@example.com")
+	if err != nil {
+		t.Fatalf("creating outbox: %s", err)
+	}
+
+	msgs := []mail.Message{
+		{To: "admin@example.com", Subject: "First", Body: "one"},
+		{To: "user@example.com", Subject: "Second\r\nBcc: evil@example.com", Body: "two"},
+	}
+	for _, m := range msgs {
+		if err := o.Send(context.Background(), m); err != nil {
+			t.Fatalf("sending message: %s", err)
+		}
+	}
+
+	got, err := o.Messages()
+	if err != nil {
+		t.Fatalf("reading messages: %s", err)
+	}
+	if exp := len(msgs); len(got) != exp {
+		t.Fatalf("expected %d messages, got %d", exp, len(got))
+	}
+
+	if !strings.Contains(got[0], "To: admin@example.com\r\n") || !strings.HasSuffix(got[0], "\r\n\r\none") {
+		t.Errorf("unexpected first message:\n%s", got[0])
+	}
+	if strings.Contains(got[1], "\r\nBcc:") {
+		t.Errorf("header injection was not prevented:\n%s", got[1])
+	}
+}
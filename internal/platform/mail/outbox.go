@@ -0,0 +1,83 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Outbox is a Mailer that writes every message into a directory instead of
+// delivering it. It is meant for local development and tests where the
+// messages can be inspected on disk.
+type Outbox struct {
+	dir  string
+	from string
+
+	mu  sync.Mutex
+	seq int
+}
+
+// NewOutbox creates an Outbox writing into dir. The directory is created
+// if it does not exist yet.
+func NewOutbox(dir, from string) (*Outbox, error) {
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "creating outbox directory")
+	}
+
+	o := Outbox{
+		dir:  dir,
+		from: from,
+	}
+	return &o, nil
+}
+
+// Send writes the message to a new .eml file in the outbox directory.
+func (o *Outbox) Send(ctx context.Context, msg Message) error {
+
+	now := time.Now()
+
+	// The sequence number keeps files written in the same instant apart
+	// and lets them sort in the order they were sent.
+	o.mu.Lock()
+	o.seq++
+	name := fmt.Sprintf("%s-%06d.eml", now.UTC().Format("20060102T150405"), o.seq)
+	o.mu.Unlock()
+
+	path := filepath.Join(o.dir, name)
+	if err := ioutil.WriteFile(path, format(o.from, msg, now), 0600); err != nil {
+		return errors.Wrap(err, "writing message to outbox")
+	}
+
+	return nil
+}
+
+// Messages returns the raw contents of every message in the outbox
+// in the order they were sent.
+func (o *Outbox) Messages() ([]string, error) {
+
+	files, err := ioutil.ReadDir(o.dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading outbox directory")
+	}
+
+	var msgs []string
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".eml" {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(o.dir, f.Name()))
+		if err != nil {
+			return nil, errors.Wrap(err, "reading message from outbox")
+		}
+		msgs = append(msgs, string(b))
+	}
+
+	return msgs, nil
+}
@@ -0,0 +1,71 @@
+package mail
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SMTPConfig holds the settings needed to deliver mail through an SMTP server.
+// Authentication is skipped when no username is provided.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// SMTP is a Mailer that delivers messages through an SMTP server.
+type SMTP struct {
+	cfg  SMTPConfig
+	auth smtp.Auth
+}
+
+// NewSMTP creates a Mailer for the SMTP server described by cfg.
+func NewSMTP(cfg SMTPConfig) (*SMTP, error) {
+
+	if cfg.Host == "" {
+		return nil, errors.New("smtp host cannot be blank")
+	}
+	if cfg.From == "" {
+		return nil, errors.New("smtp from address cannot be blank")
+	}
+
+	s := SMTP{
+		cfg: cfg,
+	}
+	if cfg.Username != "" {
+		s.auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+
+	return &s, nil
+}
+
+// Send delivers the message. The net/smtp package does not accept a context
+// so the message is sent in the background and abandoned when ctx is done.
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+	body := format(s.cfg.From, msg, time.Now())
+
+	// The channel is buffered so the goroutine can always finish.
+	errc := make(chan error, 1)
+	go func() {
+		errc <- smtp.SendMail(addr, s.auth, s.cfg.From, []string{msg.To}, body)
+	}()
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			return errors.Wrap(err, "sending mail")
+		}
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "sending mail")
+	}
+}
@@ -0,0 +1,188 @@
+// Package money provides the Money type for amounts of a currency. Amounts
+// are kept as integers in the minor unit of their currency, like cents, so
+// they can be added up without rounding errors.
+package money
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrCurrencyMismatch occurs when combining amounts of different currencies.
+var ErrCurrencyMismatch = errors.New("currencies do not match")
+
+// Money is an amount in the minor unit of an ISO 4217 currency. Amounts
+// sent by clients can not be negative, negative amounts only come from
+// entries reversing earlier ones.
+//
+// In the database Money is stored in columns of the composite type
+// money_value (amount BIGINT, currency CHAR(3)).
+type Money struct {
+	Amount   int64  `json:"amount" validate:"gte=0"`
+	Currency string `json:"currency" validate:"currency"`
+}
+
+// New creates an amount of a currency.
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// Add returns the sum of m and o which must be of the same currency.
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, ErrCurrencyMismatch
+	}
+	return Money{Amount: m.Amount + o.Amount, Currency: m.Currency}, nil
+}
+
+// Sub returns m minus o which must be of the same currency.
+func (m Money) Sub(o Money) (Money, error) {
+	return m.Add(o.Neg())
+}
+
+// Cmp compares m and o which must be of the same currency. The result is
+// -1 when m is less than o, 0 when they are equal and 1 otherwise.
+func (m Money) Cmp(o Money) (int, error) {
+	if m.Currency != o.Currency {
+		return 0, ErrCurrencyMismatch
+	}
+	switch {
+	case m.Amount < o.Amount:
+		return -1, nil
+	case m.Amount > o.Amount:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Neg returns the amount with the opposite sign.
+func (m Money) Neg() Money {
+	return Money{Amount: -m.Amount, Currency: m.Currency}
+}
+
+// IsZero reports whether the amount is zero regardless of its currency.
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// String formats the amount in major units followed by the currency,
+// like "12.50 USD".
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+// Decimal formats the amount in major units without the currency, like
+// "12.50" for 1250 cents.
+func (m Money) Decimal() string {
+
+	digits := Digits(m.Currency)
+	if digits == 0 {
+		return strconv.FormatInt(m.Amount, 10)
+	}
+
+	sign, amount := "", m.Amount
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+
+	s := strconv.FormatInt(amount, 10)
+	if len(s) <= digits {
+		s = strings.Repeat("0", digits-len(s)+1) + s
+	}
+	return sign + s[:len(s)-digits] + "." + s[len(s)-digits:]
+}
+
+// Parse reads an amount written in major units, like "12.50", as Money of
+// the currency. The amount may have at most as many decimals as the minor
+// unit of the currency has digits.
+func Parse(amount, currency string) (Money, error) {
+
+	if !Valid(currency) {
+		return Money{}, errors.Errorf("invalid currency %q", currency)
+	}
+
+	s := strings.TrimSpace(amount)
+	sign := int64(1)
+	if strings.HasPrefix(s, "-") {
+		sign, s = -1, s[1:]
+	}
+
+	whole, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, frac = s[:i], s[i+1:]
+	}
+	digits := Digits(currency)
+	if whole == "" || len(frac) > digits || strings.ContainsAny(whole+frac, "+-") {
+		return Money{}, errors.Errorf("invalid amount %q", amount)
+	}
+	frac += strings.Repeat("0", digits-len(frac))
+
+	minor, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return Money{}, errors.Errorf("invalid amount %q", amount)
+	}
+
+	return Money{Amount: sign * minor, Currency: currency}, nil
+}
+
+// Value implements the driver.Valuer interface by encoding the amount as a
+// money_value row.
+func (m Money) Value() (driver.Value, error) {
+	if !Valid(m.Currency) {
+		return nil, errors.Errorf("invalid currency %q", m.Currency)
+	}
+	return fmt.Sprintf("(%d,%s)", m.Amount, m.Currency), nil
+}
+
+// Scan implements the sql.Scanner interface for money_value rows,
+// which the database sends as text like "(1250,USD)".
+func (m *Money) Scan(src interface{}) error {
+
+	var s string
+	switch v := src.(type) {
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	default:
+		return errors.Errorf("scanning money: unexpected type %T", src)
+	}
+
+	fields := strings.Split(strings.TrimSuffix(strings.TrimPrefix(s, "("), ")"), ",")
+	if len(fields) != 2 {
+		return errors.Errorf("scanning money: invalid value %q", s)
+	}
+	amount, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return errors.Errorf("scanning money: invalid amount %q", s)
+	}
+
+	m.Amount = amount
+	m.Currency = strings.TrimSpace(fields[1])
+	return nil
+}
+
+// Sum adds up amounts of any currency. It returns one total per currency
+// ordered by currency code and no totals at all when ms is empty.
+func Sum(ms ...Money) []Money {
+
+	totals := make(map[string]int64)
+	for _, m := range ms {
+		totals[m.Currency] += m.Amount
+	}
+
+	sums := make([]Money, 0, len(totals))
+	for c, a := range totals {
+		sums = append(sums, Money{Amount: a, Currency: c})
+	}
+	sort.Slice(sums, func(i, j int) bool {
+		return sums[i].Currency < sums[j].Currency
+	})
+
+	return sums
+}
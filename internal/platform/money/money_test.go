@@ -0,0 +1,123 @@
+package money_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sreejeet/garagesale/internal/platform/money"
+)
+
+func TestArithmetic(t *testing.T) {
+
+	sum, err := money.New(1250, "USD").Add(money.New(50, "USD"))
+	if err != nil {
+		t.Fatalf("adding: %s", err)
+	}
+	if sum != money.New(1300, "USD") {
+		t.Fatalf("expected 1300 USD, got %v", sum)
+	}
+
+	if _, err := money.New(1250, "USD").Add(money.New(50, "INR")); err != money.ErrCurrencyMismatch {
+		t.Fatalf("adding currencies: expected %v, got %v", money.ErrCurrencyMismatch, err)
+	}
+	if _, err := money.New(1250, "USD").Sub(money.New(50, "INR")); err != money.ErrCurrencyMismatch {
+		t.Fatalf("subtracting currencies: expected %v, got %v", money.ErrCurrencyMismatch, err)
+	}
+	if _, err := money.New(1250, "USD").Cmp(money.New(50, "INR")); err != money.ErrCurrencyMismatch {
+		t.Fatalf("comparing currencies: expected %v, got %v", money.ErrCurrencyMismatch, err)
+	}
+
+	if c, err := money.New(1250, "USD").Cmp(money.New(1300, "USD")); err != nil || c != -1 {
+		t.Fatalf("comparing: expected -1, got %d %v", c, err)
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		m    money.Money
+		want string
+	}{
+		{money.New(1250, "USD"), "12.50 USD"},
+		{money.New(5, "EUR"), "0.05 EUR"},
+		{money.New(-1250, "INR"), "-12.50 INR"},
+		{money.New(1250, "JPY"), "1250 JPY"},
+		{money.New(1250, "KWD"), "1.250 KWD"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.String(); got != tt.want {
+			t.Errorf("expected %q, got %q", tt.want, got)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		amount   string
+		currency string
+		want     money.Money
+		valid    bool
+	}{
+		{"12.50", "USD", money.New(1250, "USD"), true},
+		{"12.5", "USD", money.New(1250, "USD"), true},
+		{"12", "USD", money.New(1200, "USD"), true},
+		{"-0.05", "EUR", money.New(-5, "EUR"), true},
+		{"1250", "JPY", money.New(1250, "JPY"), true},
+		{"12.505", "USD", money.Money{}, false},
+		{"12.5", "JPY", money.Money{}, false},
+		{"twelve", "USD", money.Money{}, false},
+		{".50", "USD", money.Money{}, false},
+		{"1-2", "USD", money.Money{}, false},
+		{"12.50", "XYZ", money.Money{}, false},
+	}
+	for _, tt := range tests {
+		got, err := money.Parse(tt.amount, tt.currency)
+		if tt.valid && (err != nil || got != tt.want) {
+			t.Errorf("parsing %q %s: expected %v, got %v %v", tt.amount, tt.currency, tt.want, got, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("parsing %q %s should fail, got %v", tt.amount, tt.currency, got)
+		}
+	}
+}
+
+func TestSQL(t *testing.T) {
+
+	v, err := money.New(-1250, "INR").Value()
+	if err != nil {
+		t.Fatalf("encoding: %s", err)
+	}
+	if v != "(-1250,INR)" {
+		t.Fatalf("expected (-1250,INR), got %v", v)
+	}
+
+	var m money.Money
+	if err := m.Scan([]byte("(-1250,INR)")); err != nil {
+		t.Fatalf("scanning: %s", err)
+	}
+	if m != money.New(-1250, "INR") {
+		t.Fatalf("expected -1250 INR, got %v", m)
+	}
+
+	if _, err := money.New(1, "usd").Value(); err == nil {
+		t.Fatal("encoding an invalid currency should fail")
+	}
+	if err := m.Scan([]byte("(12.50,USD)")); err == nil {
+		t.Fatal("scanning a fractional amount should fail")
+	}
+}
+
+func TestSum(t *testing.T) {
+	got := money.Sum(
+		money.New(100, "USD"),
+		money.New(5000, "INR"),
+		money.New(250, "USD"),
+	)
+	want := []money.Money{money.New(5000, "INR"), money.New(350, "USD")}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected totals:\n%s", diff)
+	}
+
+	if got := money.Sum(); len(got) != 0 {
+		t.Fatalf("expected no totals, got %v", got)
+	}
+}
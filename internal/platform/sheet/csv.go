@@ -0,0 +1,59 @@
+package sheet
+
+import (
+	"encoding/csv"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// csvWriter writes a table as CSV starting with a header of the column
+// names. Missing values are left empty and strings that look like formulas
+// are defused.
+type csvWriter struct {
+	w       *csv.Writer
+	columns int
+	record  []string
+}
+
+func newCSV(w io.Writer, columns []string) (*csvWriter, error) {
+	cw := csvWriter{
+		w:       csv.NewWriter(w),
+		columns: len(columns),
+		record:  make([]string, len(columns)),
+	}
+	if err := cw.w.Write(columns); err != nil {
+		return nil, errors.Wrap(err, "writing csv header")
+	}
+	return &cw, nil
+}
+
+// Write writes a row as a record.
+func (cw *csvWriter) Write(values ...interface{}) error {
+
+	if err := checkRow(values, cw.columns); err != nil {
+		return err
+	}
+
+	for i, v := range values {
+		text, kind, err := cell(v)
+		if err != nil {
+			return err
+		}
+		if kind == kindString {
+			text = defuse(text)
+		}
+		cw.record[i] = text
+	}
+
+	if err := cw.w.Write(cw.record); err != nil {
+		return errors.Wrap(err, "writing csv record")
+	}
+	return nil
+}
+
+// Close flushes the records still buffered.
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	return errors.Wrap(cw.w.Error(), "flushing csv")
+}
@@ -0,0 +1,83 @@
+package sheet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// ndjsonWriter writes a table as one JSON object per line. Objects are
+// keyed by the column names in the order of the columns and missing values
+// are null.
+type ndjsonWriter struct {
+	w    *bufio.Writer
+	keys [][]byte
+	line []byte
+	str  bytes.Buffer
+	enc  *json.Encoder
+}
+
+func newNDJSON(w io.Writer, columns []string) *ndjsonWriter {
+	nw := ndjsonWriter{
+		w:    bufio.NewWriter(w),
+		keys: make([][]byte, len(columns)),
+	}
+
+	// Values are data rather than markup so they are not HTML escaped.
+	nw.enc = json.NewEncoder(&nw.str)
+	nw.enc.SetEscapeHTML(false)
+
+	// Marshaling a string never fails.
+	for i, c := range columns {
+		nw.keys[i], _ = json.Marshal(c)
+	}
+	return &nw
+}
+
+// Write writes a row as a JSON object on its own line.
+func (nw *ndjsonWriter) Write(values ...interface{}) error {
+
+	if err := checkRow(values, len(nw.keys)); err != nil {
+		return err
+	}
+
+	line := append(nw.line[:0], '{')
+	for i, v := range values {
+		text, kind, err := cell(v)
+		if err != nil {
+			return err
+		}
+
+		if i > 0 {
+			line = append(line, ',')
+		}
+		line = append(line, nw.keys[i]...)
+		line = append(line, ':')
+
+		switch kind {
+		case kindNull:
+			line = append(line, "null"...)
+		case kindString:
+			nw.str.Reset()
+			if err := nw.enc.Encode(text); err != nil {
+				return errors.Wrap(err, "marshaling value")
+			}
+			line = append(line, bytes.TrimSuffix(nw.str.Bytes(), []byte("\n"))...)
+		default:
+			line = append(line, text...)
+		}
+	}
+	line = append(line, '}', '\n')
+	nw.line = line
+
+	_, err := nw.w.Write(line)
+	return errors.Wrap(err, "writing ndjson line")
+}
+
+// Close flushes the lines still buffered.
+func (nw *ndjsonWriter) Close() error {
+	return errors.Wrap(nw.w.Flush(), "flushing ndjson")
+}
@@ -0,0 +1,136 @@
+// Package sheet writes tables of rows as CSV, NDJSON or XLSX files. Rows are
+// written out as they come so tables of any size can be streamed without
+// holding them in memory.
+package sheet
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// These are the formats a table can be written in.
+const (
+	CSV    = "csv"
+	NDJSON = "ndjson"
+	XLSX   = "xlsx"
+)
+
+// MaxXLSXRows is the most rows a sheet of an XLSX file can have, counting
+// the header.
+const MaxXLSXRows = 1048576
+
+var (
+	// ErrFormat is returned when asking for an unknown format.
+	ErrFormat = errors.New("format must be csv, ndjson or xlsx")
+
+	// ErrTooManyRows is returned when writing more rows than an XLSX sheet
+	// can hold.
+	ErrTooManyRows = errors.Errorf("xlsx sheets are limited to %d rows", MaxXLSXRows)
+)
+
+// contentTypes holds the media type of every format.
+var contentTypes = map[string]string{
+	CSV:    "text/csv; charset=utf-8",
+	NDJSON: "application/x-ndjson",
+	XLSX:   "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// Writer writes the rows of a table. Every row has one value per column of
+// the table. Values are strings, ints, int64s, json.Numbers, bools,
+// time.Times or pointers to them, and nil for missing values. Close must be
+// called once all rows are written to complete the file.
+type Writer interface {
+	Write(values ...interface{}) error
+	Close() error
+}
+
+// New creates a Writer for a table named name with the given columns in
+// format. The name is used as the title of the sheet of XLSX files.
+func New(w io.Writer, format, name string, columns []string) (Writer, error) {
+	switch format {
+	case CSV:
+		return newCSV(w, columns)
+	case NDJSON:
+		return newNDJSON(w, columns), nil
+	case XLSX:
+		return newXLSX(w, name, columns)
+	}
+	return nil, ErrFormat
+}
+
+// ContentType returns the media type of files in format.
+func ContentType(format string) string {
+	return contentTypes[format]
+}
+
+// Valid reports whether format is a known format.
+func Valid(format string) bool {
+	_, ok := contentTypes[format]
+	return ok
+}
+
+// These are the kinds of cells values are turned into.
+const (
+	kindNull = iota
+	kindString
+	kindNumber
+	kindBool
+)
+
+// cell turns a value of a row into its text and kind. Numbers are written
+// the way JSON writes them and times in RFC 3339 in UTC.
+func cell(v interface{}) (string, int, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", kindNull, nil
+	case string:
+		return v, kindString, nil
+	case *string:
+		if v == nil {
+			return "", kindNull, nil
+		}
+		return *v, kindString, nil
+	case int:
+		return strconv.Itoa(v), kindNumber, nil
+	case int64:
+		return strconv.FormatInt(v, 10), kindNumber, nil
+	case json.Number:
+		if _, err := strconv.ParseFloat(string(v), 64); err != nil {
+			return "", 0, errors.Errorf("invalid number %q", v)
+		}
+		return string(v), kindNumber, nil
+	case bool:
+		return strconv.FormatBool(v), kindBool, nil
+	case time.Time:
+		return v.UTC().Format(time.RFC3339), kindString, nil
+	case *time.Time:
+		if v == nil {
+			return "", kindNull, nil
+		}
+		return v.UTC().Format(time.RFC3339), kindString, nil
+	}
+	return "", 0, errors.Errorf("unsupported value of type %T", v)
+}
+
+// defuse keeps spreadsheet applications from running text of a CSV field as
+// a formula by prefixing text that starts like one with a quote. XLSX cells
+// need no such care as inline strings are never evaluated.
+func defuse(text string) string {
+	if text != "" && strings.ContainsRune("=+-@\t\r", rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
+
+// checkRow makes sure a row has one value per column.
+func checkRow(values []interface{}, columns int) error {
+	if len(values) != columns {
+		return errors.Errorf("row has %d values for %d columns", len(values), columns)
+	}
+	return nil
+}
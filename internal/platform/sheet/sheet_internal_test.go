@@ -0,0 +1,56 @@
+package sheet
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestColumnRef(t *testing.T) {
+	tests := map[int]string{0: "A", 25: "Z", 26: "AA", 51: "AZ", 52: "BA", 701: "ZZ", 702: "AAA"}
+	for i, want := range tests {
+		if got := columnRef(i); got != want {
+			t.Errorf("column %d: expected %q, got %q", i, want, got)
+		}
+	}
+}
+
+func TestSheetName(t *testing.T) {
+	tests := map[string]string{
+		"Sales":         "Sales",
+		"Sales 2019/01": "Sales 2019_01",
+		"":              "Sheet1",
+		"Products of the organization in January": "Products of the organization in",
+	}
+	for name, want := range tests {
+		if got := sheetName(name); got != want {
+			t.Errorf("sheet %q: expected %q, got %q", name, want, got)
+		}
+	}
+}
+
+func TestXLSXLimits(t *testing.T) {
+	xw, err := newXLSX(ioutil.Discard, "Products", []string{"name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Inline strings are never evaluated so they are written as they are.
+	if err := xw.Write("-10% sale"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(xw.scratch), ">-10% sale<") {
+		t.Fatalf("expected the text unchanged, got %s", xw.scratch)
+	}
+
+	xw.row = MaxXLSXRows - 1
+	if err := xw.Write("Lamp"); err != nil {
+		t.Fatalf("writing the last row: %s", err)
+	}
+	if !strings.Contains(string(xw.scratch), `r="1048576"`) {
+		t.Fatalf("expected the last row to be 1048576, got %s", xw.scratch)
+	}
+	if err := xw.Write("Rug"); err != ErrTooManyRows {
+		t.Fatalf("expected %v, got %v", ErrTooManyRows, err)
+	}
+}
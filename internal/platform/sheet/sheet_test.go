@@ -0,0 +1,160 @@
+package sheet_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sreejeet/garagesale/internal/platform/sheet"
+)
+
+var (
+	columns = []string{"name", "cost", "quantity", "deleted", "date_created", "category_id"}
+	created = time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	rows    = [][]interface{}{
+		{"Lamp, large", json.Number("12.50"), 3, false, created, nil},
+		{`"Rug" & <Vase>`, json.Number("0.05"), int64(1), true, &created, (*string)(nil)},
+	}
+)
+
+// write writes the test table in format.
+func write(t *testing.T, format string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, err := sheet.New(&buf, format, "Products", columns)
+	if err != nil {
+		t.Fatalf("creating %s writer: %s", format, err)
+	}
+	for _, row := range rows {
+		if err := w.Write(row...); err != nil {
+			t.Fatalf("writing %s row: %s", format, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("closing %s writer: %s", format, err)
+	}
+	return buf.Bytes()
+}
+
+func TestCSV(t *testing.T) {
+	want := "name,cost,quantity,deleted,date_created,category_id\n" +
+		`"Lamp, large",12.50,3,false,2020-01-01T12:00:00Z,` + "\n" +
+		`"""Rug"" & <Vase>",0.05,1,true,2020-01-01T12:00:00Z,` + "\n"
+	if diff := cmp.Diff(want, string(write(t, sheet.CSV))); diff != "" {
+		t.Fatalf("unexpected csv:\n%s", diff)
+	}
+}
+
+func TestFormulas(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := sheet.New(&buf, sheet.CSV, "Products", []string{"a", "b", "c", "d", "e", "f"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write("=1+1", "+1", "-1", "@SUM(A1)", "\tx", json.Number("-5")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Strings are defused, numbers keep their sign.
+	want := "a,b,c,d,e,f\n'=1+1,'+1,'-1,'@SUM(A1),'\tx,-5\n"
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Fatalf("unexpected csv:\n%s", diff)
+	}
+}
+
+func TestNDJSON(t *testing.T) {
+	want := `{"name":"Lamp, large","cost":12.50,"quantity":3,"deleted":false,"date_created":"2020-01-01T12:00:00Z","category_id":null}` + "\n" +
+		`{"name":"\"Rug\" & <Vase>","cost":0.05,"quantity":1,"deleted":true,"date_created":"2020-01-01T12:00:00Z","category_id":null}` + "\n"
+	if diff := cmp.Diff(want, string(write(t, sheet.NDJSON))); diff != "" {
+		t.Fatalf("unexpected ndjson:\n%s", diff)
+	}
+}
+
+func TestXLSX(t *testing.T) {
+	data := write(t, sheet.XLSX)
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("reading workbook: %s", err)
+	}
+
+	parts := map[string][]byte{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("opening %s: %s", f.Name, err)
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("reading %s: %s", f.Name, err)
+		}
+		parts[f.Name] = b
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels"} {
+		if _, ok := parts[name]; !ok {
+			t.Fatalf("workbook misses %s", name)
+		}
+	}
+
+	var sheetData struct {
+		Rows []struct {
+			Ref   string `xml:"r,attr"`
+			Cells []struct {
+				Ref    string `xml:"r,attr"`
+				Type   string `xml:"t,attr"`
+				Value  string `xml:"v"`
+				Inline string `xml:"is>t"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := xml.Unmarshal(parts["xl/worksheets/sheet1.xml"], &sheetData); err != nil {
+		t.Fatalf("decoding worksheet: %s", err)
+	}
+	if exp, got := 3, len(sheetData.Rows); exp != got {
+		t.Fatalf("expected %d rows, got %d", exp, got)
+	}
+
+	// Missing values are left out so the second row has one cell less.
+	row := sheetData.Rows[2]
+	if exp, got := len(columns)-1, len(row.Cells); exp != got {
+		t.Fatalf("expected %d cells, got %d", exp, got)
+	}
+	name, cost, deleted := row.Cells[0], row.Cells[1], row.Cells[3]
+	if name.Ref != "A3" || name.Type != "inlineStr" || name.Inline != `"Rug" & <Vase>` {
+		t.Fatalf("unexpected name cell %+v", name)
+	}
+	if cost.Ref != "B3" || cost.Type != "" || cost.Value != "0.05" {
+		t.Fatalf("unexpected cost cell %+v", cost)
+	}
+	if deleted.Ref != "D3" || deleted.Type != "b" || deleted.Value != "1" {
+		t.Fatalf("unexpected deleted cell %+v", deleted)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := sheet.New(&buf, "pdf", "Products", columns); err != sheet.ErrFormat {
+		t.Fatalf("expected %v, got %v", sheet.ErrFormat, err)
+	}
+
+	w, err := sheet.New(&buf, sheet.CSV, "Products", columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write("Lamp"); err == nil {
+		t.Fatal("expected an error writing a row with too few values")
+	}
+	if err := w.Write("Lamp", 1.5, 1, false, created, nil); err == nil {
+		t.Fatal("expected an error writing a float")
+	}
+}
@@ -0,0 +1,204 @@
+package sheet
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The parts of an XLSX file besides the worksheet. A workbook with a single
+// sheet needs nothing more: styles and shared strings are optional and
+// every string is written inline.
+const (
+	xlsxContentTypes = xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`
+
+	xlsxRels = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`
+
+	xlsxWorkbookRels = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`
+
+	// xlsxWorkbook needs the escaped name of the sheet.
+	xlsxWorkbook = xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets>` +
+		`</workbook>`
+
+	xlsxSheetStart = xml.Header + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	xlsxSheetEnd   = `</sheetData></worksheet>`
+)
+
+// maxSheetName is the longest name spreadsheet applications accept for a sheet.
+const maxSheetName = 31
+
+// xlsxWriter writes a table as the only sheet of an XLSX workbook. The
+// workbook is a zip archive which is written front to back, with the
+// worksheet as its last part, so rows go out as soon as they are written.
+// The first row holds the column names. Missing values are left out.
+type xlsxWriter struct {
+	zw      *zip.Writer
+	w       *bufio.Writer
+	refs    []string
+	row     int
+	scratch []byte
+}
+
+func newXLSX(w io.Writer, name string, columns []string) (*xlsxWriter, error) {
+
+	xw := xlsxWriter{
+		zw:   zip.NewWriter(w),
+		refs: make([]string, len(columns)),
+	}
+	for i := range columns {
+		xw.refs[i] = columnRef(i)
+	}
+
+	parts := []struct {
+		name string
+		data string
+	}{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/workbook.xml", fmt.Sprintf(xlsxWorkbook, escape(sheetName(name)))},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+	}
+	for _, p := range parts {
+		f, err := xw.zw.Create(p.name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "creating %s", p.name)
+		}
+		if _, err := io.WriteString(f, p.data); err != nil {
+			return nil, errors.Wrapf(err, "writing %s", p.name)
+		}
+	}
+
+	f, err := xw.zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, errors.Wrap(err, "creating worksheet")
+	}
+	xw.w = bufio.NewWriter(f)
+	if _, err := xw.w.WriteString(xlsxSheetStart); err != nil {
+		return nil, errors.Wrap(err, "writing worksheet")
+	}
+
+	header := make([]interface{}, len(columns))
+	for i, c := range columns {
+		header[i] = c
+	}
+	if err := xw.Write(header...); err != nil {
+		return nil, err
+	}
+
+	return &xw, nil
+}
+
+// Write writes a row of the worksheet. Numbers and bools become cells of
+// their own type so spreadsheets can calculate with them. It fails with
+// ErrTooManyRows once the sheet is full.
+func (xw *xlsxWriter) Write(values ...interface{}) error {
+
+	if err := checkRow(values, len(xw.refs)); err != nil {
+		return err
+	}
+	if xw.row >= MaxXLSXRows {
+		return ErrTooManyRows
+	}
+
+	xw.row++
+	row := strconv.Itoa(xw.row)
+
+	b := append(xw.scratch[:0], `<row r="`...)
+	b = append(b, row...)
+	b = append(b, `">`...)
+	for i, v := range values {
+		text, kind, err := cell(v)
+		if err != nil {
+			return err
+		}
+
+		ref := xw.refs[i] + row
+		switch kind {
+		case kindNull:
+			continue
+		case kindString:
+			b = append(b, `<c r="`+ref+`" t="inlineStr"><is><t xml:space="preserve">`...)
+			b = append(b, escape(text)...)
+			b = append(b, `</t></is></c>`...)
+		case kindNumber:
+			b = append(b, `<c r="`+ref+`"><v>`+text+`</v></c>`...)
+		case kindBool:
+			v := "0"
+			if text == "true" {
+				v = "1"
+			}
+			b = append(b, `<c r="`+ref+`" t="b"><v>`+v+`</v></c>`...)
+		}
+	}
+	b = append(b, `</row>`...)
+	xw.scratch = b
+
+	_, err := xw.w.Write(b)
+	return errors.Wrap(err, "writing worksheet row")
+}
+
+// Close ends the worksheet and writes the directory of the zip archive.
+func (xw *xlsxWriter) Close() error {
+	if _, err := xw.w.WriteString(xlsxSheetEnd); err != nil {
+		return errors.Wrap(err, "writing worksheet")
+	}
+	if err := xw.w.Flush(); err != nil {
+		return errors.Wrap(err, "flushing worksheet")
+	}
+	return errors.Wrap(xw.zw.Close(), "closing workbook")
+}
+
+// columnRef returns the letters naming the column at index i: A to Z, then
+// AA to AZ and so on.
+func columnRef(i int) string {
+	var ref []byte
+	for i++; i > 0; i = (i - 1) / 26 {
+		ref = append([]byte{byte('A' + (i-1)%26)}, ref...)
+	}
+	return string(ref)
+}
+
+// sheetName turns name into a name spreadsheet applications accept. Names
+// are at most 31 characters long and can not hold any of : \ / ? * [ ].
+func sheetName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`:\/?*[]`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if r := []rune(name); len(r) > maxSheetName {
+		name = string(r[:maxSheetName])
+	}
+	if name == "" {
+		name = "Sheet1"
+	}
+	return name
+}
+
+// escape escapes text for use in XML. Characters XML can not hold are
+// replaced by the Unicode replacement character.
+func escape(text string) string {
+	var sb strings.Builder
+
+	// Writing to a strings.Builder never fails.
+	xml.EscapeText(&sb, []byte(text))
+	return sb.String()
+}
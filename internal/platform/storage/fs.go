@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// FS is a BlobStore keeping objects as files below a directory. It is meant
+// for single instance deployments, local development and tests.
+type FS struct {
+	dir string
+}
+
+// NewFS creates an FS storing objects in dir. The directory is created if it
+// does not exist yet.
+func NewFS(dir string) (*FS, error) {
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "creating storage directory")
+	}
+
+	return &FS{dir: dir}, nil
+}
+
+// path returns the file an object is stored in.
+func (fs *FS) path(key string) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(fs.dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes the object to its file. The data is written to a temporary
+// file first so readers never see a partially written object. The content
+// type is not kept as callers know what they stored.
+func (fs *FS) Put(ctx context.Context, key string, data []byte, contentType string) error {
+
+	path, err := fs.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errors.Wrapf(err, "creating directory for %s", key)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return errors.Wrapf(err, "creating file for %s", key)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrapf(err, "writing %s", key)
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrapf(err, "writing %s", key)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return errors.Wrapf(err, "saving %s", key)
+	}
+
+	return nil
+}
+
+// Get opens the file of an object.
+func (fs *FS) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+
+	path, err := fs.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "opening %s", key)
+	}
+
+	return f, nil
+}
+
+// Delete removes the file of an object.
+func (fs *FS) Delete(ctx context.Context, key string) error {
+
+	path, err := fs.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "deleting %s", key)
+	}
+
+	return nil
+}
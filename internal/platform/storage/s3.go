@@ -0,0 +1,279 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// S3Config holds the settings needed to store objects in a bucket of an S3
+// compatible service such as AWS S3 or MinIO. Endpoint is the base URL of
+// the service, like https://s3.eu-west-1.amazonaws.com or
+// http://localhost:9000. Buckets are addressed in the path of requests.
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+}
+
+// S3 is a BlobStore keeping objects in an S3 compatible bucket. Requests are
+// signed with AWS Signature Version 4.
+type S3 struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// NewS3 creates a BlobStore for the bucket described by cfg.
+func NewS3(cfg S3Config) (*S3, error) {
+
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3 bucket cannot be blank")
+	}
+	if cfg.Region == "" {
+		return nil, errors.New("s3 region cannot be blank")
+	}
+
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, errors.Errorf("invalid s3 endpoint %q", cfg.Endpoint)
+	}
+
+	s := S3{
+		cfg:      cfg,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: time.Minute},
+	}
+	return &s, nil
+}
+
+// Put uploads the object.
+func (s *S3) Put(ctx context.Context, key string, data []byte, contentType string) error {
+
+	req, err := s.request(ctx, http.MethodPut, key, data)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := s.do(req, data)
+	if err != nil {
+		return errors.Wrapf(err, "uploading %s", key)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Wrapf(responseError(resp), "uploading %s", key)
+	}
+
+	return nil
+}
+
+// Get downloads the object. The caller must close the returned body.
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+
+	req, err := s.request(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.do(req, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "downloading %s", key)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+
+	defer resp.Body.Close()
+	return nil, errors.Wrapf(responseError(resp), "downloading %s", key)
+}
+
+// Delete removes the object.
+func (s *S3) Delete(ctx context.Context, key string) error {
+
+	req, err := s.request(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(req, nil)
+	if err != nil {
+		return errors.Wrapf(err, "deleting %s", key)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	}
+
+	return errors.Wrapf(responseError(resp), "deleting %s", key)
+}
+
+// request builds an unsigned request for an object of the bucket.
+func (s *S3) request(ctx context.Context, method, key string, body []byte) (*http.Request, error) {
+
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.cfg.Bucket + "/" + key
+	u.RawPath = encodePath(u.Path)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrapf(err, "creating request for %s", key)
+	}
+
+	return req.WithContext(ctx), nil
+}
+
+// do signs and sends a request.
+func (s *S3) do(req *http.Request, body []byte) (*http.Response, error) {
+
+	sum := sha256.Sum256(body)
+	s.sign(req, hex.EncodeToString(sum[:]), time.Now())
+
+	return s.client.Do(req)
+}
+
+// sign adds the AWS Signature Version 4 headers to a request. Every header
+// already set on the request is signed along with the host.
+func (s *S3) sign(req *http.Request, payloadHash string, now time.Time) {
+
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", k, headers[k])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + s.cfg.Region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), day)
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s,SignedHeaders=%s,Signature=%s",
+		s.cfg.AccessKey, scope, signedHeaders, signature,
+	))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data using key.
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes query parameters sorted by name as required
+// for signing.
+func canonicalQuery(v url.Values) string {
+
+	names := make([]string, 0, len(v))
+	for k := range v {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var pairs []string
+	for _, k := range names {
+		values := append([]string{}, v[k]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, encode(k, true)+"="+encode(value, true))
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+// encodePath percent encodes a path for signing, keeping the slashes.
+func encodePath(path string) string {
+	return encode(path, false)
+}
+
+// encode percent encodes every byte of s except the unreserved characters
+// of RFC 3986. Slashes are encoded too when slash is set.
+func encode(s string, slash bool) string {
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !slash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+// responseError turns an unexpected response into an error holding
+// the start of the body, which describes the problem.
+func responseError(resp *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	return errors.Errorf("unexpected status %s: %s", resp.Status, bytes.TrimSpace(body))
+}
@@ -0,0 +1,56 @@
+// Package storage keeps binary objects, like product images, outside of the
+// database. Objects are addressed by keys that look like relative paths.
+package storage
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is returned when reading an object that does not exist.
+var ErrNotFound = errors.New("object not found")
+
+// BlobStore saves and loads objects by key. Implementations are expected to
+// be safe for concurrent use because handlers share a single BlobStore.
+// Deleting an object that does not exist is not an error.
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// checkKey makes sure a key is a relative slash separated path without
+// empty, "." or ".." elements so it can not escape the store.
+func checkKey(key string) error {
+	if key == "" {
+		return errors.New("object key cannot be blank")
+	}
+	for _, elem := range strings.Split(key, "/") {
+		if elem == "" || elem == "." || elem == ".." || strings.Contains(elem, `\`) {
+			return errors.Errorf("invalid object key %q", key)
+		}
+	}
+	return nil
+}
+
+// Config selects the BlobStore to use. Kind is "fs" to keep objects in the
+// directory Dir or "s3" to keep them in the bucket described by S3.
+type Config struct {
+	Kind string
+	Dir  string
+	S3   S3Config
+}
+
+// Open creates the BlobStore described by cfg.
+func Open(cfg Config) (BlobStore, error) {
+	switch cfg.Kind {
+	case "fs":
+		return NewFS(cfg.Dir)
+	case "s3":
+		return NewS3(cfg.S3)
+	}
+	return nil, errors.Errorf("unknown storage kind %q", cfg.Kind)
+}
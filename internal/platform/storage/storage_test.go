@@ -0,0 +1,126 @@
+package storage_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sreejeet/garagesale/internal/platform/storage"
+)
+
+func TestFS(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs, err := storage.NewFS(dir)
+	if err != nil {
+		t.Fatalf("creating store: %s", err)
+	}
+
+	testStore(t, fs)
+}
+
+func TestS3(t *testing.T) {
+
+	// The stand-in keeps objects in memory and only accepts signed
+	// requests whose payload matches the signed hash.
+	var mu sync.Mutex
+	objects := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=minio/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		sum := sha256.Sum256(body)
+		if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.Method {
+		case http.MethodPut:
+			objects[r.URL.Path] = body
+		case http.MethodGet:
+			data, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(data)
+		case http.MethodDelete:
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	s3, err := storage.NewS3(storage.S3Config{
+		Endpoint:  srv.URL,
+		Region:    "us-east-1",
+		Bucket:    "images",
+		AccessKey: "minio",
+		SecretKey: "minio-secret",
+	})
+	if err != nil {
+		t.Fatalf("creating store: %s", err)
+	}
+
+	testStore(t, s3)
+
+	if _, ok := objects["/images/products/1/photo.png"]; ok {
+		t.Fatal("expected object to be deleted from the bucket")
+	}
+}
+
+// testStore runs the same checks against every BlobStore.
+func testStore(t *testing.T, store storage.BlobStore) {
+	t.Helper()
+
+	ctx := context.Background()
+	const key = "products/1/photo.png"
+
+	if err := store.Put(ctx, key, []byte("picture"), "image/png"); err != nil {
+		t.Fatalf("putting object: %s", err)
+	}
+
+	r, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("getting object: %s", err)
+	}
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil || string(data) != "picture" {
+		t.Fatalf("expected stored data, got %q, %v", data, err)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("deleting object: %s", err)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("deleting missing object: %s", err)
+	}
+	if _, err := store.Get(ctx, key); err != storage.ErrNotFound {
+		t.Fatalf("expected %v after delete, got %v", storage.ErrNotFound, err)
+	}
+
+	for _, bad := range []string{"", "../secret", "a//b", "/abs"} {
+		if err := store.Put(ctx, bad, nil, "text/plain"); err == nil {
+			t.Fatalf("expected key %q to be rejected", bad)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package web
+
+import (
+	"fmt"
+
+	ut "github.com/go-playground/universal-translator"
+	"github.com/sreejeet/garagesale/internal/platform/money"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+// validateCurrency implements the "currency" validation tag.
+func validateCurrency(fl validator.FieldLevel) bool {
+	return money.Valid(fl.Field().String())
+}
+
+// registerCurrencyValidation adds the "currency" tag and its english
+// error message to the validator.
+func registerCurrencyValidation(lang ut.Translator) {
+
+	validate.RegisterValidation("currency", validateCurrency)
+
+	register := func(ut ut.Translator) error {
+		return nil
+	}
+	translate := func(ut ut.Translator, fe validator.FieldError) string {
+		return fmt.Sprintf("%s must be an upper case ISO 4217 currency code", fe.Field())
+	}
+	validate.RegisterTranslation("currency", lang, register, translate)
+}
@@ -0,0 +1,92 @@
+package web
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// These are the paging defaults used when a client does not ask for
+// a specific page or asks for more items than we are willing to return.
+const (
+	DefaultLimit = 50
+	MaxLimit     = 500
+)
+
+// Page is the envelope returned by handlers that respond with a paginated list.
+// Next and Prev are links to the neighbouring pages and are left blank when
+// no such page exists.
+type Page struct {
+	Items interface{} `json:"items"`
+	Total int         `json:"total"`
+	Page  int         `json:"page"`
+	Limit int         `json:"limit"`
+	Next  string      `json:"next,omitempty"`
+	Prev  string      `json:"prev,omitempty"`
+}
+
+// ParsePaging reads the page and limit query parameters from a request.
+// Pages start at 1. Missing values fall back to the defaults and the limit
+// is capped at MaxLimit.
+func ParsePaging(r *http.Request) (page, limit int, err error) {
+
+	page, limit = 1, DefaultLimit
+	q := r.URL.Query()
+
+	if v := q.Get("page"); v != "" {
+		page, err = strconv.Atoi(v)
+		if err != nil || page < 1 {
+			return 0, 0, NewRequestError(errors.Errorf("invalid page %q", v), http.StatusBadRequest)
+		}
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return 0, 0, NewRequestError(errors.Errorf("invalid limit %q", v), http.StatusBadRequest)
+		}
+	}
+	if limit > MaxLimit {
+		limit = MaxLimit
+	}
+
+	return page, limit, nil
+}
+
+// NewPage builds a Page for the provided items. The links to the next
+// and previous pages are created from the request URL so any filters
+// the client provided are carried over.
+func NewPage(r *http.Request, items interface{}, total, page, limit int) Page {
+
+	p := Page{
+		Items: items,
+		Total: total,
+		Page:  page,
+		Limit: limit,
+	}
+
+	if page*limit < total {
+		p.Next = pageURL(r.URL, page+1, limit)
+	}
+	if page > 1 {
+		p.Prev = pageURL(r.URL, page-1, limit)
+	}
+
+	return p
+}
+
+// pageURL returns the path and query of u with the paging parameters replaced.
+func pageURL(u *url.URL, page, limit int) string {
+
+	q := u.Query()
+	q.Set("page", strconv.Itoa(page))
+	q.Set("limit", strconv.Itoa(limit))
+
+	link := url.URL{
+		Path:     u.Path,
+		RawQuery: q.Encode(),
+	}
+	return link.String()
+}
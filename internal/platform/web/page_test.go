@@ -0,0 +1,50 @@
+package web
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParsePaging(t *testing.T) {
+
+	r := httptest.NewRequest("GET", "/v1/products", nil)
+	page, limit, err := ParsePaging(r)
+	if err != nil {
+		t.Fatalf("parsing default paging: %s", err)
+	}
+	if page != 1 || limit != DefaultLimit {
+		t.Fatalf("expected page 1 limit %d, got page %d limit %d", DefaultLimit, page, limit)
+	}
+
+	r = httptest.NewRequest("GET", "/v1/products?page=3&limit=100000", nil)
+	page, limit, err = ParsePaging(r)
+	if err != nil {
+		t.Fatalf("parsing paging: %s", err)
+	}
+	if page != 3 || limit != MaxLimit {
+		t.Fatalf("expected page 3 limit %d, got page %d limit %d", MaxLimit, page, limit)
+	}
+
+	r = httptest.NewRequest("GET", "/v1/products?page=0", nil)
+	if _, _, err := ParsePaging(r); err == nil {
+		t.Fatal("expected an error for page 0")
+	}
+}
+
+func TestNewPage(t *testing.T) {
+
+	r := httptest.NewRequest("GET", "/v1/products?name=toys&page=2&limit=10", nil)
+	p := NewPage(r, []int{}, 35, 2, 10)
+
+	if exp, got := "/v1/products?limit=10&name=toys&page=3", p.Next; exp != got {
+		t.Errorf("expected next link %q, got %q", exp, got)
+	}
+	if exp, got := "/v1/products?limit=10&name=toys&page=1", p.Prev; exp != got {
+		t.Errorf("expected prev link %q, got %q", exp, got)
+	}
+
+	p = NewPage(r, []int{}, 20, 2, 10)
+	if p.Next != "" {
+		t.Errorf("expected no next link on the last page, got %q", p.Next)
+	}
+}
@@ -0,0 +1,75 @@
+package web
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	ut "github.com/go-playground/universal-translator"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+// PasswordPolicy holds the rules enforced on fields using the "password"
+// validation tag. Banned passwords are compared without regard to case.
+type PasswordPolicy struct {
+	MinLength int
+	Banned    []string
+}
+
+// DefaultBannedPasswords is a short list of the most commonly used passwords.
+var DefaultBannedPasswords = []string{
+	"123456", "12345678", "123456789", "1234567890", "password",
+	"password1", "qwerty", "qwerty123", "abc123", "111111",
+	"iloveyou", "letmein", "welcome", "admin", "monkey",
+	"dragon", "football", "baseball", "sunshine", "princess",
+	"passw0rd", "trustno1", "superman", "starwars", "garagesale",
+}
+
+// policy is the password policy in effect along with a lookup of banned
+// passwords. It is only replaced during startup by SetPasswordPolicy.
+var policy struct {
+	PasswordPolicy
+	banned map[string]bool
+}
+
+// SetPasswordPolicy replaces the rules enforced by the "password" validation
+// tag. It is not safe to call while requests are being decoded and should
+// be used while the service starts.
+func SetPasswordPolicy(p PasswordPolicy) {
+	policy.PasswordPolicy = p
+	policy.banned = make(map[string]bool, len(p.Banned))
+	for _, b := range p.Banned {
+		policy.banned[strings.ToLower(b)] = true
+	}
+}
+
+// validatePassword implements the "password" validation tag.
+func validatePassword(fl validator.FieldLevel) bool {
+	pass := fl.Field().String()
+	if utf8.RuneCountInString(pass) < policy.MinLength {
+		return false
+	}
+	return !policy.banned[strings.ToLower(pass)]
+}
+
+// registerPasswordValidation adds the "password" tag and its english
+// error message to the validator.
+func registerPasswordValidation(lang ut.Translator) {
+
+	SetPasswordPolicy(PasswordPolicy{
+		MinLength: 8,
+		Banned:    DefaultBannedPasswords,
+	})
+
+	validate.RegisterValidation("password", validatePassword)
+
+	// The message is built when the error is translated
+	// so it always reflects the policy in effect.
+	register := func(ut ut.Translator) error {
+		return nil
+	}
+	translate := func(ut ut.Translator, fe validator.FieldError) string {
+		return fmt.Sprintf("%s must be at least %d characters and not a commonly used password", fe.Field(), policy.MinLength)
+	}
+	validate.RegisterTranslation("password", lang, register, translate)
+}
@@ -0,0 +1,45 @@
+package web
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	defer SetPasswordPolicy(PasswordPolicy{MinLength: 8, Banned: DefaultBannedPasswords})
+
+	SetPasswordPolicy(PasswordPolicy{
+		MinLength: 10,
+		Banned:    []string{"correcthorse"},
+	})
+
+	tests := []struct {
+		password string
+		valid    bool
+	}{
+		{"short", false},
+		{"CorrectHorse", false},
+		{"battery staple", true},
+	}
+
+	for _, tt := range tests {
+		var v struct {
+			Password string `json:"password" validate:"required,password"`
+		}
+
+		body := strings.NewReader(`{"password":"` + tt.password + `"}`)
+		r := httptest.NewRequest("POST", "/", body)
+
+		err := Decode(r, &v)
+		if tt.valid && err != nil {
+			t.Errorf("password %q should be valid: %s", tt.password, err)
+		}
+		if !tt.valid {
+			webErr, ok := err.(*Error)
+			if !ok || len(webErr.Fields) != 1 || webErr.Fields[0].Field != "password" {
+				t.Errorf("password %q should fail validation, got %v", tt.password, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,427 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// These are the media types of the patch documents accepted by Patch.
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+// MaxPatchSize limits the size of a patch document read by Patch.
+const MaxPatchSize = 1 << 20
+
+// Patch applies the patch document in the request body to val, which must be
+// a pointer to a struct holding the current state of a resource. Depending on
+// the Content-Type the body is a JSON merge patch (RFC 7386) or a JSON Patch
+// (RFC 6902). The patched document is decoded into a fresh value, so patches
+// can clear fields, and checked for validation tags like in Decode. val is
+// only changed when the patch applied and the result is valid. Documents
+// larger than MaxPatchSize are refused.
+func Patch(w http.ResponseWriter, r *http.Request, val interface{}) error {
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MaxPatchSize))
+	if err != nil {
+		if TooLarge(err) {
+			return NewRequestError(err, http.StatusRequestEntityTooLarge)
+		}
+		return NewRequestError(err, http.StatusBadRequest)
+	}
+
+	doc, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var patched []byte
+	switch mediaType {
+	case MergePatchType:
+		patched, err = mergePatch(doc, body)
+	case JSONPatchType:
+		patched, err = jsonPatch(doc, body)
+	default:
+		err := errors.Errorf("unsupported patch media type %q, use %s or %s", mediaType, MergePatchType, JSONPatchType)
+		return NewRequestError(err, http.StatusUnsupportedMediaType)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Decoding into a zero value makes fields removed by the patch empty.
+	fresh := reflect.New(reflect.TypeOf(val).Elem())
+
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(fresh.Interface()); err != nil {
+		return NewRequestError(err, http.StatusBadRequest)
+	}
+
+	if err := Validate(fresh.Interface()); err != nil {
+		return err
+	}
+
+	reflect.ValueOf(val).Elem().Set(fresh.Elem())
+
+	return nil
+}
+
+// unmarshal decodes a JSON document keeping numbers exact.
+func unmarshal(data []byte) (interface{}, error) {
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, NewRequestError(err, http.StatusBadRequest)
+	}
+
+	return v, nil
+}
+
+// mergePatch applies an RFC 7386 merge patch to doc.
+func mergePatch(doc, patch []byte) ([]byte, error) {
+
+	target, err := unmarshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	p, err := unmarshal(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(merge(target, p))
+}
+
+// merge implements the MergePatch function of RFC 7386. Members set to
+// null in the patch are removed, objects are merged recursively and any
+// other value replaces the target.
+func merge(target, patch interface{}) interface{} {
+
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = merge(t[k], v)
+	}
+
+	return t
+}
+
+// operation is a single operation of a JSON Patch document. Value is nil
+// when the member is missing, which is different from an explicit null.
+type operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// jsonPatch applies the operations of an RFC 6902 JSON Patch to doc in order.
+// The whole patch fails when any operation fails. A failed test operation is
+// reported as 409 Conflict.
+func jsonPatch(doc, patch []byte) ([]byte, error) {
+
+	target, err := unmarshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var ops []operation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, NewRequestError(err, http.StatusBadRequest)
+	}
+
+	for i, op := range ops {
+		target, err = op.apply(target)
+		if err != nil {
+			if werr, ok := err.(*Error); ok {
+				werr.Err = errors.Wrapf(werr.Err, "operation %d", i)
+				return nil, werr
+			}
+			return nil, NewRequestError(errors.Wrapf(err, "operation %d", i), http.StatusBadRequest)
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+// apply performs the operation on doc and returns the changed document.
+func (op operation) apply(doc interface{}) (interface{}, error) {
+
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, errors.Errorf("%s requires a value", op.Op)
+		}
+		value, err := unmarshal(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		switch op.Op {
+		case "add":
+			return add(doc, path, value)
+		case "replace":
+			return replace(doc, path, value)
+		}
+		current, err := get(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, NewRequestError(errors.Errorf("test of %q failed", op.Path), http.StatusConflict)
+		}
+		return doc, nil
+
+	case "remove":
+		return remove(doc, path)
+
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := get(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if strings.HasPrefix(op.Path, op.From+"/") {
+				return nil, errors.Errorf("can not move %q into itself", op.From)
+			}
+			if doc, err = remove(doc, from); err != nil {
+				return nil, err
+			}
+		} else {
+
+			// Copies must not share maps or slices with the original.
+			raw, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			if value, err = unmarshal(raw); err != nil {
+				return nil, err
+			}
+		}
+		return add(doc, path, value)
+	}
+
+	return nil, errors.Errorf("unknown operation %q", op.Op)
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into its unescaped
+// reference tokens. The empty pointer refers to the whole document.
+func parsePointer(pointer string) ([]string, error) {
+
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, errors.Errorf("invalid JSON pointer %q", pointer)
+	}
+
+	unescape := strings.NewReplacer("~1", "/", "~0", "~")
+	tokens := strings.Split(pointer[1:], "/")
+	for i := range tokens {
+		tokens[i] = unescape.Replace(tokens[i])
+	}
+
+	return tokens, nil
+}
+
+// arrayIndex parses a reference token used on an array of length n. When end
+// is set the index may point just past the last element, which "-" refers to.
+func arrayIndex(token string, n int, end bool) (int, error) {
+
+	if token == "-" && end {
+		return n, nil
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, errors.Errorf("invalid array index %q", token)
+	}
+	if i > n || (i == n && !end) {
+		return 0, errors.Errorf("array index %d out of range", i)
+	}
+
+	return i, nil
+}
+
+// get returns the value at path.
+func get(doc interface{}, path []string) (interface{}, error) {
+
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			v, ok := node[token]
+			if !ok {
+				return nil, errors.Errorf("member %q not found", token)
+			}
+			doc = v
+		case []interface{}:
+			i, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, errors.Errorf("can not find %q in a scalar value", token)
+		}
+	}
+
+	return doc, nil
+}
+
+// change walks to the container holding the last token of path and replaces
+// it with the result of f. Arrays may grow or shrink so every container on
+// the way is written back into its parent.
+func change(doc interface{}, path []string, f func(container interface{}, token string) (interface{}, error)) (interface{}, error) {
+
+	if len(path) == 1 {
+		return f(doc, path[0])
+	}
+
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		child, ok := node[path[0]]
+		if !ok {
+			return nil, errors.Errorf("member %q not found", path[0])
+		}
+		child, err := change(child, path[1:], f)
+		if err != nil {
+			return nil, err
+		}
+		node[path[0]] = child
+		return node, nil
+
+	case []interface{}:
+		i, err := arrayIndex(path[0], len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		child, err := change(node[i], path[1:], f)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = child
+		return node, nil
+	}
+
+	return nil, errors.Errorf("can not find %q in a scalar value", path[0])
+}
+
+// add sets a member of an object or inserts an element into an array.
+func add(doc interface{}, path []string, value interface{}) (interface{}, error) {
+
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	f := func(container interface{}, token string) (interface{}, error) {
+		switch node := container.(type) {
+		case map[string]interface{}:
+			node[token] = value
+			return node, nil
+		case []interface{}:
+			i, err := arrayIndex(token, len(node), true)
+			if err != nil {
+				return nil, err
+			}
+			node = append(node, nil)
+			copy(node[i+1:], node[i:])
+			node[i] = value
+			return node, nil
+		}
+		return nil, errors.Errorf("can not add %q to a scalar value", token)
+	}
+
+	return change(doc, path, f)
+}
+
+// remove deletes a member of an object or an element of an array.
+func remove(doc interface{}, path []string) (interface{}, error) {
+
+	if len(path) == 0 {
+		return nil, errors.New("can not remove the whole document")
+	}
+
+	f := func(container interface{}, token string) (interface{}, error) {
+		switch node := container.(type) {
+		case map[string]interface{}:
+			if _, ok := node[token]; !ok {
+				return nil, errors.Errorf("member %q not found", token)
+			}
+			delete(node, token)
+			return node, nil
+		case []interface{}:
+			i, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			return append(node[:i], node[i+1:]...), nil
+		}
+		return nil, errors.Errorf("can not remove %q from a scalar value", token)
+	}
+
+	return change(doc, path, f)
+}
+
+// replace changes an existing member of an object or element of an array.
+func replace(doc interface{}, path []string, value interface{}) (interface{}, error) {
+
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	f := func(container interface{}, token string) (interface{}, error) {
+		switch node := container.(type) {
+		case map[string]interface{}:
+			if _, ok := node[token]; !ok {
+				return nil, errors.Errorf("member %q not found", token)
+			}
+			node[token] = value
+			return node, nil
+		case []interface{}:
+			i, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			node[i] = value
+			return node, nil
+		}
+		return nil, errors.Errorf("can not replace %q in a scalar value", token)
+	}
+
+	return change(doc, path, f)
+}
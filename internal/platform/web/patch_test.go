@@ -0,0 +1,76 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type patchItem struct {
+	Name  string   `json:"name" validate:"required"`
+	Note  string   `json:"note,omitempty"`
+	Count int      `json:"count" validate:"gte=0"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
+func TestPatch(t *testing.T) {
+
+	orig := patchItem{Name: "Puzzles", Note: "Boxed", Count: 3, Tags: []string{"a", "b"}}
+
+	tests := []struct {
+		name   string
+		typ    string
+		body   string
+		want   patchItem
+		status int
+	}{
+		{"MergeChange", MergePatchType, `{"count":5}`,
+			patchItem{Name: "Puzzles", Note: "Boxed", Count: 5, Tags: []string{"a", "b"}}, 0},
+		{"MergeClear", MergePatchType, `{"note":null,"tags":null}`,
+			patchItem{Name: "Puzzles", Count: 3}, 0},
+		{"MergeInvalid", MergePatchType, `{"name":null}`, orig, http.StatusBadRequest},
+		{"MergeUnknownField", MergePatchType, `{"color":"red"}`, orig, http.StatusBadRequest},
+		{"PatchOps", JSONPatchType, `[
+			{"op":"test","path":"/count","value":3},
+			{"op":"replace","path":"/name","value":"Jigsaws"},
+			{"op":"add","path":"/tags/-","value":"c"},
+			{"op":"remove","path":"/tags/0"},
+			{"op":"copy","from":"/name","path":"/note"}
+		]`, patchItem{Name: "Jigsaws", Note: "Jigsaws", Count: 3, Tags: []string{"b", "c"}}, 0},
+		{"PatchMove", JSONPatchType, `[{"op":"move","from":"/note","path":"/name"}]`,
+			patchItem{Name: "Boxed", Count: 3, Tags: []string{"a", "b"}}, 0},
+		{"PatchTestFails", JSONPatchType, `[{"op":"test","path":"/count","value":4},{"op":"replace","path":"/count","value":9}]`,
+			orig, http.StatusConflict},
+		{"PatchMissingPath", JSONPatchType, `[{"op":"replace","path":"/tags/7","value":"x"}]`, orig, http.StatusBadRequest},
+		{"PatchInvalidResult", JSONPatchType, `[{"op":"replace","path":"/count","value":-1}]`, orig, http.StatusBadRequest},
+		{"UnsupportedType", "application/json", `{"count":5}`, orig, http.StatusUnsupportedMediaType},
+		{"TooLarge", MergePatchType, `{"note":"` + strings.Repeat("x", MaxPatchSize) + `"}`, orig, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			got := orig
+			got.Tags = append([]string{}, orig.Tags...)
+
+			r := httptest.NewRequest("PATCH", "/", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.typ)
+
+			err := Patch(httptest.NewRecorder(), r, &got)
+			if tt.status != 0 {
+				werr, ok := err.(*Error)
+				if !ok || werr.Status != tt.status {
+					t.Fatalf("expected status %d, got %v", tt.status, err)
+				}
+			} else if err != nil {
+				t.Fatalf("patching: %s", err)
+			}
+
+			if !reflect.DeepEqual(tt.want, got) {
+				t.Fatalf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
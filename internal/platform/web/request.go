@@ -31,6 +31,10 @@ func init() {
 	lang, _ := translator.GetTranslator("en")
 	en_translations.RegisterDefaultTranslations(validate, lang)
 
+	// Register our own validation tags.
+	registerPasswordValidation(lang)
+	registerCurrencyValidation(lang)
+
 	// Use JSON tag names for errors instead of Go struct names.
 	validate.RegisterTagNameFunc(func(fld reflect.StructField) string {
 		name := strings.SplitN(fld.Tag.Get("json"), ",", 2)[0]
@@ -41,6 +45,12 @@ func init() {
 	})
 }
 
+// TooLarge reports whether err was caused by a request body exceeding the
+// limit of an http.MaxBytesReader.
+func TooLarge(err error) bool {
+	return err != nil && err.Error() == "http: request body too large"
+}
+
 // Decode function decodes a json response into the provided value.
 // If the provided value is a struct then it is checked for validation tags.
 func Decode(r *http.Request, val interface{}) error {
@@ -54,6 +64,13 @@ func Decode(r *http.Request, val interface{}) error {
 		return NewRequestError(err, http.StatusBadRequest)
 	}
 
+	return Validate(val)
+}
+
+// Validate checks the validation tags of a struct value. Failed checks are
+// reported as a 400 Bad Request error listing every invalid field.
+func Validate(val interface{}) error {
+
 	if err := validate.Struct(val); err != nil {
 
 		// Use a type assertion to get the real error value.
@@ -3,6 +3,7 @@ package web
 import (
 	"context"
 	"encoding/json"
+	"io"
 	"net/http"
 
 	"github.com/pkg/errors"
@@ -41,6 +42,25 @@ func Respond(ctx context.Context, w http.ResponseWriter, data interface{}, statu
 	return nil
 }
 
+// RespondStream copies the content of r to the response writer as is. It is
+// used for responses that are not JSON, like files.
+func RespondStream(ctx context.Context, w http.ResponseWriter, r io.Reader, contentType string, statusCode int) error {
+
+	v, ok := ctx.Value(KeyValues).(*Values)
+	if !ok {
+		return NewShutdownError("web value missing from context")
+	}
+	v.StatusCode = statusCode
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(statusCode)
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // RespondError is used to send error responses to the client.
 func RespondError(ctx context.Context, w http.ResponseWriter, err error) error {
 
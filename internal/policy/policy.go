@@ -0,0 +1,117 @@
+// Package policy decides what the roles of a client allow it to do. Roles are
+// mapped to permissions in the database so they can be changed without a
+// release.
+package policy
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/sreejeet/garagesale/internal/platform/auth"
+	"go.opencensus.io/trace"
+)
+
+// These are the permissions that can be granted to roles. A permission ending
+// in OwnSuffix only applies to resources created by the client itself.
+const (
+	ProductsRead      = "products:read"
+	ProductsCreate    = "products:create"
+	ProductsUpdate    = "products:update"
+	ProductsUpdateOwn = ProductsUpdate + OwnSuffix
+	ProductsDelete    = "products:delete"
+	ProductsRestore   = "products:restore"
+	SalesRead         = "sales:read"
+	SalesCreate       = "sales:create"
+	SalesReverse      = "sales:reverse"
+	OrdersRead        = "orders:read"
+	OrdersCreate      = "orders:create"
+	UsersManage       = "users:manage"
+	APIKeysManage     = "apikeys:manage"
+	ReportsRead       = "reports:read"
+	OrgsCreate        = "orgs:create"
+	CategoriesManage  = "categories:manage"
+	TagsManage        = "tags:manage"
+)
+
+// OwnSuffix limits a permission to resources owned by the client.
+const OwnSuffix = ":own"
+
+// Permissions returns every permission granted to at least one of the roles.
+// Permissions the HasPermission middleware already resolved for the same
+// roles are taken from the context.
+func Permissions(ctx context.Context, db *sqlx.DB, roles []string) ([]string, error) {
+
+	if g, ok := ctx.Value(auth.GrantedKey).(auth.Granted); ok && g.Of(roles) {
+		return g.Permissions, nil
+	}
+
+	ctx, span := trace.StartSpan(ctx, "internal.policy.Permissions")
+	defer span.End()
+
+	perms := []string{}
+	if len(roles) == 0 {
+		return perms, nil
+	}
+
+	const q = `SELECT DISTINCT permission FROM role_permissions
+				WHERE role = ANY($1)
+				ORDER BY permission`
+	if err := db.SelectContext(ctx, &perms, q, pq.Array(roles)); err != nil {
+		return nil, errors.Wrap(err, "selecting permissions")
+	}
+
+	return perms, nil
+}
+
+// KnownRoles reports whether every one of the roles exists. Roles are
+// created by migrations so a role missing from the database is a mistake of
+// the client.
+func KnownRoles(ctx context.Context, db sqlx.QueryerContext, roles []string) (bool, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.policy.KnownRoles")
+	defer span.End()
+
+	var missing int
+	const q = `SELECT COUNT(*) FROM UNNEST($1::TEXT[]) AS r(role)
+				WHERE r.role NOT IN (SELECT role FROM roles)`
+	if err := sqlx.GetContext(ctx, db, &missing, q, pq.Array(roles)); err != nil {
+		return false, errors.Wrap(err, "checking roles")
+	}
+
+	return missing == 0, nil
+}
+
+// Resolver returns a PermissionsFunc looking up the permissions of roles in
+// the database. It is used by the HasPermission middleware.
+func Resolver(db *sqlx.DB) auth.PermissionsFunc {
+	return func(ctx context.Context, roles []string) ([]string, error) {
+		return Permissions(ctx, db, roles)
+	}
+}
+
+// Allowed reports whether the client may perform an action on a resource
+// owned by ownerID. The client needs the permission itself or, when it owns
+// the resource, the permission limited to its own resources.
+func Allowed(ctx context.Context, db *sqlx.DB, claims auth.Claims, perm, ownerID string) (bool, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.policy.Allowed")
+	defer span.End()
+
+	perms, err := Permissions(ctx, db, claims.Roles)
+	if err != nil {
+		return false, err
+	}
+
+	for _, p := range perms {
+		if p == perm {
+			return true, nil
+		}
+		if p == perm+OwnSuffix && ownerID != "" && ownerID == claims.Subject {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
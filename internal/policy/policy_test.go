@@ -0,0 +1,94 @@
+package policy_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sreejeet/garagesale/internal/platform/auth"
+	"github.com/sreejeet/garagesale/internal/policy"
+	"github.com/sreejeet/garagesale/internal/tests"
+)
+
+func TestPolicy(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	perms, err := policy.Permissions(ctx, db, []string{auth.RoleCashier})
+	if err != nil {
+		t.Fatalf("getting permissions: %s", err)
+	}
+	want := []string{policy.OrdersCreate, policy.ProductsRead, policy.SalesCreate}
+	if diff := cmp.Diff(want, perms); diff != "" {
+		t.Fatalf("unexpected cashier permissions:\n%s", diff)
+	}
+
+	// Permissions of several roles are combined.
+	perms, err = policy.Permissions(ctx, db, []string{auth.RoleCashier, auth.RoleAuditor})
+	if err != nil {
+		t.Fatalf("getting permissions: %s", err)
+	}
+	if len(perms) != 5 {
+		t.Fatalf("expected 5 combined permissions, got %v", perms)
+	}
+
+	for _, tt := range []struct {
+		roles []string
+		known bool
+	}{
+		{[]string{auth.RoleAdmin, auth.RoleCashier}, true},
+		{[]string{}, true},
+		{[]string{auth.RoleUser, "ADMN"}, false},
+	} {
+		if known, err := policy.KnownRoles(ctx, db, tt.roles); err != nil || known != tt.known {
+			t.Fatalf("roles %v: expected known %v, got %v %v", tt.roles, tt.known, known, err)
+		}
+	}
+
+	seller := auth.NewClaims("718ffbea-f4a1-4667-8ae3-b349da52675e", []string{auth.RoleSeller}, now, time.Hour)
+	admin := auth.NewClaims(tests.AdminID, []string{auth.RoleAdmin}, now, time.Hour)
+	other := "45b5fbd3-755f-4379-8f07-a58d4a30fa2f"
+
+	tests := []struct {
+		name    string
+		claims  auth.Claims
+		perm    string
+		owner   string
+		allowed bool
+	}{
+		{"SellerOwnProduct", seller, policy.ProductsUpdate, seller.Subject, true},
+		{"SellerOtherProduct", seller, policy.ProductsUpdate, other, false},
+		{"SellerNoOwner", seller, policy.ProductsUpdate, "", false},
+		{"SellerDelete", seller, policy.ProductsDelete, seller.Subject, false},
+		{"AdminOtherProduct", admin, policy.ProductsUpdate, other, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, err := policy.Allowed(ctx, db, tt.claims, tt.perm, tt.owner)
+			if err != nil {
+				t.Fatalf("checking permission: %s", err)
+			}
+			if allowed != tt.allowed {
+				t.Fatalf("expected allowed %v, got %v", tt.allowed, allowed)
+			}
+		})
+	}
+
+	// Permissions resolved earlier in the request are used for the same
+	// roles only.
+	granted := auth.Granted{Roles: seller.Roles, Permissions: []string{policy.ProductsDelete}}
+	rctx := context.WithValue(ctx, auth.GrantedKey, granted)
+	if allowed, err := policy.Allowed(rctx, db, seller, policy.ProductsDelete, ""); err != nil || !allowed {
+		t.Fatalf("expected the permissions of the context to be used, got %v %v", allowed, err)
+	}
+	if allowed, err := policy.Allowed(rctx, db, admin, policy.ProductsDelete, ""); err != nil || !allowed {
+		t.Fatalf("expected the permissions of other roles to be resolved, got %v %v", allowed, err)
+	}
+	if allowed, err := policy.Allowed(rctx, db, seller, policy.ProductsUpdate, other); err != nil || allowed {
+		t.Fatalf("expected only the permissions of the context, got %v %v", allowed, err)
+	}
+}
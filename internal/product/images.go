@@ -0,0 +1,306 @@
+package product
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"time"
+
+	// Register the decoders of the accepted formats other than PNG and JPEG.
+	_ "image/gif"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/sreejeet/garagesale/internal/platform/auth"
+	"github.com/sreejeet/garagesale/internal/platform/imaging"
+	"github.com/sreejeet/garagesale/internal/platform/storage"
+	"github.com/sreejeet/garagesale/internal/policy"
+	"go.opencensus.io/trace"
+)
+
+// These limit the images that can be added to a product. Pixels are limited
+// separately because small files can hold images too large to decode. A
+// decoded image takes up to 8 bytes per pixel, plus 4 while it is scaled, so
+// only MaxImageDecodes images are decoded at the same time.
+const (
+	MaxImageSize    = 5 << 20
+	MaxImagePixels  = 12000000
+	MaxImageDecodes = 4
+	ThumbnailSize   = 256
+)
+
+// decodes holds a slot for every image being decoded.
+var decodes = make(chan struct{}, MaxImageDecodes)
+
+// imageTypes are the content types accepted for product images.
+var imageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// Custom errors for images
+var (
+	// ErrImageNotFound occurs when a product has no image of the requested ID.
+	ErrImageNotFound = errors.New("image not found")
+	// ErrImageType occurs when an upload is not an image of an accepted format.
+	ErrImageType = errors.New("image must be a JPEG, PNG or GIF")
+	// ErrImageTooLarge occurs when an image exceeds MaxImageSize or MaxImagePixels.
+	ErrImageTooLarge = errors.New("image is too large")
+)
+
+// AddImage stores a new image of a product together with a thumbnail of at
+// most ThumbnailSize pixels on each side. The content type is detected from
+// the data itself. Like updates, adding images requires permission to change
+// the product and changes its version.
+func AddImage(ctx context.Context, db *sqlx.DB, store storage.BlobStore, user auth.Claims, productID string, data []byte, now time.Time) (*Image, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.product.AddImage")
+	defer span.End()
+
+	if len(data) > MaxImageSize {
+		return nil, ErrImageTooLarge
+	}
+	contentType := http.DetectContentType(data)
+	if !imageTypes[contentType] {
+		return nil, ErrImageType
+	}
+
+	p, err := Retrieve(ctx, db, user.OrgID, productID, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkUpdate(ctx, db, user, p); err != nil {
+		return nil, err
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrImageType
+	}
+	if cfg.Width*cfg.Height > MaxImagePixels {
+		return nil, ErrImageTooLarge
+	}
+
+	img := Image{
+		ID:          uuid.New().String(),
+		ProductID:   p.ID,
+		ContentType: contentType,
+		Size:        len(data),
+		Width:       cfg.Width,
+		Height:      cfg.Height,
+		DateCreated: now.UTC(),
+	}
+	img.setURLs()
+
+	thumb, err := thumbnail(ctx, data, img.ThumbnailType())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := store.Put(ctx, img.Key(), data, img.ContentType); err != nil {
+		return nil, err
+	}
+	if err := store.Put(ctx, img.ThumbnailKey(), thumb, img.ThumbnailType()); err != nil {
+		deleteBlobs(ctx, store, img)
+		return nil, err
+	}
+
+	if err := insertImage(ctx, db, img); err != nil {
+		deleteBlobs(ctx, store, img)
+		return nil, err
+	}
+
+	return &img, nil
+}
+
+// insertImage records an image and bumps the version of its product.
+func insertImage(ctx context.Context, db *sqlx.DB, img Image) error {
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting image transaction")
+	}
+
+	// Rolling back after a commit is a no-op so this is safe to defer.
+	defer tx.Rollback()
+
+	const q = `INSERT INTO product_images
+		(image_id, product_id, content_type, size, width, height, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err = tx.ExecContext(ctx, q,
+		img.ID, img.ProductID, img.ContentType,
+		img.Size, img.Width, img.Height, img.DateCreated)
+	if err != nil {
+		return errors.Wrap(err, "inserting image")
+	}
+
+	if err := bumpVersion(ctx, tx, img.ProductID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing image")
+	}
+
+	return nil
+}
+
+// ListImages returns the images of a product of the organization orgID
+// in the order they were added.
+func ListImages(ctx context.Context, db *sqlx.DB, orgID, productID string) ([]Image, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.product.ListImages")
+	defer span.End()
+
+	if _, err := Retrieve(ctx, db, orgID, productID, false); err != nil {
+		return nil, err
+	}
+
+	images := []Image{}
+	const q = `SELECT * FROM product_images WHERE product_id = $1 ORDER BY date_created, image_id`
+	if err := db.SelectContext(ctx, &images, q, productID); err != nil {
+		return nil, errors.Wrap(err, "selecting images")
+	}
+	for i := range images {
+		images[i].setURLs()
+	}
+
+	return images, nil
+}
+
+// RetrieveImage gets a single image of a product of the organization orgID.
+func RetrieveImage(ctx context.Context, db *sqlx.DB, orgID, productID, id string) (*Image, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.product.RetrieveImage")
+	defer span.End()
+
+	if _, err := Retrieve(ctx, db, orgID, productID, false); err != nil {
+		return nil, err
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var img Image
+	const q = `SELECT * FROM product_images WHERE image_id = $1 AND product_id = $2`
+	if err := db.GetContext(ctx, &img, q, id, productID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrImageNotFound
+		}
+		return nil, errors.Wrap(err, "selecting one image")
+	}
+	img.setURLs()
+
+	return &img, nil
+}
+
+// DeleteImage removes an image of a product and its thumbnail.
+func DeleteImage(ctx context.Context, db *sqlx.DB, store storage.BlobStore, user auth.Claims, productID, id string) error {
+
+	ctx, span := trace.StartSpan(ctx, "internal.product.DeleteImage")
+	defer span.End()
+
+	p, err := Retrieve(ctx, db, user.OrgID, productID, false)
+	if err != nil {
+		return err
+	}
+	if err := checkUpdate(ctx, db, user, p); err != nil {
+		return err
+	}
+	img, err := RetrieveImage(ctx, db, user.OrgID, productID, id)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting image transaction")
+	}
+
+	// Rolling back after a commit is a no-op so this is safe to defer.
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM product_images WHERE image_id = $1`, img.ID); err != nil {
+		return errors.Wrapf(err, "deleting image %s", img.ID)
+	}
+	if err := bumpVersion(ctx, tx, img.ProductID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing image")
+	}
+
+	if err := store.Delete(ctx, img.Key()); err != nil {
+		return err
+	}
+	return store.Delete(ctx, img.ThumbnailKey())
+}
+
+// thumbnail decodes an image and encodes a thumbnail of it in contentType.
+// It waits for a free decoding slot first so concurrent uploads can not
+// exhaust the memory.
+func thumbnail(ctx context.Context, data []byte, contentType string) ([]byte, error) {
+	select {
+	case decodes <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-decodes }()
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrImageType
+	}
+
+	var thumb bytes.Buffer
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(&thumb, imaging.Fit(src, ThumbnailSize, ThumbnailSize), nil)
+	} else {
+		err = png.Encode(&thumb, imaging.Fit(src, ThumbnailSize, ThumbnailSize))
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding thumbnail")
+	}
+
+	return thumb.Bytes(), nil
+}
+
+// checkUpdate makes sure the client may change the product: it needs
+// permission to update any product or be the owner of this one.
+func checkUpdate(ctx context.Context, db *sqlx.DB, user auth.Claims, p *Product) error {
+
+	allowed, err := policy.Allowed(ctx, db, user, policy.ProductsUpdate, p.UserID)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return ErrForbidden
+	}
+
+	return nil
+}
+
+// bumpVersion marks a product as changed so clients holding
+// its old representation can notice.
+func bumpVersion(ctx context.Context, tx sqlx.ExecerContext, productID string) error {
+
+	const q = `UPDATE products SET version = version + 1 WHERE product_id = $1`
+	if _, err := tx.ExecContext(ctx, q, productID); err != nil {
+		return errors.Wrapf(err, "updating version of product %s", productID)
+	}
+
+	return nil
+}
+
+// deleteBlobs removes the stored files of an image that could not be
+// recorded. Errors are ignored as there is nothing left to clean up with.
+func deleteBlobs(ctx context.Context, store storage.BlobStore, img Image) {
+	store.Delete(ctx, img.Key())
+	store.Delete(ctx, img.ThumbnailKey())
+}
@@ -0,0 +1,355 @@
+package product
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/sreejeet/garagesale/internal/platform/auth"
+	"github.com/sreejeet/garagesale/internal/platform/money"
+	"github.com/sreejeet/garagesale/internal/platform/web"
+	"go.opencensus.io/trace"
+)
+
+// These are the formats products can be imported from. CSV files start with
+// a header naming their columns: name, description, cost, currency, quantity,
+// category_id and tags. Costs are written in major units like 12.50 and tags
+// are separated by semicolons. NDJSON files hold one NewProduct per line.
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// These are the modes of an import. All-or-nothing imports nothing when any
+// row is invalid while best-effort imports every valid row.
+const (
+	ImportAllOrNothing = "all-or-nothing"
+	ImportBestEffort   = "best-effort"
+)
+
+// MaxImportRows limits the number of products of a single import.
+const MaxImportRows = 10000
+
+// csvColumns are the columns of a CSV import and whether they are required.
+var csvColumns = map[string]bool{
+	"name":        true,
+	"description": false,
+	"cost":        true,
+	"currency":    true,
+	"quantity":    true,
+	"category_id": false,
+	"tags":        false,
+}
+
+// Custom errors of imports as a whole. Problems with single rows are
+// reported in the ImportResult instead.
+var (
+	// ErrImportFormat occurs when importing from an unknown format.
+	ErrImportFormat = errors.New("import format must be csv or ndjson")
+	// ErrImportMode occurs when asking for an unknown import mode.
+	ErrImportMode = errors.New("import mode must be all-or-nothing or best-effort")
+	// ErrImportHeader occurs when the header of a CSV import has unknown
+	// columns or lacks a required one.
+	ErrImportHeader = errors.New("csv header must name the columns name, cost, currency and quantity " +
+		"and may name description, category_id and tags")
+	// ErrImportEmpty occurs when an import holds no products.
+	ErrImportEmpty = errors.New("import holds no products")
+	// ErrImportTooLarge occurs when an import holds more than MaxImportRows products.
+	ErrImportTooLarge = errors.Errorf("imports are limited to %d products", MaxImportRows)
+)
+
+// RowError explains why a row of an import was not imported. Rows are
+// counted from 1 for the first product, leaving out the CSV header and
+// blank lines.
+type RowError struct {
+	Row    int              `json:"row"`
+	Error  string           `json:"error"`
+	Fields []web.FieldError `json:"fields,omitempty"`
+}
+
+// ImportResult describes the outcome of an import. Products holds the IDs of
+// the imported products in the order of their rows.
+type ImportResult struct {
+	Mode     string     `json:"mode"`
+	Rows     int        `json:"rows"`
+	Imported int        `json:"imported"`
+	Products []string   `json:"products"`
+	Errors   []RowError `json:"errors"`
+}
+
+// importRow is a product read from an import and the row it was read from.
+type importRow struct {
+	row int
+	np  NewProduct
+}
+
+// Import creates products of the user from a CSV or NDJSON file. Every row is
+// validated like a NewProduct sent to the API. The products are created in a
+// single transaction: in all-or-nothing mode nothing is created when any row
+// is invalid, in best-effort mode invalid rows are skipped. Errors of single
+// rows are listed in the result while errors of the file as a whole, like a
+// bad header, are returned.
+func Import(ctx context.Context, db *sqlx.DB, user auth.Claims, r io.Reader, format, mode string, now time.Time) (*ImportResult, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.product.Import")
+	defer span.End()
+
+	if mode != ImportAllOrNothing && mode != ImportBestEffort {
+		return nil, ErrImportMode
+	}
+
+	var rows []importRow
+	var rowErrs []RowError
+	var err error
+	switch format {
+	case FormatCSV:
+		rows, rowErrs, err = readCSV(r)
+	case FormatNDJSON:
+		rows, rowErrs, err = readNDJSON(r)
+	default:
+		return nil, ErrImportFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := ImportResult{
+		Mode:     mode,
+		Rows:     len(rows) + len(rowErrs),
+		Products: []string{},
+		Errors:   rowErrs,
+	}
+	if res.Rows == 0 {
+		return nil, ErrImportEmpty
+	}
+
+	valid := rows[:0]
+	for _, row := range rows {
+		if err := web.Validate(row.np); err != nil {
+			res.Errors = append(res.Errors, rowError(row.row, err))
+			continue
+		}
+		valid = append(valid, row)
+	}
+
+	if mode == ImportAllOrNothing && len(res.Errors) > 0 {
+		sortRowErrors(res.Errors)
+		return &res, nil
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting import transaction")
+	}
+
+	// Rolling back after a commit is a no-op so this is safe to defer.
+	defer tx.Rollback()
+
+	for _, row := range valid {
+		prod := newProduct(user, row.np, now)
+		if err := insertProduct(ctx, tx, prod, row.np.Tags, now); err != nil {
+			if err != ErrInvalidCategory {
+				return nil, errors.Wrapf(err, "importing row %d", row.row)
+			}
+			res.Errors = append(res.Errors, RowError{
+				Row:    row.row,
+				Error:  err.Error(),
+				Fields: []web.FieldError{{Field: "category_id", Error: "category_id must be a category of the organization"}},
+			})
+			continue
+		}
+		res.Products = append(res.Products, prod.ID)
+	}
+
+	sortRowErrors(res.Errors)
+	if mode == ImportAllOrNothing && len(res.Errors) > 0 {
+		res.Products = []string{}
+		return &res, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing import")
+	}
+	res.Imported = len(res.Products)
+
+	return &res, nil
+}
+
+// rowError turns a validation error into the error of a row.
+func rowError(row int, err error) RowError {
+	re := RowError{Row: row, Error: err.Error()}
+	if webErr, ok := err.(*web.Error); ok {
+		re.Fields = webErr.Fields
+	}
+	return re
+}
+
+// sortRowErrors orders row errors by row.
+func sortRowErrors(errs []RowError) {
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Row < errs[j].Row
+	})
+}
+
+// readCSV reads the products of a CSV import. Values are trimmed and blank
+// values are treated as missing. A malformed record stops the reading as
+// the rest of the file can not be trusted. Failing to read r is an error of
+// the import rather than of a row.
+func readCSV(r io.Reader) ([]importRow, []RowError, error) {
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil, ErrImportEmpty
+	}
+	if _, ok := err.(*csv.ParseError); err != nil && !ok {
+		return nil, nil, errors.Wrap(err, "reading csv")
+	}
+	if err != nil {
+		return nil, nil, ErrImportHeader
+	}
+
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := csvColumns[name]; !ok {
+			return nil, nil, ErrImportHeader
+		}
+		if _, dup := index[name]; dup {
+			return nil, nil, ErrImportHeader
+		}
+		index[name] = i
+	}
+	for name, required := range csvColumns {
+		if _, ok := index[name]; required && !ok {
+			return nil, nil, ErrImportHeader
+		}
+	}
+
+	var rows []importRow
+	var rowErrs []RowError
+	for n := 1; ; n++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if _, ok := err.(*csv.ParseError); err != nil && !ok {
+			return nil, nil, errors.Wrap(err, "reading csv")
+		}
+		if err != nil {
+			rowErrs = append(rowErrs, RowError{Row: n, Error: err.Error() + ", stopped reading"})
+			break
+		}
+		if n > MaxImportRows {
+			return nil, nil, ErrImportTooLarge
+		}
+		if len(record) != len(header) {
+			rowErrs = append(rowErrs, RowError{Row: n, Error: "row must have a value for every column of the header"})
+			continue
+		}
+
+		get := func(name string) string {
+			if i, ok := index[name]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		np, fields := parseCSVRow(get)
+		if len(fields) > 0 {
+			rowErrs = append(rowErrs, RowError{Row: n, Error: "field parsing error", Fields: fields})
+			continue
+		}
+		rows = append(rows, importRow{row: n, np: np})
+	}
+
+	return rows, rowErrs, nil
+}
+
+// parseCSVRow converts the values of a CSV row to a NewProduct. Values that
+// can not be converted are reported as field errors.
+func parseCSVRow(get func(name string) string) (NewProduct, []web.FieldError) {
+
+	var fields []web.FieldError
+	np := NewProduct{
+		Name:        get("name"),
+		Description: get("description"),
+		Tags:        []string{},
+	}
+
+	// An invalid currency is left for validation to report.
+	np.Cost.Currency = get("currency")
+	if money.Valid(np.Cost.Currency) {
+		cost, err := money.Parse(get("cost"), np.Cost.Currency)
+		if err != nil {
+			fields = append(fields, web.FieldError{Field: "cost", Error: "cost must be an amount like 12.50 in the currency"})
+		}
+		np.Cost = cost
+	}
+
+	quantity, err := strconv.Atoi(get("quantity"))
+	if err != nil {
+		fields = append(fields, web.FieldError{Field: "quantity", Error: "quantity must be a whole number"})
+	}
+	np.Quantity = quantity
+
+	if id := get("category_id"); id != "" {
+		np.CategoryID = &id
+	}
+	for _, t := range strings.Split(get("tags"), ";") {
+		if t = strings.TrimSpace(t); t != "" {
+			np.Tags = append(np.Tags, t)
+		}
+	}
+
+	return np, fields
+}
+
+// readNDJSON reads the products of an NDJSON import. Blank lines are skipped.
+func readNDJSON(r io.Reader) ([]importRow, []RowError, error) {
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1<<20)
+
+	var rows []importRow
+	var rowErrs []RowError
+	n := 0
+	for s.Scan() {
+		line := bytes.TrimSpace(s.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		n++
+		if n > MaxImportRows {
+			return nil, nil, ErrImportTooLarge
+		}
+
+		var np NewProduct
+		d := json.NewDecoder(bytes.NewReader(line))
+		d.DisallowUnknownFields()
+		if err := d.Decode(&np); err != nil {
+			rowErrs = append(rowErrs, RowError{Row: n, Error: err.Error()})
+			continue
+		}
+		rows = append(rows, importRow{row: n, np: np})
+	}
+	switch err := s.Err(); {
+	case err == bufio.ErrTooLong:
+		rowErrs = append(rowErrs, RowError{Row: n + 1, Error: err.Error() + ", stopped reading"})
+	case err != nil:
+		return nil, nil, errors.Wrap(err, "reading ndjson")
+	}
+
+	return rows, rowErrs, nil
+}
@@ -1,25 +1,127 @@
 package product
 
-import "time"
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/sreejeet/garagesale/internal/platform/money"
+)
 
 // Product is an individial item that can be sold.
+// Stock is the number of items still available, that is Quantity minus Sold.
+// DeletedAt is set once the product was deleted. Deleted products keep their
+// sales history until they are purged. Version is incremented by every change
+// so clients can detect concurrent updates. CategoryID is nil for products
+// that are not in a category. Tags are sorted by name and images are in the
+// order they were added. The currency of the cost is the currency the product
+// is sold in, so Revenue and every sale of the product share it.
 type Product struct {
-	ID          string    `db:"product_id" json:"id"`
-	UserID      string    `db:"user_id" json:"user_id"`
-	Name        string    `db:"name" json:"name"`
-	Cost        int       `db:"cost" json:"cost"`
-	Quantity    int       `db:"quantity" json:"quantity"`
-	Sold        int       `db:"sold" json:"sold"`
-	Revenue     int       `db:"revenue" json:"revenue"`
-	DateCreated time.Time `db:"date_created" json:"date_created"`
-	DateUpdated time.Time `db:"date_updated" json:"date_updated"`
+	ID          string         `db:"product_id" json:"id"`
+	UserID      string         `db:"user_id" json:"user_id"`
+	OrgID       string         `db:"org_id" json:"org_id"`
+	CategoryID  *string        `db:"category_id" json:"category_id"`
+	Name        string         `db:"name" json:"name"`
+	Description string         `db:"description" json:"description"`
+	Cost        money.Money    `db:"cost" json:"cost"`
+	Quantity    int            `db:"quantity" json:"quantity"`
+	Tags        pq.StringArray `db:"tags" json:"tags"`
+	Images      Images         `db:"images" json:"images"`
+	Sold        int            `db:"sold" json:"sold"`
+	Revenue     money.Money    `db:"revenue" json:"revenue"`
+	Stock       int            `db:"stock" json:"stock"`
+	Version     int            `db:"version" json:"version"`
+	DateCreated time.Time      `db:"date_created" json:"date_created"`
+	DateUpdated time.Time      `db:"date_updated" json:"date_updated"`
+	DeletedAt   *time.Time     `db:"deleted_at" json:"deleted_at,omitempty"`
+}
+
+// Image is a picture of a product. URL and ThumbnailURL are the API paths
+// serving the image and its thumbnail.
+type Image struct {
+	ID           string    `db:"image_id" json:"id"`
+	ProductID    string    `db:"product_id" json:"product_id"`
+	ContentType  string    `db:"content_type" json:"content_type"`
+	Size         int       `db:"size" json:"size"`
+	Width        int       `db:"width" json:"width"`
+	Height       int       `db:"height" json:"height"`
+	DateCreated  time.Time `db:"date_created" json:"date_created"`
+	URL          string    `db:"-" json:"url"`
+	ThumbnailURL string    `db:"-" json:"thumbnail_url"`
+}
+
+// Key is the key the image is kept under in the blob store.
+func (i Image) Key() string {
+	return fmt.Sprintf("products/%s/%s", i.ProductID, i.ID)
+}
+
+// ThumbnailKey is the key the thumbnail of the image is kept under.
+func (i Image) ThumbnailKey() string {
+	return i.Key() + "-thumbnail"
+}
+
+// ThumbnailType is the content type of the thumbnail. Photos stay JPEGs
+// while every other format is turned into a PNG to keep transparency.
+func (i Image) ThumbnailType() string {
+	if i.ContentType == "image/jpeg" {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
+
+// setURLs fills in the paths serving the image.
+func (i *Image) setURLs() {
+	i.URL = fmt.Sprintf("/v1/products/%s/images/%s", i.ProductID, i.ID)
+	i.ThumbnailURL = i.URL + "/thumbnail"
+}
+
+// Images are the images of a product as read together with the product.
+type Images []Image
+
+// Scan implements the sql.Scanner interface for the JSON array of images
+// aggregated by the product queries.
+func (imgs *Images) Scan(src interface{}) error {
+
+	b, ok := src.([]byte)
+	if !ok {
+		return errors.Errorf("scanning images: unexpected type %T", src)
+	}
+
+	list := Images{}
+	if err := json.Unmarshal(b, &list); err != nil {
+		return errors.Wrap(err, "scanning images")
+	}
+	for i := range list {
+		list[i].DateCreated = list[i].DateCreated.UTC()
+		list[i].setURLs()
+	}
+
+	*imgs = list
+	return nil
 }
 
 // NewProduct type is expected from clients when creating a product.
+// The category and tags are optional.
 type NewProduct struct {
-	Name     string `json:"name" validate:"required"`
-	Cost     int    `json:"cost" validate:"gte=0"`
-	Quantity int    `json:"quantity" validate:"gte=1"`
+	Name        string      `json:"name" validate:"required"`
+	Description string      `json:"description"`
+	Cost        money.Money `json:"cost"`
+	Quantity    int         `json:"quantity" validate:"gte=1"`
+	CategoryID  *string     `json:"category_id" validate:"omitempty,uuid"`
+	Tags        []string    `json:"tags" validate:"dive,required,max=50"`
+}
+
+// EditProduct holds every field of a product that clients may change. Patches
+// are applied to it and the result is validated before it is stored.
+type EditProduct struct {
+	Name        string      `json:"name" validate:"required"`
+	Description string      `json:"description"`
+	Cost        money.Money `json:"cost"`
+	Quantity    int         `json:"quantity" validate:"gte=1"`
+	CategoryID  *string     `json:"category_id" validate:"omitempty,uuid"`
+	Tags        []string    `json:"tags" validate:"dive,required,max=50"`
 }
 
 // UpdateProduct defines what information may be provided to modify an
@@ -27,26 +129,110 @@ type NewProduct struct {
 // fields they want changed. It uses pointer fields so we can differentiate
 // between a field that was not provided and a field that was provided as
 // explicitly blank. Normally we do not want to use pointers to basic types but
-// we make exceptions around marshalling/unmarshalling.
+// we make exceptions around marshalling/unmarshalling. Tags replace all tags
+// of the product when set. Use a patch to remove a product from its category.
 type UpdateProduct struct {
-	Name     *string `json:"name"`
-	Cost     *int    `json:"cost" validate:"omitempty,gte=0"`
-	Quantity *int    `json:"quantity" validate:"omitempty,gte=1"`
+	Name        *string      `json:"name"`
+	Description *string      `json:"description"`
+	Cost        *money.Money `json:"cost"`
+	Quantity    *int         `json:"quantity" validate:"omitempty,gte=1"`
+	CategoryID  *string      `json:"category_id" validate:"omitempty,uuid"`
+	Tags        []string     `json:"tags" validate:"omitempty,dive,required,max=50"`
 }
 
+// These are the kinds of entries recorded in the sales table. Refunds and
+// voids are reversing entries: they hold negative quantities and amounts
+// and point back to the sale they reverse, which itself is never changed.
+const (
+	KindSale   = "SALE"
+	KindRefund = "REFUND"
+	KindVoid   = "VOID"
+)
+
 // Sale type denotes a single sale transaction of a product.
 // Quantity is the number of items of a product were sold in this transaction.
-// Paid is the cumulative amount that was paid for this transaction
+// Paid is the cumulative amount that was paid for this transaction, always
+// in the currency of the product.
+// OrderID is set when the sale is a line of a multi-item order.
 type Sale struct {
-	ID          string    `db:"sale_id" json:"id"`
-	ProductID   string    `db:"product_id" json:"product_id"`
-	Quantity    int       `db:"quantity" json:"quantity" validate:"gte=0"`
-	Paid        int       `db:"paid" json:"paid" validate:"gte=0"`
-	DateCreated time.Time `db:"date_created" json:"date_created"`
+	ID          string      `db:"sale_id" json:"id"`
+	ProductID   string      `db:"product_id" json:"product_id"`
+	OrderID     *string     `db:"order_id" json:"order_id,omitempty"`
+	Quantity    int         `db:"quantity" json:"quantity" validate:"gte=0"`
+	Paid        money.Money `db:"paid" json:"paid"`
+	Kind        string      `db:"kind" json:"kind"`
+	ReversesID  *string     `db:"reverses_sale_id" json:"reverses_sale_id,omitempty"`
+	Reason      string      `db:"reason" json:"reason,omitempty"`
+	UserID      string      `db:"user_id" json:"user_id"`
+	DateCreated time.Time   `db:"date_created" json:"date_created"`
 }
 
-// NewSale is the form for recording a transaction.
+// NewSale is the form for recording a transaction. Paid must be in the
+// currency of the product.
 type NewSale struct {
-	Quantity int `json:"quantity"`
-	Paid     int `json:"paid"`
+	Quantity int         `json:"quantity" validate:"gte=1"`
+	Paid     money.Money `json:"paid"`
+}
+
+// NewRefund is the form for refunding part or all of a sale. Quantity is the
+// number of items returned to stock and may be zero when only money is given
+// back. Paid is the amount returned to the buyer in the currency of the sale.
+type NewRefund struct {
+	Quantity int         `json:"quantity" validate:"gte=0"`
+	Paid     money.Money `json:"paid"`
+	Reason   string      `json:"reason" validate:"required"`
+}
+
+// NewVoid is the form for voiding a sale that was recorded by mistake.
+type NewVoid struct {
+	Reason string `json:"reason" validate:"required"`
+}
+
+// ListQuery describes which products a client wants to see and in what order.
+// Only products of the organization OrgID are listed, by default the first
+// page of them, oldest first. Deleted products are left out unless
+// IncludeDeleted is set. Pointer fields are optional filters that are only
+// applied when set. CategoryID matches products in the category or any of its
+// subcategories and products must carry every one of Tags. Search looks for
+// products by the words in their name and description, tolerating typos;
+// matches are ordered by relevance unless Sort is set. Currency limits the
+// list to products sold in it. MinCost and MaxCost are in its minor unit, so
+// they can only be used together with Currency.
+type ListQuery struct {
+	OrgID          string
+	Page           int
+	Limit          int
+	Name           string
+	Search         string
+	Currency       string
+	MinCost        *int64
+	MaxCost        *int64
+	InStock        bool
+	UserID         string
+	CategoryID     string
+	Tags           []string
+	Sort           string
+	IncludeDeleted bool
+}
+
+// Facets summarize the products matching a ListQuery for building
+// navigation. Categories count the products in each category including its
+// subcategories. Only categories and tags with matching products are listed.
+type Facets struct {
+	Categories []CategoryFacet `json:"categories"`
+	Tags       []TagFacet      `json:"tags"`
+}
+
+// CategoryFacet is the number of matching products in a category.
+type CategoryFacet struct {
+	ID       string  `db:"category_id" json:"id"`
+	ParentID *string `db:"parent_id" json:"parent_id"`
+	Name     string  `db:"name" json:"name"`
+	Count    int     `db:"count" json:"count"`
+}
+
+// TagFacet is the number of matching products carrying a tag.
+type TagFacet struct {
+	Name  string `db:"name" json:"name"`
+	Count int    `db:"count" json:"count"`
 }
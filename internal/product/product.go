@@ -7,8 +7,14 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
+	"github.com/sreejeet/garagesale/internal/category"
 	"github.com/sreejeet/garagesale/internal/platform/auth"
+	"github.com/sreejeet/garagesale/internal/platform/money"
+	"github.com/sreejeet/garagesale/internal/platform/storage"
+	"github.com/sreejeet/garagesale/internal/platform/web"
+	"github.com/sreejeet/garagesale/internal/tag"
 	"go.opencensus.io/trace"
 )
 
@@ -20,32 +26,85 @@ var (
 	ErrNotFound = errors.New("product not found")
 	// ErrForbidden occurs when a user tries something they dont have access to.
 	ErrForbidden = errors.New("Attempted action is not allowed")
+	// ErrVersionConflict occurs when a product was changed by someone else
+	// since the client read it.
+	ErrVersionConflict = errors.New("product was modified concurrently")
+	// ErrInvalidCategory occurs when a product is put in a category that does
+	// not exist in its organization.
+	ErrInvalidCategory = errors.New("invalid category")
+	// ErrCurrencyChange occurs when changing the currency of a product that
+	// was already sold, which would mix currencies in its revenue.
+	ErrCurrencyChange = errors.New("currency of a product with sales can not change")
 )
 
-// List retrieves all products from the database
-func List(ctx context.Context, db *sqlx.DB) ([]Product, error) {
+// List retrieves one page of products matching the query from the database.
+// It also returns the total number of matching products so callers can page
+// through the rest of them.
+func List(ctx context.Context, db *sqlx.DB, q ListQuery) ([]Product, int, error) {
 
 	ctx, span := trace.StartSpan(ctx, "internal.product.List")
 	defer span.End()
 
+	if q.Page < 1 {
+		q.Page = 1
+	}
+	if q.Limit < 1 {
+		q.Limit = web.DefaultLimit
+	}
+
+	b, err := newQueryBuilder(q)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	query, args := b.countQuery()
+	if err := db.GetContext(ctx, &total, query, args...); err != nil {
+		return nil, 0, errors.Wrap(err, "counting products")
+	}
+
 	products := []Product{}
-	const query = `SELECT
-						p.*,
-						COALESCE(SUM(s.quantity), 0) AS sold,
-						COALESCE(SUM(s.paid), 0) AS revenue
-					FROM products AS p
-					LEFT JOIN sales AS s ON p.product_id = s.product_id
-					GROUP BY p.product_id`
+	query, args = b.selectQuery(q.Page, q.Limit)
+	if err := db.SelectContext(ctx, &products, query, args...); err != nil {
+		return nil, 0, errors.Wrap(err, "selecting products")
+	}
+
+	return products, total, nil
+}
+
+// ListFacets counts the products matching the query per category and per tag.
+// Paging and sorting of the query are ignored.
+func ListFacets(ctx context.Context, db *sqlx.DB, q ListQuery) (*Facets, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.product.ListFacets")
+	defer span.End()
+
+	b, err := newQueryBuilder(q)
+	if err != nil {
+		return nil, err
+	}
 
-	if err := db.SelectContext(ctx, &products, query); err != nil {
-		return nil, errors.Wrap(err, "selecting products")
+	f := Facets{
+		Categories: []CategoryFacet{},
+		Tags:       []TagFacet{},
 	}
 
-	return products, nil
+	query, args := b.categoryFacetsQuery()
+	if err := db.SelectContext(ctx, &f.Categories, query, args...); err != nil {
+		return nil, errors.Wrap(err, "counting products per category")
+	}
+
+	query, args = b.tagFacetsQuery()
+	if err := db.SelectContext(ctx, &f.Tags, query, args...); err != nil {
+		return nil, errors.Wrap(err, "counting products per tag")
+	}
+
+	return &f, nil
 }
 
-// Retrieve is used to get a single product based on its ID from the URL parameter.
-func Retrieve(ctx context.Context, db *sqlx.DB, id string) (*Product, error) {
+// Retrieve is used to get a single product of the organization orgID based on its ID.
+// Deleted products are reported as not found unless includeDeleted is set.
+func Retrieve(ctx context.Context, db *sqlx.DB, orgID, id string, includeDeleted bool) (*Product, error) {
 
 	ctx, span := trace.StartSpan(ctx, "internal.product.Retrieve")
 	defer span.End()
@@ -54,19 +113,17 @@ func Retrieve(ctx context.Context, db *sqlx.DB, id string) (*Product, error) {
 	if _, err := uuid.Parse(id); err != nil {
 		return nil, ErrInvalidID
 	}
+	if _, err := uuid.Parse(orgID); err != nil {
+		return nil, ErrInvalidID
+	}
 
 	var prod Product
 
-	const query = `SELECT
-						p.*,
-						COALESCE(SUM(s.quantity), 0) AS sold,
-						COALESCE(SUM(s.paid), 0) AS revenue
-					FROM products AS p
-					LEFT JOIN sales AS s ON p.product_id = s.product_id
-					WHERE p.product_id = $1
-					GROUP BY p.product_id`
+	// Products of other organizations are reported as not found.
+	const query = selectProducts + ` WHERE p.product_id = $1 AND p.org_id = $2
+					AND ($3 OR p.deleted_at IS NULL)`
 
-	if err := db.GetContext(ctx, &prod, query, id); err != nil {
+	if err := db.GetContext(ctx, &prod, query, id, orgID, includeDeleted); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ErrNotFound
 		}
@@ -77,86 +134,237 @@ func Retrieve(ctx context.Context, db *sqlx.DB, id string) (*Product, error) {
 }
 
 // Create creates a new product int the database and return the created product.
+// The product belongs to the active organization of the user.
 func Create(ctx context.Context, db *sqlx.DB, user auth.Claims, newProd NewProduct, now time.Time) (*Product, error) {
 
 	ctx, span := trace.StartSpan(ctx, "internal.product.Create")
 	defer span.End()
 
-	prod := Product{
+	prod := newProduct(user, newProd, now)
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting product transaction")
+	}
+
+	// Rolling back after a commit is a no-op so this is safe to defer.
+	defer tx.Rollback()
+
+	if err := insertProduct(ctx, tx, prod, newProd.Tags, now); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing product")
+	}
+
+	// Read the tags back as they are normalized and sorted when saved.
+	if len(newProd.Tags) > 0 {
+		return Retrieve(ctx, db, prod.OrgID, prod.ID, false)
+	}
+
+	return &prod, nil
+}
+
+// newProduct builds a product of the user that has not been sold yet.
+func newProduct(user auth.Claims, np NewProduct, now time.Time) Product {
+	return Product{
 		ID:          uuid.New().String(),
 		UserID:      user.Subject,
-		Name:        newProd.Name,
-		Cost:        newProd.Cost,
-		Quantity:    newProd.Quantity,
+		OrgID:       user.OrgID,
+		CategoryID:  np.CategoryID,
+		Name:        np.Name,
+		Description: np.Description,
+		Cost:        np.Cost,
+		Quantity:    np.Quantity,
+		Tags:        pq.StringArray{},
+		Images:      Images{},
+		Revenue:     money.New(0, np.Cost.Currency),
+		Stock:       np.Quantity,
+		Version:     1,
 		DateCreated: now.UTC(),
 		DateUpdated: now.UTC(),
 	}
+}
+
+// insertProduct writes a new product and its tags as part of a transaction.
+func insertProduct(ctx context.Context, tx *sqlx.Tx, prod Product, tags []string, now time.Time) error {
+
+	if err := checkCategory(ctx, tx, prod.OrgID, prod.CategoryID); err != nil {
+		return err
+	}
 
 	const query = `INSERT INTO products
-		(product_id, user_id, name, cost, quantity, date_created, date_updated)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+		(product_id, user_id, org_id, category_id, name, description, cost, quantity, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
 
-	_, err := db.ExecContext(ctx, query,
-		prod.ID, prod.UserID,
-		prod.Name, prod.Cost, prod.Quantity,
+	_, err := tx.ExecContext(ctx, query,
+		prod.ID, prod.UserID, prod.OrgID, prod.CategoryID,
+		prod.Name, prod.Description, prod.Cost, prod.Quantity,
 		prod.DateCreated, prod.DateUpdated)
 
 	if err != nil {
-		return nil, errors.Wrap(err, "Creating new product")
+		return errors.Wrap(err, "Creating new product")
 	}
 
-	return &prod, nil
+	return tag.Set(ctx, tx, prod.OrgID, prod.ID, tags, now)
+}
+
+// checkCategory makes sure a product of the organization orgID can be put
+// in the category id. Products do not need to be in a category.
+func checkCategory(ctx context.Context, db sqlx.QueryerContext, orgID string, id *string) error {
+
+	if id == nil {
+		return nil
+	}
+
+	ok, err := category.Exists(ctx, db, orgID, *id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidCategory
+	}
+
+	return nil
 }
 
-// Update modifies an existing product.
-func Update(ctx context.Context, db *sqlx.DB, user auth.Claims, id string, update UpdateProduct, now time.Time) error {
+// Update modifies an existing product, changing only the fields that are set
+// in update. Version works like in Patch.
+func Update(ctx context.Context, db *sqlx.DB, user auth.Claims, id string, version int, update UpdateProduct, now time.Time) error {
 
 	ctx, span := trace.StartSpan(ctx, "internal.product.Update")
 	defer span.End()
 
+	// Only update fields that have been passed as all fields are optional
+	apply := func(e *EditProduct) error {
+		if update.Name != nil {
+			e.Name = *update.Name
+		}
+		if update.Description != nil {
+			e.Description = *update.Description
+		}
+		if update.Cost != nil {
+			e.Cost = *update.Cost
+		}
+		if update.Quantity != nil {
+			e.Quantity = *update.Quantity
+		}
+		if update.CategoryID != nil {
+			e.CategoryID = update.CategoryID
+		}
+		if update.Tags != nil {
+			e.Tags = update.Tags
+		}
+		return nil
+	}
+
+	return Patch(ctx, db, user, id, version, apply, now)
+}
+
+// Patch modifies an existing product by letting apply change its editable
+// fields. Errors returned by apply are returned unchanged. When version is not
+// zero the product must still be at that version. In any case the change is
+// only written if nobody else changed the product since it was read;
+// ErrVersionConflict is returned otherwise.
+func Patch(ctx context.Context, db *sqlx.DB, user auth.Claims, id string, version int, apply func(*EditProduct) error, now time.Time) error {
+
+	ctx, span := trace.StartSpan(ctx, "internal.product.Patch")
+	defer span.End()
+
 	// Use the retrieve function to get the product to be updated.
-	p, err := Retrieve(ctx, db, id)
+	p, err := Retrieve(ctx, db, user.OrgID, id, false)
 	if err != nil {
 		return err
 	}
 
-	// Only allow this action to be performed by an admin
-	// or the owner of this product.
-	if !user.HasRole(auth.RoleAdmin) && p.UserID != user.Subject {
-		return ErrForbidden
+	// Only allow this action to be performed by clients who may update
+	// any product or by the owner of this product.
+	if err := checkUpdate(ctx, db, user, p); err != nil {
+		return err
 	}
 
-	// Only update fields that have been passed as all fields are optional
-	if update.Name != nil {
-		p.Name = *update.Name
+	if version != 0 && version != p.Version {
+		return ErrVersionConflict
 	}
-	if update.Cost != nil {
-		p.Cost = *update.Cost
+
+	e := EditProduct{
+		Name:        p.Name,
+		Description: p.Description,
+		Cost:        p.Cost,
+		Quantity:    p.Quantity,
+		CategoryID:  p.CategoryID,
+		Tags:        p.Tags,
 	}
-	if update.Quantity != nil {
-		p.Quantity = *update.Quantity
+	if err := apply(&e); err != nil {
+		return err
 	}
-	p.DateUpdated = now
 
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting product transaction")
+	}
+
+	// Rolling back after a commit is a no-op so this is safe to defer.
+	defer tx.Rollback()
+
+	if err := checkCategory(ctx, tx, p.OrgID, e.CategoryID); err != nil {
+		return err
+	}
+	if e.Cost.Currency != p.Cost.Currency {
+		var sold bool
+		const q = `SELECT EXISTS (SELECT 1 FROM sales WHERE product_id = $1)`
+		if err := tx.GetContext(ctx, &sold, q, id); err != nil {
+			return errors.Wrap(err, "checking for sales")
+		}
+		if sold {
+			return ErrCurrencyChange
+		}
+	}
+
+	// The version read above guards against a concurrent update
+	// between reading and writing the product.
 	const q = `UPDATE products SET
                "name" = $2,
-               "cost" = $3,
-               "quantity" = $4,
-               "date_updated" = $5
-               WHERE product_id = $1`
-	_, err = db.ExecContext(ctx, q, id,
-		p.Name, p.Cost,
-		p.Quantity, p.DateUpdated,
+               "description" = $3,
+               "cost" = $4,
+               "quantity" = $5,
+               "category_id" = $6,
+               "date_updated" = $7,
+               "version" = version + 1
+               WHERE product_id = $1 AND version = $8`
+	res, err := tx.ExecContext(ctx, q, id,
+		e.Name, e.Description, e.Cost,
+		e.Quantity, e.CategoryID, now,
+		p.Version,
 	)
 	if err != nil {
 		return errors.Wrap(err, "updating product")
 	}
 
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "counting updated products")
+	}
+	if n == 0 {
+		return ErrVersionConflict
+	}
+
+	if err := tag.Set(ctx, tx, p.OrgID, id, e.Tags, now); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing product")
+	}
+
 	return nil
 }
 
-// Delete removes products from the database based on the id provided.
-func Delete(ctx context.Context, db *sqlx.DB, id string) error {
+// Delete archives a product of the organization orgID. The product and its
+// sales stay in the database so revenue figures are kept, but it is hidden and
+// can no longer be sold. Deleting a product twice keeps the first time.
+func Delete(ctx context.Context, db *sqlx.DB, orgID, id string, now time.Time) error {
 
 	ctx, span := trace.StartSpan(ctx, "internal.product.Delete")
 	defer span.End()
@@ -164,12 +372,102 @@ func Delete(ctx context.Context, db *sqlx.DB, id string) error {
 	if _, err := uuid.Parse(id); err != nil {
 		return ErrInvalidID
 	}
+	if _, err := uuid.Parse(orgID); err != nil {
+		return ErrInvalidID
+	}
 
-	const q = `DELETE FROM products WHERE product_id = $1`
+	const q = `UPDATE products SET deleted_at = $3, version = version + 1
+				WHERE product_id = $1 AND org_id = $2 AND deleted_at IS NULL`
 
-	if _, err := db.ExecContext(ctx, q, id); err != nil {
+	if _, err := db.ExecContext(ctx, q, id, orgID, now.UTC()); err != nil {
 		return errors.Wrapf(err, "deleting product %s", id)
 	}
 
 	return nil
 }
+
+// Restore brings back a deleted product of the organization orgID.
+func Restore(ctx context.Context, db *sqlx.DB, orgID, id string) error {
+
+	ctx, span := trace.StartSpan(ctx, "internal.product.Restore")
+	defer span.End()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+	if _, err := uuid.Parse(orgID); err != nil {
+		return ErrInvalidID
+	}
+
+	const q = `UPDATE products SET deleted_at = NULL, version = version + 1
+				WHERE product_id = $1 AND org_id = $2 AND deleted_at IS NOT NULL`
+
+	res, err := db.ExecContext(ctx, q, id, orgID)
+	if err != nil {
+		return errors.Wrapf(err, "restoring product %s", id)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "counting restored products")
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Purge permanently removes products of every organization that were deleted
+// before the provided time, together with their sales and images. It returns
+// how many products were removed.
+func Purge(ctx context.Context, db *sqlx.DB, store storage.BlobStore, before time.Time) (int64, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.product.Purge")
+	defer span.End()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "starting purge transaction")
+	}
+
+	// Rolling back after a commit is a no-op so this is safe to defer.
+	defer tx.Rollback()
+
+	// Deleted products can not get new images so the
+	// images read here are all the ones to remove.
+	var images []Image
+	const imgs = `SELECT i.* FROM product_images AS i
+					JOIN products AS p ON p.product_id = i.product_id
+					WHERE p.deleted_at < $1`
+	if err := tx.SelectContext(ctx, &images, imgs, before.UTC()); err != nil {
+		return 0, errors.Wrap(err, "selecting images of purged products")
+	}
+
+	const q = `DELETE FROM products WHERE deleted_at < $1`
+
+	res, err := tx.ExecContext(ctx, q, before.UTC())
+	if err != nil {
+		return 0, errors.Wrap(err, "purging products")
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "counting purged products")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, errors.Wrap(err, "committing purge")
+	}
+
+	for _, img := range images {
+		if err := store.Delete(ctx, img.Key()); err != nil {
+			return n, err
+		}
+		if err := store.Delete(ctx, img.ThumbnailKey()); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
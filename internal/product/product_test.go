@@ -1,12 +1,21 @@
 package product_test
 
 import (
+	"bytes"
 	"context"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/sreejeet/garagesale/internal/org"
 	"github.com/sreejeet/garagesale/internal/platform/auth"
+	"github.com/sreejeet/garagesale/internal/platform/money"
+	"github.com/sreejeet/garagesale/internal/platform/storage"
 	"github.com/sreejeet/garagesale/internal/product"
 	"github.com/sreejeet/garagesale/internal/schema"
 	"github.com/sreejeet/garagesale/internal/tests"
@@ -18,7 +27,7 @@ func TestProducts(t *testing.T) {
 
 	newP := product.NewProduct{
 		Name:     "Bite my shiny metal as - Bender B Rodríguez",
-		Cost:     2999,
+		Cost:     money.New(2999, "USD"),
 		Quantity: 1,
 	}
 	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
@@ -30,13 +39,14 @@ func TestProducts(t *testing.T) {
 		[]string{auth.RoleAdmin, auth.RoleUser},
 		now, time.Hour,
 	)
+	claims.OrgID = org.DefaultID
 
 	p0, err := product.Create(ctx, db, claims, newP, now)
 	if err != nil {
 		t.Fatalf("creating product: %s", err)
 	}
 
-	p1, err := product.Retrieve(ctx, db, p0.ID)
+	p1, err := product.Retrieve(ctx, db, org.DefaultID, p0.ID, false)
 	if err != nil {
 		t.Fatalf("getting product: %s", err)
 	}
@@ -45,17 +55,31 @@ func TestProducts(t *testing.T) {
 		t.Fatalf("fetched product not same as created product:\n%s", diff)
 	}
 
+	// Other organizations can not see the product.
+	const otherOrg = "8c1f0b5e-2f4d-4a7e-9b8e-3d7a1c2b4e5f"
+	if _, err := product.Retrieve(ctx, db, otherOrg, p0.ID, false); err != product.ErrNotFound {
+		t.Fatalf("expected %v reading product of another organization, got %v", product.ErrNotFound, err)
+	}
+	if _, total, err := product.List(ctx, db, product.ListQuery{OrgID: otherOrg}); err != nil || total != 0 {
+		t.Fatalf("expected no products in another organization, got %v, %v", total, err)
+	}
+
 	update := product.UpdateProduct{
 		Name: tests.StringPointer("Updated Name"),
-		Cost: tests.IntPointer(51),
+		Cost: &money.Money{Amount: 5100, Currency: "USD"},
 	}
 	updatedTime := time.Date(2020, time.January, 1, 1, 1, 1, 0, time.UTC)
 
-	if err := product.Update(ctx, db, claims, p0.ID, update, updatedTime); err != nil {
+	if err := product.Update(ctx, db, claims, p0.ID, p0.Version, update, updatedTime); err != nil {
 		t.Fatalf("updating product p0: %s", err)
 	}
 
-	saved, err := product.Retrieve(ctx, db, p0.ID)
+	// Updates based on the old version must not overwrite the change.
+	if err := product.Update(ctx, db, claims, p0.ID, p0.Version, update, updatedTime); err != product.ErrVersionConflict {
+		t.Fatalf("expected %v updating stale version, got %v", product.ErrVersionConflict, err)
+	}
+
+	saved, err := product.Retrieve(ctx, db, org.DefaultID, p0.ID, false)
 	if err != nil {
 		t.Fatalf("getting product p0: %s", err)
 	}
@@ -64,23 +88,111 @@ func TestProducts(t *testing.T) {
 	// and change just the fields we expect then diff it with what was saved.
 	want := *p0
 	want.Name = "Updated Name"
-	want.Cost = 51
+	want.Cost = money.New(5100, "USD")
 	want.DateUpdated = updatedTime
+	want.Version = p0.Version + 1
 
 	if diff := cmp.Diff(want, *saved); diff != "" {
 		t.Fatalf("updated record did not match:\n%s", diff)
 	}
 
 	// Check if product delete works
-	if err := product.Delete(ctx, db, p0.ID); err != nil {
+	if err := product.Delete(ctx, db, org.DefaultID, p0.ID, now); err != nil {
 		t.Fatalf("deleting product: %v", err)
 	}
 
-	_, err = product.Retrieve(ctx, db, p0.ID)
+	_, err = product.Retrieve(ctx, db, org.DefaultID, p0.ID, false)
 	if err == nil {
 		t.Fatalf("should not be able to retrieve deleted product")
 	}
 
+	// Deleted products are only archived.
+	deleted, err := product.Retrieve(ctx, db, org.DefaultID, p0.ID, true)
+	if err != nil {
+		t.Fatalf("getting deleted product: %s", err)
+	}
+	if deleted.DeletedAt == nil || !deleted.DeletedAt.Equal(now) {
+		t.Fatalf("expected product deleted at %v, got %v", now, deleted.DeletedAt)
+	}
+	if _, total, err := product.List(ctx, db, product.ListQuery{OrgID: org.DefaultID}); err != nil || total != 0 {
+		t.Fatalf("expected deleted product to be hidden, got %v, %v", total, err)
+	}
+	if _, total, err := product.List(ctx, db, product.ListQuery{OrgID: org.DefaultID, IncludeDeleted: true}); err != nil || total != 1 {
+		t.Fatalf("expected deleted product to be listed on request, got %v, %v", total, err)
+	}
+	if _, err := product.AddSale(ctx, db, claims, product.NewSale{Quantity: 1}, p0.ID, now); err != product.ErrNotFound {
+		t.Fatalf("expected %v selling deleted product, got %v", product.ErrNotFound, err)
+	}
+
+	if err := product.Restore(ctx, db, org.DefaultID, p0.ID); err != nil {
+		t.Fatalf("restoring product: %s", err)
+	}
+	if err := product.Restore(ctx, db, org.DefaultID, p0.ID); err != product.ErrNotFound {
+		t.Fatalf("expected %v restoring product that is not deleted, got %v", product.ErrNotFound, err)
+	}
+	if _, err := product.Retrieve(ctx, db, org.DefaultID, p0.ID, false); err != nil {
+		t.Fatalf("getting restored product: %s", err)
+	}
+
+	// Images are stored with a thumbnail.
+	dir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := storage.NewFS(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pic bytes.Buffer
+	if err := png.Encode(&pic, image.NewRGBA(image.Rect(0, 0, 640, 320))); err != nil {
+		t.Fatal(err)
+	}
+	img, err := product.AddImage(ctx, db, store, claims, p0.ID, pic.Bytes(), now)
+	if err != nil {
+		t.Fatalf("adding image: %s", err)
+	}
+	if img.ContentType != "image/png" || img.Width != 640 || img.Height != 320 {
+		t.Fatalf("unexpected image %+v", img)
+	}
+	thumb, err := store.Get(ctx, img.ThumbnailKey())
+	if err != nil {
+		t.Fatalf("getting thumbnail: %s", err)
+	}
+	cfg, err := png.DecodeConfig(thumb)
+	thumb.Close()
+	if err != nil || cfg.Width != product.ThumbnailSize || cfg.Height != product.ThumbnailSize/2 {
+		t.Fatalf("expected thumbnail of %dx%d, got %+v, %v", product.ThumbnailSize, product.ThumbnailSize/2, cfg, err)
+	}
+	if _, err := product.AddImage(ctx, db, store, claims, p0.ID, []byte("not a picture"), now); err != product.ErrImageType {
+		t.Fatalf("expected %v adding text, got %v", product.ErrImageType, err)
+	}
+
+	withImage, err := product.Retrieve(ctx, db, org.DefaultID, p0.ID, false)
+	if err != nil {
+		t.Fatalf("getting product with image: %s", err)
+	}
+	if len(withImage.Images) != 1 || withImage.Images[0].ID != img.ID || withImage.Images[0].URL != img.URL {
+		t.Fatalf("expected product to list image %+v, got %+v", img, withImage.Images)
+	}
+
+	// Purging only removes products deleted before the retention period.
+	if err := product.Delete(ctx, db, org.DefaultID, p0.ID, now); err != nil {
+		t.Fatalf("deleting product: %v", err)
+	}
+	if n, err := product.Purge(ctx, db, store, now); err != nil || n != 0 {
+		t.Fatalf("expected nothing purged, got %v, %v", n, err)
+	}
+	if n, err := product.Purge(ctx, db, store, now.Add(time.Second)); err != nil || n != 1 {
+		t.Fatalf("expected one product purged, got %v, %v", n, err)
+	}
+	if _, err := product.Retrieve(ctx, db, org.DefaultID, p0.ID, true); err != product.ErrNotFound {
+		t.Fatalf("expected %v after purge, got %v", product.ErrNotFound, err)
+	}
+	if _, err := store.Get(ctx, img.Key()); err != storage.ErrNotFound {
+		t.Fatalf("expected %v for image of purged product, got %v", storage.ErrNotFound, err)
+	}
 }
 
 func TestProductList(t *testing.T) {
@@ -91,11 +203,123 @@ func TestProductList(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	ps, err := product.List(context.Background(), db)
+	ctx := context.Background()
+
+	ps, total, err := product.List(ctx, db, product.ListQuery{OrgID: org.DefaultID})
 	if err != nil {
 		t.Fatalf("listing products: %s", err)
 	}
 	if exp, got := 2, len(ps); exp != got {
 		t.Fatalf("expected product list size %v, got %v", exp, got)
 	}
+	if exp, got := 2, total; exp != got {
+		t.Fatalf("expected product total %v, got %v", exp, got)
+	}
+
+	// Filtering and sorting should narrow and reorder the list
+	// while the total reflects every matching product.
+	q := product.ListQuery{
+		OrgID:    org.DefaultID,
+		Limit:    1,
+		Currency: "USD",
+		MinCost:  tests.Int64Pointer(6000),
+		Sort:     "-revenue",
+	}
+	ps, total, err = product.List(ctx, db, q)
+	if err != nil {
+		t.Fatalf("listing filtered products: %s", err)
+	}
+	if exp, got := 1, total; exp != got {
+		t.Fatalf("expected filtered product total %v, got %v", exp, got)
+	}
+	if exp, got := "McDonalds Toys", ps[0].Name; exp != got {
+		t.Fatalf("expected filtered product %q, got %q", exp, got)
+	}
+
+	q = product.ListQuery{OrgID: org.DefaultID, Limit: 1, Page: 2, Sort: "-sold"}
+	ps, total, err = product.List(ctx, db, q)
+	if err != nil {
+		t.Fatalf("listing second page: %s", err)
+	}
+	if exp, got := 2, total; exp != got {
+		t.Fatalf("expected product total %v, got %v", exp, got)
+	}
+	if exp, got := "McDonalds Toys", ps[0].Name; exp != got {
+		t.Fatalf("expected product %q on second page, got %q", exp, got)
+	}
+
+	// Searching matches words and tolerates typos.
+	for search, exp := range map[string]string{"books": "Comic Books", "McDonals": "McDonalds Toys"} {
+		ps, total, err = product.List(ctx, db, product.ListQuery{OrgID: org.DefaultID, Search: search})
+		if err != nil {
+			t.Fatalf("searching products: %s", err)
+		}
+		if total != 1 || ps[0].Name != exp {
+			t.Fatalf("expected only %q searching %q, got %+v", exp, search, ps)
+		}
+	}
+
+	if _, _, err := product.List(ctx, db, product.ListQuery{OrgID: org.DefaultID, Sort: "password"}); err != product.ErrInvalidSort {
+		t.Fatalf("expected %v for unknown sort field, got %v", product.ErrInvalidSort, err)
+	}
+}
+
+func TestImport(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	claims := auth.NewClaims(
+		"718ffbea-f4a1-4667-8ae3-b349da52675e",
+		[]string{auth.RoleAdmin, auth.RoleUser},
+		now, time.Hour,
+	)
+	claims.OrgID = org.DefaultID
+
+	const file = "name,description,cost,currency,quantity,category_id,tags\n" +
+		"Lamp,Reading lamp,12.50,USD,3,,home;light\n" +
+		"Chair,,12.505,USD,1,,\n" +
+		"Desk,,80,USD,1,5f6a2f1e-9c3b-4b43-8a55-1f0a9a2d7c10,\n" +
+		",,1,USD,1,,\n"
+
+	// A single bad row keeps all-or-nothing imports from writing anything.
+	res, err := product.Import(ctx, db, claims, strings.NewReader(file), product.FormatCSV, product.ImportAllOrNothing, now)
+	if err != nil {
+		t.Fatalf("importing all or nothing: %s", err)
+	}
+	if res.Imported != 0 || len(res.Products) != 0 {
+		t.Fatalf("expected nothing imported, got %+v", res)
+	}
+	if _, total, err := product.List(ctx, db, product.ListQuery{OrgID: org.DefaultID}); err != nil || total != 0 {
+		t.Fatalf("expected no products after a failed import, got %v, %v", total, err)
+	}
+
+	res, err = product.Import(ctx, db, claims, strings.NewReader(file), product.FormatCSV, product.ImportBestEffort, now)
+	if err != nil {
+		t.Fatalf("importing best effort: %s", err)
+	}
+	if res.Rows != 4 || res.Imported != 1 {
+		t.Fatalf("expected one of four rows imported, got %+v", res)
+	}
+	var rows []int
+	for _, re := range res.Errors {
+		rows = append(rows, re.Row)
+	}
+	if diff := cmp.Diff([]int{2, 3, 4}, rows); diff != "" {
+		t.Fatalf("unexpected rows with errors:\n%s", diff)
+	}
+
+	p, err := product.Retrieve(ctx, db, org.DefaultID, res.Products[0], false)
+	if err != nil {
+		t.Fatalf("getting imported product: %s", err)
+	}
+	if p.Name != "Lamp" || p.Cost != money.New(1250, "USD") || len(p.Tags) != 2 {
+		t.Fatalf("unexpected imported product %+v", p)
+	}
+
+	if _, err := product.Import(ctx, db, claims, strings.NewReader("name,price\n"), product.FormatCSV, product.ImportBestEffort, now); err != product.ErrImportHeader {
+		t.Fatalf("expected %v for an unknown column, got %v", product.ErrImportHeader, err)
+	}
 }
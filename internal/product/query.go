@@ -0,0 +1,241 @@
+package product
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/sreejeet/garagesale/internal/tag"
+)
+
+// Errors of invalid list queries.
+var (
+	// ErrInvalidSort occurs when a client asks to order products by a field
+	// that is not supported.
+	ErrInvalidSort = errors.New("invalid sort field")
+	// ErrMissingCurrency occurs when filtering by cost without saying which
+	// currency the amounts are in.
+	ErrMissingCurrency = errors.New("cost filters require a currency")
+)
+
+// selectProducts is the base query for reading products together with the
+// aggregated figures of their sales, their tags and their images. Sales are
+// summed in a subquery so the outer query can filter and sort on sold and
+// revenue like any other column. The search vector is only used for
+// filtering and is not selected. Sales are always in the currency of their
+// product so the revenue is too.
+const selectProducts = `SELECT
+						p.product_id, p.user_id, p.org_id, p.category_id,
+						p.name, p.description, p.cost, p.quantity, p.version,
+						p.date_created, p.date_updated, p.deleted_at,
+						COALESCE(s.sold, 0) AS sold,
+						ROW(COALESCE(s.revenue, 0), (p.cost).currency)::money_value AS revenue,
+						p.quantity - COALESCE(s.sold, 0) AS stock,
+						COALESCE(tg.tags, '{}') AS tags,
+						COALESCE(img.images, '[]') AS images
+					FROM products AS p
+					LEFT JOIN (
+						SELECT product_id, SUM(quantity) AS sold, SUM((paid).amount) AS revenue
+						FROM sales
+						GROUP BY product_id
+					) AS s ON p.product_id = s.product_id
+					LEFT JOIN (
+						SELECT pt.product_id, ARRAY_AGG(t.name ORDER BY t.name) AS tags
+						FROM product_tags AS pt
+						JOIN tags AS t ON t.tag_id = pt.tag_id
+						GROUP BY pt.product_id
+					) AS tg ON p.product_id = tg.product_id
+					LEFT JOIN (
+						SELECT product_id, JSON_AGG(JSON_BUILD_OBJECT(
+							'id', image_id,
+							'product_id', product_id,
+							'content_type', content_type,
+							'size', size,
+							'width', width,
+							'height', height,
+							'date_created', date_created AT TIME ZONE 'UTC'
+						) ORDER BY date_created, image_id) AS images
+						FROM product_images
+						GROUP BY product_id
+					) AS img ON p.product_id = img.product_id`
+
+// sortColumns maps the sort keys accepted from clients to SQL expressions.
+// Only keys in this map may ever be written into a query.
+var sortColumns = map[string]string{
+	"name":         "p.name",
+	"cost":         "(p.cost).amount",
+	"date_created": "p.date_created",
+	"sold":         "sold",
+	"revenue":      "COALESCE(s.revenue, 0)",
+	"stock":        "stock",
+}
+
+// queryBuilder assembles the WHERE, ORDER BY and LIMIT clauses of a product
+// query while keeping track of the positional arguments for the driver.
+type queryBuilder struct {
+	where []string
+	order string
+	args  []interface{}
+}
+
+// newQueryBuilder translates a ListQuery into a queryBuilder.
+func newQueryBuilder(q ListQuery) (*queryBuilder, error) {
+
+	var b queryBuilder
+
+	if _, err := uuid.Parse(q.OrgID); err != nil {
+		return nil, ErrInvalidID
+	}
+	b.add("p.org_id = $%d", q.OrgID)
+
+	if !q.IncludeDeleted {
+		b.where = append(b.where, "p.deleted_at IS NULL")
+	}
+
+	if q.Name != "" {
+		b.add("p.name ILIKE $%d", "%"+escapeLike(q.Name)+"%")
+	}
+	var relevance string
+	if q.Search != "" {
+		b.args = append(b.args, q.Search)
+		n := len(b.args)
+
+		// Words are matched through the search vector while the trigram
+		// indexes find names and descriptions with similar spelling.
+		b.where = append(b.where, fmt.Sprintf(`(p.search_vector @@ websearch_to_tsquery('english', $%[1]d)
+					OR p.name %%> $%[1]d OR p.description %%> $%[1]d)`, n))
+		relevance = fmt.Sprintf(`ts_rank(p.search_vector, websearch_to_tsquery('english', $%[1]d))
+					+ GREATEST(word_similarity($%[1]d, p.name), word_similarity($%[1]d, p.description)) DESC`, n)
+	}
+	if q.Currency != "" {
+		b.add("(p.cost).currency = $%d", q.Currency)
+	} else if q.MinCost != nil || q.MaxCost != nil {
+		return nil, ErrMissingCurrency
+	}
+	if q.MinCost != nil {
+		b.add("(p.cost).amount >= $%d", *q.MinCost)
+	}
+	if q.MaxCost != nil {
+		b.add("(p.cost).amount <= $%d", *q.MaxCost)
+	}
+	if q.UserID != "" {
+		if _, err := uuid.Parse(q.UserID); err != nil {
+			return nil, ErrInvalidID
+		}
+		b.add("p.user_id = $%d", q.UserID)
+	}
+	if q.CategoryID != "" {
+		if _, err := uuid.Parse(q.CategoryID); err != nil {
+			return nil, ErrInvalidID
+		}
+		b.add(`p.category_id IN (
+					WITH RECURSIVE tree AS (
+						SELECT category_id FROM categories WHERE category_id = $%d
+						UNION ALL
+						SELECT c.category_id FROM categories AS c
+						JOIN tree ON c.parent_id = tree.category_id
+					)
+					SELECT category_id FROM tree
+				)`, q.CategoryID)
+	}
+	for _, name := range q.Tags {
+		b.add(`EXISTS (
+					SELECT 1 FROM product_tags AS pt
+					JOIN tags AS t ON t.tag_id = pt.tag_id
+					WHERE pt.product_id = p.product_id AND t.name = $%d
+				)`, tag.Normalize(name))
+	}
+	if q.InStock {
+		b.where = append(b.where, "p.quantity - COALESCE(s.sold, 0) > 0")
+	}
+
+	// Always finish with the primary key so pages are stable when
+	// several products share the same value for the sort column.
+	b.order = "p.date_created, p.product_id"
+	if relevance != "" {
+		b.order = relevance + ", p.product_id"
+	}
+	if q.Sort != "" {
+		key, dir := q.Sort, "ASC"
+		if strings.HasPrefix(key, "-") {
+			key, dir = key[1:], "DESC"
+		}
+		col, ok := sortColumns[key]
+		if !ok {
+			return nil, ErrInvalidSort
+		}
+		b.order = fmt.Sprintf("%s %s, p.product_id", col, dir)
+	}
+
+	return &b, nil
+}
+
+// add appends a condition holding a single placeholder verb which is
+// replaced with the position of arg.
+func (b *queryBuilder) add(cond string, arg interface{}) {
+	b.args = append(b.args, arg)
+	b.where = append(b.where, fmt.Sprintf(cond, len(b.args)))
+}
+
+// whereClause returns the combined conditions or an empty string.
+func (b *queryBuilder) whereClause() string {
+	if len(b.where) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(b.where, " AND ")
+}
+
+// selectQuery returns the query for one page of products and its arguments.
+func (b *queryBuilder) selectQuery(page, limit int) (string, []interface{}) {
+
+	args := append([]interface{}{}, b.args...)
+	args = append(args, limit, (page-1)*limit)
+
+	q := fmt.Sprintf("%s%s ORDER BY %s LIMIT $%d OFFSET $%d",
+		selectProducts, b.whereClause(), b.order, len(args)-1, len(args))
+
+	return q, args
+}
+
+// countQuery returns the query counting every product matching the filters.
+func (b *queryBuilder) countQuery() (string, []interface{}) {
+	q := fmt.Sprintf("SELECT COUNT(*) FROM (%s%s) AS filtered", selectProducts, b.whereClause())
+	return q, b.args
+}
+
+// categoryFacetsQuery returns the query counting the matching products in
+// every category of the organization, including those in subcategories. The
+// organization is always the first argument.
+func (b *queryBuilder) categoryFacetsQuery() (string, []interface{}) {
+	q := fmt.Sprintf(`WITH RECURSIVE tree AS (
+						SELECT category_id AS root, category_id FROM categories WHERE org_id = $1
+						UNION ALL
+						SELECT tree.root, c.category_id FROM categories AS c
+						JOIN tree ON c.parent_id = tree.category_id
+					)
+					SELECT c.category_id, c.parent_id, c.name, COUNT(f.product_id) AS count
+					FROM tree
+					JOIN categories AS c ON c.category_id = tree.root
+					JOIN (%s%s) AS f ON f.category_id = tree.category_id
+					GROUP BY c.category_id, c.parent_id, c.name
+					ORDER BY c.name, c.category_id`, selectProducts, b.whereClause())
+	return q, b.args
+}
+
+// tagFacetsQuery returns the query counting the matching products per tag.
+func (b *queryBuilder) tagFacetsQuery() (string, []interface{}) {
+	q := fmt.Sprintf(`SELECT t.name, COUNT(*) AS count
+					FROM (%s%s) AS f
+					JOIN product_tags AS pt ON pt.product_id = f.product_id
+					JOIN tags AS t ON t.tag_id = pt.tag_id
+					GROUP BY t.name
+					ORDER BY count DESC, t.name`, selectProducts, b.whereClause())
+	return q, b.args
+}
+
+// escapeLike escapes the wildcard characters of a LIKE pattern.
+func escapeLike(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s)
+}
@@ -2,53 +2,303 @@ package product
 
 import (
 	"context"
+	"database/sql"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
+	"github.com/sreejeet/garagesale/internal/platform/auth"
+	"github.com/sreejeet/garagesale/internal/platform/money"
 	"go.opencensus.io/trace"
 )
 
-// AddSale records a single sale transaction for a product.
-func AddSale(ctx context.Context, db *sqlx.DB, ns NewSale, productID string, now time.Time) (*Sale, error) {
+// Custom errors for expected failing conditions of sales.
+var (
+	// ErrInsufficientStock occurs when a sale asks for more items
+	// than are left in stock for a product.
+	ErrInsufficientStock = errors.New("not enough items in stock")
+	// ErrSaleNotFound occurs when a sale does not exist.
+	ErrSaleNotFound = errors.New("sale not found")
+	// ErrInvalidReversal occurs when a refund or void would take back more
+	// than was sold, or targets a sale that can not be reversed.
+	ErrInvalidReversal = errors.New("sale can not be reversed")
+	// ErrCurrency occurs when an amount paid is not in the currency
+	// of the product that was sold.
+	ErrCurrency = errors.New("amount is not in the currency of the product")
+)
+
+// AddSale records a single sale transaction for a product. The product row is
+// locked for the duration of the transaction so concurrent sales can not sell
+// the same items twice.
+func AddSale(ctx context.Context, db *sqlx.DB, user auth.Claims, ns NewSale, productID string, now time.Time) (*Sale, error) {
 
 	ctx, span := trace.StartSpan(ctx, "internal.product.AddSale")
 	defer span.End()
 
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting sale transaction")
+	}
+
+	// Rolling back after a commit is a no-op so this is safe to defer.
+	defer tx.Rollback()
+
+	s, err := AddSaleTx(ctx, tx, user, ns, productID, nil, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing sale")
+	}
+
+	return s, nil
+}
+
+// AddSaleTx records a sale as part of a transaction owned by the caller, which
+// is how several sales are recorded together. The product row stays locked
+// until the caller commits or rolls back. The sale is linked to an order
+// when orderID is not nil.
+func AddSaleTx(ctx context.Context, tx *sqlx.Tx, user auth.Claims, ns NewSale, productID string, orderID *string, now time.Time) (*Sale, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.product.AddSaleTx")
+	defer span.End()
+
+	if _, err := uuid.Parse(productID); err != nil {
+		return nil, ErrInvalidID
+	}
+	if _, err := uuid.Parse(user.OrgID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	stock, currency, err := lockStock(ctx, tx, user.OrgID, productID)
+	if err != nil {
+		return nil, err
+	}
+	if ns.Paid.Currency != currency {
+		return nil, ErrCurrency
+	}
+	if ns.Quantity > stock {
+		return nil, ErrInsufficientStock
+	}
+
 	s := Sale{
 		ID:          uuid.New().String(),
 		ProductID:   productID,
+		OrderID:     orderID,
 		Quantity:    ns.Quantity,
 		Paid:        ns.Paid,
-		DateCreated: now,
+		Kind:        KindSale,
+		UserID:      user.Subject,
+		DateCreated: now.UTC(),
+	}
+
+	if err := insertSale(ctx, tx, s); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// lockStock locks the row of a product of the organization orgID until the end
+// of the transaction and returns the number of items that are still in stock
+// and the currency the product is sold in.
+func lockStock(ctx context.Context, tx *sqlx.Tx, orgID, productID string) (int, string, error) {
+
+	// FOR UPDATE can not be combined with the aggregate so the product
+	// row is locked first and its sales are summed afterwards. Deleted
+	// products can not be sold.
+	var p struct {
+		Quantity int    `db:"quantity"`
+		Currency string `db:"currency"`
+	}
+	const lock = `SELECT quantity, (cost).currency AS currency FROM products
+					WHERE product_id = $1 AND org_id = $2 AND deleted_at IS NULL
+					FOR UPDATE`
+	if err := tx.GetContext(ctx, &p, lock, productID, orgID); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, "", ErrNotFound
+		}
+		return 0, "", errors.Wrap(err, "locking product")
+	}
+
+	var sold int
+	const sum = `SELECT COALESCE(SUM(quantity), 0) FROM sales WHERE product_id = $1`
+	if err := tx.GetContext(ctx, &sold, sum, productID); err != nil {
+		return 0, "", errors.Wrap(err, "summing sales")
+	}
+
+	return p.Quantity - sold, p.Currency, nil
+}
+
+// Refund records a reversing entry for part or all of a sale. Returned items
+// go back into stock. The total refunded quantity and amount can never exceed
+// what was originally sold and paid, and refunds are paid in the currency of
+// the sale.
+func Refund(ctx context.Context, db *sqlx.DB, user auth.Claims, saleID string, nr NewRefund, now time.Time) (*Sale, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.product.Refund")
+	defer span.End()
+
+	r := reversal{
+		kind:     KindRefund,
+		quantity: nr.Quantity,
+		paid:     nr.Paid,
+		reason:   nr.Reason,
+	}
+	return reverseSale(ctx, db, user, saleID, r, now)
+}
+
+// Void cancels a sale that was recorded by mistake. It reverses the whole sale
+// and is only allowed when nothing has been refunded against it yet.
+func Void(ctx context.Context, db *sqlx.DB, user auth.Claims, saleID string, nv NewVoid, now time.Time) (*Sale, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.product.Void")
+	defer span.End()
+
+	r := reversal{
+		kind:   KindVoid,
+		reason: nv.Reason,
+	}
+	return reverseSale(ctx, db, user, saleID, r, now)
+}
+
+// reversal holds the details of a refund or void before it is recorded.
+// For voids the quantity and amount are taken from the original sale.
+type reversal struct {
+	kind     string
+	quantity int
+	paid     money.Money
+	reason   string
+}
+
+// reverseSale records a reversing entry against a sale inside a transaction
+// that locks the original sale so concurrent reversals are serialized.
+func reverseSale(ctx context.Context, db *sqlx.DB, user auth.Claims, saleID string, r reversal, now time.Time) (*Sale, error) {
+
+	if _, err := uuid.Parse(saleID); err != nil {
+		return nil, ErrInvalidID
+	}
+	if _, err := uuid.Parse(user.OrgID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting reversal transaction")
+	}
+	defer tx.Rollback()
+
+	// Sales of products of other organizations are reported as not found.
+	var orig Sale
+	const lock = `SELECT s.* FROM sales AS s
+					JOIN products AS p ON p.product_id = s.product_id
+					WHERE s.sale_id = $1 AND p.org_id = $2
+					FOR UPDATE OF s`
+	if err := tx.GetContext(ctx, &orig, lock, saleID, user.OrgID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSaleNotFound
+		}
+		return nil, errors.Wrap(err, "locking sale")
+	}
+
+	// Only original sales can be reversed, never a reversal itself.
+	if orig.Kind != KindSale {
+		return nil, ErrInvalidReversal
+	}
+
+	// Reversing entries are negative so summing them gives what was
+	// already taken back from this sale.
+	var done struct {
+		Quantity int   `db:"quantity"`
+		Paid     int64 `db:"paid"`
+	}
+	const sum = `SELECT
+					COALESCE(-SUM(quantity), 0) AS quantity,
+					COALESCE(-SUM((paid).amount), 0) AS paid
+				FROM sales WHERE reverses_sale_id = $1`
+	if err := tx.GetContext(ctx, &done, sum, saleID); err != nil {
+		return nil, errors.Wrap(err, "summing reversals")
 	}
 
+	if r.kind == KindVoid {
+		if done.Quantity != 0 || done.Paid != 0 {
+			return nil, ErrInvalidReversal
+		}
+		r.quantity, r.paid = orig.Quantity, orig.Paid
+	}
+
+	if r.paid.Currency != orig.Paid.Currency {
+		return nil, ErrCurrency
+	}
+	if done.Quantity+r.quantity > orig.Quantity || done.Paid+r.paid.Amount > orig.Paid.Amount {
+		return nil, ErrInvalidReversal
+	}
+
+	s := Sale{
+		ID:          uuid.New().String(),
+		ProductID:   orig.ProductID,
+		OrderID:     orig.OrderID,
+		Quantity:    -r.quantity,
+		Paid:        r.paid.Neg(),
+		Kind:        r.kind,
+		ReversesID:  &orig.ID,
+		Reason:      r.reason,
+		UserID:      user.Subject,
+		DateCreated: now.UTC(),
+	}
+
+	if err := insertSale(ctx, tx, s); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing reversal")
+	}
+
+	return &s, nil
+}
+
+// insertSale writes a sales entry as part of a transaction.
+func insertSale(ctx context.Context, tx *sqlx.Tx, s Sale) error {
+
 	const q = `INSERT INTO sales
-		(sale_id, product_id, quantity, paid, date_created)
-		VALUES ($1, $2, $3, $4, $5)`
+		(sale_id, product_id, order_id, quantity, paid, kind, reverses_sale_id, reason, user_id, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
 
-	_, err := db.ExecContext(ctx, q,
-		s.ID, s.ProductID, s.Quantity,
-		s.Paid, s.DateCreated,
+	_, err := tx.ExecContext(ctx, q,
+		s.ID, s.ProductID, s.OrderID, s.Quantity, s.Paid,
+		s.Kind, s.ReversesID, s.Reason,
+		s.UserID, s.DateCreated,
 	)
 	if err != nil {
-		return nil, errors.Wrap(err, "creating sale")
+		return errors.Wrap(err, "creating sale")
 	}
 
-	return &s, nil
+	return nil
 }
 
-// ListSales lists all sale transactions for a product.
-func ListSales(ctx context.Context, db *sqlx.DB, productID string) ([]Sale, error) {
+// ListSales lists all sale transactions for a product of the organization orgID.
+func ListSales(ctx context.Context, db *sqlx.DB, orgID, productID string) ([]Sale, error) {
 
 	ctx, span := trace.StartSpan(ctx, "internal.product.ListSales")
 	defer span.End()
 
+	if _, err := uuid.Parse(productID); err != nil {
+		return nil, ErrInvalidID
+	}
+	if _, err := uuid.Parse(orgID); err != nil {
+		return nil, ErrInvalidID
+	}
+
 	sales := []Sale{}
 
-	const q = `SELECT * FROM sales WHERE product_id = $1`
-	if err := db.SelectContext(ctx, &sales, q, productID); err != nil {
+	const q = `SELECT s.* FROM sales AS s
+				JOIN products AS p ON p.product_id = s.product_id
+				WHERE s.product_id = $1 AND p.org_id = $2
+				ORDER BY s.date_created, s.sale_id`
+	if err := db.SelectContext(ctx, &sales, q, productID, orgID); err != nil {
 		return nil, errors.Wrap(err, "listing sales")
 	}
 
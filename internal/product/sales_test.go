@@ -5,7 +5,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/sreejeet/garagesale/internal/org"
 	"github.com/sreejeet/garagesale/internal/platform/auth"
+	"github.com/sreejeet/garagesale/internal/platform/money"
 	"github.com/sreejeet/garagesale/internal/product"
 	"github.com/sreejeet/garagesale/internal/tests"
 )
@@ -22,7 +24,7 @@ func TestSales(t *testing.T) {
 	// Create two products as seed data.
 	newPuzzles := product.NewProduct{
 		Name:     "Puzzles",
-		Cost:     25,
+		Cost:     money.New(2500, "USD"),
 		Quantity: 6,
 	}
 
@@ -32,6 +34,7 @@ func TestSales(t *testing.T) {
 		[]string{auth.RoleAdmin, auth.RoleUser},
 		now, time.Hour,
 	)
+	claims.OrgID = org.DefaultID
 
 	puzzles, err := product.Create(ctx, db, claims, newPuzzles, now)
 	if err != nil {
@@ -40,7 +43,7 @@ func TestSales(t *testing.T) {
 
 	newToys := product.NewProduct{
 		Name:     "Toys",
-		Cost:     40,
+		Cost:     money.New(4000, "USD"),
 		Quantity: 3,
 	}
 	toys, err := product.Create(ctx, db, claims, newToys, now)
@@ -52,16 +55,16 @@ func TestSales(t *testing.T) {
 
 		ns := product.NewSale{
 			Quantity: 3,
-			Paid:     70,
+			Paid:     money.New(7000, "USD"),
 		}
 
-		s, err := product.AddSale(ctx, db, ns, puzzles.ID, now)
+		s, err := product.AddSale(ctx, db, claims, ns, puzzles.ID, now)
 		if err != nil {
 			t.Fatalf("creating sale: %s", err)
 		}
 
 		// Puzzles should show the one sale added above.
-		sales, err := product.ListSales(ctx, db, puzzles.ID)
+		sales, err := product.ListSales(ctx, db, org.DefaultID, puzzles.ID)
 		if err != nil {
 			t.Fatalf("listing sales: %s", err)
 		}
@@ -73,7 +76,7 @@ func TestSales(t *testing.T) {
 		}
 
 		// Toys should have 0 sales.
-		sales, err = product.ListSales(ctx, db, toys.ID)
+		sales, err = product.ListSales(ctx, db, org.DefaultID, toys.ID)
 		if err != nil {
 			t.Fatalf("listing sales: %s", err)
 		}
@@ -81,4 +84,103 @@ func TestSales(t *testing.T) {
 			t.Fatalf("expected sale list size %v, got %v", exp, got)
 		}
 	}
+
+	{ // Stock is enforced
+
+		// Only 3 puzzles remain after the sale above.
+		ns := product.NewSale{
+			Quantity: 4,
+			Paid:     money.New(10000, "USD"),
+		}
+		if _, err := product.AddSale(ctx, db, claims, ns, puzzles.ID, now); err != product.ErrInsufficientStock {
+			t.Fatalf("expected %v when overselling, got %v", product.ErrInsufficientStock, err)
+		}
+
+		ns.Quantity = 3
+		if _, err := product.AddSale(ctx, db, claims, ns, puzzles.ID, now); err != nil {
+			t.Fatalf("selling remaining stock: %s", err)
+		}
+
+		p, err := product.Retrieve(ctx, db, org.DefaultID, puzzles.ID, false)
+		if err != nil {
+			t.Fatalf("getting product: %s", err)
+		}
+		if exp, got := 0, p.Stock; exp != got {
+			t.Fatalf("expected stock %v, got %v", exp, got)
+		}
+
+		// Sales are paid in the currency of the product.
+		ns.Paid = money.New(10000, "INR")
+		if _, err := product.AddSale(ctx, db, claims, ns, toys.ID, now); err != product.ErrCurrency {
+			t.Fatalf("expected %v paying in another currency, got %v", product.ErrCurrency, err)
+		}
+
+		const unknown = "0b9a6b6f-3a0c-4c5d-9a53-6f0b6b6b6b6b"
+		if _, err := product.AddSale(ctx, db, claims, ns, unknown, now); err != product.ErrNotFound {
+			t.Fatalf("expected %v for unknown product, got %v", product.ErrNotFound, err)
+		}
+	}
+
+	{ // Refunds and voids
+
+		ns := product.NewSale{
+			Quantity: 3,
+			Paid:     money.New(12000, "USD"),
+		}
+		s, err := product.AddSale(ctx, db, claims, ns, toys.ID, now)
+		if err != nil {
+			t.Fatalf("creating sale: %s", err)
+		}
+
+		nr := product.NewRefund{
+			Quantity: 1,
+			Paid:     money.New(4000, "USD"),
+			Reason:   "Broken on arrival",
+		}
+		refund, err := product.Refund(ctx, db, claims, s.ID, nr, now)
+		if err != nil {
+			t.Fatalf("refunding sale: %s", err)
+		}
+		if exp, got := -1, refund.Quantity; exp != got {
+			t.Fatalf("expected refund quantity %v, got %v", exp, got)
+		}
+
+		// Refunding more than what is left of the sale must fail.
+		nr.Quantity = 3
+		if _, err := product.Refund(ctx, db, claims, s.ID, nr, now); err != product.ErrInvalidReversal {
+			t.Fatalf("expected %v for excessive refund, got %v", product.ErrInvalidReversal, err)
+		}
+
+		// A partly refunded sale can not be voided.
+		nv := product.NewVoid{Reason: "Wrong product"}
+		if _, err := product.Void(ctx, db, claims, s.ID, nv, now); err != product.ErrInvalidReversal {
+			t.Fatalf("expected %v when voiding refunded sale, got %v", product.ErrInvalidReversal, err)
+		}
+
+		p, err := product.Retrieve(ctx, db, org.DefaultID, toys.ID, false)
+		if err != nil {
+			t.Fatalf("getting product: %s", err)
+		}
+		if exp, got := 2, p.Sold; exp != got {
+			t.Fatalf("expected sold %v, got %v", exp, got)
+		}
+		if exp, got := money.New(8000, "USD"), p.Revenue; exp != got {
+			t.Fatalf("expected revenue %v, got %v", exp, got)
+		}
+		if exp, got := 1, p.Stock; exp != got {
+			t.Fatalf("expected stock %v, got %v", exp, got)
+		}
+
+		// The original sale stays intact next to its reversal.
+		sales, err := product.ListSales(ctx, db, org.DefaultID, toys.ID)
+		if err != nil {
+			t.Fatalf("listing sales: %s", err)
+		}
+		if exp, got := 2, len(sales); exp != got {
+			t.Fatalf("expected sale list size %v, got %v", exp, got)
+		}
+		if exp, got := 3, sales[0].Quantity; exp != got {
+			t.Fatalf("expected original sale quantity %v, got %v", exp, got)
+		}
+	}
 }
@@ -0,0 +1,63 @@
+package report
+
+import (
+	"time"
+
+	"github.com/sreejeet/garagesale/internal/platform/money"
+)
+
+// Range is the period a report covers. From is inclusive and To exclusive.
+type Range struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// Period is what was sold in one currency during one period of a revenue
+// report. Start is the beginning of the period in UTC.
+type Period struct {
+	Start   time.Time   `db:"start" json:"start"`
+	Units   int         `db:"units" json:"units"`
+	Revenue money.Money `db:"revenue" json:"revenue"`
+}
+
+// TopProduct is a product ranked by the units sold or the revenue made.
+type TopProduct struct {
+	ProductID string      `db:"product_id" json:"product_id"`
+	Name      string      `db:"name" json:"name"`
+	Rank      int         `db:"rank" json:"rank"`
+	Units     int         `db:"units" json:"units"`
+	Revenue   money.Money `db:"revenue" json:"revenue"`
+}
+
+// SellThroughRate is the share of the stocked quantity of a product that was
+// sold, between 0 and 1 unless refunds exceed sales in the range.
+type SellThroughRate struct {
+	ProductID string  `db:"product_id" json:"product_id"`
+	Name      string  `db:"name" json:"name"`
+	Quantity  int     `db:"quantity" json:"quantity"`
+	Sold      int     `db:"sold" json:"sold"`
+	Rate      float64 `db:"rate" json:"rate"`
+}
+
+// Discount compares what was paid with the cost of the items sold in one
+// currency. List is the cost of every item sold and Discount what buyers paid
+// less than that. AverageDiscount is the discount per item and Rate the
+// discount as a share of List. Discounts are negative when buyers paid more.
+type Discount struct {
+	Sales           int         `db:"sales" json:"sales"`
+	Units           int         `db:"units" json:"units"`
+	List            money.Money `db:"list" json:"list"`
+	Paid            money.Money `db:"paid" json:"paid"`
+	Discount        money.Money `db:"discount" json:"discount"`
+	AverageDiscount money.Money `db:"average_discount" json:"average_discount"`
+	Rate            float64     `db:"rate" json:"rate"`
+}
+
+// Seller is what the products of one user earned in one currency.
+type Seller struct {
+	UserID   string      `db:"user_id" json:"user_id"`
+	Name     string      `db:"name" json:"name"`
+	Products int         `db:"products" json:"products"`
+	Units    int         `db:"units" json:"units"`
+	Revenue  money.Money `db:"revenue" json:"revenue"`
+}
@@ -0,0 +1,237 @@
+// Package report computes sales figures of an organization. Every report
+// covers a Range of time and only counts sales recorded within it. Refunds and
+// voids are counted against the sales they reverse unless stated otherwise.
+// Amounts are never added up across currencies: figures involving money are
+// reported once per currency.
+package report
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// Custom errors for invalid report parameters.
+var (
+	// ErrInvalidID occurs when the organization is not a valid UUID.
+	ErrInvalidID = errors.New("invalid ID")
+	// ErrInvalidRange occurs when a range does not end after it starts.
+	ErrInvalidRange = errors.New("range must end after it starts")
+	// ErrInvalidInterval occurs when asking for revenue per an unknown period.
+	ErrInvalidInterval = errors.New("interval must be day, week or month")
+	// ErrInvalidRanking occurs when ranking products by an unknown figure.
+	ErrInvalidRanking = errors.New("products can be ranked by units or revenue")
+)
+
+// intervals are the periods revenue can be reported by. Weeks start on Monday.
+var intervals = map[string]bool{
+	"day":   true,
+	"week":  true,
+	"month": true,
+}
+
+// rankings holds the window function ranking products and the order of the
+// ranked rows for each figure products can be ranked by. Units are ranked
+// across all products while revenue is ranked within each currency.
+var rankings = map[string]struct {
+	rank  string
+	order string
+}{
+	"units": {
+		rank:  "ROW_NUMBER() OVER (ORDER BY SUM(s.quantity) DESC, p.product_id)",
+		order: "rank",
+	},
+	"revenue": {
+		rank:  "ROW_NUMBER() OVER (PARTITION BY (s.paid).currency ORDER BY SUM((s.paid).amount) DESC, p.product_id)",
+		order: "(revenue).currency, rank",
+	},
+}
+
+// check validates the organization and range shared by all reports.
+func check(orgID string, r Range) error {
+	if _, err := uuid.Parse(orgID); err != nil {
+		return ErrInvalidID
+	}
+	if !r.From.Before(r.To) {
+		return ErrInvalidRange
+	}
+	return nil
+}
+
+// Revenue returns the units sold and the revenue made per interval, which is
+// one of "day", "week" or "month". Periods are in UTC and periods without
+// sales are left out.
+func Revenue(ctx context.Context, db *sqlx.DB, orgID string, r Range, interval string) ([]Period, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.report.Revenue")
+	defer span.End()
+
+	if err := check(orgID, r); err != nil {
+		return nil, err
+	}
+	if !intervals[interval] {
+		return nil, ErrInvalidInterval
+	}
+
+	periods := []Period{}
+	const q = `SELECT
+					date_trunc($4, s.date_created) AS start,
+					SUM(s.quantity) AS units,
+					ROW(SUM((s.paid).amount), (s.paid).currency)::money_value AS revenue
+				FROM sales AS s
+				JOIN products AS p ON p.product_id = s.product_id
+				WHERE p.org_id = $1 AND s.date_created >= $2 AND s.date_created < $3
+				GROUP BY start, (s.paid).currency
+				ORDER BY start, (s.paid).currency`
+	if err := db.SelectContext(ctx, &periods, q, orgID, r.From.UTC(), r.To.UTC(), interval); err != nil {
+		return nil, errors.Wrap(err, "selecting revenue")
+	}
+
+	return periods, nil
+}
+
+// TopProducts returns the limit best selling products ranked by "units" or
+// "revenue". As revenue can only be compared within a currency, ranking by
+// revenue returns the top products of every currency.
+func TopProducts(ctx context.Context, db *sqlx.DB, orgID string, r Range, by string, limit int) ([]TopProduct, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.report.TopProducts")
+	defer span.End()
+
+	if err := check(orgID, r); err != nil {
+		return nil, err
+	}
+	ranking, ok := rankings[by]
+	if !ok {
+		return nil, ErrInvalidRanking
+	}
+
+	products := []TopProduct{}
+	q := fmt.Sprintf(`SELECT product_id, name, rank, units, revenue FROM (
+					SELECT
+						p.product_id, p.name,
+						%s AS rank,
+						SUM(s.quantity) AS units,
+						ROW(SUM((s.paid).amount), (s.paid).currency)::money_value AS revenue
+					FROM sales AS s
+					JOIN products AS p ON p.product_id = s.product_id
+					WHERE p.org_id = $1 AND s.date_created >= $2 AND s.date_created < $3
+					GROUP BY p.product_id, p.name, (s.paid).currency
+				) AS ranked
+				WHERE rank <= $4
+				ORDER BY %s`, ranking.rank, ranking.order)
+	if err := db.SelectContext(ctx, &products, q, orgID, r.From.UTC(), r.To.UTC(), limit); err != nil {
+		return nil, errors.Wrap(err, "selecting top products")
+	}
+
+	return products, nil
+}
+
+// SellThrough returns the limit products that sold the largest share of their
+// quantity. Deleted products are left out.
+func SellThrough(ctx context.Context, db *sqlx.DB, orgID string, r Range, limit int) ([]SellThroughRate, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.report.SellThrough")
+	defer span.End()
+
+	if err := check(orgID, r); err != nil {
+		return nil, err
+	}
+
+	products := []SellThroughRate{}
+	const q = `SELECT
+					p.product_id, p.name, p.quantity,
+					COALESCE(SUM(s.quantity), 0) AS sold,
+					COALESCE(COALESCE(SUM(s.quantity), 0)::FLOAT8 / NULLIF(p.quantity, 0), 0) AS rate
+				FROM products AS p
+				LEFT JOIN sales AS s ON s.product_id = p.product_id
+					AND s.date_created >= $2 AND s.date_created < $3
+				WHERE p.org_id = $1 AND p.deleted_at IS NULL
+				GROUP BY p.product_id, p.name, p.quantity
+				ORDER BY rate DESC, p.product_id
+				LIMIT $4`
+	if err := db.SelectContext(ctx, &products, q, orgID, r.From.UTC(), r.To.UTC(), limit); err != nil {
+		return nil, errors.Wrap(err, "selecting sell-through")
+	}
+
+	return products, nil
+}
+
+// Discounts compares what buyers paid with the current cost of the products
+// they bought, once per currency. Only sales count here: voided sales are
+// left out and refunds are not treated as discounts.
+func Discounts(ctx context.Context, db *sqlx.DB, orgID string, r Range) ([]Discount, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.report.Discounts")
+	defer span.End()
+
+	if err := check(orgID, r); err != nil {
+		return nil, err
+	}
+
+	discounts := []Discount{}
+	const q = `SELECT
+					sales, units,
+					ROW(list, currency)::money_value AS list,
+					ROW(paid, currency)::money_value AS paid,
+					ROW(list - paid, currency)::money_value AS discount,
+					ROW(ROUND((list - paid)::NUMERIC / NULLIF(units, 0)), currency)::money_value AS average_discount,
+					COALESCE((list - paid)::FLOAT8 / NULLIF(list, 0), 0) AS rate
+				FROM (
+					SELECT
+						(s.paid).currency AS currency,
+						COUNT(*) AS sales,
+						SUM(s.quantity) AS units,
+						SUM((p.cost).amount * s.quantity) AS list,
+						SUM((s.paid).amount) AS paid
+					FROM sales AS s
+					JOIN products AS p ON p.product_id = s.product_id
+					WHERE p.org_id = $1 AND s.date_created >= $2 AND s.date_created < $3
+						AND s.kind = 'SALE'
+						AND NOT EXISTS (
+							SELECT 1 FROM sales AS v
+							WHERE v.reverses_sale_id = s.sale_id AND v.kind = 'VOID'
+						)
+					GROUP BY (s.paid).currency
+				) AS totals
+				ORDER BY currency`
+	if err := db.SelectContext(ctx, &discounts, q, orgID, r.From.UTC(), r.To.UTC()); err != nil {
+		return nil, errors.Wrap(err, "selecting discounts")
+	}
+
+	return discounts, nil
+}
+
+// Sellers returns the number of products sold, the units sold and the revenue
+// made per owner of the products, highest revenue first within each currency.
+func Sellers(ctx context.Context, db *sqlx.DB, orgID string, r Range) ([]Seller, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.report.Sellers")
+	defer span.End()
+
+	if err := check(orgID, r); err != nil {
+		return nil, err
+	}
+
+	sellers := []Seller{}
+	const q = `SELECT
+					p.user_id, COALESCE(u.name, '') AS name,
+					COUNT(DISTINCT p.product_id) AS products,
+					SUM(s.quantity) AS units,
+					ROW(SUM((s.paid).amount), (s.paid).currency)::money_value AS revenue
+				FROM sales AS s
+				JOIN products AS p ON p.product_id = s.product_id
+				LEFT JOIN users AS u ON u.user_id = p.user_id
+				WHERE p.org_id = $1 AND s.date_created >= $2 AND s.date_created < $3
+				GROUP BY p.user_id, u.name, (s.paid).currency
+				ORDER BY (s.paid).currency, SUM((s.paid).amount) DESC, p.user_id`
+	if err := db.SelectContext(ctx, &sellers, q, orgID, r.From.UTC(), r.To.UTC()); err != nil {
+		return nil, errors.Wrap(err, "selecting sellers")
+	}
+
+	return sellers, nil
+}
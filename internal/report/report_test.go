@@ -0,0 +1,159 @@
+package report_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sreejeet/garagesale/internal/org"
+	"github.com/sreejeet/garagesale/internal/platform/auth"
+	"github.com/sreejeet/garagesale/internal/platform/money"
+	"github.com/sreejeet/garagesale/internal/product"
+	"github.com/sreejeet/garagesale/internal/report"
+	"github.com/sreejeet/garagesale/internal/schema"
+	"github.com/sreejeet/garagesale/internal/tests"
+)
+
+func TestReports(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	if err := schema.Seed(db); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	week := time.Date(2019, time.January, 8, 12, 0, 0, 0, time.UTC)
+
+	claims := auth.NewClaims(tests.AdminID, []string{auth.RoleAdmin}, week, time.Hour)
+	claims.OrgID = org.DefaultID
+
+	// The seed data holds 10 items sold for 575.00 USD on the first of
+	// January. Add a product sold in rupees a week later.
+	np := product.NewProduct{Name: "Kites", Cost: money.New(100000, "INR"), Quantity: 4}
+	kites, err := product.Create(ctx, db, claims, np, week)
+	if err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+	ns := product.NewSale{Quantity: 2, Paid: money.New(150000, "INR")}
+	if _, err := product.AddSale(ctx, db, claims, ns, kites.ID, week); err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+
+	const comics = "a2b0639f-2cc6-44b8-b97b-15d69dbb511e"
+	january := report.Range{
+		From: time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC),
+		To:   time.Date(2019, time.February, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	{ // Revenue is grouped by period and currency.
+		periods, err := report.Revenue(ctx, db, org.DefaultID, january, "month")
+		if err != nil {
+			t.Fatalf("reporting revenue: %s", err)
+		}
+		want := []report.Period{
+			{Start: january.From, Units: 2, Revenue: money.New(150000, "INR")},
+			{Start: january.From, Units: 10, Revenue: money.New(57500, "USD")},
+		}
+		if diff := cmp.Diff(want, periods); diff != "" {
+			t.Fatalf("unexpected monthly revenue:\n%s", diff)
+		}
+
+		periods, err = report.Revenue(ctx, db, org.DefaultID, january, "week")
+		if err != nil {
+			t.Fatalf("reporting revenue: %s", err)
+		}
+		if len(periods) != 2 || !periods[1].Start.Equal(time.Date(2019, time.January, 7, 0, 0, 0, 0, time.UTC)) {
+			t.Fatalf("expected weeks starting on Monday, got %v", periods)
+		}
+
+		if _, err := report.Revenue(ctx, db, org.DefaultID, january, "year"); err != report.ErrInvalidInterval {
+			t.Fatalf("expected %v, got %v", report.ErrInvalidInterval, err)
+		}
+		backwards := report.Range{From: january.To, To: january.From}
+		if _, err := report.Revenue(ctx, db, org.DefaultID, backwards, "day"); err != report.ErrInvalidRange {
+			t.Fatalf("expected %v, got %v", report.ErrInvalidRange, err)
+		}
+	}
+
+	{ // Top products by units are ranked across currencies.
+		top, err := report.TopProducts(ctx, db, org.DefaultID, january, "units", 1)
+		if err != nil {
+			t.Fatalf("reporting top products: %s", err)
+		}
+		if len(top) != 1 || top[0].ProductID != comics || top[0].Units != 7 {
+			t.Fatalf("expected comics to sell the most units, got %v", top)
+		}
+
+		// By revenue they are ranked within each currency.
+		top, err = report.TopProducts(ctx, db, org.DefaultID, january, "revenue", 1)
+		if err != nil {
+			t.Fatalf("reporting top products: %s", err)
+		}
+		if len(top) != 2 || top[0].ProductID != kites.ID || top[1].ProductID != comics || top[1].Revenue != money.New(35000, "USD") {
+			t.Fatalf("expected the top product of each currency, got %v", top)
+		}
+
+		if _, err := report.TopProducts(ctx, db, org.DefaultID, january, "cost", 1); err != report.ErrInvalidRanking {
+			t.Fatalf("expected %v, got %v", report.ErrInvalidRanking, err)
+		}
+	}
+
+	{ // Sell-through
+		rates, err := report.SellThrough(ctx, db, org.DefaultID, january, 10)
+		if err != nil {
+			t.Fatalf("reporting sell-through: %s", err)
+		}
+		if len(rates) != 3 || rates[0].ProductID != kites.ID || rates[0].Rate != 0.5 {
+			t.Fatalf("expected kites to sell through half their quantity, got %v", rates)
+		}
+	}
+
+	{ // Discounts
+		discounts, err := report.Discounts(ctx, db, org.DefaultID, january)
+		if err != nil {
+			t.Fatalf("reporting discounts: %s", err)
+		}
+		want := []report.Discount{
+			{
+				Sales:           1,
+				Units:           2,
+				List:            money.New(200000, "INR"),
+				Paid:            money.New(150000, "INR"),
+				Discount:        money.New(50000, "INR"),
+				AverageDiscount: money.New(25000, "INR"),
+				Rate:            0.25,
+			},
+			{
+				Sales:           3,
+				Units:           10,
+				List:            money.New(57500, "USD"),
+				Paid:            money.New(57500, "USD"),
+				Discount:        money.New(0, "USD"),
+				AverageDiscount: money.New(0, "USD"),
+			},
+		}
+		if diff := cmp.Diff(want, discounts); diff != "" {
+			t.Fatalf("unexpected discounts:\n%s", diff)
+		}
+	}
+
+	{ // Sellers
+		sellers, err := report.Sellers(ctx, db, org.DefaultID, january)
+		if err != nil {
+			t.Fatalf("reporting sellers: %s", err)
+		}
+		if len(sellers) != 2 || sellers[0].UserID != tests.AdminID || sellers[0].Name != "Admin Gopher" || sellers[1].Products != 2 {
+			t.Fatalf("unexpected sellers %v", sellers)
+		}
+	}
+
+	{ // Other organizations see none of it.
+		const otherOrg = "8c1f0b5e-2f4d-4a7e-9b8e-3d7a1c2b4e5f"
+		periods, err := report.Revenue(ctx, db, otherOrg, january, "day")
+		if err != nil || len(periods) != 0 {
+			t.Fatalf("expected no revenue in another organization, got %v, %v", periods, err)
+		}
+	}
+}
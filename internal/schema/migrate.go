@@ -1,8 +1,13 @@
 package schema
 
 import (
+	"strings"
+
 	"github.com/GuiaBolso/darwin"
+	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/sreejeet/garagesale/internal/platform/money"
 )
 
 // This file contains the database schema.
@@ -55,11 +60,388 @@ var migrations = []darwin.Migration{
 		Script: `ALTER TABLE products
 					ADD COLUMN user_id UUID DEFAULT '00000000-0000-0000-0000-000000000000'`,
 	},
+	{
+		Version:     5,
+		Description: "Add reversal columns to sales",
+		Script: `ALTER TABLE sales
+					ADD COLUMN kind TEXT NOT NULL DEFAULT 'SALE',
+					ADD COLUMN reverses_sale_id UUID REFERENCES sales(sale_id),
+					ADD COLUMN reason TEXT NOT NULL DEFAULT '',
+					ADD COLUMN user_id UUID DEFAULT '00000000-0000-0000-0000-000000000000'`,
+	},
+	{
+		Version:     6,
+		Description: "Add orders",
+		Script: `CREATE TABLE orders (
+					order_id     UUID,
+					user_id      UUID,
+					total        INT,
+					date_created TIMESTAMP,
+					PRIMARY KEY (order_id)
+				);
+				ALTER TABLE sales
+					ADD COLUMN order_id UUID REFERENCES orders(order_id);
+				CREATE INDEX sales_order_id_idx ON sales (order_id);`,
+	},
+	{
+		Version:     7,
+		Description: "Add user verification",
+		Script: `ALTER TABLE users
+					ADD COLUMN verified BOOLEAN NOT NULL DEFAULT TRUE;
+				CREATE TABLE user_verifications (
+					token_hash   TEXT,
+					user_id      UUID REFERENCES users(user_id) ON DELETE CASCADE,
+					date_expires TIMESTAMP,
+					PRIMARY KEY (token_hash)
+				);`,
+	},
+	{
+		Version:     8,
+		Description: "Add password resets",
+		Script: `CREATE TABLE password_resets (
+					token_hash   TEXT,
+					user_id      UUID REFERENCES users(user_id) ON DELETE CASCADE,
+					date_expires TIMESTAMP,
+					PRIMARY KEY (token_hash)
+				);`,
+	},
+	{
+		Version:     9,
+		Description: "Add login failures",
+		Script: `CREATE TABLE login_failures (
+					key          TEXT,
+					failures     INT,
+					last_failure TIMESTAMP,
+					PRIMARY KEY (key)
+				);`,
+	},
+	{
+		Version:     10,
+		Description: "Add sessions",
+		Script: `CREATE TABLE refresh_tokens (
+					token_hash   TEXT,
+					family_id    UUID,
+					user_id      UUID REFERENCES users(user_id) ON DELETE CASCADE,
+					date_created TIMESTAMP,
+					date_expires TIMESTAMP,
+					date_used    TIMESTAMP,
+					revoked      BOOLEAN NOT NULL DEFAULT FALSE,
+					PRIMARY KEY (token_hash)
+				);
+				CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+				CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens (user_id);
+				CREATE TABLE revoked_tokens (
+					jti          TEXT,
+					date_expires TIMESTAMP,
+					PRIMARY KEY (jti)
+				);
+				CREATE TABLE session_revocations (
+					user_id      UUID REFERENCES users(user_id) ON DELETE CASCADE,
+					date_revoked TIMESTAMP,
+					PRIMARY KEY (user_id)
+				);`,
+	},
+	{
+		Version:     11,
+		Description: "Add api keys",
+		Script: `CREATE TABLE api_keys (
+					key_id         UUID,
+					name           TEXT,
+					prefix         TEXT,
+					key_hash       TEXT UNIQUE,
+					roles          TEXT[],
+					user_id        UUID,
+					date_created   TIMESTAMP,
+					date_last_used TIMESTAMP,
+					date_revoked   TIMESTAMP,
+					PRIMARY KEY (key_id)
+				);`,
+	},
+	{
+		Version:     12,
+		Description: "Add roles and permissions",
+		Script: `CREATE TABLE roles (
+					role        TEXT,
+					description TEXT,
+					PRIMARY KEY (role)
+				);
+				CREATE TABLE role_permissions (
+					role       TEXT REFERENCES roles(role) ON DELETE CASCADE,
+					permission TEXT,
+					PRIMARY KEY (role, permission)
+				);
+				INSERT INTO roles (role, description) VALUES
+					('ADMIN', 'Full access'),
+					('USER', 'Manages own products and reads sales'),
+					('CASHIER', 'Records sales only'),
+					('SELLER', 'Manages own products'),
+					('AUDITOR', 'Read-only access to sales and reports');
+				INSERT INTO role_permissions (role, permission) VALUES
+					('ADMIN', 'products:read'),
+					('ADMIN', 'products:create'),
+					('ADMIN', 'products:update'),
+					('ADMIN', 'products:delete'),
+					('ADMIN', 'sales:read'),
+					('ADMIN', 'sales:create'),
+					('ADMIN', 'sales:reverse'),
+					('ADMIN', 'orders:read'),
+					('ADMIN', 'orders:create'),
+					('ADMIN', 'users:manage'),
+					('ADMIN', 'apikeys:manage'),
+					('ADMIN', 'reports:read'),
+					('USER', 'products:read'),
+					('USER', 'products:create'),
+					('USER', 'products:update:own'),
+					('USER', 'sales:read'),
+					('USER', 'orders:read'),
+					('CASHIER', 'products:read'),
+					('CASHIER', 'sales:create'),
+					('CASHIER', 'orders:create'),
+					('SELLER', 'products:read'),
+					('SELLER', 'products:create'),
+					('SELLER', 'products:update:own'),
+					('SELLER', 'sales:read'),
+					('AUDITOR', 'products:read'),
+					('AUDITOR', 'sales:read'),
+					('AUDITOR', 'orders:read'),
+					('AUDITOR', 'reports:read');`,
+	},
+	{
+		Version:     13,
+		Description: "Add organizations",
+		Script: `CREATE TABLE organizations (
+					org_id       UUID,
+					name         TEXT,
+					date_created TIMESTAMP,
+					PRIMARY KEY (org_id)
+				);
+				INSERT INTO organizations (org_id, name, date_created)
+					VALUES ('00000000-0000-0000-0000-000000000001', 'Default', NOW());
+				CREATE TABLE org_members (
+					org_id      UUID REFERENCES organizations(org_id) ON DELETE CASCADE,
+					user_id     UUID REFERENCES users(user_id) ON DELETE CASCADE,
+					date_joined TIMESTAMP,
+					PRIMARY KEY (org_id, user_id)
+				);
+				CREATE INDEX org_members_user_id_idx ON org_members (user_id);
+				INSERT INTO org_members (org_id, user_id, date_joined)
+					SELECT '00000000-0000-0000-0000-000000000001', user_id, date_created FROM users;
+				CREATE TABLE org_invites (
+					token_hash   TEXT,
+					org_id       UUID REFERENCES organizations(org_id) ON DELETE CASCADE,
+					email        TEXT,
+					invited_by   UUID,
+					date_expires TIMESTAMP,
+					PRIMARY KEY (token_hash)
+				);
+				ALTER TABLE products
+					ADD COLUMN org_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations(org_id);
+				CREATE INDEX products_org_id_idx ON products (org_id, date_created);
+				ALTER TABLE orders
+					ADD COLUMN org_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations(org_id);
+				CREATE INDEX orders_org_id_idx ON orders (org_id, date_created);
+				ALTER TABLE api_keys
+					ADD COLUMN org_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations(org_id);
+				ALTER TABLE refresh_tokens
+					ADD COLUMN org_id UUID;
+				INSERT INTO role_permissions (role, permission) VALUES ('ADMIN', 'orgs:create');`,
+	},
+	{
+		Version:     14,
+		Description: "Add product soft delete",
+		Script: `ALTER TABLE products ADD COLUMN deleted_at TIMESTAMP;
+				CREATE INDEX products_deleted_at_idx ON products (deleted_at) WHERE deleted_at IS NOT NULL;
+				INSERT INTO role_permissions (role, permission) VALUES ('ADMIN', 'products:restore');`,
+	},
+	{
+		Version:     15,
+		Description: "Add product versions",
+		Script:      `ALTER TABLE products ADD COLUMN version INT NOT NULL DEFAULT 1;`,
+	},
+	{
+		Version:     16,
+		Description: "Add categories and tags",
+		Script: `CREATE TABLE categories (
+					category_id  UUID,
+					org_id       UUID NOT NULL REFERENCES organizations(org_id) ON DELETE CASCADE,
+					parent_id    UUID REFERENCES categories(category_id),
+					name         TEXT NOT NULL,
+					date_created TIMESTAMP,
+					date_updated TIMESTAMP,
+					PRIMARY KEY (category_id)
+				);
+				CREATE UNIQUE INDEX categories_name_idx ON categories (org_id, COALESCE(parent_id, org_id), name);
+				CREATE INDEX categories_parent_id_idx ON categories (parent_id);
+				ALTER TABLE products
+					ADD COLUMN category_id UUID REFERENCES categories(category_id) ON DELETE SET NULL;
+				CREATE INDEX products_category_id_idx ON products (category_id);
+				CREATE TABLE tags (
+					tag_id       UUID,
+					org_id       UUID NOT NULL REFERENCES organizations(org_id) ON DELETE CASCADE,
+					name         TEXT NOT NULL,
+					date_created TIMESTAMP,
+					PRIMARY KEY (tag_id),
+					UNIQUE (org_id, name)
+				);
+				CREATE TABLE product_tags (
+					product_id UUID REFERENCES products(product_id) ON DELETE CASCADE,
+					tag_id     UUID REFERENCES tags(tag_id) ON DELETE CASCADE,
+					PRIMARY KEY (product_id, tag_id)
+				);
+				CREATE INDEX product_tags_tag_id_idx ON product_tags (tag_id);
+				INSERT INTO role_permissions (role, permission) VALUES
+					('ADMIN', 'categories:manage'),
+					('ADMIN', 'tags:manage');`,
+	},
+	{
+		Version:     17,
+		Description: "Add product descriptions and search",
+		Script: `CREATE EXTENSION IF NOT EXISTS pg_trgm;
+				ALTER TABLE products
+					ADD COLUMN description   TEXT NOT NULL DEFAULT '',
+					ADD COLUMN search_vector TSVECTOR;
+				CREATE FUNCTION products_search_vector() RETURNS trigger AS $$
+				BEGIN
+					NEW.search_vector :=
+						setweight(to_tsvector('english', NEW.name), 'A') ||
+						setweight(to_tsvector('english', NEW.description), 'B');
+					RETURN NEW;
+				END
+				$$ LANGUAGE plpgsql;
+				CREATE TRIGGER products_search_vector_update
+					BEFORE INSERT OR UPDATE OF name, description ON products
+					FOR EACH ROW EXECUTE PROCEDURE products_search_vector();
+				UPDATE products SET name = name;
+				CREATE INDEX products_search_vector_idx ON products USING GIN (search_vector);
+				CREATE INDEX products_name_trgm_idx ON products USING GIN (name gin_trgm_ops);
+				CREATE INDEX products_description_trgm_idx ON products USING GIN (description gin_trgm_ops);`,
+	},
+	{
+		Version:     18,
+		Description: "Add product images",
+		Script: `CREATE TABLE product_images (
+					image_id     UUID,
+					product_id   UUID NOT NULL REFERENCES products(product_id) ON DELETE CASCADE,
+					content_type TEXT NOT NULL,
+					size         INT NOT NULL,
+					width        INT NOT NULL,
+					height       INT NOT NULL,
+					date_created TIMESTAMP,
+					PRIMARY KEY (image_id)
+				);
+				CREATE INDEX product_images_product_id_idx ON product_images (product_id, date_created);`,
+	},
+	{
+		// Amounts used to be whole units of a currency each organization
+		// chose for itself. They are converted to minor units of the
+		// currency Migrate was given for the organization, or to cents of
+		// US dollars.
+		Version:     19,
+		Description: "Add currencies to amounts",
+		Script: `CREATE TYPE money_value AS (amount BIGINT, currency CHAR(3));
+				CREATE TEMPORARY TABLE legacy_amounts ON COMMIT DROP AS
+					SELECT o.org_id,
+						COALESCE(l.currency, 'USD') AS legacy_currency,
+						COALESCE(l.scale, 100) AS legacy_scale
+					FROM organizations AS o
+					LEFT JOIN migrate_legacy_currencies AS l ON l.org_id = o.org_id;
+				ALTER TABLE products ADD COLUMN amount money_value;
+				UPDATE products AS p
+					SET amount = ROW(COALESCE(p.cost, 0)::BIGINT * o.legacy_scale, o.legacy_currency)::money_value
+					FROM legacy_amounts AS o WHERE o.org_id = p.org_id;
+				ALTER TABLE products DROP COLUMN cost;
+				ALTER TABLE products RENAME COLUMN amount TO cost;
+				ALTER TABLE products ALTER COLUMN cost SET NOT NULL;
+				ALTER TABLE sales ADD COLUMN amount money_value;
+				UPDATE sales AS s
+					SET amount = ROW(COALESCE(s.paid, 0)::BIGINT * o.legacy_scale, o.legacy_currency)::money_value
+					FROM products AS p, legacy_amounts AS o
+					WHERE p.product_id = s.product_id AND o.org_id = p.org_id;
+				ALTER TABLE sales DROP COLUMN paid;
+				ALTER TABLE sales RENAME COLUMN amount TO paid;
+				ALTER TABLE sales ALTER COLUMN paid SET NOT NULL;
+				ALTER TABLE orders ADD COLUMN amount money_value;
+				UPDATE orders AS r
+					SET amount = ROW(COALESCE(r.total, 0)::BIGINT * o.legacy_scale, o.legacy_currency)::money_value
+					FROM legacy_amounts AS o WHERE o.org_id = r.org_id;
+				ALTER TABLE orders DROP COLUMN total;
+				ALTER TABLE orders RENAME COLUMN amount TO total;
+				ALTER TABLE orders ALTER COLUMN total SET NOT NULL;
+				CREATE INDEX products_currency_idx ON products (((cost).currency));`,
+	},
+	{
+		// Reports and exports read the sales of a range of dates.
+		Version:     20,
+		Description: "Add index on sale dates",
+		Script:      `CREATE INDEX sales_date_created_idx ON sales (date_created, sale_id);`,
+	},
+	{
+		// Keys act on behalf of their user and go away with them.
+		Version:     21,
+		Description: "Add user foreign key to api keys",
+		Script: `DELETE FROM api_keys WHERE user_id IS NULL OR user_id NOT IN (SELECT user_id FROM users);
+				ALTER TABLE api_keys
+					ALTER COLUMN user_id SET NOT NULL,
+					ADD FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE;`,
+	},
+	{
+		// A user may be an admin in one organization and a cashier in
+		// another so roles belong to the membership.
+		Version:     22,
+		Description: "Move roles to organization members",
+		Script: `ALTER TABLE org_members ADD COLUMN roles TEXT[] NOT NULL DEFAULT '{}';
+				UPDATE org_members AS m SET roles = COALESCE(u.roles, '{}')
+					FROM users AS u WHERE u.user_id = m.user_id;
+				ALTER TABLE org_members ALTER COLUMN roles DROP DEFAULT;
+				ALTER TABLE users DROP COLUMN roles;`,
+	},
+	{
+		// Exports add up the sales of every product they write.
+		Version:     23,
+		Description: "Add index on sale products",
+		Script:      `CREATE INDEX sales_product_id_idx ON sales (product_id);`,
+	},
 }
 
 // Migrate attempts to bring the db schema up to date
-// with the migrations in this package.
-func Migrate(db *sqlx.DB) error {
+// with the migrations in this package. Amounts kept before they had
+// currencies are converted from whole units of legacy[orgID], a currency
+// code, or of US dollars for organizations missing from legacy.
+func Migrate(db *sqlx.DB, legacy map[string]string) error {
+
+	// Migration 19 reads the legacy currencies from this table, which only
+	// exists while migrating.
+	const create = `CREATE TABLE IF NOT EXISTS migrate_legacy_currencies (
+						org_id   UUID,
+						currency CHAR(3) NOT NULL,
+						scale    BIGINT NOT NULL,
+						PRIMARY KEY (org_id)
+					);
+					DELETE FROM migrate_legacy_currencies;`
+	if _, err := db.Exec(create); err != nil {
+		return errors.Wrap(err, "creating legacy currencies")
+	}
+	defer db.Exec(`DROP TABLE migrate_legacy_currencies`)
+
+	for orgID, code := range legacy {
+		if _, err := uuid.Parse(orgID); err != nil {
+			return errors.Errorf("legacy currency for invalid organization %q", orgID)
+		}
+		code = strings.ToUpper(code)
+		if !money.Valid(code) {
+			return errors.Errorf("unknown legacy currency %q", code)
+		}
+
+		scale := int64(1)
+		for i := 0; i < money.Digits(code); i++ {
+			scale *= 10
+		}
+		const q = `INSERT INTO migrate_legacy_currencies (org_id, currency, scale) VALUES ($1, $2, $3)`
+		if _, err := db.Exec(q, orgID, code, scale); err != nil {
+			return errors.Wrap(err, "inserting legacy currency")
+		}
+	}
+
 	driver := darwin.NewGenericDriver(db.DB, darwin.PostgresDialect{})
 	d := darwin.New(driver, migrations, nil)
 	return d.Migrate()
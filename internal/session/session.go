@@ -0,0 +1,249 @@
+// Package session manages refresh tokens and the revocation of issued tokens.
+//
+// Refresh tokens are rotated: every refresh token can be exchanged once for a
+// new access token and a new refresh token of the same family. Presenting a
+// refresh token a second time means it was copied, so the whole family is
+// revoked and the legitimate client has to log in again.
+package session
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+
+	"github.com/sreejeet/garagesale/internal/platform/auth"
+)
+
+// RefreshTTL is how long a refresh token can be used after it was issued.
+const RefreshTTL = 30 * 24 * time.Hour
+
+var (
+	// ErrInvalidToken occurs when a refresh token is unknown, expired or revoked.
+	ErrInvalidToken = errors.New("invalid or expired refresh token")
+	// ErrTokenReuse occurs when a refresh token that was already used is
+	// presented again. Its whole family is revoked when this happens.
+	ErrTokenReuse = errors.New("refresh token has already been used")
+)
+
+// refreshToken is a row of the refresh_tokens table.
+type refreshToken struct {
+	TokenHash   string     `db:"token_hash"`
+	FamilyID    string     `db:"family_id"`
+	UserID      string     `db:"user_id"`
+	OrgID       *string    `db:"org_id"`
+	DateCreated time.Time  `db:"date_created"`
+	DateExpires time.Time  `db:"date_expires"`
+	DateUsed    *time.Time `db:"date_used"`
+	Revoked     bool       `db:"revoked"`
+}
+
+// Issue creates the first refresh token of a new family for a user who just
+// logged in to the organization orgID, which may be blank. Only a hash of the
+// token is stored.
+func Issue(ctx context.Context, db *sqlx.DB, userID, orgID string, now time.Time) (string, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.session.Issue")
+	defer span.End()
+
+	return insertToken(ctx, db, userID, orgID, uuid.New().String(), now)
+}
+
+// Rotate exchanges a refresh token for a new one of the same family and
+// returns the user and the active organization it belongs to. Reusing a
+// token revokes its family.
+func Rotate(ctx context.Context, db *sqlx.DB, token string, now time.Time) (string, string, string, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.session.Rotate")
+	defer span.End()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return "", "", "", errors.Wrap(err, "starting refresh transaction")
+	}
+
+	// Rolling back after a commit is a no-op so this is safe to defer.
+	defer tx.Rollback()
+
+	var rt refreshToken
+	const q = `SELECT * FROM refresh_tokens WHERE token_hash = $1 FOR UPDATE`
+	if err := tx.GetContext(ctx, &rt, q, auth.HashSecret(token)); err != nil {
+		if err == sql.ErrNoRows {
+			return "", "", "", ErrInvalidToken
+		}
+		return "", "", "", errors.Wrap(err, "selecting refresh token")
+	}
+
+	if rt.DateUsed != nil {
+
+		// The revocation has to be committed even though the request fails.
+		if err := revokeFamily(ctx, tx, rt.FamilyID); err != nil {
+			return "", "", "", err
+		}
+		if err := tx.Commit(); err != nil {
+			return "", "", "", errors.Wrap(err, "committing family revocation")
+		}
+		return "", "", "", ErrTokenReuse
+	}
+
+	if rt.Revoked || !now.Before(rt.DateExpires) {
+		return "", "", "", ErrInvalidToken
+	}
+
+	const use = `UPDATE refresh_tokens SET date_used = $2 WHERE token_hash = $1`
+	if _, err := tx.ExecContext(ctx, use, rt.TokenHash, now.UTC()); err != nil {
+		return "", "", "", errors.Wrap(err, "using refresh token")
+	}
+
+	var orgID string
+	if rt.OrgID != nil {
+		orgID = *rt.OrgID
+	}
+
+	next, err := insertToken(ctx, tx, rt.UserID, orgID, rt.FamilyID, now)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", "", "", errors.Wrap(err, "committing refresh")
+	}
+
+	return rt.UserID, orgID, next, nil
+}
+
+// Logout revokes the access token the claims belong to and, when provided,
+// the refresh token family of the same session.
+func Logout(ctx context.Context, db *sqlx.DB, claims auth.Claims, refresh string, now time.Time) error {
+
+	ctx, span := trace.StartSpan(ctx, "internal.session.Logout")
+	defer span.End()
+
+	// Expired tokens are rejected anyway so their denylist
+	// entries can be removed while we are here.
+	const deny = `INSERT INTO revoked_tokens (jti, date_expires)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`
+	if _, err := db.ExecContext(ctx, deny, claims.Id, time.Unix(claims.ExpiresAt, 0).UTC()); err != nil {
+		return errors.Wrap(err, "revoking access token")
+	}
+	const purge = `DELETE FROM revoked_tokens WHERE date_expires < $1`
+	if _, err := db.ExecContext(ctx, purge, now.UTC()); err != nil {
+		return errors.Wrap(err, "purging revoked tokens")
+	}
+
+	if refresh == "" {
+		return nil
+	}
+
+	// Users can only log out of their own sessions.
+	const q = `UPDATE refresh_tokens SET revoked = TRUE
+		WHERE family_id = (
+			SELECT family_id FROM refresh_tokens
+			WHERE token_hash = $1 AND user_id = $2
+		)`
+	if _, err := db.ExecContext(ctx, q, auth.HashSecret(refresh), claims.Subject); err != nil {
+		return errors.Wrap(err, "revoking refresh tokens")
+	}
+
+	return nil
+}
+
+// RevokeAll ends every session of a user. Access tokens issued up to now are
+// rejected and no refresh token of the user can be used anymore.
+func RevokeAll(ctx context.Context, db *sqlx.DB, userID string, now time.Time) error {
+
+	ctx, span := trace.StartSpan(ctx, "internal.session.RevokeAll")
+	defer span.End()
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return errors.Wrap(err, "parsing user id")
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting revocation transaction")
+	}
+	defer tx.Rollback()
+
+	// Token issue times only have second precision so the revocation
+	// time is truncated to match.
+	const q = `INSERT INTO session_revocations (user_id, date_revoked)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET date_revoked = $2`
+	if _, err := tx.ExecContext(ctx, q, userID, now.UTC().Truncate(time.Second)); err != nil {
+		return errors.Wrap(err, "revoking sessions")
+	}
+
+	const rt = `UPDATE refresh_tokens SET revoked = TRUE WHERE user_id = $1`
+	if _, err := tx.ExecContext(ctx, rt, userID); err != nil {
+		return errors.Wrap(err, "revoking refresh tokens")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing revocation")
+	}
+
+	return nil
+}
+
+// RevocationCheck returns the function used by the authentication middleware
+// to reject tokens that were revoked individually or by RevokeAll.
+func RevocationCheck(db *sqlx.DB) auth.RevokedFunc {
+
+	f := func(ctx context.Context, claims auth.Claims) (bool, error) {
+
+		ctx, span := trace.StartSpan(ctx, "internal.session.IsRevoked")
+		defer span.End()
+
+		var revoked bool
+		const q = `SELECT
+				EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)
+				OR EXISTS (
+					SELECT 1 FROM session_revocations
+					WHERE user_id = $2 AND date_revoked >= $3
+				)`
+		issued := time.Unix(claims.IssuedAt, 0).UTC()
+		if err := db.GetContext(ctx, &revoked, q, claims.Id, claims.Subject, issued); err != nil {
+			return false, errors.Wrap(err, "checking token revocation")
+		}
+
+		return revoked, nil
+	}
+
+	return f
+}
+
+// insertToken stores a new refresh token of a family and returns it.
+func insertToken(ctx context.Context, db sqlx.ExecerContext, userID, orgID, familyID string, now time.Time) (string, error) {
+
+	token, hash, err := auth.NewSecret()
+	if err != nil {
+		return "", err
+	}
+
+	const q = `INSERT INTO refresh_tokens
+		(token_hash, family_id, user_id, org_id, date_created, date_expires)
+		VALUES ($1, $2, $3, NULLIF($4, '')::UUID, $5, $6)`
+	_, err = db.ExecContext(ctx, q, hash, familyID, userID, orgID, now.UTC(), now.Add(RefreshTTL).UTC())
+	if err != nil {
+		return "", errors.Wrap(err, "creating refresh token")
+	}
+
+	return token, nil
+}
+
+// revokeFamily revokes every refresh token descending from the same login.
+func revokeFamily(ctx context.Context, tx *sqlx.Tx, familyID string) error {
+
+	const q = `UPDATE refresh_tokens SET revoked = TRUE WHERE family_id = $1`
+	if _, err := tx.ExecContext(ctx, q, familyID); err != nil {
+		return errors.Wrap(err, "revoking refresh token family")
+	}
+
+	return nil
+}
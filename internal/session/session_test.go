@@ -0,0 +1,80 @@
+package session_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sreejeet/garagesale/internal/org"
+	"github.com/sreejeet/garagesale/internal/platform/auth"
+	"github.com/sreejeet/garagesale/internal/session"
+	"github.com/sreejeet/garagesale/internal/tests"
+)
+
+func TestSession(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	first, err := session.Issue(ctx, db, tests.UserID, org.DefaultID, now)
+	if err != nil {
+		t.Fatalf("issuing refresh token: %s", err)
+	}
+
+	userID, orgID, second, err := session.Rotate(ctx, db, first, now)
+	if err != nil {
+		t.Fatalf("rotating refresh token: %s", err)
+	}
+	if userID != tests.UserID {
+		t.Fatalf("expected refresh token of user %s, got %s", tests.UserID, userID)
+	}
+	if orgID != org.DefaultID {
+		t.Fatalf("expected refresh token for organization %s, got %s", org.DefaultID, orgID)
+	}
+
+	// Reusing a rotated token revokes the whole family.
+	if _, _, _, err := session.Rotate(ctx, db, first, now); err != session.ErrTokenReuse {
+		t.Fatalf("expected %v reusing token, got %v", session.ErrTokenReuse, err)
+	}
+	if _, _, _, err := session.Rotate(ctx, db, second, now); err != session.ErrInvalidToken {
+		t.Fatalf("expected %v after reuse, got %v", session.ErrInvalidToken, err)
+	}
+
+	expired, err := session.Issue(ctx, db, tests.UserID, org.DefaultID, now)
+	if err != nil {
+		t.Fatalf("issuing refresh token: %s", err)
+	}
+	if _, _, _, err := session.Rotate(ctx, db, expired, now.Add(session.RefreshTTL)); err != session.ErrInvalidToken {
+		t.Fatalf("expected %v for expired token, got %v", session.ErrInvalidToken, err)
+	}
+
+	isRevoked := session.RevocationCheck(db)
+
+	claims := auth.NewClaims(tests.UserID, []string{auth.RoleUser}, now, time.Hour)
+	if revoked, err := isRevoked(ctx, claims); err != nil || revoked {
+		t.Fatalf("expected fresh token to be valid, got %v, %v", revoked, err)
+	}
+
+	if err := session.Logout(ctx, db, claims, "", now); err != nil {
+		t.Fatalf("logging out: %s", err)
+	}
+	if revoked, err := isRevoked(ctx, claims); err != nil || !revoked {
+		t.Fatalf("expected logged out token to be revoked, got %v, %v", revoked, err)
+	}
+
+	// Revoking all sessions refuses every token issued up to that point.
+	older := auth.NewClaims(tests.UserID, []string{auth.RoleUser}, now, time.Hour)
+	if err := session.RevokeAll(ctx, db, tests.UserID, now.Add(time.Minute)); err != nil {
+		t.Fatalf("revoking sessions: %s", err)
+	}
+	if revoked, err := isRevoked(ctx, older); err != nil || !revoked {
+		t.Fatalf("expected older token to be revoked, got %v, %v", revoked, err)
+	}
+
+	newer := auth.NewClaims(tests.UserID, []string{auth.RoleUser}, now.Add(time.Hour), time.Hour)
+	if revoked, err := isRevoked(ctx, newer); err != nil || revoked {
+		t.Fatalf("expected newer token to be valid, got %v, %v", revoked, err)
+	}
+}
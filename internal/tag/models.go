@@ -0,0 +1,18 @@
+package tag
+
+import "time"
+
+// Tag is a free-form label, like "vintage" or "signed", products can be
+// marked with. Products counts the products currently carrying the tag.
+type Tag struct {
+	ID          string    `db:"tag_id" json:"id"`
+	OrgID       string    `db:"org_id" json:"org_id"`
+	Name        string    `db:"name" json:"name"`
+	Products    int       `db:"products" json:"products"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+}
+
+// NewTag is what we require from clients when creating or renaming a tag.
+type NewTag struct {
+	Name string `json:"name" validate:"required,max=50"`
+}
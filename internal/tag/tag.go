@@ -0,0 +1,215 @@
+// Package tag manages the free-form tags of products. Tags are stored in
+// lower case so "Vintage" and "vintage" are the same tag.
+package tag
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// Custom errors for expected failing conditions
+var (
+	// Invalid UUID
+	ErrInvalidID = errors.New("invalid ID")
+	// Unable to find tag based on UUID
+	ErrNotFound = errors.New("tag not found")
+	// ErrDuplicateName occurs when a tag of the same name already exists.
+	ErrDuplicateName = errors.New("tag already exists")
+)
+
+// isUniqueViolation reports whether err was caused by a unique constraint.
+func isUniqueViolation(err error) bool {
+	pqErr, ok := errors.Cause(err).(*pq.Error)
+	return ok && pqErr.Code == "23505"
+}
+
+// Normalize returns the form a tag name is stored in.
+func Normalize(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// selectTags reads tags together with the number of products
+// that carry them. Deleted products are not counted.
+const selectTags = `SELECT t.*, COUNT(p.product_id) AS products
+					FROM tags AS t
+					LEFT JOIN product_tags AS pt ON pt.tag_id = t.tag_id
+					LEFT JOIN products AS p ON p.product_id = pt.product_id AND p.deleted_at IS NULL`
+
+// List returns every tag of an organization ordered by name.
+func List(ctx context.Context, db *sqlx.DB, orgID string) ([]Tag, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.tag.List")
+	defer span.End()
+
+	if _, err := uuid.Parse(orgID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	tags := []Tag{}
+	const q = selectTags + ` WHERE t.org_id = $1 GROUP BY t.tag_id ORDER BY t.name`
+	if err := db.SelectContext(ctx, &tags, q, orgID); err != nil {
+		return nil, errors.Wrap(err, "selecting tags")
+	}
+
+	return tags, nil
+}
+
+// Retrieve gets a single tag of the organization orgID.
+func Retrieve(ctx context.Context, db *sqlx.DB, orgID, id string) (*Tag, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.tag.Retrieve")
+	defer span.End()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+	if _, err := uuid.Parse(orgID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var t Tag
+	const q = selectTags + ` WHERE t.tag_id = $1 AND t.org_id = $2 GROUP BY t.tag_id`
+	if err := db.GetContext(ctx, &t, q, id, orgID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "selecting one tag")
+	}
+
+	return &t, nil
+}
+
+// Create adds a tag to the organization orgID. Tags are also created
+// implicitly when products are tagged.
+func Create(ctx context.Context, db *sqlx.DB, orgID string, nt NewTag, now time.Time) (*Tag, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.tag.Create")
+	defer span.End()
+
+	t := Tag{
+		ID:          uuid.New().String(),
+		OrgID:       orgID,
+		Name:        Normalize(nt.Name),
+		DateCreated: now.UTC(),
+	}
+
+	const q = `INSERT INTO tags (tag_id, org_id, name, date_created) VALUES ($1, $2, $3, $4)`
+	if _, err := db.ExecContext(ctx, q, t.ID, t.OrgID, t.Name, t.DateCreated); err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrDuplicateName
+		}
+		return nil, errors.Wrap(err, "inserting tag")
+	}
+
+	return &t, nil
+}
+
+// Rename changes the name of a tag of the organization orgID. The products
+// carrying the tag keep it.
+func Rename(ctx context.Context, db *sqlx.DB, orgID, id string, nt NewTag) error {
+
+	ctx, span := trace.StartSpan(ctx, "internal.tag.Rename")
+	defer span.End()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+	if _, err := uuid.Parse(orgID); err != nil {
+		return ErrInvalidID
+	}
+
+	const q = `UPDATE tags SET name = $3 WHERE tag_id = $1 AND org_id = $2`
+	res, err := db.ExecContext(ctx, q, id, orgID, Normalize(nt.Name))
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrDuplicateName
+		}
+		return errors.Wrapf(err, "renaming tag %s", id)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "counting renamed tags")
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Delete removes a tag of the organization orgID from every product.
+func Delete(ctx context.Context, db *sqlx.DB, orgID, id string) error {
+
+	ctx, span := trace.StartSpan(ctx, "internal.tag.Delete")
+	defer span.End()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+	if _, err := uuid.Parse(orgID); err != nil {
+		return ErrInvalidID
+	}
+
+	const q = `DELETE FROM tags WHERE tag_id = $1 AND org_id = $2`
+	res, err := db.ExecContext(ctx, q, id, orgID)
+	if err != nil {
+		return errors.Wrapf(err, "deleting tag %s", id)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "counting deleted tags")
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Set replaces the tags of a product with names, creating the tags of the
+// organization that do not exist yet. It accepts a transaction so products
+// and their tags are saved together.
+func Set(ctx context.Context, db sqlx.ExecerContext, orgID, productID string, names []string, now time.Time) error {
+
+	const clear = `DELETE FROM product_tags WHERE product_id = $1`
+	if _, err := db.ExecContext(ctx, clear, productID); err != nil {
+		return errors.Wrap(err, "removing product tags")
+	}
+
+	normalized := make([]string, 0, len(names))
+	for _, name := range names {
+		if name = Normalize(name); name != "" {
+			normalized = append(normalized, name)
+		}
+	}
+	if len(normalized) == 0 {
+		return nil
+	}
+
+	const create = `INSERT INTO tags (tag_id, org_id, name, date_created)
+					VALUES ($1, $2, $3, $4)
+					ON CONFLICT (org_id, name) DO NOTHING`
+	for _, name := range normalized {
+		if _, err := db.ExecContext(ctx, create, uuid.New().String(), orgID, name, now.UTC()); err != nil {
+			return errors.Wrapf(err, "creating tag %q", name)
+		}
+	}
+
+	const link = `INSERT INTO product_tags (product_id, tag_id)
+					SELECT $1, tag_id FROM tags WHERE org_id = $2 AND name = ANY($3)`
+	if _, err := db.ExecContext(ctx, link, productID, orgID, pq.Array(normalized)); err != nil {
+		return errors.Wrap(err, "tagging product")
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package tag_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sreejeet/garagesale/internal/org"
+	"github.com/sreejeet/garagesale/internal/schema"
+	"github.com/sreejeet/garagesale/internal/tag"
+	"github.com/sreejeet/garagesale/internal/tests"
+)
+
+func TestTags(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	if err := schema.Seed(db); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	const comics = "a2b0639f-2cc6-44b8-b97b-15d69dbb511e"
+
+	signed, err := tag.Create(ctx, db, org.DefaultID, tag.NewTag{Name: " Signed "}, now)
+	if err != nil {
+		t.Fatalf("creating tag: %s", err)
+	}
+	if signed.Name != "signed" {
+		t.Fatalf("expected normalized name, got %q", signed.Name)
+	}
+	if _, err := tag.Create(ctx, db, org.DefaultID, tag.NewTag{Name: "SIGNED"}, now); err != tag.ErrDuplicateName {
+		t.Fatalf("expected %v creating duplicate, got %v", tag.ErrDuplicateName, err)
+	}
+
+	// Tagging a product creates missing tags and reuses existing ones.
+	if err := tag.Set(ctx, db, org.DefaultID, comics, []string{"signed", "Rare", "rare", " "}, now); err != nil {
+		t.Fatalf("tagging product: %s", err)
+	}
+
+	list, err := tag.List(ctx, db, org.DefaultID)
+	if err != nil {
+		t.Fatalf("listing tags: %s", err)
+	}
+	if len(list) != 2 || list[0].Name != "rare" || list[0].Products != 1 || list[1].ID != signed.ID || list[1].Products != 1 {
+		t.Fatalf("unexpected tags %+v", list)
+	}
+
+	if err := tag.Rename(ctx, db, org.DefaultID, signed.ID, tag.NewTag{Name: "rare"}); err != tag.ErrDuplicateName {
+		t.Fatalf("expected %v renaming to existing tag, got %v", tag.ErrDuplicateName, err)
+	}
+	if err := tag.Rename(ctx, db, org.DefaultID, signed.ID, tag.NewTag{Name: "Autographed"}); err != nil {
+		t.Fatalf("renaming tag: %s", err)
+	}
+
+	// Other organizations can not change the tags.
+	const otherOrg = "8c1f0b5e-2f4d-4a7e-9b8e-3d7a1c2b4e5f"
+	if err := tag.Delete(ctx, db, otherOrg, signed.ID); err != tag.ErrNotFound {
+		t.Fatalf("expected %v deleting tag of another organization, got %v", tag.ErrNotFound, err)
+	}
+
+	if err := tag.Delete(ctx, db, org.DefaultID, signed.ID); err != nil {
+		t.Fatalf("deleting tag: %s", err)
+	}
+	if _, err := tag.Retrieve(ctx, db, org.DefaultID, signed.ID); err != tag.ErrNotFound {
+		t.Fatalf("expected %v after delete, got %v", tag.ErrNotFound, err)
+	}
+}
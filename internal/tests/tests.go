@@ -4,15 +4,19 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
+	"io/ioutil"
 	"log"
 	"os"
 	"testing"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/sreejeet/garagesale/internal/lockout"
 	"github.com/sreejeet/garagesale/internal/platform/auth"
 	"github.com/sreejeet/garagesale/internal/platform/database"
 	"github.com/sreejeet/garagesale/internal/platform/database/databasetest"
+	"github.com/sreejeet/garagesale/internal/platform/mail"
+	"github.com/sreejeet/garagesale/internal/platform/storage"
 	"github.com/sreejeet/garagesale/internal/schema"
 	"github.com/sreejeet/garagesale/internal/user"
 )
@@ -64,7 +68,7 @@ func NewUnit(t *testing.T) (*sqlx.DB, func()) {
 	}
 
 	// Perform schema migration
-	if err := schema.Migrate(db); err != nil {
+	if err := schema.Migrate(db, nil); err != nil {
 		databasetest.StopContainer(t, c)
 		t.Fatalf("Migration failed %s", err)
 	}
@@ -84,6 +88,10 @@ type Test struct {
 	DB            *sqlx.DB
 	Log           *log.Logger
 	Authenticator *auth.Authenticator
+	Keys          *auth.KeySet
+	Outbox        *mail.Outbox
+	Store         *storage.FS
+	Guard         *lockout.Guard
 
 	t       *testing.T
 	cleanup func()
@@ -111,18 +119,62 @@ func New(t *testing.T) *Test {
 
 	// Build an authenticator using this static key.
 	kid := "4754d86b-7a6d-4df5-9c65-224741361492"
-	kf := auth.NewSimpleKeyLookupFunc(kid, key.Public().(*rsa.PublicKey))
-	authenticator, err := auth.NewAuthenticator(key, kid, "RS256", kf)
+	keys := auth.NewKeySet(kid, key)
+	tokens := auth.Policy{
+		Issuer:   "garagesale-test",
+		Audience: "sales-api",
+		Lifetime: time.Hour,
+	}
+	authenticator, err := auth.NewAuthenticator(key, kid, "RS256", keys.Lookup, tokens)
 	if err != nil {
 		t.Fatal(err)
 	}
 
+	// Collect sent mail in a temporary directory so tests can read it.
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbox, err := mail.NewOutbox(dir, "test@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep uploaded images in a temporary directory as well.
+	blobDir, err := ioutil.TempDir("", "blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := storage.NewFS(blobDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Track failed logins in memory. Delays are disabled so tests with
+	// failed logins do not slow down the ones that follow.
+	policy := lockout.Policy{
+		MaxFailures:     3,
+		LockoutDuration: time.Minute,
+		Window:          time.Minute,
+	}
+	addrPolicy := policy
+	addrPolicy.MaxFailures = 100
+	guard := lockout.NewGuard(lockout.NewMemoryStore(), policy, addrPolicy)
+
 	return &Test{
 		DB:            db,
 		Log:           logger,
 		Authenticator: authenticator,
+		Keys:          keys,
+		Outbox:        outbox,
+		Store:         store,
+		Guard:         guard,
 		t:             t,
-		cleanup:       cleanup,
+		cleanup: func() {
+			cleanup()
+			os.RemoveAll(dir)
+			os.RemoveAll(blobDir)
+		},
 	}
 }
 
@@ -162,3 +214,9 @@ func StringPointer(s string) *string {
 func IntPointer(i int) *int {
 	return &i
 }
+
+// Int64Pointer is a helper function to return a pointer to an int64.
+// We do not need this outside testing so it is declared here.
+func Int64Pointer(i int64) *int64 {
+	return &i
+}
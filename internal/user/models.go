@@ -7,12 +7,17 @@ import (
 )
 
 // User model for any internal user of the system.
+// Users who registered themselves are not Verified until they
+// confirm their email address and can not log in before that.
+// Roles are granted per organization; they are the user's roles
+// in the organization the user was read in.
 type User struct {
 	ID           string         `db:"user_id" json:"id"`
 	Name         string         `db:"name" json:"name"`
 	Email        string         `db:"email" json:"email"`
 	Roles        pq.StringArray `db:"roles" json:"roles"`
 	PasswordHash []byte         `db:"password_hash" json:"-"`
+	Verified     bool           `db:"verified" json:"verified"`
 	DateCreated  time.Time      `db:"date_created" json:"date_created"`
 	DateUpdated  time.Time      `db:"date_updated" json:"date_updated"`
 }
@@ -20,8 +25,65 @@ type User struct {
 // NewUser is the form sent by the client for creating new users
 type NewUser struct {
 	Name            string   `json:"name" validate:"required"`
-	Email           string   `json:"email" validate:"required"`
+	Email           string   `json:"email" validate:"required,email"`
 	Roles           []string `json:"roles" validate:"required"`
-	Password        string   `json:"password" validate:"required"`
+	Password        string   `json:"password" validate:"required,password"`
 	PasswordConfirm string   `json:"password_confirm" validate:"eqfield=Password"`
 }
+
+// Registration is the form sent by people signing themselves up. Unlike
+// NewUser it has no roles; self registered users get them in the
+// organizations they join.
+type Registration struct {
+	Name            string `json:"name" validate:"required"`
+	Email           string `json:"email" validate:"required,email"`
+	Password        string `json:"password" validate:"required,password"`
+	PasswordConfirm string `json:"password_confirm" validate:"eqfield=Password"`
+}
+
+// UpdateUser defines what information may be provided to modify an existing
+// User. All fields are optional so clients can send just the fields they want
+// changed. It uses pointer fields so we can differentiate between a field that
+// was not provided and a field that was provided as explicitly blank.
+// Passwords are changed through their own flow and are not part of this form.
+type UpdateUser struct {
+	Name  *string  `json:"name"`
+	Email *string  `json:"email" validate:"omitempty,email"`
+	Roles []string `json:"roles"`
+}
+
+// EditUser holds the fields of a User that can be changed. Patches are
+// applied to it and the result must still be a valid user.
+type EditUser struct {
+	Name  string   `json:"name" validate:"required"`
+	Email string   `json:"email" validate:"required,email"`
+	Roles []string `json:"roles" validate:"required"`
+}
+
+// VerificationRequest is the form for asking for a new verification email
+// when the first one was lost or has expired.
+type VerificationRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// PasswordChange is the form for users changing their own password.
+// The current password is required so a stolen token is not enough
+// to take over an account.
+type PasswordChange struct {
+	OldPassword     string `json:"old_password" validate:"required"`
+	Password        string `json:"password" validate:"required,password"`
+	PasswordConfirm string `json:"password_confirm" validate:"eqfield=Password"`
+}
+
+// PasswordResetRequest is the form for requesting a password reset email.
+type PasswordResetRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// PasswordReset is the form for choosing a new password
+// using the token from a password reset email.
+type PasswordReset struct {
+	Token           string `json:"token" validate:"required"`
+	Password        string `json:"password" validate:"required,password"`
+	PasswordConfirm string `json:"password_confirm" validate:"eqfield=Password"`
+}
@@ -0,0 +1,130 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/sreejeet/garagesale/internal/platform/auth"
+)
+
+// ResetTTL is how long a password reset token can be used after it was issued.
+const ResetTTL = time.Hour
+
+// ChangePassword replaces the password of a user after
+// checking that they know their current one.
+func ChangePassword(ctx context.Context, db *sqlx.DB, id string, cp PasswordChange, now time.Time) error {
+
+	ctx, span := trace.StartSpan(ctx, "internal.user.ChangePassword")
+	defer span.End()
+
+	var hash []byte
+	const q = `SELECT password_hash FROM users WHERE user_id = $1`
+	if err := db.GetContext(ctx, &hash, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return errors.Wrapf(err, "selecting user %q", id)
+	}
+
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(cp.OldPassword)); err != nil {
+		return ErrAuthenticationFailure
+	}
+
+	return setPassword(ctx, db, id, cp.Password, now)
+}
+
+// RequestPasswordReset issues a single use password reset token for the user
+// with the provided email. Only a hash of the token is stored. ErrNotFound is
+// returned for unknown emails; callers should not reveal that to clients.
+func RequestPasswordReset(ctx context.Context, db *sqlx.DB, email string, now time.Time) (*User, string, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.user.RequestPasswordReset")
+	defer span.End()
+
+	var u User
+	const q = `SELECT * FROM users WHERE email = $1`
+	if err := db.GetContext(ctx, &u, q, email); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, "", ErrNotFound
+		}
+		return nil, "", errors.Wrap(err, "selecting user by email")
+	}
+
+	token, hash, err := auth.NewSecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	const ins = `INSERT INTO password_resets
+		(token_hash, user_id, date_expires)
+		VALUES ($1, $2, $3)`
+	if _, err := db.ExecContext(ctx, ins, hash, u.ID, now.Add(ResetTTL).UTC()); err != nil {
+		return nil, "", errors.Wrap(err, "creating password reset")
+	}
+
+	return &u, token, nil
+}
+
+// ResetPassword sets a new password using a reset token. The token is used up
+// and any other outstanding reset tokens of the user are discarded.
+func ResetPassword(ctx context.Context, db *sqlx.DB, rp PasswordReset, now time.Time) error {
+
+	ctx, span := trace.StartSpan(ctx, "internal.user.ResetPassword")
+	defer span.End()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting reset transaction")
+	}
+
+	// Rolling back after a commit is a no-op so this is safe to defer.
+	defer tx.Rollback()
+
+	var userID string
+	const use = `DELETE FROM password_resets
+		WHERE token_hash = $1 AND date_expires > $2
+		RETURNING user_id`
+	if err := tx.GetContext(ctx, &userID, use, auth.HashSecret(rp.Token), now.UTC()); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrInvalidToken
+		}
+		return errors.Wrap(err, "consuming reset token")
+	}
+
+	const discard = `DELETE FROM password_resets WHERE user_id = $1`
+	if _, err := tx.ExecContext(ctx, discard, userID); err != nil {
+		return errors.Wrap(err, "discarding reset tokens")
+	}
+
+	if err := setPassword(ctx, tx, userID, rp.Password, now); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing password reset")
+	}
+
+	return nil
+}
+
+// setPassword hashes and stores a new password for a user.
+func setPassword(ctx context.Context, db sqlx.ExecerContext, id, password string, now time.Time) error {
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return errors.Wrap(err, "generating password hash")
+	}
+
+	const q = `UPDATE users SET password_hash = $2, date_updated = $3 WHERE user_id = $1`
+	if _, err := db.ExecContext(ctx, q, id, hash, now.UTC()); err != nil {
+		return errors.Wrap(err, "updating password")
+	}
+
+	return nil
+}
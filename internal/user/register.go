@@ -0,0 +1,171 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/sreejeet/garagesale/internal/platform/auth"
+)
+
+// VerificationTTL is how long a verification token can be used after sign up.
+const VerificationTTL = 24 * time.Hour
+
+// ErrInvalidToken occurs when a verification token is unknown or expired.
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// VerifyFunc sends the token confirming the email address of a user to that
+// address. It is called before the change needing confirmation is committed
+// so nothing is saved when the email can not be sent.
+type VerifyFunc func(ctx context.Context, u User, token string) error
+
+// Register creates an unverified user for someone signing themselves up. They
+// get roles once they join an organization. The verification token is handed
+// to send, which has to deliver it to their email address. Only a hash of the
+// token is stored.
+func Register(ctx context.Context, db *sqlx.DB, r Registration, now time.Time, send VerifyFunc) (*User, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.user.Register")
+	defer span.End()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(r.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, errors.Wrap(err, "generating password hash")
+	}
+
+	u := User{
+		ID:           uuid.New().String(),
+		Name:         r.Name,
+		Email:        r.Email,
+		PasswordHash: hash,
+		Verified:     false,
+		DateCreated:  now.UTC(),
+		DateUpdated:  now.UTC(),
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting registration transaction")
+	}
+
+	// Rolling back after a commit is a no-op so this is safe to defer.
+	defer tx.Rollback()
+
+	if err := insertUser(ctx, tx, u); err != nil {
+		return nil, err
+	}
+	if err := sendVerification(ctx, tx, u, now, send); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing registration")
+	}
+
+	return &u, nil
+}
+
+// ResendVerification sends a new verification token to the unverified user
+// with the provided email, replacing the tokens sent before. ErrNotFound is
+// returned when there is no such user; callers should not reveal that to
+// clients.
+func ResendVerification(ctx context.Context, db *sqlx.DB, email string, now time.Time, send VerifyFunc) error {
+
+	ctx, span := trace.StartSpan(ctx, "internal.user.ResendVerification")
+	defer span.End()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting verification transaction")
+	}
+	defer tx.Rollback()
+
+	var u User
+	const q = `SELECT * FROM users WHERE email = $1 AND NOT verified FOR UPDATE`
+	if err := tx.GetContext(ctx, &u, q, email); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return errors.Wrap(err, "selecting user by email")
+	}
+
+	if err := sendVerification(ctx, tx, u, now, send); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing verification")
+	}
+
+	return nil
+}
+
+// sendVerification replaces the verification tokens of a user with a new one
+// and hands it to send as part of a transaction.
+func sendVerification(ctx context.Context, tx *sqlx.Tx, u User, now time.Time, send VerifyFunc) error {
+
+	token, tokenHash, err := auth.NewSecret()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_verifications WHERE user_id = $1`, u.ID); err != nil {
+		return errors.Wrap(err, "deleting verifications")
+	}
+
+	const q = `INSERT INTO user_verifications
+		(token_hash, user_id, date_expires)
+		VALUES ($1, $2, $3)`
+	if _, err := tx.ExecContext(ctx, q, tokenHash, u.ID, now.Add(VerificationTTL).UTC()); err != nil {
+		return errors.Wrap(err, "creating verification")
+	}
+
+	if err := send(ctx, u, token); err != nil {
+		return errors.Wrap(err, "sending verification")
+	}
+
+	return nil
+}
+
+// Verify confirms the email address of the user the token was issued to.
+// Tokens can only be used once.
+func Verify(ctx context.Context, db *sqlx.DB, token string, now time.Time) error {
+
+	ctx, span := trace.StartSpan(ctx, "internal.user.Verify")
+	defer span.End()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting verification transaction")
+	}
+	defer tx.Rollback()
+
+	// Deleting the token as it is read makes sure it is only used once.
+	var userID string
+	const del = `DELETE FROM user_verifications
+		WHERE token_hash = $1 AND date_expires > $2
+		RETURNING user_id`
+	if err := tx.GetContext(ctx, &userID, del, auth.HashSecret(token), now.UTC()); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrInvalidToken
+		}
+		return errors.Wrap(err, "consuming verification token")
+	}
+
+	const upd = `UPDATE users SET verified = TRUE, date_updated = $2 WHERE user_id = $1`
+	if _, err := tx.ExecContext(ctx, upd, userID, now.UTC()); err != nil {
+		return errors.Wrap(err, "verifying user")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing verification")
+	}
+
+	return nil
+}
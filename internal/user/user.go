@@ -7,24 +7,116 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 	"go.opencensus.io/trace"
 	"golang.org/x/crypto/bcrypt"
 
+	"github.com/sreejeet/garagesale/internal/org"
 	"github.com/sreejeet/garagesale/internal/platform/auth"
+	"github.com/sreejeet/garagesale/internal/policy"
 )
 
 var (
 	// ErrAuthenticationFailure is used to indicate any kind of authentication error.
 	ErrAuthenticationFailure = errors.New("Authentication failed")
+	// ErrInvalidID is used when a user id is not a valid UUID.
+	ErrInvalidID = errors.New("invalid ID")
+	// ErrNotFound is used when a specific user is requested but does not exist.
+	ErrNotFound = errors.New("user not found")
+	// ErrForbidden occurs when a user tries something they dont have access to.
+	ErrForbidden = errors.New("Attempted action is not allowed")
+	// ErrDuplicateEmail occurs when an email is already used by another user.
+	ErrDuplicateEmail = errors.New("email is already in use")
+	// ErrNotVerified occurs when a user who has not confirmed their email tries to log in.
+	ErrNotVerified = errors.New("email address has not been verified")
+	// ErrUnknownRole occurs when a user is given a role that does not exist.
+	ErrUnknownRole = errors.New("roles must be known roles")
 )
 
-// Create is used to create a new user.
-func Create(ctx context.Context, db *sqlx.DB, n NewUser, now time.Time) (*User, error) {
+// isUniqueViolation reports whether err was caused by a unique constraint.
+func isUniqueViolation(err error) bool {
+	pqErr, ok := errors.Cause(err).(*pq.Error)
+	return ok && pqErr.Code == "23505"
+}
+
+// List retrieves all members of an organization from the database.
+func List(ctx context.Context, db *sqlx.DB, orgID string) ([]User, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.user.List")
+	defer span.End()
+
+	users := []User{}
+	const q = `SELECT u.*, m.roles FROM users AS u
+				JOIN org_members AS m ON m.user_id = u.user_id
+				WHERE m.org_id = $1
+				ORDER BY u.date_created, u.user_id`
+
+	if err := db.SelectContext(ctx, &users, q, orgID); err != nil {
+		return nil, errors.Wrap(err, "selecting users")
+	}
+
+	return users, nil
+}
+
+// Retrieve gets the specified user from the database. Users may retrieve
+// their own record; clients allowed to manage users may also retrieve the
+// members of their active organization. The roles of the user are those of
+// their membership in the active organization.
+func Retrieve(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string) (*User, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.user.Retrieve")
+	defer span.End()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	if claims.Subject != id {
+		allowed, err := policy.Allowed(ctx, db, claims, policy.UsersManage, id)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			return nil, ErrForbidden
+		}
+	}
+
+	// Users of other organizations are reported as not found
+	// so admins can not probe which users exist.
+	var u User
+	const q = `SELECT u.*, m.roles FROM users AS u
+				LEFT JOIN org_members AS m
+					ON m.user_id = u.user_id AND m.org_id = NULLIF($3, '')::UUID
+				WHERE u.user_id = $1 AND (u.user_id = $2 OR m.user_id IS NOT NULL)`
+	if err := db.GetContext(ctx, &u, q, id, claims.Subject, claims.OrgID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting user %q", id)
+	}
+
+	return &u, nil
+}
+
+// Create is used to create a new user. The user becomes a member of
+// the organization orgID with the roles provided unless it is blank.
+// Roles that do not exist fail with ErrUnknownRole.
+func Create(ctx context.Context, db *sqlx.DB, orgID string, n NewUser, now time.Time) (*User, error) {
 
 	ctx, span := trace.StartSpan(ctx, "internal.user.Create")
 	defer span.End()
 
+	if orgID != "" {
+		known, err := policy.KnownRoles(ctx, db, n.Roles)
+		if err != nil {
+			return nil, err
+		}
+		if !known {
+			return nil, ErrUnknownRole
+		}
+	}
+
 	hash, err := bcrypt.GenerateFromPassword([]byte(n.Password), bcrypt.DefaultCost)
 	if err != nil {
 		return nil, errors.Wrap(err, "generating password hash")
@@ -35,26 +127,255 @@ func Create(ctx context.Context, db *sqlx.DB, n NewUser, now time.Time) (*User,
 		Name:         n.Name,
 		Email:        n.Email,
 		PasswordHash: hash,
-		Roles:        n.Roles,
+		Verified:     true,
 		DateCreated:  now.UTC(),
 		DateUpdated:  now.UTC(),
 	}
 
-	// Insert the new user into the database.
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting user transaction")
+	}
+
+	// Rolling back after a commit is a no-op so this is safe to defer.
+	defer tx.Rollback()
+
+	if err := insertUser(ctx, tx, u); err != nil {
+		return nil, err
+	}
+	if orgID != "" {
+		if err := org.AddMember(ctx, tx, orgID, u.ID, n.Roles, now); err != nil {
+			return nil, err
+		}
+		u.Roles = n.Roles
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing user")
+	}
+
+	return &u, nil
+}
+
+// insertUser writes a new user into the database.
+func insertUser(ctx context.Context, db sqlx.ExecerContext, u User) error {
+
 	const q = `INSERT INTO users
-               (user_id, name, email, password_hash, roles, date_created, date_updated)
+               (user_id, name, email, password_hash, verified, date_created, date_updated)
                VALUES ($1, $2, $3, $4, $5, $6, $7)`
-	_, err = db.ExecContext(
+	_, err := db.ExecContext(
 		ctx, q,
 		u.ID, u.Name, u.Email,
-		u.PasswordHash, u.Roles,
+		u.PasswordHash, u.Verified,
 		u.DateCreated, u.DateUpdated,
 	)
 	if err != nil {
-		return nil, errors.Wrap(err, "creating new user")
+		if isUniqueViolation(err) {
+			return ErrDuplicateEmail
+		}
+		return errors.Wrap(err, "creating new user")
 	}
 
-	return &u, nil
+	return nil
+}
+
+// Update modifies the fields of a user that are provided. Users may update
+// their own record but only clients allowed to manage users change roles,
+// which only apply in the active organization.
+// A new email address has to be confirmed like at sign up; the token is
+// handed to send.
+func Update(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string, upd UpdateUser, now time.Time, send VerifyFunc) error {
+
+	ctx, span := trace.StartSpan(ctx, "internal.user.Update")
+	defer span.End()
+
+	// Only update fields that have been passed as all fields are optional
+	apply := func(e *EditUser) error {
+		if upd.Name != nil {
+			e.Name = *upd.Name
+		}
+		if upd.Email != nil {
+			e.Email = *upd.Email
+		}
+		if upd.Roles != nil {
+			e.Roles = upd.Roles
+		}
+		return nil
+	}
+
+	return Patch(ctx, claims, db, id, apply, now, send)
+}
+
+// Patch modifies an existing user by letting apply change its editable
+// fields. Errors returned by apply are returned unchanged. Only clients
+// allowed to manage users may change the roles of a user, and only those of
+// their membership in the active organization, and only to roles that exist
+// or it fails with ErrUnknownRole. The email address of another
+// user can only be changed while they belong to no other organization.
+// Changing the email address marks the user as unverified until the token
+// handed to send is used, so an address nobody confirmed can never be used
+// to log in.
+func Patch(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string, apply func(*EditUser) error, now time.Time, send VerifyFunc) error {
+
+	ctx, span := trace.StartSpan(ctx, "internal.user.Patch")
+	defer span.End()
+
+	u, err := Retrieve(ctx, claims, db, id)
+	if err != nil {
+		return err
+	}
+
+	e := EditUser{
+		Name:  u.Name,
+		Email: u.Email,
+		Roles: append([]string{}, u.Roles...),
+	}
+	if err := apply(&e); err != nil {
+		return err
+	}
+
+	newRoles := !sameRoles(u.Roles, e.Roles)
+	if newRoles {
+		allowed, err := policy.Allowed(ctx, db, claims, policy.UsersManage, id)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return ErrForbidden
+		}
+
+		known, err := policy.KnownRoles(ctx, db, e.Roles)
+		if err != nil {
+			return err
+		}
+		if !known {
+			return ErrUnknownRole
+		}
+	}
+
+	// The account is shared by every organization of the user so admins
+	// of one can not take it over by changing where its email goes.
+	newEmail := e.Email != u.Email
+	if newEmail && id != claims.Subject {
+		elsewhere, err := memberElsewhere(ctx, db, id, claims.OrgID)
+		if err != nil {
+			return err
+		}
+		if elsewhere {
+			return ErrForbidden
+		}
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting user transaction")
+	}
+
+	// Rolling back after a commit is a no-op so this is safe to defer.
+	defer tx.Rollback()
+
+	const q = `UPDATE users SET
+				"name" = $2,
+				"email" = $3,
+				"verified" = "verified" AND NOT $4,
+				"date_updated" = $5
+				WHERE user_id = $1`
+	_, err = tx.ExecContext(ctx, q, id, e.Name, e.Email, newEmail, now.UTC())
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrDuplicateEmail
+		}
+		return errors.Wrap(err, "updating user")
+	}
+
+	if newRoles {
+		const q = `UPDATE org_members SET roles = $3 WHERE org_id = NULLIF($1, '')::UUID AND user_id = $2`
+		res, err := tx.ExecContext(ctx, q, claims.OrgID, id, pq.StringArray(e.Roles))
+		if err != nil {
+			return errors.Wrap(err, "updating roles")
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return errors.Wrap(err, "counting updated members")
+		}
+		if n == 0 {
+			return ErrForbidden
+		}
+	}
+
+	if newEmail {
+		u.Name, u.Email, u.Roles, u.Verified = e.Name, e.Email, e.Roles, false
+		if err := sendVerification(ctx, tx, *u, now, send); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing user")
+	}
+
+	return nil
+}
+
+// memberElsewhere reports whether a user belongs to an organization other
+// than orgID.
+func memberElsewhere(ctx context.Context, db *sqlx.DB, userID, orgID string) (bool, error) {
+
+	var elsewhere bool
+	const q = `SELECT EXISTS (
+				SELECT 1 FROM org_members
+				WHERE user_id = $1 AND org_id IS DISTINCT FROM NULLIF($2, '')::UUID
+			)`
+	if err := db.GetContext(ctx, &elsewhere, q, userID, orgID); err != nil {
+		return false, errors.Wrapf(err, "checking organizations of user %s", userID)
+	}
+
+	return elsewhere, nil
+}
+
+// sameRoles reports whether a and b hold the same roles in any order.
+func sameRoles(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[string]int, len(a))
+	for _, r := range a {
+		seen[r]++
+	}
+	for _, r := range b {
+		if seen[r] == 0 {
+			return false
+		}
+		seen[r]--
+	}
+	return true
+}
+
+// Delete removes a user from the database. Admins may only delete users who
+// are not members of any organization other than their active one.
+func Delete(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string) error {
+
+	ctx, span := trace.StartSpan(ctx, "internal.user.Delete")
+	defer span.End()
+
+	if _, err := Retrieve(ctx, claims, db, id); err != nil {
+		return err
+	}
+
+	elsewhere, err := memberElsewhere(ctx, db, id, claims.OrgID)
+	if err != nil {
+		return err
+	}
+	if elsewhere {
+		return ErrForbidden
+	}
+
+	const q = `DELETE FROM users WHERE user_id = $1`
+	if _, err := db.ExecContext(ctx, q, id); err != nil {
+		return errors.Wrapf(err, "deleting user %s", id)
+	}
+
+	return nil
 }
 
 // Authenticate searches the database for a user based on the email, verifies the password
@@ -86,7 +407,73 @@ func Authenticate(ctx context.Context, db *sqlx.DB, now time.Time, email, passwo
 		return auth.Claims{}, ErrAuthenticationFailure
 	}
 
+	// Only tell the caller the account is unverified once they have
+	// proven they know the password.
+	if !u.Verified {
+		return auth.Claims{}, ErrNotVerified
+	}
+
 	// Finally after the above checks have been successful, genereate user token.
-	claims := auth.NewClaims(u.ID, u.Roles, now, time.Hour)
+	return newClaims(ctx, db, u, "", now)
+}
+
+// Claims creates a fresh Claims object for an existing user, for example when
+// a session is refreshed or the user switches to the organization orgID. It
+// fails the same way as Authenticate when the user no longer exists or is not
+// verified, and with ErrForbidden when the user is not a member of orgID.
+func Claims(ctx context.Context, db *sqlx.DB, id, orgID string, now time.Time) (auth.Claims, error) {
+
+	ctx, span := trace.StartSpan(ctx, "internal.user.Claims")
+	defer span.End()
+
+	var u User
+	const q = `SELECT * FROM users WHERE user_id = $1`
+	if err := db.GetContext(ctx, &u, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return auth.Claims{}, ErrAuthenticationFailure
+		}
+		return auth.Claims{}, errors.Wrap(err, "selecting single user")
+	}
+
+	if !u.Verified {
+		return auth.Claims{}, ErrNotVerified
+	}
+
+	return newClaims(ctx, db, u, orgID, now)
+}
+
+// newClaims builds the claims for a user's access token acting in the
+// organization orgID. When orgID is blank the organization the user joined
+// first is used; users who are not a member of any have no active one and no
+// roles. The roles are those of the user's membership in the organization.
+func newClaims(ctx context.Context, db *sqlx.DB, u User, orgID string, now time.Time) (auth.Claims, error) {
+
+	var m struct {
+		OrgID string         `db:"org_id"`
+		Roles pq.StringArray `db:"roles"`
+	}
+	if orgID == "" {
+		const q = `SELECT org_id, roles FROM org_members WHERE user_id = $1
+					ORDER BY date_joined, org_id LIMIT 1`
+		if err := db.GetContext(ctx, &m, q, u.ID); err != nil && err != sql.ErrNoRows {
+			return auth.Claims{}, errors.Wrap(err, "selecting organization")
+		}
+	} else {
+		if _, err := uuid.Parse(orgID); err != nil {
+			return auth.Claims{}, ErrInvalidID
+		}
+		const q = `SELECT org_id, roles FROM org_members WHERE user_id = $1 AND org_id = $2`
+		if err := db.GetContext(ctx, &m, q, u.ID, orgID); err != nil {
+			if err == sql.ErrNoRows {
+				return auth.Claims{}, ErrForbidden
+			}
+			return auth.Claims{}, errors.Wrap(err, "checking membership")
+		}
+	}
+
+	// Tokens get the lifetime configured for the authenticator.
+	claims := auth.NewClaims(u.ID, m.Roles, now, 0)
+	claims.OrgID = m.OrgID
+
 	return claims, nil
 }
@@ -0,0 +1,339 @@
+package user_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/sreejeet/garagesale/internal/org"
+	"github.com/sreejeet/garagesale/internal/platform/auth"
+	"github.com/sreejeet/garagesale/internal/tests"
+	"github.com/sreejeet/garagesale/internal/user"
+)
+
+func TestUser(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	nu := user.NewUser{
+		Name:            "Bender B Rodríguez",
+		Email:           "bender@example.com",
+		Roles:           []string{auth.RoleUser},
+		Password:        "gophers",
+		PasswordConfirm: "gophers",
+	}
+
+	u, err := user.Create(ctx, db, org.DefaultID, nu, now)
+	if err != nil {
+		t.Fatalf("creating user: %s", err)
+	}
+
+	// Users may read their own record but not others.
+	claims := auth.NewClaims(u.ID, u.Roles, now, time.Hour)
+	claims.OrgID = org.DefaultID
+
+	saved, err := user.Retrieve(ctx, claims, db, u.ID)
+	if err != nil {
+		t.Fatalf("getting user: %s", err)
+	}
+	if diff := cmp.Diff(u, saved); diff != "" {
+		t.Fatalf("fetched user not same as created user:\n%s", diff)
+	}
+
+	if _, err := user.Retrieve(ctx, claims, db, tests.AdminID); err != user.ErrForbidden {
+		t.Fatalf("expected %v reading another user, got %v", user.ErrForbidden, err)
+	}
+
+	if _, err := user.Create(ctx, db, org.DefaultID, nu, now); err != user.ErrDuplicateEmail {
+		t.Fatalf("expected %v creating duplicate email, got %v", user.ErrDuplicateEmail, err)
+	}
+
+	// Users may change their own details but not their roles.
+	noMail := func(context.Context, user.User, string) error {
+		t.Fatal("no verification email should be sent")
+		return nil
+	}
+	upd := user.UpdateUser{
+		Name: tests.StringPointer("Bender"),
+	}
+	if err := user.Update(ctx, claims, db, u.ID, upd, now, noMail); err != nil {
+		t.Fatalf("updating user: %s", err)
+	}
+
+	upd = user.UpdateUser{
+		Roles: []string{auth.RoleAdmin},
+	}
+	if err := user.Update(ctx, claims, db, u.ID, upd, now, noMail); err != user.ErrForbidden {
+		t.Fatalf("expected %v changing own roles, got %v", user.ErrForbidden, err)
+	}
+
+	saved, err = user.Retrieve(ctx, claims, db, u.ID)
+	if err != nil {
+		t.Fatalf("getting user: %s", err)
+	}
+	if exp, got := "Bender", saved.Name; exp != got {
+		t.Fatalf("expected name %q, got %q", exp, got)
+	}
+
+	// Admins only see the members of their active organization.
+	admin := auth.NewClaims(tests.AdminID, []string{auth.RoleAdmin}, now, time.Hour)
+	admin.OrgID = uuid.New().String()
+	if _, err := user.Retrieve(ctx, admin, db, u.ID); err != user.ErrNotFound {
+		t.Fatalf("expected %v reading user of another organization, got %v", user.ErrNotFound, err)
+	}
+
+	admin.OrgID = org.DefaultID
+	if err := user.Delete(ctx, admin, db, u.ID); err != nil {
+		t.Fatalf("deleting user: %s", err)
+	}
+	if _, err := user.Retrieve(ctx, claims, db, u.ID); err != user.ErrNotFound {
+		t.Fatalf("expected %v after delete, got %v", user.ErrNotFound, err)
+	}
+}
+
+func TestOrgRoles(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	nu := user.NewUser{
+		Name:            "Hubert Farnsworth",
+		Email:           "hubert@example.com",
+		Roles:           []string{auth.RoleUser},
+		Password:        "good-news",
+		PasswordConfirm: "good-news",
+	}
+	u, err := user.Create(ctx, db, org.DefaultID, nu, now)
+	if err != nil {
+		t.Fatalf("creating user: %s", err)
+	}
+
+	self, err := user.Claims(ctx, db, u.ID, "", now)
+	if err != nil {
+		t.Fatalf("getting claims: %s", err)
+	}
+	o, err := org.Create(ctx, db, self, org.NewOrg{Name: "Planet Express"}, now)
+	if err != nil {
+		t.Fatalf("creating organization: %s", err)
+	}
+
+	// The roles of the claims are those of the active organization.
+	for _, tt := range []struct {
+		orgID string
+		want  []string
+	}{
+		{org.DefaultID, []string{auth.RoleUser}},
+		{o.ID, []string{auth.RoleAdmin}},
+	} {
+		claims, err := user.Claims(ctx, db, u.ID, tt.orgID, now)
+		if err != nil {
+			t.Fatalf("getting claims for %s: %s", tt.orgID, err)
+		}
+		if claims.OrgID != tt.orgID {
+			t.Fatalf("expected claims for %s, got %s", tt.orgID, claims.OrgID)
+		}
+		if diff := cmp.Diff(tt.want, claims.Roles); diff != "" {
+			t.Fatalf("unexpected roles in %s:\n%s", tt.orgID, diff)
+		}
+	}
+
+	// Admins of one organization only change the roles held in it.
+	admin := auth.NewClaims(tests.AdminID, []string{auth.RoleAdmin}, now, time.Hour)
+	admin.OrgID = org.DefaultID
+	upd := user.UpdateUser{Roles: []string{auth.RoleCashier}}
+	if err := user.Update(ctx, admin, db, u.ID, upd, now, nil); err != nil {
+		t.Fatalf("changing roles: %s", err)
+	}
+	saved, err := user.Retrieve(ctx, admin, db, u.ID)
+	if err != nil {
+		t.Fatalf("getting user: %s", err)
+	}
+	if diff := cmp.Diff(upd.Roles, []string(saved.Roles)); diff != "" {
+		t.Fatalf("unexpected roles after update:\n%s", diff)
+	}
+	claims, err := user.Claims(ctx, db, u.ID, o.ID, now)
+	if err != nil {
+		t.Fatalf("getting claims: %s", err)
+	}
+	if !claims.HasRole(auth.RoleAdmin) || claims.HasRole(auth.RoleCashier) {
+		t.Fatalf("roles in another organization should be kept, got %v", claims.Roles)
+	}
+
+	// Roles that do not exist are refused instead of leaving a member
+	// without permissions.
+	upd = user.UpdateUser{Roles: []string{"CASHEIR"}}
+	if err := user.Update(ctx, admin, db, u.ID, upd, now, nil); err != user.ErrUnknownRole {
+		t.Fatalf("expected %v updating to an unknown role, got %v", user.ErrUnknownRole, err)
+	}
+	nu.Email, nu.Roles = "cubert@example.com", []string{"CLONE"}
+	if _, err := user.Create(ctx, db, org.DefaultID, nu, now); err != user.ErrUnknownRole {
+		t.Fatalf("expected %v creating a user with an unknown role, got %v", user.ErrUnknownRole, err)
+	}
+
+	// The account is shared with the other organization so its
+	// email can not be changed by admins of one of them.
+	upd = user.UpdateUser{Email: tests.StringPointer("professor@example.com")}
+	if err := user.Update(ctx, admin, db, u.ID, upd, now, nil); err != user.ErrForbidden {
+		t.Fatalf("expected %v changing email of a member of another organization, got %v", user.ErrForbidden, err)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	// sent remembers the last token handed out for verification.
+	var sent string
+	send := func(_ context.Context, u user.User, token string) error {
+		sent = token
+		return nil
+	}
+	broken := func(context.Context, user.User, string) error {
+		return errors.New("mail server unavailable")
+	}
+
+	reg := user.Registration{
+		Name:            "Amy Wong",
+		Email:           "amy@example.com",
+		Password:        "gophers-rule",
+		PasswordConfirm: "gophers-rule",
+	}
+
+	// Nothing is kept when the verification email can not be sent
+	// so the address can be registered again.
+	if _, err := user.Register(ctx, db, reg, now, broken); err == nil {
+		t.Fatal("expected an error when the verification email can not be sent")
+	}
+	u, err := user.Register(ctx, db, reg, now, send)
+	if err != nil {
+		t.Fatalf("registering: %s", err)
+	}
+
+	// Asking again replaces the token sent before.
+	first := sent
+	if err := user.ResendVerification(ctx, db, reg.Email, now, send); err != nil {
+		t.Fatalf("resending verification: %s", err)
+	}
+	if err := user.Verify(ctx, db, first, now); err != user.ErrInvalidToken {
+		t.Fatalf("expected %v for a replaced token, got %v", user.ErrInvalidToken, err)
+	}
+	if err := user.Verify(ctx, db, sent, now); err != nil {
+		t.Fatalf("verifying: %s", err)
+	}
+	if err := user.ResendVerification(ctx, db, reg.Email, now, send); err != user.ErrNotFound {
+		t.Fatalf("expected %v resending to a verified user, got %v", user.ErrNotFound, err)
+	}
+
+	// A new email address has to be confirmed before it can log in.
+	claims := auth.NewClaims(u.ID, u.Roles, now, time.Hour)
+	upd := user.UpdateUser{Email: tests.StringPointer("amy.wong@example.com")}
+	if err := user.Update(ctx, claims, db, u.ID, upd, now, send); err != nil {
+		t.Fatalf("changing email: %s", err)
+	}
+	if _, err := user.Authenticate(ctx, db, now, "amy.wong@example.com", reg.Password); err != user.ErrNotVerified {
+		t.Fatalf("expected %v with unconfirmed email, got %v", user.ErrNotVerified, err)
+	}
+	if err := user.Verify(ctx, db, sent, now); err != nil {
+		t.Fatalf("verifying new email: %s", err)
+	}
+	if _, err := user.Authenticate(ctx, db, now, "amy.wong@example.com", reg.Password); err != nil {
+		t.Fatalf("authenticating with confirmed email: %s", err)
+	}
+
+	// The email stays unchanged when the confirmation can not be sent.
+	upd = user.UpdateUser{Email: tests.StringPointer("amy@planetexpress.com")}
+	if err := user.Update(ctx, claims, db, u.ID, upd, now, broken); err == nil {
+		t.Fatal("expected an error when the confirmation can not be sent")
+	}
+	saved, err := user.Retrieve(ctx, claims, db, u.ID)
+	if err != nil {
+		t.Fatalf("getting user: %s", err)
+	}
+	if !saved.Verified || saved.Email != "amy.wong@example.com" {
+		t.Fatalf("expected the confirmed email to be kept, got %q verified %v", saved.Email, saved.Verified)
+	}
+}
+
+func TestPassword(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	nu := user.NewUser{
+		Name:            "Hermes Conrad",
+		Email:           "hermes@example.com",
+		Roles:           []string{auth.RoleUser},
+		Password:        "bureaucrat",
+		PasswordConfirm: "bureaucrat",
+	}
+	u, err := user.Create(ctx, db, "", nu, now)
+	if err != nil {
+		t.Fatalf("creating user: %s", err)
+	}
+
+	{ // Change password
+
+		pc := user.PasswordChange{
+			OldPassword:     "wrong",
+			Password:        "grade-36",
+			PasswordConfirm: "grade-36",
+		}
+		if err := user.ChangePassword(ctx, db, u.ID, pc, now); err != user.ErrAuthenticationFailure {
+			t.Fatalf("expected %v with wrong old password, got %v", user.ErrAuthenticationFailure, err)
+		}
+
+		pc.OldPassword = "bureaucrat"
+		if err := user.ChangePassword(ctx, db, u.ID, pc, now); err != nil {
+			t.Fatalf("changing password: %s", err)
+		}
+		if _, err := user.Authenticate(ctx, db, now, nu.Email, "grade-36"); err != nil {
+			t.Fatalf("authenticating with new password: %s", err)
+		}
+	}
+
+	{ // Reset password
+
+		if _, _, err := user.RequestPasswordReset(ctx, db, "nobody@example.com", now); err != user.ErrNotFound {
+			t.Fatalf("expected %v for unknown email, got %v", user.ErrNotFound, err)
+		}
+
+		_, token, err := user.RequestPasswordReset(ctx, db, nu.Email, now)
+		if err != nil {
+			t.Fatalf("requesting reset: %s", err)
+		}
+
+		pr := user.PasswordReset{
+			Token:           token,
+			Password:        "limbo-champion",
+			PasswordConfirm: "limbo-champion",
+		}
+
+		// Expired tokens can not be used.
+		if err := user.ResetPassword(ctx, db, pr, now.Add(user.ResetTTL+time.Second)); err != user.ErrInvalidToken {
+			t.Fatalf("expected %v for expired token, got %v", user.ErrInvalidToken, err)
+		}
+
+		if err := user.ResetPassword(ctx, db, pr, now); err != nil {
+			t.Fatalf("resetting password: %s", err)
+		}
+		if err := user.ResetPassword(ctx, db, pr, now); err != user.ErrInvalidToken {
+			t.Fatalf("expected %v reusing token, got %v", user.ErrInvalidToken, err)
+		}
+		if _, err := user.Authenticate(ctx, db, now, nu.Email, "limbo-champion"); err != nil {
+			t.Fatalf("authenticating with reset password: %s", err)
+		}
+	}
+}